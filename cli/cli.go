@@ -16,6 +16,7 @@ package cli
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
@@ -36,11 +37,16 @@ var (
 
 	logDebug   bool
 	logVerbose bool
+	logJSON    bool
 	logLevel   = capnslog.NOTICE
 
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "cli")
 )
 
+// logJSONEnv is the environment variable which enables JSON logging if
+// --log-json isn't given.
+const logJSONEnv = "MANTLE_LOG_JSON"
+
 // Execute sets up common features that all mantle commands should share
 // and then executes the command. It does not return.
 func Execute(main *cobra.Command) {
@@ -58,6 +64,9 @@ func Execute(main *cobra.Command) {
 		"Alias for --log-level=INFO")
 	main.PersistentFlags().BoolVarP(&logDebug, "debug", "d", false,
 		"Alias for --log-level=DEBUG")
+	jsonDefault, _ := strconv.ParseBool(os.Getenv(logJSONEnv))
+	main.PersistentFlags().BoolVar(&logJSON, "log-json", jsonDefault,
+		"Log JSON lines instead of text; also set by $"+logJSONEnv)
 
 	WrapPreRun(main, func(cmd *cobra.Command, args []string) error {
 		startLogging(cmd)
@@ -86,7 +95,11 @@ func startLogging(cmd *cobra.Command) {
 		logLevel = capnslog.INFO
 	}
 
-	capnslog.SetFormatter(capnslog.NewStringFormatter(cmd.Out()))
+	if logJSON {
+		capnslog.SetFormatter(newJSONFormatter(cmd.Out()))
+	} else {
+		capnslog.SetFormatter(capnslog.NewStringFormatter(cmd.Out()))
+	}
 	capnslog.SetGlobalLogLevel(logLevel)
 
 	// In the context of the internally linked etcd, the NOTICE messages
@@ -0,0 +1,61 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+// jsonEntry is a single line of JSON log output.
+type jsonEntry struct {
+	Time      string `json:"timestamp"`
+	Level     string `json:"level"`
+	Component string `json:"component,omitempty"`
+	Message   string `json:"message"`
+}
+
+// jsonFormatter is a capnslog.Formatter writing one JSON object per line,
+// for consumption by log aggregators.
+type jsonFormatter struct {
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func newJSONFormatter(w io.Writer) capnslog.Formatter {
+	enc := json.NewEncoder(w)
+	// messages often quote HTML-ish things; keep them readable
+	enc.SetEscapeHTML(false)
+	return &jsonFormatter{
+		enc: enc,
+		now: time.Now,
+	}
+}
+
+func (j *jsonFormatter) Format(pkg string, l capnslog.LogLevel, depth int, entries ...interface{}) {
+	j.enc.Encode(&jsonEntry{
+		Time:      j.now().UTC().Format(time.RFC3339Nano),
+		Level:     l.String(),
+		Component: pkg,
+		Message:   strings.TrimSuffix(fmt.Sprint(entries...), "\n"),
+	})
+}
+
+func (j *jsonFormatter) Flush() {}
@@ -0,0 +1,71 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	f := newJSONFormatter(&buf).(*jsonFormatter)
+	f.now = func() time.Time {
+		return time.Date(2018, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	}
+
+	capnslog.SetFormatter(f)
+	defer capnslog.SetFormatter(capnslog.NewStringFormatter(&bytes.Buffer{}))
+	logger := capnslog.NewPackageLogger("github.com/coreos/mantle", "cli/test")
+	capnslog.SetGlobalLogLevel(capnslog.DEBUG)
+	defer capnslog.SetGlobalLogLevel(capnslog.NOTICE)
+
+	logger.Debugf("Operation %q is %q after %v", "op-1", "RUNNING", 10*time.Second)
+	logger.Warningf("Unknown status for %q: <%s>\nsecond line\n", "op-2", "x")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+
+	expected := []jsonEntry{
+		{
+			Time:      "2018-01-02T02:04:05Z",
+			Level:     "DEBUG",
+			Component: "cli/test",
+			Message:   `Operation "op-1" is "RUNNING" after 10s`,
+		},
+		{
+			Time:      "2018-01-02T02:04:05Z",
+			Level:     "WARNING",
+			Component: "cli/test",
+			Message:   "Unknown status for \"op-2\": <x>\nsecond line",
+		},
+	}
+	for i, line := range lines {
+		var entry jsonEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %d is not JSON: %v: %s", i, err, line)
+		}
+		if entry != expected[i] {
+			t.Errorf("line %d: expected %+v, got %+v", i, expected[i], entry)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"text/tabwriter"
 
+	"github.com/coreos/go-semver/semver"
 	"github.com/coreos/pkg/capnslog"
 	"github.com/spf13/cobra"
 
@@ -56,13 +57,22 @@ will be ignored.
 	}
 
 	cmdList = &cobra.Command{
-		Use:   "list",
+		Use:   "list [glob pattern]",
 		Short: "List kola test names",
-		Run:   runList,
+		Long: `List the kola tests matching the glob pattern (default all), with the
+platforms they run on. With --platform, list only the tests kola run would
+run on that platform.
+`,
+		Run:    runList,
+		PreRun: preRun,
 	}
+
+	listJSON bool
 )
 
 func init() {
+	cmdList.Flags().BoolVar(&listJSON, "json", false, "print the tests as JSON")
+
 	root.AddCommand(cmdRun)
 	root.AddCommand(cmdList)
 }
@@ -106,7 +116,12 @@ func runRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	runErr := kola.RunTests(pattern, kolaPlatform, outputDir)
+	var runErr error
+	if images, _ := root.PersistentFlags().GetStringSlice("image"); len(images) > 0 {
+		runErr = kola.RunImageMatrix(pattern, kolaPlatform, outputDir, images)
+	} else {
+		runErr = kola.RunTests(pattern, kolaPlatform, outputDir)
+	}
 
 	// needs to be after RunTests() because harness empties the directory
 	if err := writeProps(); err != nil {
@@ -114,10 +129,25 @@ func runRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	var uploadErr error
+	if uploadResults != "" {
+		var resultsURL string
+		resultsURL, uploadErr = uploadOutput(outputDir, uploadResults)
+		if uploadErr != nil {
+			fmt.Fprintf(os.Stderr, "Uploading results failed: %v\n", uploadErr)
+		}
+		if resultsURL != "" {
+			fmt.Printf("Results: %s\n", resultsURL)
+		}
+	}
+
 	if runErr != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", runErr)
 		os.Exit(1)
 	}
+	if uploadErr != nil {
+		os.Exit(1)
+	}
 }
 
 func writeProps() error {
@@ -202,21 +232,60 @@ func writeProps() error {
 }
 
 func runList(cmd *cobra.Command, args []string) {
-	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
-	var testlist []item
+	if len(args) > 1 {
+		fmt.Fprintf(os.Stderr, "Extra arguments specified. Usage: 'kola list [glob pattern]'\n")
+		os.Exit(2)
+	}
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
 
-	for name, test := range register.Tests {
-		testlist = append(testlist, item{
-			name,
-			test.Platforms,
-			test.ExcludePlatforms,
-			test.Architectures})
+	// list the platforms each test would run on, using the same
+	// filtering as kola run
+	platforms := kolaPlatforms
+	if root.PersistentFlags().Changed("platform") {
+		platforms = []string{kolaPlatform}
+	}
+	items := make(map[string]*item)
+	for _, pltfrm := range platforms {
+		tests, err := kola.FilterTests(pattern, pltfrm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		for name, test := range tests {
+			i, ok := items[name]
+			if !ok {
+				i = newItem(test)
+				items[name] = i
+			}
+			i.Platforms = append(i.Platforms, pltfrm)
+		}
 	}
 
+	var testlist []*item
+	for _, i := range items {
+		testlist = append(testlist, i)
+	}
 	sort.Slice(testlist, func(i, j int) bool {
 		return testlist[i].Name < testlist[j].Name
 	})
 
+	if listJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if testlist == nil {
+			testlist = []*item{}
+		}
+		if err := enc.Encode(testlist); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 0, '\t', 0)
 	fmt.Fprintln(w, "Test Name\tPlatforms\tArchitectures")
 	fmt.Fprintln(w, "\t")
 	for _, item := range testlist {
@@ -225,35 +294,41 @@ func runList(cmd *cobra.Command, args []string) {
 	w.Flush()
 }
 
+// item describes a test for kola list.
 type item struct {
-	Name             string
-	Platforms        []string
-	ExcludePlatforms []string
-	Architectures    []string
+	Name          string                `json:"name"`
+	Platforms     []string              `json:"platforms"`
+	Architectures []string              `json:"architectures,omitempty"`
+	Requires      []register.Capability `json:"requires,omitempty"`
+	ClusterSize   int                   `json:"clusterSize"`
+	MinVersion    string                `json:"minVersion,omitempty"`
+	EndVersion    string                `json:"endVersion,omitempty"`
+	Container     string                `json:"container,omitempty"`
 }
 
-func (i item) String() string {
-	if len(i.ExcludePlatforms) > 0 {
-		excludePlatforms := map[string]struct{}{}
-		for _, platform := range i.ExcludePlatforms {
-			excludePlatforms[platform] = struct{}{}
-		}
-		if len(i.Platforms) == 0 {
-			i.Platforms = kolaPlatforms
-		}
-		platforms := []string{}
-		for _, platform := range i.Platforms {
-			if _, ok := excludePlatforms[platform]; !ok {
-				platforms = append(platforms, platform)
-			}
-		}
-		i.Platforms = platforms
+func newItem(test *register.Test) *item {
+	i := &item{
+		Name:          test.Name,
+		Architectures: test.Architectures,
+		Requires:      test.Requires,
+		ClusterSize:   test.ClusterSize,
+	}
+	if (test.MinVersion != semver.Version{}) {
+		i.MinVersion = test.MinVersion.String()
 	}
-	if len(i.Platforms) == 0 {
-		i.Platforms = []string{"all"}
+	if (test.EndVersion != semver.Version{}) {
+		i.EndVersion = test.EndVersion.String()
 	}
-	if len(i.Architectures) == 0 {
-		i.Architectures = []string{"all"}
+	if test.Container != nil {
+		i.Container = test.Container.Image
+	}
+	return i
+}
+
+func (i item) String() string {
+	architectures := i.Architectures
+	if len(architectures) == 0 {
+		architectures = []string{"all"}
 	}
-	return fmt.Sprintf("%v\t%v\t%v", i.Name, i.Platforms, i.Architectures)
+	return fmt.Sprintf("%v\t%v\t%v", i.Name, i.Platforms, architectures)
 }
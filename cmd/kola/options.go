@@ -16,20 +16,28 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coreos/mantle/auth"
 	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/kola/register"
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
 	"github.com/coreos/mantle/sdk"
 )
 
 var (
 	outputDir          string
 	kolaPlatform       string
+	networkMode        string
+	gceStartupScript   string
+	envKeyRegexp       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 	defaultTargetBoard = sdk.DefaultBoard()
-	kolaPlatforms      = []string{"aws", "do", "esx", "gce", "packet", "qemu"}
+	kolaPlatforms      = []string{"aws", "do", "esx", "gce", "packet", "qemu", "ssh"}
 	kolaDefaultImages  = map[string]string{
 		"amd64-usr": sdk.BuildRoot() + "/images/amd64-usr/latest/coreos_production_image.bin",
 		"arm64-usr": sdk.BuildRoot() + "/images/arm64-usr/latest/coreos_production_image.bin",
@@ -44,16 +52,42 @@ var (
 func init() {
 	sv := root.PersistentFlags().StringVar
 	bv := root.PersistentFlags().BoolVar
+	iv := root.PersistentFlags().IntVar
 	ss := root.PersistentFlags().StringSlice
 
 	// general options
 	sv(&outputDir, "output-dir", "", "Temporary output directory for test data and logs")
+	ss("image", []string{}, "image to run the tests against, overriding the platform's image option. Specify multiple times to compare images; gce accepts family/<name>.")
 	sv(&kola.TorcxManifestFile, "torcx-manifest", "", "Path to a torcx manifest that should be made available to tests")
 	root.PersistentFlags().StringVarP(&kolaPlatform, "platform", "p", "qemu", "VM platform: "+strings.Join(kolaPlatforms, ", "))
 	root.PersistentFlags().IntVarP(&kola.TestParallelism, "parallel", "j", 1, "number of tests to run in parallel")
-	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to")
+	iv(&kola.MaxMachines, "max-machines", 0, "maximum number of machines used by tests running in parallel, including kept clusters of failed tests (default unlimited)")
+	root.PersistentFlags().DurationVar(&kola.KeepFailed, "keep-failed", 0, "keep clusters of failed tests running for this long, or until their printed release file is touched, before destroying them")
+	root.PersistentFlags().Lookup("keep-failed").NoOptDefVal = "1h"
+	bv(&kola.SnapshotOnFailure, "snapshot-on-failure", false, "snapshot the boot disks of machines of failed tests, currently on gce; the snapshots are kept")
+	bv(&kola.FailFast, "fail-fast", false, "stop the run at the first test failure, cancelling running tests")
+	iv(&kola.Retries, "retries", 0, "number of times to retry a test whose cluster failed to start because of an infrastructure problem, or which failed after its machines were preempted")
+	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to, suffixed with -image-N for each --image")
+	sv(&kola.JUnitFile, "output-junit", "", "file to write JUnit XML results to, suffixed with -image-N for each --image")
+	sv(&kola.ReadinessCheck, "readiness-check", "", "command which must succeed on machines before tests use them (default waits for systemd to finish booting)")
+	root.PersistentFlags().DurationVar(&kola.ReadinessTimeout, "readiness-timeout", platform.DefaultReadinessTimeout, "time allowed for the readiness check")
+	sv(&kola.ResultsDB, "results-db", "", "file recording the result of each test per image and platform across runs")
+	bv(&kola.SkipPassed, "skip-passed", false, "skip tests that --results-db records as passed")
+	root.PersistentFlags().DurationVar(&kola.SkipPassedWithin, "skip-passed-within", 24*time.Hour, "only skip tests that passed this recently, 0 for any time")
 	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
+	sv(&kola.Options.SSHJumpHost, "ssh-jump-host", "", "host[:port] to reach machines through, authenticating with $SSH_AUTH_SOCK")
+	sv(&kola.Options.SSHJumpUser, "ssh-jump-user", "core", "user to log in to the SSH jump host as")
+	sv(&kola.Options.SSHKeyFile, "ssh-key-file", "", "unencrypted private SSH key to authorize on machines instead of a generated one")
+	sv(&kola.Options.IgnitionVersion, "ignition-version", "", "Ignition spec version to translate configs to: "+strings.Join(conf.IgnitionVersions, ", ")+" (default is the version of each config)")
+	sv(&kola.Options.SSHKnownHosts, "ssh-known-hosts", "", "known_hosts file verifying the keys of the SSH jump host and --ssh-host hosts (default ~/.ssh/known_hosts)")
+	bv(&kola.Options.SSHInsecureIgnoreHostKeys, "ssh-insecure-ignore-host-keys", false, "don't verify the keys of the SSH jump host and --ssh-host hosts")
+	bv(&kola.Options.SSHForwardAgent, "ssh-forward-agent", false, "forward the SSH agent, including keys at $SSH_AUTH_SOCK, to test commands")
 	ss("debug-systemd-unit", []string{}, "full-unit-name.service to enable SYSTEMD_LOG_LEVEL=debug on. Specify multiple times for multiple units.")
+	sv(&networkMode, "network-mode", "dual", "IP protocols for machines: dual, ipv4-only, ipv6-only (only qemu supports single-stack)")
+	ss("capabilities", []string{}, "capabilities to assume the platform has, in addition to its defaults")
+	ss("exclude-capability", []string{}, "capability to assume the platform lacks. Specify multiple times for multiple capabilities.")
+	ss("env", []string{}, "KEY=VALUE, or KEY to pass on kola's $KEY, for tests that read KEY. Specify multiple times for multiple variables.")
+	ss("secret-env", []string{}, "like --env, but the value is redacted from test output. Specify multiple times for multiple variables.")
 
 	// aws-specific options
 	defaultRegion := os.Getenv("AWS_REGION")
@@ -67,6 +101,12 @@ func init() {
 	sv(&kola.AWSOptions.InstanceType, "aws-type", "m4.large", "AWS instance type")
 	sv(&kola.AWSOptions.SecurityGroup, "aws-sg", "kola", "AWS security group name")
 	sv(&kola.AWSOptions.IAMInstanceProfile, "aws-iam-profile", "kola", "AWS IAM instance profile name")
+	sv(&kola.AWSOptions.SpotPrice, "aws-spot-price", "", "AWS maximum spot price; launch spot instances instead of on-demand")
+	bv(&kola.AWSOptions.SpotFallback, "aws-spot-fallback", false, "launch on-demand AWS instances if spot instances are unavailable")
+	sv(&kola.AWSOptions.VolumeType, "aws-volume-type", "", "AWS root volume type, e.g. gp3 or io2 (default from AMI)")
+	root.PersistentFlags().Int64Var(&kola.AWSOptions.VolumeIOPS, "aws-volume-iops", 0, "AWS root volume provisioned IOPS")
+	sv(&kola.AWSOptions.PlacementGroup, "aws-placement-group", "", "AWS cluster placement group to launch instances into; if missing, each cluster uses a group of its own")
+	ss("aws-tag", []string{}, "key=value tag to apply to AWS instances. Specify multiple times for multiple tags.")
 
 	// do-specific options
 	sv(&kola.DOOptions.ConfigPath, "do-config-file", "", "DigitalOcean config file (default \"~/"+auth.DOConfigPath+"\")")
@@ -81,16 +121,34 @@ func init() {
 	sv(&kola.ESXOptions.Server, "esx-server", "", "ESX server")
 	sv(&kola.ESXOptions.Profile, "esx-profile", "", "ESX profile (default \"default\")")
 	sv(&kola.ESXOptions.BaseVMName, "esx-base-vm", "", "ESX base VM name")
+	sv(&kola.ESXOptions.ResourcePool, "esx-resource-pool", "", "ESX resource pool path (default resource pool if empty)")
+	sv(&kola.ESXOptions.Folder, "esx-folder", "", "ESX VM folder path (default VM folder if empty)")
+	sv(&kola.ESXOptions.Datastore, "esx-datastore", "", "ESX datastore or datastore cluster name (default datastore if empty)")
 
 	// gce-specific options
 	sv(&kola.GCEOptions.Image, "gce-image", "projects/coreos-cloud/global/images/family/coreos-alpha", "GCE image, full api endpoints names are accepted if resource is in a different project")
 	sv(&kola.GCEOptions.Project, "gce-project", "coreos-gce-testing", "GCE project name")
 	sv(&kola.GCEOptions.Zone, "gce-zone", "us-central1-a", "GCE zone name")
+	root.PersistentFlags().StringSliceVar(&kola.GCEOptions.Zones, "gce-zones", nil, "GCE zones in one region to spread cluster machines across (overrides --gce-zone)")
 	sv(&kola.GCEOptions.MachineType, "gce-machinetype", "n1-standard-1", "GCE machine type")
+	root.PersistentFlags().StringSliceVar(&kola.GCEOptions.MachineTypes, "gce-fallback-machinetypes", nil, "GCE machine types or patterns like e2-standard-* to try in order if the zone is out of capacity")
 	sv(&kola.GCEOptions.DiskType, "gce-disktype", "pd-ssd", "GCE disk type")
 	sv(&kola.GCEOptions.Network, "gce-network", "default", "GCE network")
+	root.PersistentFlags().StringSliceVar(&kola.GCEOptions.FirewallSourceRanges, "gce-firewall-source-ranges", nil, "CIDR ranges besides the network's own, such as the test controller's address, allowed to reach the ports tests open")
+	sv(&kola.GCEOptions.Subnetwork, "gce-subnetwork", "", "GCE subnetwork of --gce-network in the zone's region")
+	ss("gce-metadata", []string{}, "key=value metadata to set on GCE instances. Specify multiple times for multiple keys.")
+	sv(&kola.GCEOptions.ServiceAccount, "gce-service-account", "", "service account email to attach to GCE instances, or \"default\"")
+	root.PersistentFlags().StringSliceVar(&kola.GCEOptions.Scopes, "gce-scopes", nil, "OAuth scopes of the GCE instance service account, as URLs or aliases (default cloud-platform)")
+	sv(&gceStartupScript, "gce-startup-script", "", "file to set as the startup-script metadata of GCE instances")
+	bv(&kola.GCEOptions.NoExternalIP, "gce-no-external-ip", false, "don't give GCE instances external IPs (use with --ssh-jump-host)")
+	iv(&kola.GCEOptions.LocalSSDCount, "gce-local-ssd-count", 0, "number of local SSDs to attach to GCE instances")
+	sv(&kola.GCEOptions.LocalSSDInterface, "gce-local-ssd-interface", "SCSI", "GCE local SSD interface: SCSI, NVME")
+	bv(&kola.GCEOptions.Preemptible, "gce-preemptible", false, "launch preemptible GCE instances")
+	sv(&kola.GCEOptions.ProvisioningModel, "gce-provisioning-model", "STANDARD", "GCE provisioning model: STANDARD, SPOT")
+	sv(&kola.GCEOptions.OnHostMaintenance, "gce-on-host-maintenance", "", "GCE host maintenance policy: MIGRATE, TERMINATE (default MIGRATE, TERMINATE when preemptible)")
 	bv(&kola.GCEOptions.ServiceAuth, "gce-service-auth", false, "for non-interactive auth when running within GCE")
 	sv(&kola.GCEOptions.JSONKeyFile, "gce-json-key", "", "use a service account's JSON key for authentication")
+	sv(&kola.GCEOptions.Endpoint, "gce-endpoint", "", "GCE compute API base URL (default production)")
 
 	// packet-specific options
 	sv(&kola.PacketOptions.ConfigPath, "packet-config-file", "", "Packet config file (default \"~/"+auth.PacketConfigPath+"\")")
@@ -99,14 +157,24 @@ func init() {
 	sv(&kola.PacketOptions.Project, "packet-project", "", "Packet project UUID (overrides config file)")
 	sv(&kola.PacketOptions.Facility, "packet-facility", "sjc1", "Packet facility code")
 	sv(&kola.PacketOptions.Plan, "packet-plan", "", "Packet plan slug (default board-dependent, e.g. \"baremetal_0\")")
+	sv(&kola.PacketOptions.HardwareReservationID, "packet-hardware-reservation", "", "Packet hardware reservation UUID, or \"next-available\"")
 	sv(&kola.PacketOptions.InstallerImageBaseURL, "packet-installer-image-base-url", "", "Packet installer image base URL, non-https (default board-dependent, e.g. \"http://stable.release.core-os.net/amd64-usr/current\")")
 	sv(&kola.PacketOptions.ImageURL, "packet-image-url", "", "Packet image URL (default board-dependent, e.g. \"https://alpha.release.core-os.net/amd64-usr/current/coreos_production_packet_image.bin.bz2\")")
 	sv(&kola.PacketOptions.StorageURL, "packet-storage-url", "gs://users.developer.core-os.net/"+os.Getenv("USER")+"/mantle", "Google Storage base URL for temporary uploads")
 
+	// ssh-specific options
+	root.PersistentFlags().StringSliceVar(&kola.SSHOptions.Hosts, "ssh-host", nil, "existing host[:port] to run tests on. Specify multiple times for multiple hosts.")
+	sv(&kola.SSHOptions.User, "ssh-user", "core", "user to log in to existing hosts as")
+	sv(&kola.SSHOptions.KeyFile, "ssh-key", "", "private key for existing hosts (default uses $SSH_AUTH_SOCK)")
+
 	// QEMU-specific options
 	sv(&kola.QEMUOptions.Board, "board", defaultTargetBoard, "target board")
 	sv(&kola.QEMUOptions.DiskImage, "qemu-image", "", "path to CoreOS disk image")
 	sv(&kola.QEMUOptions.BIOSImage, "qemu-bios", "", "BIOS to use for QEMU vm")
+	bv(&kola.QEMUOptions.NestedVirt, "qemu-nested-virt", false, "enable nested virtualization in QEMU guests")
+	bv(&kola.QEMUOptions.KeepOverlay, "qemu-keep-overlay", false, "keep each QEMU guest's disk overlay in its output directory")
+	iv(&kola.QEMUOptions.CPUs, "qemu-cpus", 0, "number of vCPUs for QEMU guests (default 1)")
+	iv(&kola.QEMUOptions.Memory, "qemu-memory", 0, "memory in MiB for QEMU guests (default 1024, 2048 for arm64)")
 }
 
 // Sync up the command line options if there is dependency
@@ -137,6 +205,53 @@ func syncOptions() error {
 	if kola.QEMUOptions.BIOSImage == "" {
 		kola.QEMUOptions.BIOSImage = kolaDefaultBIOS[kola.QEMUOptions.Board]
 	}
+	var err error
+	if kola.AWSOptions.Tags, err = parseKeyValues("aws-tag"); err != nil {
+		return err
+	}
+	if kola.GCEOptions.Metadata, err = parseKeyValues("gce-metadata"); err != nil {
+		return err
+	}
+	if gceStartupScript != "" {
+		script, err := ioutil.ReadFile(gceStartupScript)
+		if err != nil {
+			return fmt.Errorf("reading GCE startup script: %v", err)
+		}
+		if kola.GCEOptions.Metadata == nil {
+			kola.GCEOptions.Metadata = make(map[string]string)
+		}
+		kola.GCEOptions.Metadata["startup-script"] = string(script)
+	}
+
+	if kola.Options.IgnitionVersion != "" {
+		if err := conf.CheckIgnitionVersion(kola.Options.IgnitionVersion); err != nil {
+			return fmt.Errorf("invalid --ignition-version: %v", err)
+		}
+	}
+
+	if kola.NetworkMode, err = platform.ParseNetworkMode(networkMode); err != nil {
+		return err
+	}
+
+	if kola.Capabilities, err = parseCapabilities("capabilities"); err != nil {
+		return err
+	}
+	if kola.ExcludeCapabilities, err = parseCapabilities("exclude-capability"); err != nil {
+		return err
+	}
+
+	if kola.Env, err = parseEnv("env"); err != nil {
+		return err
+	}
+	if kola.SecretEnv, err = parseEnv("secret-env"); err != nil {
+		return err
+	}
+	for key := range kola.SecretEnv {
+		if _, ok := kola.Env[key]; ok {
+			return fmt.Errorf("%s given with both --env and --secret-env", key)
+		}
+	}
+
 	units, _ := root.PersistentFlags().GetStringSlice("debug-systemd-units")
 	for _, unit := range units {
 		kola.Options.SystemdDropins = append(kola.Options.SystemdDropins, platform.SystemdDropin{
@@ -148,3 +263,67 @@ func syncOptions() error {
 
 	return nil
 }
+
+// parseKeyValues reads a map from the named flag, given as key=value
+// pairs. It returns nil if the flag wasn't given.
+func parseKeyValues(flag string) (map[string]string, error) {
+	pairs, _ := root.PersistentFlags().GetStringSlice(flag)
+	var m map[string]string
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --%s %q: expected key=value", flag, pair)
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// parseEnv reads test environment variables from the named flag, given
+// as KEY=VALUE or as KEY to take the value from kola's environment.
+func parseEnv(flag string) (map[string]string, error) {
+	vars, _ := root.PersistentFlags().GetStringSlice(flag)
+	var env map[string]string
+	for _, v := range vars {
+		kv := strings.SplitN(v, "=", 2)
+		if !envKeyRegexp.MatchString(kv[0]) {
+			return nil, fmt.Errorf("invalid --%s %q: %q isn't a valid variable name", flag, v, kv[0])
+		}
+		if len(kv) == 1 {
+			value, ok := os.LookupEnv(kv[0])
+			if !ok {
+				return nil, fmt.Errorf("invalid --%s %q: $%s isn't set", flag, v, kv[0])
+			}
+			kv = append(kv, value)
+		}
+		if env == nil {
+			env = make(map[string]string)
+		}
+		env[kv[0]] = kv[1]
+	}
+	return env, nil
+}
+
+// parseCapabilities reads a list of kola test capabilities from the named
+// flag, rejecting unknown ones.
+func parseCapabilities(flag string) ([]register.Capability, error) {
+	names, _ := root.PersistentFlags().GetStringSlice(flag)
+	var caps []register.Capability
+	for _, name := range names {
+		found := false
+		for _, c := range register.Capabilities {
+			if string(c) == name {
+				caps = append(caps, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown capability %q for --%s", name, flag)
+		}
+	}
+	return caps, nil
+}
@@ -20,9 +20,11 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
@@ -40,13 +42,22 @@ var (
 		PreRun: preRun,
 		Use:    "spawn",
 		Short:  "spawn a CoreOS instance",
+		Long: `Spawn CoreOS instances using the same platform code as tests.
+
+By default a shell is started on one of the instances, and all instances
+are removed when it exits. With --shell=false, the SSH command for each
+instance is printed and the instances are removed on interrupt, unless
+--keep is given. Kept instances are reached with your own SSH keys, or
+with --ssh-key-file.`,
 	}
 
 	spawnNodeCount      int
 	spawnUserData       string
+	spawnIgnition       string
 	spawnDetach         bool
 	spawnShell          bool
 	spawnRemove         bool
+	spawnKeep           bool
 	spawnVerbose        bool
 	spawnMachineOptions string
 	spawnSetSSHKeys     bool
@@ -56,9 +67,11 @@ var (
 func init() {
 	cmdSpawn.Flags().IntVarP(&spawnNodeCount, "nodecount", "c", 1, "number of nodes to spawn")
 	cmdSpawn.Flags().StringVarP(&spawnUserData, "userdata", "u", "", "file containing userdata to pass to the instances")
+	cmdSpawn.Flags().StringVar(&spawnIgnition, "ignition", "", "file containing an Ignition config to pass to the instances")
 	cmdSpawn.Flags().BoolVarP(&spawnDetach, "detach", "t", false, "-kv --shell=false --remove=false")
 	cmdSpawn.Flags().BoolVarP(&spawnShell, "shell", "s", true, "spawn a shell in an instance before exiting")
 	cmdSpawn.Flags().BoolVarP(&spawnRemove, "remove", "r", true, "remove instances after shell exits")
+	cmdSpawn.Flags().BoolVar(&spawnKeep, "keep", false, "leave instances running on exit (same as --remove=false); implies -k unless --ssh-key-file is given")
 	cmdSpawn.Flags().BoolVarP(&spawnVerbose, "verbose", "v", false, "output information about spawned instances")
 	cmdSpawn.Flags().StringVar(&spawnMachineOptions, "qemu-options", "", "experimental: path to QEMU machine options json")
 	cmdSpawn.Flags().BoolVarP(&spawnSetSSHKeys, "keys", "k", false, "add SSH keys from --key options")
@@ -82,11 +95,23 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 		spawnShell = false
 		spawnRemove = false
 	}
+	if spawnKeep {
+		spawnRemove = false
+	}
+	// the cluster's SSH agent exits with kola, so instances left running
+	// must authorize the user's own keys
+	if !spawnRemove && kola.Options.SSHKeyFile == "" {
+		spawnSetSSHKeys = true
+	}
 
 	if spawnNodeCount <= 0 {
 		return fmt.Errorf("Cluster Failed: nodecount must be one or more")
 	}
 
+	if spawnUserData != "" && spawnIgnition != "" {
+		return fmt.Errorf("--userdata and --ignition are mutually exclusive")
+	}
+
 	var userdata *conf.UserData
 	if spawnUserData != "" {
 		userbytes, err := ioutil.ReadFile(spawnUserData)
@@ -94,6 +119,12 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("Reading userdata failed: %v", err)
 		}
 		userdata = conf.Unknown(string(userbytes))
+	} else if spawnIgnition != "" {
+		ignbytes, err := ioutil.ReadFile(spawnIgnition)
+		if err != nil {
+			return fmt.Errorf("Reading Ignition config failed: %v", err)
+		}
+		userdata = conf.Ignition(string(ignbytes))
 	}
 	if spawnSetSSHKeys {
 		if userdata == nil {
@@ -125,7 +156,7 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 		defer cluster.Destroy()
 	}
 
-	var someMach platform.Machine
+	var machs []platform.Machine
 	for i := 0; i < spawnNodeCount; i++ {
 		var mach platform.Machine
 		var err error
@@ -154,10 +185,17 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 			fmt.Printf("Machine %v spawned at %v\n", mach.ID(), mach.IP())
 		}
 
-		someMach = mach
+		machs = append(machs, mach)
 	}
-
-	if spawnShell {
+	if !spawnShell {
+		for _, mach := range machs {
+			fmt.Printf("%s: %s\n", mach.ID(), sshCommand(cluster, mach))
+		}
+		if spawnRemove {
+			waitForInterrupt()
+		}
+	} else {
+		someMach := machs[len(machs)-1]
 		if spawnRemove {
 			reader := strings.NewReader(`PS1="\[\033[0;31m\][bound]\[\033[0m\] $PS1"` + "\n")
 			if err := platform.InstallFile(reader, someMach, "/etc/profile.d/kola-spawn-bound.sh"); err != nil {
@@ -171,6 +209,30 @@ func doSpawn(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// sshCommand returns a command line for logging in to mach. The cluster's
+// agent is only used while kola keeps running.
+func sshCommand(cluster platform.Cluster, mach platform.Machine) string {
+	if kola.Options.SSHKeyFile != "" {
+		return fmt.Sprintf("ssh -i %s core@%s", kola.Options.SSHKeyFile, mach.IP())
+	}
+	if a, ok := cluster.(interface {
+		AgentSocket() string
+	}); ok && spawnRemove {
+		return fmt.Sprintf("SSH_AUTH_SOCK=%s ssh core@%s", a.AgentSocket(), mach.IP())
+	}
+	return fmt.Sprintf("ssh core@%s", mach.IP())
+}
+
+// waitForInterrupt blocks until the process receives SIGINT or SIGTERM.
+func waitForInterrupt() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	fmt.Println("Press Ctrl-C to remove the instances")
+	<-sigs
+}
+
 func addSSHKeys(userdata *conf.UserData) (*conf.UserData, error) {
 	// if no keys specified, use keys from agent plus ~/.ssh/id_{rsa,dsa,ecdsa,ed25519}.pub
 	if len(spawnSSHKeys) == 0 {
@@ -0,0 +1,137 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/pkg/multierror"
+	"golang.org/x/net/context"
+	gs "google.golang.org/api/storage/v1"
+
+	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/kola"
+	"github.com/coreos/mantle/platform/api/aws"
+	"github.com/coreos/mantle/storage"
+)
+
+var uploadResults string
+
+func init() {
+	cmdRun.Flags().StringVar(&uploadResults, "upload-results", "", "gs:// or s3:// URL to upload the output directory to after the run")
+}
+
+// uploadOutput uploads the files in dir below dest, a gs:// or s3:// URL,
+// and returns the URL of the uploaded directory. A file that fails to
+// upload doesn't stop the others; all failures are returned together.
+func uploadOutput(dir, dest string) (string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%s is missing a bucket name", dest)
+	}
+	// keep runs sharing a destination apart
+	prefix := path.Join(strings.Trim(u.Path, "/"), filepath.Base(dir))
+
+	var put func(name string, f *os.File) error
+	switch u.Scheme {
+	case "gs":
+		client, err := googleClient()
+		if err != nil {
+			return "", err
+		}
+		bucket, err := storage.NewBucket(client, dest)
+		if err != nil {
+			return "", err
+		}
+		ctx := context.Background()
+		put = func(name string, f *os.File) error {
+			obj := &gs.Object{
+				Name:        path.Join(prefix, name),
+				ContentType: mime.TypeByExtension(path.Ext(name)),
+			}
+			return bucket.Upload(ctx, obj, f)
+		}
+	case "s3":
+		api, err := aws.New(&kola.AWSOptions)
+		if err != nil {
+			return "", err
+		}
+		put = func(name string, f *os.File) error {
+			return api.UploadObject(f, u.Host, path.Join(prefix, name), true)
+		}
+	default:
+		return "", fmt.Errorf("%s must be a gs:// or s3:// URL", dest)
+	}
+
+	var merr multierror.Error
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			merr = append(merr, err)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			merr = append(merr, err)
+			return nil
+		}
+		defer f.Close()
+		if err := put(filepath.ToSlash(rel), f); err != nil {
+			merr = append(merr, fmt.Errorf("uploading %s: %v", rel, err))
+		}
+		return nil
+	})
+	if err != nil {
+		merr = append(merr, err)
+	}
+
+	uploaded := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + prefix + "/"}
+	return uploaded.String(), merr.AsError()
+}
+
+// googleClient authenticates to Google Cloud using the GCE credentials
+// options.
+func googleClient() (*http.Client, error) {
+	switch {
+	case kola.GCEOptions.ServiceAuth:
+		return auth.GoogleServiceClient(), nil
+	case kola.GCEOptions.JSONKeyFile != "":
+		b, err := ioutil.ReadFile(kola.GCEOptions.JSONKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth.GoogleClientFromJSONKey(b)
+	default:
+		return auth.GoogleClient()
+	}
+}
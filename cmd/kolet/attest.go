@@ -0,0 +1,237 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// tpmDir is the sysfs directory of the first TPM.
+const tpmDir = "/sys/class/tpm/tpm0"
+
+var (
+	attestManifest string
+
+	cmdAttest = &cobra.Command{
+		Use:   "attest [--manifest <file>] [path...]",
+		Short: "Report TPM PCR values and file hashes as JSON",
+		Long: `Report the PCR values of the TPM, if there is one, and the SHA-256 of
+each path. With --manifest, also hash the files listed in a sha256sum-style
+manifest and fail if any of them doesn't match.`,
+		Run: runAttest,
+	}
+)
+
+func init() {
+	cmdAttest.Flags().StringVar(&attestManifest, "manifest", "", "sha256sum-style list of files and expected hashes to verify")
+	root.AddCommand(cmdAttest)
+}
+
+// attestation is the JSON output of kolet attest.
+type attestation struct {
+	// TPM reports whether the machine has a TPM. PCRs maps hash
+	// algorithms to the hex digests of each PCR; it's empty if the
+	// kernel doesn't expose the PCRs of the TPM.
+	TPM  bool                      `json:"tpm"`
+	PCRs map[string]map[int]string `json:"pcrs,omitempty"`
+
+	Files []fileHash `json:"files,omitempty"`
+}
+
+type fileHash struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Expected is the hash from the manifest, if the file is in it.
+	Expected string `json:"expected,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func runAttest(cmd *cobra.Command, args []string) {
+	var a attestation
+	var err error
+	a.TPM, a.PCRs, err = readPCRs(tpmDir)
+	if err != nil {
+		plog.Fatal(err)
+	}
+
+	for _, path := range args {
+		a.Files = append(a.Files, hashFile(path, ""))
+	}
+	ok := true
+	if attestManifest != "" {
+		expected, err := readManifest(attestManifest)
+		if err != nil {
+			plog.Fatal(err)
+		}
+		for _, e := range expected {
+			h := hashFile(e.Path, e.Expected)
+			if h.SHA256 != h.Expected {
+				ok = false
+			}
+			a.Files = append(a.Files, h)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&a); err != nil {
+		plog.Fatal(err)
+	}
+	if !ok {
+		plog.Fatal("files don't match the manifest")
+	}
+	os.Exit(0)
+}
+
+// readPCRs reads the PCRs of the TPM at dir from sysfs. TPM 2.0 PCRs are
+// in a directory per hash algorithm, and TPM 1.2 PCRs are listed in a
+// single SHA-1 file.
+func readPCRs(dir string) (bool, map[string]map[int]string, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return false, nil, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+
+	pcrs := make(map[string]map[int]string)
+	banks, err := filepath.Glob(filepath.Join(dir, "pcr-*"))
+	if err != nil {
+		return true, nil, err
+	}
+	for _, bank := range banks {
+		alg := strings.TrimPrefix(filepath.Base(bank), "pcr-")
+		files, err := ioutil.ReadDir(bank)
+		if err != nil {
+			return true, nil, err
+		}
+		pcrs[alg] = make(map[int]string)
+		for _, f := range files {
+			index, err := strconv.Atoi(f.Name())
+			if err != nil {
+				continue
+			}
+			value, err := ioutil.ReadFile(filepath.Join(bank, f.Name()))
+			if err != nil {
+				return true, nil, err
+			}
+			pcrs[alg][index] = strings.ToLower(strings.TrimSpace(string(value)))
+		}
+	}
+	if len(pcrs) > 0 {
+		return true, pcrs, nil
+	}
+
+	for _, path := range []string{filepath.Join(dir, "pcrs"), filepath.Join(dir, "device", "pcrs")} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return true, nil, err
+		}
+		defer f.Close()
+		sha1, err := parseLegacyPCRs(f)
+		if err != nil {
+			return true, nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+		pcrs["sha1"] = sha1
+		return true, pcrs, nil
+	}
+	return true, nil, nil
+}
+
+// parseLegacyPCRs parses the TPM 1.2 pcrs file, which has lines like
+// "PCR-00: 3A 3F ...".
+func parseLegacyPCRs(r io.Reader) (map[int]string, error) {
+	pcrs := make(map[int]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "PCR-") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(parts[0], "PCR-"))
+		if err != nil {
+			return nil, err
+		}
+		pcrs[index] = strings.ToLower(strings.Join(strings.Fields(parts[1]), ""))
+	}
+	return pcrs, scanner.Err()
+}
+
+// readManifest parses a manifest in the format written by sha256sum.
+func readManifest(path string) ([]fileHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []fileHash
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// file names may contain spaces, so only split off the hash
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed manifest line %q", line)
+		}
+		sum, name := line[:i], strings.TrimLeft(line[i:], " \t")
+		// sha256sum marks files hashed in binary mode with '*'
+		name = strings.TrimPrefix(name, "*")
+		if name == "" {
+			return nil, fmt.Errorf("malformed manifest line %q", line)
+		}
+		entries = append(entries, fileHash{
+			Path:     name,
+			Expected: strings.ToLower(sum),
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// hashFile returns the SHA-256 of the file at path, or the error reading
+// it.
+func hashFile(path, expected string) fileHash {
+	h := fileHash{Path: path, Expected: expected}
+	f, err := os.Open(path)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	defer f.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return h
+}
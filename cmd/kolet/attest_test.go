@@ -0,0 +1,162 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLegacyPCRs(t *testing.T) {
+	for _, tt := range []struct {
+		input    string
+		expected map[int]string
+		valid    bool
+	}{
+		{"", map[int]string{}, true},
+		{"PCR-00: 3A 3F 78\nPCR-01: 00 0A ff\n", map[int]string{0: "3a3f78", 1: "000aff"}, true},
+		// other lines are ignored
+		{"Manufacturer: 0x1\nPCR-10: AB\n", map[int]string{10: "ab"}, true},
+		{"PCR-xx: AB\n", nil, false},
+	} {
+		pcrs, err := parseLegacyPCRs(strings.NewReader(tt.input))
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+		} else if !reflect.DeepEqual(pcrs, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.input, tt.expected, pcrs)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kolet-attest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		input    string
+		expected []fileHash
+		valid    bool
+	}{
+		{"", nil, true},
+		{"# comment\n\nABCD  /etc/os-release\n", []fileHash{{Path: "/etc/os-release", Expected: "abcd"}}, true},
+		{"abcd */usr/lib/binary\n", []fileHash{{Path: "/usr/lib/binary", Expected: "abcd"}}, true},
+		{"abcd  /etc/file with spaces\n", []fileHash{{Path: "/etc/file with spaces", Expected: "abcd"}}, true},
+		{"abcd\n", nil, false},
+		{"abcd  *\n", nil, false},
+	} {
+		path := filepath.Join(dir, "manifest")
+		if err := ioutil.WriteFile(path, []byte(tt.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := readManifest(path)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+		} else if !reflect.DeepEqual(entries, tt.expected) {
+			t.Errorf("%q: expected %+v, got %+v", tt.input, tt.expected, entries)
+		}
+	}
+
+	if _, err := readManifest(filepath.Join(dir, "missing")); err == nil {
+		t.Error("read a missing manifest")
+	}
+}
+
+func TestReadPCRs(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		files    map[string]string // relative to the TPM directory
+		noTPM    bool
+		expected map[string]map[int]string
+	}{
+		{
+			name:  "no TPM",
+			noTPM: true,
+		},
+		{
+			name:  "no PCRs",
+			files: map[string]string{"device/description": "TPM 2.0\n"},
+		},
+		{
+			name: "TPM 2.0",
+			files: map[string]string{
+				"pcr-sha1/0":    "AB\n",
+				"pcr-sha256/0":  "CD\n",
+				"pcr-sha256/7":  "EF\n",
+				"pcr-sha256/xx": "ignored\n",
+			},
+			expected: map[string]map[int]string{
+				"sha1":   {0: "ab"},
+				"sha256": {0: "cd", 7: "ef"},
+			},
+		},
+		{
+			name:     "TPM 1.2",
+			files:    map[string]string{"device/pcrs": "PCR-00: AB CD\nPCR-01: EF 01\n"},
+			expected: map[string]map[int]string{"sha1": {0: "abcd", 1: "ef01"}},
+		},
+	} {
+		root, err := ioutil.TempDir("", "kolet-attest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		dir := filepath.Join(root, "tpm0")
+		if !tt.noTPM {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, contents := range tt.files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tpm, pcrs, err := readPCRs(dir)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if tpm == tt.noTPM {
+			t.Errorf("%s: expected TPM %v, got %v", tt.name, !tt.noTPM, tpm)
+		}
+		if !reflect.DeepEqual(pcrs, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, pcrs)
+		}
+	}
+}
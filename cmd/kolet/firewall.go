@@ -0,0 +1,128 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// partitionChain holds the rules added by "kolet firewall block".
+const partitionChain = "KOLA-PARTITION"
+
+var (
+	cmdFirewall = &cobra.Command{
+		Use:   "firewall",
+		Short: "Block traffic to other machines",
+		Run:   run,
+	}
+
+	cmdFirewallBlock = &cobra.Command{
+		Use:   "block <ip>...",
+		Short: "Drop all traffic to and from the given addresses",
+		Run:   runFirewallBlock,
+	}
+
+	cmdFirewallHeal = &cobra.Command{
+		Use:   "heal",
+		Short: "Remove all rules added by block",
+		Run:   runFirewallHeal,
+	}
+)
+
+func init() {
+	cmdFirewall.AddCommand(cmdFirewallBlock)
+	cmdFirewall.AddCommand(cmdFirewallHeal)
+	root.AddCommand(cmdFirewall)
+}
+
+func runFirewallBlock(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	for _, arg := range args {
+		ip := net.ParseIP(arg)
+		if ip == nil {
+			plog.Fatalf("invalid IP address %q", arg)
+		}
+		iptables := iptablesFor(ip)
+		if err := ensureChain(iptables); err != nil {
+			plog.Fatal(err)
+		}
+		for _, dir := range []string{"-s", "-d"} {
+			if err := runIptables(iptables, "-A", partitionChain, dir, arg, "-j", "DROP"); err != nil {
+				plog.Fatal(err)
+			}
+		}
+	}
+	os.Exit(0)
+}
+
+func runFirewallHeal(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	for _, iptables := range []string{"iptables", "ip6tables"} {
+		// nothing to do if block was never run
+		if exec.Command(iptables, "-n", "-L", partitionChain).Run() != nil {
+			continue
+		}
+		if err := runIptables(iptables, "-F", partitionChain); err != nil {
+			plog.Fatal(err)
+		}
+	}
+	os.Exit(0)
+}
+
+func iptablesFor(ip net.IP) string {
+	if ip.To4() == nil {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// ensureChain creates the partition chain and jumps to it from INPUT and
+// OUTPUT, if that hasn't been done yet.
+func ensureChain(iptables string) error {
+	if exec.Command(iptables, "-n", "-L", partitionChain).Run() != nil {
+		if err := runIptables(iptables, "-N", partitionChain); err != nil {
+			return err
+		}
+	}
+	for _, chain := range []string{"INPUT", "OUTPUT"} {
+		if exec.Command(iptables, "-C", chain, "-j", partitionChain).Run() == nil {
+			continue
+		}
+		if err := runIptables(iptables, "-I", chain, "-j", partitionChain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runIptables(iptables string, args ...string) error {
+	out, err := exec.Command(iptables, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s: %v", iptables, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
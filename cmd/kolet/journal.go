@@ -0,0 +1,181 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/mantle/kola/cluster"
+)
+
+var (
+	journalUnit   string
+	journalFollow bool
+
+	cmdJournal = &cobra.Command{
+		Use:   "journal --unit <name> [--follow]",
+		Short: "Stream the status and journal of a systemd unit",
+		Long: `Print the status and journal of a systemd unit as JSON frames, one
+per line. With --follow, new messages are streamed until stdin is closed.`,
+		Run: runJournal,
+	}
+)
+
+func init() {
+	cmdJournal.Flags().StringVar(&journalUnit, "unit", "", "systemd unit to stream")
+	cmdJournal.Flags().BoolVar(&journalFollow, "follow", false, "stream new messages")
+	root.AddCommand(cmdJournal)
+}
+
+func runJournal(cmd *cobra.Command, args []string) {
+	if len(args) != 0 || journalUnit == "" {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	if err := streamJournal(os.Stdout); err != nil {
+		plog.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+func streamJournal(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := writeStatus(enc); err != nil {
+		return err
+	}
+
+	args := []string{"--unit", journalUnit, "--output", "json", "--no-pager"}
+	if journalFollow {
+		args = append(args, "--follow")
+	}
+	journalctl := exec.Command("journalctl", args...)
+	journalctl.Stderr = os.Stderr
+	stdout, err := journalctl.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := journalctl.Start(); err != nil {
+		return fmt.Errorf("starting journalctl: %v", err)
+	}
+
+	if journalFollow {
+		// the controller closes stdin when it is done streaming
+		go func() {
+			io.Copy(ioutil.Discard, os.Stdin)
+			journalctl.Process.Kill()
+		}()
+	}
+
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := writeEntry(enc, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := journalctl.Wait(); err != nil && !journalFollow {
+		return fmt.Errorf("journalctl: %v", err)
+	}
+	if !journalFollow {
+		return writeStatus(enc)
+	}
+	return nil
+}
+
+// writeStatus writes a status frame with the current state of the unit.
+func writeStatus(enc *json.Encoder) error {
+	out, err := exec.Command("systemctl", "show", "--property=ActiveState,SubState", journalUnit).Output()
+	if err != nil {
+		return fmt.Errorf("getting status of %s: %v", journalUnit, err)
+	}
+
+	props := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			props[parts[0]] = parts[1]
+		}
+	}
+
+	return enc.Encode(&cluster.JournalFrame{
+		Type:  cluster.JournalFrameStatus,
+		Unit:  journalUnit,
+		Time:  time.Now().UTC(),
+		State: fmt.Sprintf("%s (%s)", props["ActiveState"], props["SubState"]),
+	})
+}
+
+// journalEntry holds the fields we use from journalctl's JSON output.
+// MESSAGE is a string, or an array of bytes if it isn't valid UTF-8.
+type journalEntry struct {
+	Message   json.RawMessage `json:"MESSAGE"`
+	Timestamp string          `json:"__REALTIME_TIMESTAMP"`
+	PID       string          `json:"_PID"`
+}
+
+// writeEntry converts a line of journalctl output to a log frame. Messages
+// from systemd itself usually mean the unit changed state, so they are
+// followed by a status frame.
+func writeEntry(enc *json.Encoder, line []byte) error {
+	var entry journalEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return fmt.Errorf("parsing journal entry: %v", err)
+	}
+
+	frame := cluster.JournalFrame{
+		Type: cluster.JournalFrameLog,
+		Unit: journalUnit,
+	}
+	if usec, err := strconv.ParseInt(entry.Timestamp, 10, 64); err == nil {
+		frame.Time = time.Unix(0, usec*int64(time.Microsecond)).UTC()
+	}
+	if err := json.Unmarshal(entry.Message, &frame.Message); err != nil {
+		// json decodes []byte from base64, not arrays
+		var raw []int
+		if err := json.Unmarshal(entry.Message, &raw); err == nil {
+			b := make([]byte, len(raw))
+			for i, c := range raw {
+				b[i] = byte(c)
+			}
+			frame.Message = string(b)
+		}
+	}
+	if err := enc.Encode(&frame); err != nil {
+		return err
+	}
+
+	if entry.PID == "1" {
+		return writeStatus(enc)
+	}
+	return nil
+}
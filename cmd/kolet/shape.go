@@ -0,0 +1,171 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	shapeLatency time.Duration
+	shapeRate    int
+
+	cmdShape = &cobra.Command{
+		Use:   "shape",
+		Short: "Shape traffic to other machines",
+		Run:   run,
+	}
+
+	cmdShapeAdd = &cobra.Command{
+		Use:   "add <ip> [--latency <duration>] [--rate <kbps>]",
+		Short: "Delay and rate limit traffic sent to an address",
+		Run:   runShapeAdd,
+	}
+
+	cmdShapeClear = &cobra.Command{
+		Use:   "clear",
+		Short: "Remove all shaping added by add",
+		Run:   runShapeClear,
+	}
+)
+
+func init() {
+	cmdShapeAdd.Flags().DurationVar(&shapeLatency, "latency", 0, "delay added to each packet")
+	cmdShapeAdd.Flags().IntVar(&shapeRate, "rate", 0, "bandwidth limit in kbit/s, 0 for unlimited")
+	cmdShape.AddCommand(cmdShapeAdd)
+	cmdShape.AddCommand(cmdShapeClear)
+	root.AddCommand(cmdShape)
+}
+
+func runShapeAdd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 || (shapeLatency <= 0 && shapeRate <= 0) {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	ip := net.ParseIP(args[0])
+	if ip == nil {
+		plog.Fatalf("invalid IP address %q", args[0])
+	}
+	if err := shapeTraffic(ip); err != nil {
+		plog.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+// shapeTraffic sends traffic to ip through its own HTB class, with a
+// netem qdisc adding the delay and rate limit. Unmatched traffic goes to
+// class 1:1, which is effectively unlimited.
+func shapeTraffic(ip net.IP) error {
+	dev, err := routeDevice(ip)
+	if err != nil {
+		return err
+	}
+
+	qdiscs, err := tc("qdisc", "show", "dev", dev)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(qdiscs, "qdisc htb 1: root") {
+		if _, err := tc("qdisc", "add", "dev", dev, "root", "handle", "1:", "htb", "default", "1"); err != nil {
+			return err
+		}
+		if _, err := tc("class", "add", "dev", dev, "parent", "1:", "classid", "1:1", "htb", "rate", "10gbit"); err != nil {
+			return err
+		}
+	}
+
+	classes, err := tc("class", "show", "dev", dev)
+	if err != nil {
+		return err
+	}
+	class := strings.Count(classes, "class htb 1:") + 1
+	classid := fmt.Sprintf("1:%x", class)
+	if _, err := tc("class", "add", "dev", dev, "parent", "1:", "classid", classid, "htb", "rate", "10gbit"); err != nil {
+		return err
+	}
+
+	netem := []string{"qdisc", "add", "dev", dev, "parent", classid, "netem"}
+	if shapeLatency > 0 {
+		netem = append(netem, "delay", fmt.Sprintf("%dus", shapeLatency/time.Microsecond))
+	}
+	if shapeRate > 0 {
+		netem = append(netem, "rate", fmt.Sprintf("%dkbit", shapeRate))
+	}
+	if _, err := tc(netem...); err != nil {
+		return err
+	}
+
+	proto, match, prefix := "ip", "ip", 32
+	if ip.To4() == nil {
+		proto, match, prefix = "ipv6", "ip6", 128
+	}
+	_, err = tc("filter", "add", "dev", dev, "parent", "1:", "protocol", proto, "prio", "1",
+		"u32", "match", match, "dst", fmt.Sprintf("%s/%d", ip, prefix), "flowid", classid)
+	return err
+}
+
+func runShapeClear(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	links, err := net.Interfaces()
+	if err != nil {
+		plog.Fatal(err)
+	}
+	for _, link := range links {
+		qdiscs, err := tc("qdisc", "show", "dev", link.Name)
+		if err != nil {
+			plog.Fatal(err)
+		}
+		if !strings.Contains(qdiscs, "qdisc htb 1: root") {
+			continue
+		}
+		if _, err := tc("qdisc", "del", "dev", link.Name, "root"); err != nil {
+			plog.Fatal(err)
+		}
+	}
+	os.Exit(0)
+}
+
+// routeDevice returns the name of the interface traffic to ip is sent on.
+func routeDevice(ip net.IP) (string, error) {
+	out, err := exec.Command("ip", "route", "get", ip.String()).Output()
+	if err != nil {
+		return "", fmt.Errorf("finding route to %s: %v", ip, err)
+	}
+	fields := strings.Fields(string(out))
+	for i, field := range fields {
+		if field == "dev" && i+1 < len(fields) {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no device in route to %s: %q", ip, out)
+}
+
+func tc(args ...string) (string, error) {
+	out, err := exec.Command("tc", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("tc %s: %s: %v", strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
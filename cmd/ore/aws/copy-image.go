@@ -18,7 +18,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/coreos/mantle/platform/api/aws"
 	"github.com/spf13/cobra"
 )
 
@@ -34,11 +36,15 @@ After a successful run, the final line of output will be a line of JSON describi
 	}
 
 	sourceImageID string
+	copyKMSKeyID  string
+	copyKMSKeyIDs []string
 )
 
 func init() {
 	AWS.AddCommand(cmdCopyImage)
 	cmdCopyImage.Flags().StringVar(&sourceImageID, "image", "", "source AMI")
+	cmdCopyImage.Flags().StringVar(&copyKMSKeyID, "kms-key-id", "", "encrypt copied images with this KMS key; with several regions, it must be an alias present in each")
+	cmdCopyImage.Flags().StringSliceVar(&copyKMSKeyIDs, "region-kms-key-id", nil, "encrypt the image copied to a region with a KMS key, as region=key")
 }
 
 func runCopyImage(cmd *cobra.Command, args []string) error {
@@ -47,16 +53,32 @@ func runCopyImage(cmd *cobra.Command, args []string) error {
 		os.Exit(2)
 	}
 
-	amis, err := API.CopyImage(sourceImageID, args)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't copy images: %v\n", err)
-		os.Exit(1)
+	copyOpts := &aws.CopyImageOptions{
+		KMSKeyID:  copyKMSKeyID,
+		KMSKeyIDs: make(map[string]string),
+	}
+	for _, kv := range copyKMSKeyIDs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fmt.Fprintf(os.Stderr, "Invalid --region-kms-key-id %q: expected region=key\n", kv)
+			os.Exit(2)
+		}
+		copyOpts.KMSKeyIDs[parts[0]] = parts[1]
 	}
 
-	err = json.NewEncoder(os.Stdout).Encode(amis)
+	// report the images that were copied even if some regions failed
+	amis, copyErr := API.CopyImageToRegions(region, sourceImageID, args, copyOpts)
+	if copyErr != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't copy images: %v\n", copyErr)
+	}
+
+	err := json.NewEncoder(os.Stdout).Encode(amis)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't encode result: %v\n", err)
 		os.Exit(1)
 	}
+	if copyErr != nil {
+		os.Exit(1)
+	}
 	return nil
 }
@@ -106,7 +106,7 @@ func createImage() error {
 		return fmt.Errorf("Failed waiting for droplet to power off (%v). Did install fail?", err)
 	}
 
-	if err := API.SnapshotDroplet(ctx, dropletID, imageName); err != nil {
+	if _, err := API.SnapshotDroplet(ctx, dropletID, imageName, false); err != nil {
 		return fmt.Errorf("couldn't snapshot droplet: %v", err)
 	}
 
@@ -39,6 +39,9 @@ var (
 func init() {
 	ESX.PersistentFlags().StringVar(&options.Server, "server", "", "ESX server")
 	ESX.PersistentFlags().StringVar(&options.Profile, "profile", "", "Profile")
+	ESX.PersistentFlags().StringVar(&options.ResourcePool, "resource-pool", "", "Resource pool path")
+	ESX.PersistentFlags().StringVar(&options.Folder, "folder", "", "VM folder path")
+	ESX.PersistentFlags().StringVar(&options.Datastore, "datastore", "", "Datastore or datastore cluster name")
 	cli.WrapPreRun(ESX, preflightCheck)
 }
 
@@ -35,12 +35,13 @@ var (
 		Run:   runCreateImage,
 	}
 
-	createImageFamily  string
-	createImageBoard   string
-	createImageVersion string
-	createImageRoot    string
-	createImageName    string
-	createImageForce   bool
+	createImageFamily   string
+	createImageBoard    string
+	createImageVersion  string
+	createImageRoot     string
+	createImageName     string
+	createImageForce    bool
+	createImageFeatures []string
 )
 
 func init() {
@@ -59,6 +60,8 @@ func init() {
 		"Storage image name")
 	cmdCreateImage.Flags().BoolVar(&createImageForce, "force",
 		false, "overwrite existing GCE images without prompt")
+	cmdCreateImage.Flags().StringSliceVar(&createImageFeatures, "guest-os-features",
+		nil, "guest OS features to enable instead of the default")
 	GCloud.AddCommand(cmdCreateImage)
 }
 
@@ -114,8 +117,9 @@ func runCreateImage(cmd *cobra.Command, args []string) {
 	// create image on gce
 	storageSrc := fmt.Sprintf("https://storage.googleapis.com/%v/%v", bucket, imageNameGS)
 	_, pending, err := api.CreateImage(&gcloud.ImageSpec{
-		Name:        imageNameGCE,
-		SourceImage: storageSrc,
+		Name:            imageNameGCE,
+		SourceImage:     storageSrc,
+		GuestOsFeatures: createImageFeatures,
 	}, createImageForce)
 	if err == nil {
 		err = pending.Wait()
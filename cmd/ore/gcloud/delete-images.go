@@ -29,9 +29,12 @@ var (
 		Short: "Delete GCE images",
 		Run:   runDeleteImage,
 	}
+
+	deleteDryRun bool
 )
 
 func init() {
+	cmdDeleteImage.Flags().BoolVar(&deleteDryRun, "dry-run", false, "show what would be deleted")
 	GCloud.AddCommand(cmdDeleteImage)
 }
 
@@ -44,13 +47,19 @@ func runDeleteImage(cmd *cobra.Command, args []string) {
 	exit := 0
 	pendings := map[string]*gcloud.Pending{}
 	for _, name := range args {
-		pending, err := api.DeleteImage(name)
-		if err != nil {
+		_, pending, err := api.DeleteImage(name, deleteDryRun)
+		if err == gcloud.ErrImageNotFound {
+			fmt.Fprintf(os.Stderr, "Image %q not found\n", name)
+			exit = 1
+			continue
+		} else if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			exit = 1
 			continue
 		}
-		pendings[name] = pending
+		if pending != nil {
+			pendings[name] = pending
+		}
 	}
 	for name, pending := range pendings {
 		if err := pending.Wait(); err != nil {
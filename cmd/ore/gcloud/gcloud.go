@@ -43,11 +43,22 @@ func init() {
 	sv(&opts.Project, "project", "coreos-gce-testing", "project")
 	sv(&opts.Zone, "zone", "us-central1-a", "zone")
 	sv(&opts.MachineType, "machinetype", "n1-standard-1", "machine type")
+	GCloud.PersistentFlags().StringSliceVar(&opts.MachineTypes, "fallback-machinetypes", nil, "machine types or patterns like e2-standard-* to try in order if the zone is out of capacity")
 	sv(&opts.DiskType, "disktype", "pd-ssd", "disk type")
 	sv(&opts.BaseName, "basename", "kola", "instance name prefix")
 	sv(&opts.Network, "network", "default", "network name")
+	sv(&opts.Subnetwork, "subnetwork", "", "subnetwork name, in the zone's region")
+	GCloud.PersistentFlags().BoolVar(&opts.NoExternalIP, "no-external-ip", false, "don't give instances external IPs")
+	GCloud.PersistentFlags().IntVar(&opts.LocalSSDCount, "local-ssd-count", 0, "number of local SSDs to attach to instances")
+	sv(&opts.LocalSSDInterface, "local-ssd-interface", "SCSI", "local SSD interface: SCSI, NVME")
+	GCloud.PersistentFlags().BoolVar(&opts.Preemptible, "preemptible", false, "launch preemptible instances")
+	sv(&opts.ProvisioningModel, "provisioning-model", "STANDARD", "provisioning model: STANDARD, SPOT")
+	sv(&opts.OnHostMaintenance, "on-host-maintenance", "", "host maintenance policy: MIGRATE, TERMINATE (default MIGRATE, TERMINATE when preemptible)")
+	sv(&opts.ServiceAccount, "service-account", "", "service account email to attach to instances, or \"default\"")
+	GCloud.PersistentFlags().StringSliceVar(&opts.Scopes, "scopes", nil, "OAuth scopes of the instance service account, as URLs or aliases (default cloud-platform)")
 	sv(&opts.JSONKeyFile, "json-key", "", "use a service account's JSON key for authentication")
 	GCloud.PersistentFlags().BoolVar(&opts.ServiceAuth, "service-auth", false, "use non-interactive auth when running within GCE")
+	sv(&opts.Endpoint, "endpoint", "", "compute API base URL (default production)")
 
 	cli.WrapPreRun(GCloud, preauth)
 }
@@ -15,26 +15,38 @@
 package gcloud
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/storage/v1"
+	gs "google.golang.org/api/storage/v1"
 
 	"github.com/coreos/mantle/platform/api/gcloud"
 	"github.com/coreos/mantle/sdk"
+	"github.com/coreos/mantle/storage"
 )
 
 var (
 	cmdUpload = &cobra.Command{
 		Use:   "upload",
 		Short: "Upload os image",
-		Long:  "Upload os image to Google Storage bucket and create image in GCE. Intended for use in SDK.",
-		Run:   runUpload,
+		Long: `Upload os image to Google Storage bucket and create image in GCE. Intended for use in SDK.
+
+The uploaded Google Storage object is deleted once the GCE image is
+created, unless --keep-object is given.`,
+		Run: runUpload,
 	}
 
 	uploadBucket    string
@@ -42,6 +54,9 @@ var (
 	uploadBoard     string
 	uploadFile      string
 	uploadForce     bool
+	uploadChunkSize int
+	uploadRetries   int
+	uploadKeep      bool
 )
 
 func init() {
@@ -53,6 +68,9 @@ func init() {
 		build+"/images/amd64-usr/latest/coreos_production_gce.tar.gz",
 		"path_to_coreos_image (build with: ./image_to_vm.sh --format=gce ...)")
 	cmdUpload.Flags().BoolVar(&uploadForce, "force", false, "overwrite existing GS and GCE images without prompt")
+	cmdUpload.Flags().IntVar(&uploadChunkSize, "chunk-size", storage.DefaultChunkSize/(1024*1024), "upload chunk size in MiB")
+	cmdUpload.Flags().IntVar(&uploadRetries, "upload-retries", storage.DefaultRetries, "retries of a failed chunk before giving up")
+	cmdUpload.Flags().BoolVar(&uploadKeep, "keep-object", false, "keep the Google Storage object after creating the GCE image")
 	GCloud.AddCommand(cmdUpload)
 }
 
@@ -61,6 +79,10 @@ func runUpload(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Unrecognized args in plume upload cmd: %v\n", args)
 		os.Exit(2)
 	}
+	if uploadChunkSize < 1 || uploadRetries < 0 {
+		fmt.Fprintf(os.Stderr, "--chunk-size must be positive and --upload-retries not negative\n")
+		os.Exit(2)
+	}
 
 	// if an image name is unspecified try to use version.txt
 	if uploadImageName == "" {
@@ -99,7 +121,7 @@ func runUpload(cmd *cobra.Command, args []string) {
 	imageNameGCE := gceSanitize(uploadImageName)
 	imageNameGS := uploadImageName + ".tar.gz"
 
-	storageAPI, err := storage.New(api.Client())
+	storageAPI, err := gs.New(api.Client())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Storage client failed: %v\n", err)
 		os.Exit(1)
@@ -112,6 +134,8 @@ func runUpload(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// only objects uploaded by this run are cleaned up
+	uploaded := false
 	if alreadyExists && !uploadForce {
 		var ans string
 		fmt.Printf("File %v already exists on Google Storage. Overwrite? (y/n):", imageNameGS)
@@ -122,12 +146,14 @@ func runUpload(cmd *cobra.Command, args []string) {
 		switch ans {
 		case "y", "Y", "yes":
 			fmt.Println("Overriding existing file...")
-			err = writeFile(storageAPI, uploadBucket, uploadFile, imageNameGS)
+			err = writeFile(api.Client(), uploadBucket, uploadFile, imageNameGS)
+			uploaded = true
 		default:
 			fmt.Println("Skipped file upload")
 		}
 	} else {
-		err = writeFile(storageAPI, uploadBucket, uploadFile, imageNameGS)
+		err = writeFile(api.Client(), uploadBucket, uploadFile, imageNameGS)
+		uploaded = true
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Uploading image failed: %v\n", err)
@@ -147,7 +173,7 @@ func runUpload(cmd *cobra.Command, args []string) {
 	}
 
 	// if image already exists ask to delete and try again
-	if err != nil && strings.HasSuffix(err.Error(), "alreadyExists") {
+	if ierr, ok := err.(*gcloud.ImageError); ok && ierr.Reason == "alreadyExists" {
 		var ans string
 		fmt.Printf("Image %v already exists on GCE. Overwrite? (y/n):", imageNameGCE)
 		if _, err = fmt.Scan(&ans); err != nil {
@@ -171,6 +197,7 @@ func runUpload(cmd *cobra.Command, args []string) {
 			fmt.Printf("Image %v sucessfully created in GCE\n", imageNameGCE)
 		default:
 			fmt.Println("Skipped GCE image creation")
+			uploaded = false
 		}
 	}
 
@@ -178,6 +205,14 @@ func runUpload(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Creating GCE image failed: %v\n", err)
 		os.Exit(1)
 	}
+
+	if uploaded && !uploadKeep {
+		fmt.Printf("Deleting gs://%v/%v...\n", uploadBucket, imageNameGS)
+		if err := storageAPI.Objects.Delete(uploadBucket, imageNameGS).Do(); err != nil {
+			fmt.Fprintf(os.Stderr, "Deleting uploaded object failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
 }
 
 // Converts an image name from Google Storage to an equivalent GCE image
@@ -207,8 +242,11 @@ func gceSanitize(name string) string {
 	return "v" + name
 }
 
-// Write file to Google Storage
-func writeFile(api *storage.Service, bucket, filename, destname string) error {
+// Write file to Google Storage. The file's CRC32C is sent along so that
+// Google Storage rejects a corrupted upload, its SHA-256 is stored in the
+// object's "sha256" metadata, and both are checked against the uploaded
+// object.
+func writeFile(client *http.Client, bucket, filename, destname string) error {
 	fmt.Printf("Writing %v to gs://%v ...\n", filename, bucket)
 	fmt.Printf("(Sometimes this takes a few minutes)\n")
 
@@ -218,23 +256,70 @@ func writeFile(api *storage.Service, bucket, filename, destname string) error {
 	}
 	defer file.Close()
 
-	req := api.Objects.Insert(bucket, &storage.Object{
-		Name:        destname,
-		ContentType: "application/x-gzip",
-	})
-	req.PredefinedAcl("authenticatedRead")
-	req.Media(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
-	if _, err := req.Do(); err != nil {
+	crc, sha, err := fileChecksums(file)
+	if err != nil {
+		return fmt.Errorf("checksumming %v: %v", filename, err)
+	}
+
+	retries := uploadRetries
+	if retries == 0 {
+		retries = -1
+	}
+	upload := storage.ResumableUpload{
+		Client: client,
+		Bucket: bucket,
+		Object: &gs.Object{
+			Name:        destname,
+			ContentType: "application/x-gzip",
+			Crc32c:      crc,
+			Metadata:    map[string]string{"sha256": sha},
+		},
+		Media:         file,
+		Size:          info.Size(),
+		ChunkSize:     int64(uploadChunkSize) * 1024 * 1024,
+		Retries:       retries,
+		PredefinedACL: "authenticatedRead",
+	}
+	obj, err := upload.Do(context.Background())
+	if err != nil {
 		return err
 	}
 
+	if obj.Crc32c != crc {
+		return fmt.Errorf("uploaded gs://%v/%v has CRC32C %v, expected %v", bucket, destname, obj.Crc32c, crc)
+	}
+	if obj.Metadata["sha256"] != sha {
+		return fmt.Errorf("uploaded gs://%v/%v has SHA-256 %q, expected %q", bucket, destname, obj.Metadata["sha256"], sha)
+	}
+
 	fmt.Printf("Upload successful!\n")
 	return nil
 }
 
+// fileChecksums returns the base64-encoded big-endian CRC32C used by Google
+// Storage and the hex SHA-256 of file, then rewinds it.
+func fileChecksums(file *os.File) (string, string, error) {
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	sha := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(crc, sha), file); err != nil {
+		return "", "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+
+	crcBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBytes, crc.Sum32())
+	return base64.StdEncoding.EncodeToString(crcBytes), hex.EncodeToString(sha.Sum(nil)), nil
+}
+
 // Test if file exists in Google Storage
-func fileQuery(api *storage.Service, bucket, name string) (bool, error) {
+func fileQuery(api *gs.Service, bucket, name string) (bool, error) {
 	req := api.Objects.Get(bucket, name)
 	if _, err := req.Do(); err != nil {
 		if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
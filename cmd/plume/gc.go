@@ -0,0 +1,317 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/auth"
+	"github.com/coreos/mantle/platform/api/aws"
+	"github.com/coreos/mantle/platform/api/azure"
+	"github.com/coreos/mantle/platform/api/gcloud"
+)
+
+var (
+	gcAge                time.Duration
+	gcPrefix             string
+	gcClouds             []string
+	gcGCEProject         string
+	gcAWSProfile         string
+	gcAWSRegions         []string
+	gcAzureSubscriptions []string
+
+	cmdGC = &cobra.Command{
+		Use:   "gc [options]",
+		Short: "Delete stale images.",
+		Run:   runGC,
+		Long: `Delete images older than --age whose names start with --prefix.
+
+Pass --dry-run to only list the images which would be deleted.`,
+	}
+)
+
+func init() {
+	cmdGC.Flags().DurationVar(&gcAge, "age", 0, "delete images older than this")
+	cmdGC.Flags().StringVar(&gcPrefix, "prefix", "", "delete images whose names start with this")
+	cmdGC.Flags().StringSliceVar(&gcClouds, "cloud", []string{"gce", "aws", "azure"}, "clouds to collect images from")
+	cmdGC.Flags().StringVar(&gcGCEProject, "gce-project", "coreos-gce-testing", "GCE project")
+	cmdGC.Flags().StringVar(&awsCredentialsFile, "aws-credentials", "", "AWS credentials file")
+	cmdGC.Flags().StringVar(&gcAWSProfile, "aws-profile", "default", "AWS profile")
+	cmdGC.Flags().StringSliceVar(&gcAWSRegions, "aws-region", []string{"us-west-2"}, "AWS regions")
+	cmdGC.Flags().StringVar(&azureProfile, "azure-profile", "", "Azure Profile json file")
+	cmdGC.Flags().StringSliceVar(&gcAzureSubscriptions, "azure-subscription", nil, "Azure subscriptions (default all in profile)")
+	root.AddCommand(cmdGC)
+}
+
+// gcResult describes one image selected for deletion.
+type gcResult struct {
+	cloud    string
+	location string
+	name     string
+	created  time.Time
+	err      error
+}
+
+func runGC(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		plog.Fatal("No args accepted")
+	}
+	if gcAge <= 0 {
+		plog.Fatal("--age must be positive")
+	}
+	if gcPrefix == "" {
+		plog.Fatal("--prefix is required")
+	}
+
+	cutoff := time.Now().Add(-gcAge)
+
+	var results []gcResult
+	for _, cloud := range gcClouds {
+		var res []gcResult
+		var err error
+		switch cloud {
+		case "gce":
+			res, err = gcGCE(cutoff)
+		case "aws":
+			res, err = gcAWS(cutoff)
+		case "azure":
+			res, err = gcAzure(cutoff)
+		default:
+			plog.Fatalf("Unknown cloud %q", cloud)
+		}
+		if err != nil {
+			plog.Fatalf("Collecting %s images failed: %v", cloud, err)
+		}
+		results = append(results, res...)
+	}
+
+	printGCResults(results)
+
+	if _, failed := gcSummary(results); failed {
+		os.Exit(1)
+	}
+}
+
+// gcEligible reports whether an image may be deleted. The APIs already
+// filter on the prefix, but this is checked again so that a lax server-side
+// filter can never cause other images to be deleted.
+func gcEligible(name string, created time.Time, cutoff time.Time) bool {
+	return strings.HasPrefix(name, gcPrefix) && !created.IsZero() && created.Before(cutoff)
+}
+
+func gcGCE(cutoff time.Time) ([]gcResult, error) {
+	api, err := gcloud.New(&gcloud.Options{
+		Project:     gcGCEProject,
+		JSONKeyFile: gceJSONKeyFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// only keep the few fields needed of each image while listing
+	var results []gcResult
+	err = api.ListImagesFunc(context.Background(), gcPrefix, func(image *compute.Image) error {
+		created, err := time.Parse(time.RFC3339, image.CreationTimestamp)
+		if err != nil {
+			plog.Warningf("Skipping GCE image %s: bad creation time: %v", image.Name, err)
+			return nil
+		}
+		if gcEligible(image.Name, created, cutoff) {
+			results = append(results, gcResult{
+				cloud:    "gce",
+				location: gcGCEProject,
+				name:     image.Name,
+				created:  created,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		r := &results[i]
+		if !dryRun {
+			plog.Noticef("Deleting GCE image %s", r.name)
+			_, pending, err := api.DeleteImage(r.name, false)
+			if err == gcloud.ErrImageNotFound {
+				// deleted since it was listed
+				err = nil
+			} else if err == nil && pending != nil {
+				err = pending.Wait()
+			}
+			r.err = err
+		}
+	}
+	return results, nil
+}
+
+func gcAWS(cutoff time.Time) ([]gcResult, error) {
+	var results []gcResult
+	for _, region := range gcAWSRegions {
+		api, err := aws.New(&aws.Options{
+			CredentialsFile: awsCredentialsFile,
+			Profile:         gcAWSProfile,
+			Region:          region,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating client for %v: %v", region, err)
+		}
+
+		images, err := api.ListImages(gcPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing images in %v: %v", region, err)
+		}
+
+		for _, image := range images {
+			if image.Name == nil || image.ImageId == nil || image.CreationDate == nil {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, *image.CreationDate)
+			if err != nil {
+				plog.Warningf("Skipping AWS image %s: bad creation time: %v", *image.ImageId, err)
+				continue
+			}
+			if !gcEligible(*image.Name, created, cutoff) {
+				continue
+			}
+
+			r := gcResult{
+				cloud:    "aws",
+				location: region,
+				name:     fmt.Sprintf("%s (%s)", *image.Name, *image.ImageId),
+				created:  created,
+			}
+			if !dryRun {
+				plog.Noticef("Deleting AWS image %s in %s", *image.ImageId, region)
+				r.err = api.DeleteImage(*image.ImageId)
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func gcAzure(cutoff time.Time) ([]gcResult, error) {
+	prof, err := auth.ReadAzureProfile(azureProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading Azure profile: %v", err)
+	}
+
+	var subscriptions []azure.Options
+	if len(gcAzureSubscriptions) == 0 {
+		subscriptions = prof.AsOptions()
+	} else {
+		for _, name := range gcAzureSubscriptions {
+			opt := prof.SubscriptionOptions(name)
+			if opt == nil {
+				return nil, fmt.Errorf("couldn't find subscription %q", name)
+			}
+			subscriptions = append(subscriptions, *opt)
+		}
+	}
+
+	var results []gcResult
+	for i := range subscriptions {
+		opt := &subscriptions[i]
+		api, err := azure.New(opt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure API: %v", err)
+		}
+
+		images, err := api.ListOSImages()
+		if err != nil {
+			return nil, fmt.Errorf("listing images in %v: %v", opt.SubscriptionName, err)
+		}
+
+		for _, image := range images {
+			// only consider images we uploaded ourselves
+			if image.Category != "User" {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, image.PublishedDate)
+			if err != nil {
+				plog.Warningf("Skipping Azure image %s: bad published date: %v", image.Name, err)
+				continue
+			}
+			if !gcEligible(image.Name, created, cutoff) {
+				continue
+			}
+
+			r := gcResult{
+				cloud:    "azure",
+				location: opt.SubscriptionName,
+				name:     image.Name,
+				created:  created,
+			}
+			if !dryRun {
+				plog.Noticef("Deleting Azure image %s in %s", image.Name, opt.SubscriptionName)
+				r.err = api.DeleteOSImage(image.Name, true)
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func printGCResults(results []gcResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLOUD\tLOCATION\tIMAGE\tCREATED\tSTATUS")
+	for _, r := range results {
+		status := "deleted"
+		if dryRun {
+			status = "would delete"
+		} else if r.err != nil {
+			status = fmt.Sprintf("failed: %v", r.err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.cloud, r.location, r.name,
+			r.created.UTC().Format("2006-01-02"), status)
+	}
+	w.Flush()
+
+	counts, _ := gcSummary(results)
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	fmt.Println()
+	for _, cloud := range gcClouds {
+		fmt.Printf("%s %d %s images\n", verb, counts[cloud], cloud)
+	}
+}
+
+// gcSummary counts the images deleted from each cloud, and reports
+// whether any deletion failed.
+func gcSummary(results []gcResult) (map[string]int, bool) {
+	counts := make(map[string]int)
+	failed := false
+	for _, r := range results {
+		if r.err != nil {
+			failed = true
+		} else {
+			counts[r.cloud]++
+		}
+	}
+	return counts, failed
+}
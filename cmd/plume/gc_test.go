@@ -0,0 +1,72 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGCEligible(t *testing.T) {
+	defer func(prefix string) { gcPrefix = prefix }(gcPrefix)
+	gcPrefix = "kola-"
+
+	cutoff := time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name     string
+		created  time.Time
+		expected bool
+	}{
+		{"kola-old", cutoff.Add(-time.Hour), true},
+		{"kola-new", cutoff.Add(time.Hour), false},
+		{"kola-cutoff", cutoff, false},
+		{"kola-unknown", time.Time{}, false},
+		// the server-side prefix filter is never trusted
+		{"coreos-stable", cutoff.Add(-time.Hour), false},
+		{"xkola-old", cutoff.Add(-time.Hour), false},
+	} {
+		if actual := gcEligible(tt.name, tt.created, cutoff); actual != tt.expected {
+			t.Errorf("%s created %v: expected %v, got %v", tt.name, tt.created, tt.expected, actual)
+		}
+	}
+}
+
+func TestGCSummary(t *testing.T) {
+	failure := errors.New("failed")
+	for i, tt := range []struct {
+		results []gcResult
+		counts  map[string]int
+		failed  bool
+	}{
+		{nil, map[string]int{}, false},
+		{
+			[]gcResult{{cloud: "gce"}, {cloud: "gce"}, {cloud: "aws"}},
+			map[string]int{"gce": 2, "aws": 1},
+			false,
+		},
+		{
+			[]gcResult{{cloud: "gce"}, {cloud: "aws", err: failure}, {cloud: "azure", err: failure}},
+			map[string]int{"gce": 1},
+			true,
+		},
+	} {
+		counts, failed := gcSummary(tt.results)
+		if !reflect.DeepEqual(counts, tt.counts) || failed != tt.failed {
+			t.Errorf("test %d: expected %v and %v, got %v and %v", i, tt.counts, tt.failed, counts, failed)
+		}
+	}
+}
@@ -25,8 +25,7 @@ import (
 )
 
 var (
-	indexDryRun bool
-	cmdIndex    = &cobra.Command{
+	cmdIndex = &cobra.Command{
 		Use:   "index [options]",
 		Short: "Update HTML indexes for download sites.",
 		Run:   runIndex,
@@ -44,8 +43,6 @@ If more flexibility is required use ore index instead.`,
 )
 
 func init() {
-	cmdIndex.Flags().BoolVarP(&indexDryRun, "dry-run", "n", false,
-		"perform a trial run, do not make changes")
 	AddSpecFlags(cmdIndex.Flags())
 	root.AddCommand(cmdIndex)
 }
@@ -112,7 +109,7 @@ func runIndex(cmd *cobra.Command, args []string) {
 			if err != nil {
 				plog.Fatal(err)
 			}
-			bkt.WriteDryRun(indexDryRun)
+			bkt.WriteDryRun(dryRun)
 
 			doIndex := func(prefix string, recursive bool) {
 				if err := bkt.FetchPrefix(ctx, prefix, recursive); err != nil {
@@ -33,10 +33,13 @@ var (
 	}
 
 	gceJSONKeyFile string
+	dryRun         bool
 )
 
 func init() {
 	root.PersistentFlags().StringVar(&gceJSONKeyFile, "gce-json-key", "", "use a JSON key for authentication")
+	root.PersistentFlags().BoolVarP(&dryRun, "dry-run", "n", false,
+		"perform a trial run, do not make changes")
 }
 
 func getGoogleClient() (*http.Client, error) {
@@ -117,6 +117,7 @@ func runPreRelease(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		plog.Fatal(err)
 	}
+	src.WriteDryRun(dryRun)
 
 	if err := src.Fetch(ctx); err != nil {
 		plog.Fatal(err)
@@ -148,7 +149,9 @@ func runPreRelease(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if imageInfoFile != "" {
+	if imageInfoFile != "" && dryRun {
+		plog.Printf("Would write image list to %v", imageInfoFile)
+	} else if imageInfoFile != "" {
 		f, err := os.OpenFile(imageInfoFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
 		if err != nil {
 			plog.Fatal(err)
@@ -162,11 +165,26 @@ func runPreRelease(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if dryRun {
+		plog.Printf("Pre-release dry run complete.")
+		return nil
+	}
+
 	plog.Printf("Pre-release complete, run `plume release` to finish.")
 
 	return nil
 }
 
+// checkImageFile checks that a CoreOS image exists in the source bucket
+// without downloading it.
+func checkImageFile(src *storage.Bucket, fileName string) error {
+	if src.Object(src.Prefix()+fileName) == nil {
+		return fmt.Errorf("image not found: %s%s", src.URL(), fileName)
+	}
+	plog.Printf("Would download image %s%s", src.URL(), fileName)
+	return nil
+}
+
 // getImageFile downloads a bzipped CoreOS image, verifies its signature,
 // decompresses it, and returns the decompressed path.
 func getImageFile(client *http.Client, src *storage.Bucket, fileName string) (string, error) {
@@ -210,6 +228,11 @@ func uploadAzureBlob(spec *channelSpec, api *azure.API, storageKey storageservic
 		return nil
 	}
 
+	if dryRun {
+		plog.Printf("Would upload %q to account %q container %q", blobName, spec.Azure.StorageAccount, container)
+		return nil
+	}
+
 	if err := api.UploadBlob(spec.Azure.StorageAccount, storageKey.PrimaryKey, vhdfile, container, blobName, false); err != nil {
 		if _, ok := err.(azure.BlobExistsError); !ok {
 			return fmt.Errorf("uploading file %q to account %q container %q failed: %v", vhdfile, spec.Azure.StorageAccount, container, err)
@@ -229,6 +252,11 @@ func createAzureImage(spec *channelSpec, api *azure.API, blobName, imageName str
 		return nil
 	}
 
+	if dryRun {
+		plog.Printf("Would create OS image with name %q", imageName)
+		return nil
+	}
+
 	plog.Printf("Creating OS image with name %q", imageName)
 
 	bloburl := api.UrlOfBlob(spec.Azure.StorageAccount, spec.Azure.Container, blobName).String()
@@ -257,6 +285,11 @@ func replicateAzureImage(spec *channelSpec, api *azure.API, imageName string) er
 		return err
 	}
 
+	if dryRun {
+		plog.Printf("Would replicate image to locations: %s", strings.Join(locations, ", "))
+		return nil
+	}
+
 	plog.Printf("Replicating image to locations: %s", strings.Join(locations, ", "))
 
 	channelTitle := strings.Title(specChannel)
@@ -287,19 +320,26 @@ func azurePreRelease(ctx context.Context, client *http.Client, src *storage.Buck
 		return fmt.Errorf("failed reading Azure profile: %v", err)
 	}
 
-	// download azure vhd image and unzip it
-	vhdfile, err := getImageFile(client, src, spec.Azure.Image)
-	if err != nil {
-		return err
-	}
+	var vhdfile string
+	if dryRun {
+		if err := checkImageFile(src, spec.Azure.Image); err != nil {
+			return err
+		}
+	} else {
+		// download azure vhd image and unzip it
+		vhdfile, err = getImageFile(client, src, spec.Azure.Image)
+		if err != nil {
+			return err
+		}
 
-	// sanity check - validate VHD file
-	plog.Printf("Validating VHD file %q", vhdfile)
-	if err := validator.ValidateVhd(vhdfile); err != nil {
-		return err
-	}
-	if err := validator.ValidateVhdSize(vhdfile); err != nil {
-		return err
+		// sanity check - validate VHD file
+		plog.Printf("Validating VHD file %q", vhdfile)
+		if err := validator.ValidateVhd(vhdfile); err != nil {
+			return err
+		}
+		if err := validator.ValidateVhdSize(vhdfile); err != nil {
+			return err
+		}
 	}
 
 	blobName := fmt.Sprintf("container-linux-%s-%s.vhd", specVersion, specChannel)
@@ -370,12 +410,6 @@ func awsUploadToPartition(spec *channelSpec, part *awsPartitionSpec, imageName,
 		return nil, nil, fmt.Errorf("creating client for %v: %v", part.Name, err)
 	}
 
-	f, err := os.Open(imagePath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Could not open image file %v: %v", imagePath, err)
-	}
-	defer f.Close()
-
 	s3ObjectPath := fmt.Sprintf("%s/%s/%s", specBoard, specVersion, strings.TrimSuffix(spec.AWS.Image, filepath.Ext(spec.AWS.Image)))
 	s3ObjectURL := fmt.Sprintf("s3://%s/%s", part.Bucket, s3ObjectPath)
 
@@ -384,6 +418,23 @@ func awsUploadToPartition(spec *channelSpec, part *awsPartitionSpec, imageName,
 		return nil, nil, fmt.Errorf("unable to check for snapshot: %v", err)
 	}
 
+	if dryRun {
+		if snapshot == nil {
+			plog.Printf("Would create S3 object %v and an EBS snapshot from it", s3ObjectURL)
+		} else {
+			plog.Printf("Would reuse EBS snapshot %v", snapshot.SnapshotID)
+		}
+		plog.Printf("Would create AMIs %q and %q and replicate them to %v",
+			imageName, imageName+"-hvm", strings.Join(part.Regions, ", "))
+		return nil, nil, nil
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not open image file %v: %v", imagePath, err)
+	}
+	defer f.Close()
+
 	if snapshot == nil {
 		plog.Printf("Creating S3 object %v...", s3ObjectURL)
 		err = api.UploadObject(f, part.Bucket, s3ObjectPath, false)
@@ -585,7 +636,13 @@ func awsPreRelease(ctx context.Context, client *http.Client, src *storage.Bucket
 	imageName = regexp.MustCompile(`[^A-Za-z0-9()\\./_-]`).ReplaceAllLiteralString(imageName, "_")
 	imageDescription := fmt.Sprintf("%v %v %v", spec.AWS.BaseDescription, specChannel, specVersion)
 
-	imagePath, err := getImageFile(client, src, spec.AWS.Image)
+	var imagePath string
+	var err error
+	if dryRun {
+		err = checkImageFile(src, spec.AWS.Image)
+	} else {
+		imagePath, err = getImageFile(client, src, spec.AWS.Image)
+	}
 	if err != nil {
 		return err
 	}
@@ -606,6 +663,11 @@ func awsPreRelease(ctx context.Context, client *http.Client, src *storage.Bucket
 		}
 	}
 
+	if dryRun {
+		plog.Printf("Would upload AMI lists to %v%v", src.URL(), spec.AWS.Prefix)
+		return nil
+	}
+
 	if err := awsUploadAmiLists(ctx, src, spec, &amis); err != nil {
 		return fmt.Errorf("uploading AMI IDs: %v", err)
 	}
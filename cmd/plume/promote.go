@@ -0,0 +1,265 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform/api/aws"
+	"github.com/coreos/mantle/platform/api/gcloud"
+)
+
+// AMIs at the head of a channel have awsHeadTag set to the channel's image
+// name prefix, e.g. CoreOS-stable-hvm. Previous heads have awsDeprecatedTag
+// set to the ID of the AMI which replaced them.
+const (
+	awsHeadTag       = "ChannelHead"
+	awsDeprecatedTag = "DeprecatedBy"
+)
+
+var (
+	cmdPromote = &cobra.Command{
+		Use:   "promote [options]",
+		Short: "Make a release the newest image of its channel.",
+		Run:   runPromote,
+		Long: `Make the images of a release the newest of their channel on each cloud,
+deprecating the previous newest images. On GCE the image becomes the head of
+the channel's image family. On AWS the AMI is tagged ` + awsHeadTag + `, and
+previous heads are retagged ` + awsDeprecatedTag + `.
+
+Promoting a release which is already the newest changes nothing.`,
+	}
+)
+
+func init() {
+	cmdPromote.Flags().StringVar(&awsCredentialsFile, "aws-credentials", "", "AWS credentials file")
+	AddSpecFlags(cmdPromote.Flags())
+	root.AddCommand(cmdPromote)
+}
+
+func runPromote(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		plog.Fatal("No args accepted")
+	}
+
+	spec := ChannelSpec()
+	ctx := context.Background()
+
+	promoteGCE(ctx, &spec)
+	promoteAzure(&spec)
+	promoteAWS(&spec)
+}
+
+func promoteGCE(ctx context.Context, spec *channelSpec) {
+	if spec.GCE.Project == "" || spec.GCE.Family == "" {
+		plog.Notice("GCE promotion disabled.")
+		return
+	}
+
+	api, err := gcloud.New(&gcloud.Options{
+		Project:     spec.GCE.Project,
+		JSONKeyFile: gceJSONKeyFile,
+	})
+	if err != nil {
+		plog.Fatalf("GCE client failed: %v", err)
+	}
+
+	images, err := api.ListImagesByFilter(ctx, &gcloud.ImageFilter{Family: spec.GCE.Family})
+	if err != nil {
+		plog.Fatal(err)
+	}
+
+	image, others, err := gceFamilyHead(images, gceImageNamePrefix(spec))
+	if err != nil {
+		plog.Fatalf("%v in family %s", err, spec.GCE.Family)
+	}
+
+	// the family resolves to its newest image which isn't deprecated
+	var pendings []*gcloud.Pending
+	deprecate := func(name string, state gcloud.DeprecationState, replacement string) {
+		_, pending, err := api.DeprecateImage(name, state, replacement)
+		if err != nil {
+			plog.Fatal(err)
+		}
+		pendings = append(pendings, pending)
+	}
+	changed := false
+	if gceImageDeprecated(image) {
+		changed = true
+		if dryRun {
+			plog.Noticef("Would reactivate GCE image %s", image.Name)
+		} else {
+			plog.Noticef("Reactivating GCE image %s", image.Name)
+			deprecate(image.Name, gcloud.DeprecationStateActive, "")
+		}
+	}
+	for _, other := range others {
+		if gceImageDeprecated(other) {
+			continue
+		}
+		changed = true
+		if dryRun {
+			plog.Noticef("Would deprecate GCE image %s", other.Name)
+		} else {
+			plog.Noticef("Deprecating GCE image %s in favor of %s", other.Name, image.Name)
+			deprecate(other.Name, gcloud.DeprecationStateDeprecated, image.SelfLink)
+		}
+	}
+	if !changed {
+		plog.Noticef("GCE image %s is already the head of family %s", image.Name, spec.GCE.Family)
+		return
+	}
+
+	plog.Infof("Waiting on %d operations.", len(pendings))
+	if err := gcloud.WaitAll(pendings...); err != nil {
+		plog.Fatal(err)
+	}
+}
+
+// gceFamilyHead finds the image of the release named nameVer among the
+// images of a family, and returns it and the other images.
+func gceFamilyHead(images []*compute.Image, nameVer string) (*compute.Image, []*compute.Image, error) {
+	var image *compute.Image
+	var others []*compute.Image
+	for _, i := range images {
+		if strings.HasPrefix(i.Name, nameVer) {
+			if image != nil {
+				return nil, nil, fmt.Errorf("Duplicate GCE images found: %s, %s", image.Name, i.Name)
+			}
+			image = i
+		} else {
+			others = append(others, i)
+		}
+	}
+	if image == nil {
+		return nil, nil, fmt.Errorf("GCE image %s* not found", nameVer)
+	}
+	if image.Status != "READY" {
+		return nil, nil, fmt.Errorf("GCE image %s is %s", image.Name, image.Status)
+	}
+	return image, others, nil
+}
+
+func gceImageDeprecated(image *compute.Image) bool {
+	return image.Deprecated != nil && image.Deprecated.State != "" &&
+		image.Deprecated.State != string(gcloud.DeprecationStateActive)
+}
+
+func promoteAzure(spec *channelSpec) {
+	if spec.Azure.StorageAccount == "" {
+		plog.Notice("Azure promotion disabled.")
+		return
+	}
+	// Releases are published as classic OS images, which have no notion
+	// of a newest version.
+	plog.Notice("Azure promotion is not supported for classic OS images.")
+}
+
+func promoteAWS(spec *channelSpec) {
+	if spec.AWS.Image == "" {
+		plog.Notice("AWS promotion disabled.")
+		return
+	}
+
+	imageName := awsImageName(spec)
+	head := fmt.Sprintf("%v-%v", spec.AWS.BaseName, specChannel)
+
+	for _, part := range spec.AWS.Partitions {
+		for _, region := range part.Regions {
+			plog.Printf("Promoting images in %v %v...", part.Name, region)
+
+			api, err := aws.New(&aws.Options{
+				CredentialsFile: awsCredentialsFile,
+				Profile:         part.Profile,
+				Region:          region,
+			})
+			if err != nil {
+				plog.Fatalf("creating client for %v %v: %v", part.Name, region, err)
+			}
+
+			promote := func(imageName, head string) {
+				imageID, err := api.FindImage(imageName)
+				if err != nil {
+					plog.Fatalf("couldn't find image %q in %v %v: %v", imageName, part.Name, region, err)
+				}
+				if imageID == "" {
+					plog.Fatalf("couldn't find image %q in %v %v", imageName, part.Name, region)
+				}
+				heads, err := api.FindImagesByTag(awsHeadTag, head)
+				if err != nil {
+					plog.Fatalf("couldn't find the head of %v in %v %v: %v", head, part.Name, region, err)
+				}
+
+				isHead, previous := awsHeads(heads, imageID)
+				if isHead && len(previous) == 0 {
+					plog.Noticef("%v (%v) is already the head of %v in %v %v", imageName, imageID, head, part.Name, region)
+					return
+				}
+
+				// tag the new head before untagging the old one, so
+				// there's always a head
+				if !isHead && dryRun {
+					plog.Noticef("Would promote %v (%v) to head of %v in %v %v", imageName, imageID, head, part.Name, region)
+				} else if !isHead {
+					plog.Noticef("Promoting %v (%v) to head of %v in %v %v", imageName, imageID, head, part.Name, region)
+					if err := api.CreateTags([]string{imageID}, map[string]string{awsHeadTag: head}); err != nil {
+						plog.Fatalf("couldn't tag %v in %v %v: %v", imageID, part.Name, region, err)
+					}
+					if err := api.DeleteTags([]string{imageID}, []string{awsDeprecatedTag}); err != nil {
+						plog.Fatalf("couldn't untag %v in %v %v: %v", imageID, part.Name, region, err)
+					}
+				}
+				for _, id := range previous {
+					if dryRun {
+						plog.Noticef("Would deprecate %v in %v %v", id, part.Name, region)
+						continue
+					}
+					plog.Noticef("Deprecating %v in favor of %v in %v %v", id, imageID, part.Name, region)
+					if err := api.CreateTags([]string{id}, map[string]string{awsDeprecatedTag: imageID}); err != nil {
+						plog.Fatalf("couldn't tag %v in %v %v: %v", id, part.Name, region, err)
+					}
+					if err := api.DeleteTags([]string{id}, []string{awsHeadTag}); err != nil {
+						plog.Fatalf("couldn't untag %v in %v %v: %v", id, part.Name, region, err)
+					}
+				}
+			}
+			if aws.RegionSupportsPV(region) {
+				promote(imageName, head)
+			}
+			promote(imageName+"-hvm", head+"-hvm")
+		}
+	}
+}
+
+// awsHeads reports whether imageID is tagged as the head of its channel,
+// and returns the other AMIs so tagged, which it replaces.
+func awsHeads(heads []*ec2.Image, imageID string) (bool, []string) {
+	isHead := false
+	var previous []string
+	for _, image := range heads {
+		if *image.ImageId == imageID {
+			isHead = true
+		} else {
+			previous = append(previous, *image.ImageId)
+		}
+	}
+	return isHead, previous
+}
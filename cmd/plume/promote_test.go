@@ -0,0 +1,103 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestGCEFamilyHead(t *testing.T) {
+	older := &compute.Image{Name: "coreos-stable-1520-8-0-v20171026", Status: "READY"}
+	newer := &compute.Image{Name: "coreos-stable-1576-4-0-v20171206", Status: "READY"}
+	pending := &compute.Image{Name: "coreos-stable-1576-4-0-v20171206", Status: "PENDING"}
+	dup := &compute.Image{Name: "coreos-stable-1576-4-0-v20171207", Status: "READY"}
+
+	for i, tt := range []struct {
+		images []*compute.Image
+		head   *compute.Image
+		others []*compute.Image
+		valid  bool
+	}{
+		{[]*compute.Image{older, newer}, newer, []*compute.Image{older}, true},
+		{[]*compute.Image{newer}, newer, nil, true},
+		{[]*compute.Image{older}, nil, nil, false},
+		{[]*compute.Image{older, pending}, nil, nil, false},
+		{[]*compute.Image{newer, dup}, nil, nil, false},
+	} {
+		head, others, err := gceFamilyHead(tt.images, "coreos-stable-1576-4-0")
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("test %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if head != tt.head || !reflect.DeepEqual(others, tt.others) {
+			t.Errorf("test %d: expected %v and %v, got %v and %v", i, tt.head, tt.others, head, others)
+		}
+	}
+}
+
+func TestGCEImageDeprecated(t *testing.T) {
+	for _, tt := range []struct {
+		deprecated *compute.DeprecationStatus
+		expected   bool
+	}{
+		{nil, false},
+		{&compute.DeprecationStatus{}, false},
+		{&compute.DeprecationStatus{State: "ACTIVE"}, false},
+		{&compute.DeprecationStatus{State: "DEPRECATED"}, true},
+		{&compute.DeprecationStatus{State: "OBSOLETE"}, true},
+	} {
+		if actual := gceImageDeprecated(&compute.Image{Deprecated: tt.deprecated}); actual != tt.expected {
+			t.Errorf("%+v: expected %v, got %v", tt.deprecated, tt.expected, actual)
+		}
+	}
+}
+
+func TestAWSHeads(t *testing.T) {
+	images := func(ids ...string) []*ec2.Image {
+		var images []*ec2.Image
+		for _, id := range ids {
+			images = append(images, &ec2.Image{ImageId: aws.String(id)})
+		}
+		return images
+	}
+
+	for _, tt := range []struct {
+		heads    []*ec2.Image
+		isHead   bool
+		previous []string
+	}{
+		{nil, false, nil},
+		{images("ami-new"), true, nil},
+		{images("ami-old"), false, []string{"ami-old"}},
+		{images("ami-old", "ami-new"), true, []string{"ami-old"}},
+		{images("ami-old", "ami-older"), false, []string{"ami-old", "ami-older"}},
+	} {
+		isHead, previous := awsHeads(tt.heads, "ami-new")
+		if isHead != tt.isHead || !reflect.DeepEqual(previous, tt.previous) {
+			t.Errorf("%v: expected %v and %v, got %v and %v", tt.heads, tt.isHead, tt.previous, isHead, previous)
+		}
+	}
+}
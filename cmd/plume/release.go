@@ -36,8 +36,7 @@ import (
 )
 
 var (
-	releaseDryRun bool
-	cmdRelease    = &cobra.Command{
+	cmdRelease = &cobra.Command{
 		Use:   "release [options]",
 		Short: "Publish a new CoreOS release.",
 		Run:   runRelease,
@@ -48,8 +47,6 @@ var (
 func init() {
 	cmdRelease.Flags().StringVar(&awsCredentialsFile, "aws-credentials", "", "AWS credentials file")
 	cmdRelease.Flags().StringVar(&azureProfile, "azure-profile", "", "Azure Profile json file")
-	cmdRelease.Flags().BoolVarP(&releaseDryRun, "dry-run", "n", false,
-		"perform a trial run, do not make changes")
 	AddSpecFlags(cmdRelease.Flags())
 	root.AddCommand(cmdRelease)
 }
@@ -70,7 +67,7 @@ func runRelease(cmd *cobra.Command, args []string) {
 	if err != nil {
 		plog.Fatal(err)
 	}
-	src.WriteDryRun(releaseDryRun)
+	src.WriteDryRun(dryRun)
 
 	if err := src.Fetch(ctx); err != nil {
 		plog.Fatal(err)
@@ -96,7 +93,7 @@ func runRelease(cmd *cobra.Command, args []string) {
 		if err != nil {
 			plog.Fatal(err)
 		}
-		dst.WriteDryRun(releaseDryRun)
+		dst.WriteDryRun(dryRun)
 
 		// Fetch parent directories non-recursively to re-index it later.
 		for _, prefix := range dSpec.ParentPrefixes() {
@@ -147,9 +144,23 @@ func sanitizeVersion() string {
 	return strings.Replace(v, "+", "-", -1)
 }
 
+// gceImageNamePrefix returns the prefix of the name of the release's GCE
+// image, which is followed by the date it was created.
+func gceImageNamePrefix(spec *channelSpec) string {
+	return fmt.Sprintf("%s-%s-v", spec.GCE.Family, sanitizeVersion())
+}
+
+// awsImageName returns the name of the release's PV AMI. The HVM AMI's
+// name has an -hvm suffix.
+func awsImageName(spec *channelSpec) string {
+	imageName := fmt.Sprintf("%v-%v-%v", spec.AWS.BaseName, specChannel, specVersion)
+	return regexp.MustCompile(`[^A-Za-z0-9()\\./_-]`).ReplaceAllLiteralString(imageName, "_")
+}
+
 func gceWaitForImage(pending *gcloud.Pending) {
 	plog.Infof("Waiting for image creation to finish...")
 	pending.Interval = 3 * time.Second
+	pending.Timeout = 0
 	pending.Progress = func(_ string, _ time.Duration, op *compute.Operation) error {
 		status := strings.ToLower(op.Status)
 		if op.Progress != 0 {
@@ -197,7 +208,7 @@ func doGCE(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 		plog.Fatalf("GCE client failed: %v", err)
 	}
 
-	nameVer := fmt.Sprintf("%s-%s-v", spec.GCE.Family, sanitizeVersion())
+	nameVer := gceImageNamePrefix(spec)
 	date := time.Now().UTC()
 	name := nameVer + date.Format("20060102")
 	desc := fmt.Sprintf("%s, %s, %s published on %s", spec.GCE.Description,
@@ -242,11 +253,7 @@ func doGCE(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 
 		plog.Noticef("GCE image already exists: %s", name)
 
-		if releaseDryRun {
-			return
-		}
-
-		if image.Status == "PENDING" {
+		if image.Status == "PENDING" && !dryRun {
 			pending, err := api.GetPendingForImage(image)
 			if err != nil {
 				plog.Fatalf("Couldn't wait for image creation: %v", err)
@@ -259,12 +266,11 @@ func doGCE(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 			plog.Fatalf("GCE image not found %s%s", src.URL(), spec.GCE.Image)
 		}
 
-		if releaseDryRun {
+		if dryRun {
 			plog.Noticef("Would create GCE image %s", name)
-			return
+		} else {
+			imageLink = gceUploadImage(spec, api, obj, name, desc)
 		}
-
-		imageLink = gceUploadImage(spec, api, obj, name, desc)
 	}
 
 	if spec.GCE.Publish != "" {
@@ -287,8 +293,12 @@ func doGCE(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 		if old.Deprecated != nil && old.Deprecated.State != "" {
 			continue
 		}
+		if dryRun {
+			plog.Noticef("Would deprecate old image %s", old.Name)
+			continue
+		}
 		plog.Noticef("Deprecating old image %s", old.Name)
-		pending, err := api.DeprecateImage(old.Name, gcloud.DeprecationStateDeprecated, imageLink)
+		_, pending, err := api.DeprecateImage(old.Name, gcloud.DeprecationStateDeprecated, imageLink)
 		if err != nil {
 			plog.Fatal(err)
 		}
@@ -304,11 +314,20 @@ func doGCE(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 				plog.Noticef("%v: not deleting: hardcoded solution to hardcoded problem", old.Name)
 				continue
 			}
+			if dryRun {
+				plog.Noticef("Would delete old image %s", old.Name)
+				continue
+			}
 			plog.Noticef("Deleting old image %s", old.Name)
-			pending, err := api.DeleteImage(old.Name)
-			if err != nil {
+			_, pending, err := api.DeleteImage(old.Name, false)
+			if err == gcloud.ErrImageNotFound {
+				continue
+			} else if err != nil {
 				plog.Fatal(err)
 			}
+			if pending == nil {
+				continue
+			}
 			pending.Interval = 1 * time.Second
 			pending.Timeout = 0
 			pendings = append(pendings, pending)
@@ -349,8 +368,14 @@ func doAzure(ctx context.Context, client *http.Client, src *storage.Bucket, spec
 			plog.Fatalf("failed to create Azure API: %v", err)
 		}
 
-		if releaseDryRun {
-			// TODO(bgilbert): check that the image exists
+		if dryRun {
+			exists, err := api.OSImageExists(imageName)
+			if err != nil {
+				plog.Fatalf("failed to check if image %q exists: %v", imageName, err)
+			}
+			if !exists {
+				plog.Fatalf("image %q not found on %v", imageName, environment.SubscriptionName)
+			}
 			plog.Printf("Would share %q on %v", imageName, environment.SubscriptionName)
 			continue
 		} else {
@@ -369,12 +394,11 @@ func doAWS(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 		return
 	}
 
-	imageName := fmt.Sprintf("%v-%v-%v", spec.AWS.BaseName, specChannel, specVersion)
-	imageName = regexp.MustCompile(`[^A-Za-z0-9()\\./_-]`).ReplaceAllLiteralString(imageName, "_")
+	imageName := awsImageName(spec)
 
 	for _, part := range spec.AWS.Partitions {
 		for _, region := range part.Regions {
-			if releaseDryRun {
+			if dryRun {
 				plog.Printf("Checking for images in %v %v...", part.Name, region)
 			} else {
 				plog.Printf("Publishing images in %v %v...", part.Name, region)
@@ -395,7 +419,12 @@ func doAWS(ctx context.Context, client *http.Client, src *storage.Bucket, spec *
 					plog.Fatalf("couldn't find image %q in %v %v: %v", imageName, part.Name, region, err)
 				}
 
-				if !releaseDryRun {
+				if dryRun {
+					if imageID == "" {
+						plog.Fatalf("couldn't find image %q in %v %v", imageName, part.Name, region)
+					}
+					plog.Printf("Would publish %v (%v) in %v %v", imageName, imageID, part.Name, region)
+				} else {
 					err := api.PublishImage(imageID)
 					if err != nil {
 						plog.Fatalf("couldn't publish image in %v %v: %v", part.Name, region, err)
@@ -75,10 +75,13 @@ type H struct {
 }
 
 func (c *H) parentContext() context.Context {
-	if c == nil || c.parent == nil || c.parent.ctx == nil {
-		return context.Background()
+	if c != nil && c.parent != nil && c.parent.ctx != nil {
+		return c.parent.ctx
 	}
-	return c.parent.ctx
+	if c != nil && c.suite != nil && c.suite.ctx != nil {
+		return c.suite.ctx
+	}
+	return context.Background()
 }
 
 // Verbose reports whether the Suite's Verbose option is set.
@@ -187,6 +190,9 @@ func (c *H) Fail() {
 		panic("Fail in goroutine after " + c.name + " has completed")
 	}
 	c.failed = true
+	if c.suite != nil {
+		c.suite.abort()
+	}
 }
 
 // Failed reports whether the function has failed.
@@ -230,6 +236,9 @@ func (c *H) FailNow() {
 
 // log generates the output. It's always at the same stack depth.
 func (c *H) log(s string) {
+	if c.suite != nil && c.suite.redactor != nil {
+		s = c.suite.redactor.Replace(s)
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.logger.Output(3, s)
@@ -379,6 +388,9 @@ func (t *H) Parallel() {
 	<-t.parent.barrier // Wait for the parent test to complete.
 	t.suite.waitParallel()
 	t.start = time.Now()
+	if t.suite.aborted() {
+		t.Skip("run aborted after a failure")
+	}
 }
 
 func tRunner(t *H, fn func(t *H)) {
@@ -433,6 +445,9 @@ func tRunner(t *H, fn func(t *H)) {
 	}()
 
 	t.start = time.Now()
+	if t.parent != nil && t.suite.aborted() {
+		t.Skip("run aborted after a failure")
+	}
 	fn(t)
 	t.finished = true
 }
@@ -487,3 +487,64 @@ func TestTempFile(t *testing.T) {
 		t.Errorf("%q missing %q prefix", second, "second")
 	}
 }
+
+func TestFailFast(t *testing.T) {
+	// a failure cancels the contexts of running tests
+	suite := NewSuite(Options{Parallel: 2, FailFast: true}, Tests{
+		"Running": func(h *H) {
+			h.Run("wait", func(h *H) {
+				h.Parallel()
+				select {
+				case <-h.Context().Done():
+				case <-time.After(5 * time.Second):
+					h.Error("context not cancelled after failure")
+				}
+			})
+			h.Run("fail", func(h *H) {
+				h.Parallel()
+				h.Fail()
+			})
+		}})
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteAborted {
+		t.Errorf("got %v; want %v", err, SuiteAborted)
+	}
+	if strings.Contains(buf.String(), "context not cancelled") {
+		t.Log("\n" + buf.String())
+		t.Errorf("running test wasn't cancelled")
+	}
+
+	// and tests not yet started are skipped
+	var ran bool
+	suite = NewSuite(Options{FailFast: true}, Tests{
+		"Pending": func(h *H) {
+			h.Run("fail", func(h *H) {
+				h.Fail()
+			})
+			h.Run("late", func(h *H) {
+				ran = true
+			})
+		}})
+	buf = &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteAborted {
+		t.Errorf("got %v; want %v", err, SuiteAborted)
+	}
+	if ran {
+		t.Errorf("test started after the run was aborted")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	suite := NewSuite(Options{Redact: []string{"hunter2", ""}}, Tests{
+		"Secret": func(h *H) {
+			h.Logf("token is %s", "hunter2")
+			h.Fail()
+		}})
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != SuiteFailed {
+		t.Errorf("got %v; want %v", err, SuiteFailed)
+	}
+	if strings.Contains(buf.String(), "hunter2") || !strings.Contains(buf.String(), "token is [redacted]") {
+		t.Errorf("secret not redacted:\n%s", buf.String())
+	}
+}
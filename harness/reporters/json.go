@@ -31,6 +31,7 @@ type jsonReporter struct {
 	// Context variables
 	Platform string `json:"platform"`
 	Version  string `json:"version"`
+	Image    string `json:"image,omitempty"`
 }
 
 type jsonTest struct {
@@ -40,10 +41,13 @@ type jsonTest struct {
 	Output   string                `json:"output"`
 }
 
-func NewJSONReporter(filename, platform, version string) *jsonReporter {
+// NewJSONReporter creates a reporter writing JSON to filename. image is
+// the image tested, if known.
+func NewJSONReporter(filename, platform, version, image string) *jsonReporter {
 	return &jsonReporter{
 		Platform: platform,
 		Version:  version,
+		Image:    image,
 		filename: filename,
 	}
 }
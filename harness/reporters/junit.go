@@ -0,0 +1,240 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+type junitReporter struct {
+	mu       sync.Mutex
+	suite    string
+	tests    map[string]junitTestCase // by full test name
+	started  time.Time
+	now      func() time.Time
+	filename string
+
+	// Context variables
+	platform string
+	version  string
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	Timestamp  string           `xml:"timestamp,attr,omitempty"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	TestCases  []junitTestCase  `xml:"testcase"`
+	Suites     []junitTestSuite `xml:"testsuite,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+
+	duration time.Duration
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// NewJUnitReporter creates a reporter writing JUnit XML to filename.
+// The subtests of a test are reported in a nested test suite named after
+// it, with the test as their class name.
+func NewJUnitReporter(filename, suite, platform, version string) *junitReporter {
+	return &junitReporter{
+		suite:    suite,
+		tests:    make(map[string]junitTestCase),
+		started:  time.Now(),
+		now:      time.Now,
+		filename: filename,
+		platform: platform,
+		version:  version,
+	}
+}
+
+func (r *junitReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+	class := r.suite
+	short := name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		class = name[:i]
+		short = name[i+1:]
+	}
+
+	output := string(b)
+	tc := junitTestCase{
+		Name:      short,
+		ClassName: class,
+		Time:      junitSeconds(duration),
+		SystemOut: output,
+		duration:  duration,
+	}
+	switch result {
+	case testresult.Fail:
+		tc.Failure = &junitMessage{Message: firstLine(output, "test failed"), Contents: output}
+	case testresult.Skip:
+		tc.Skipped = &junitMessage{Message: firstLine(output, "test skipped")}
+	}
+
+	r.mu.Lock()
+	r.tests[name] = tc
+	r.mu.Unlock()
+}
+
+func (r *junitReporter) Output(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for name := range r.tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	suite := r.nestedSuite(r.suite, "", names)
+	suite.Time = junitSeconds(r.now().Sub(r.started))
+	suite.Timestamp = r.started.UTC().Format("2006-01-02T15:04:05")
+	var props []junitProperty
+	if r.platform != "" {
+		props = append(props, junitProperty{"platform", r.platform})
+	}
+	if r.version != "" {
+		props = append(props, junitProperty{"version", r.version})
+	}
+	if props != nil {
+		suite.Properties = &junitProperties{props}
+	}
+
+	f, err := os.Create(filepath.Join(path, r.filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "\t")
+	err = enc.Encode(junitTestSuites{
+		Name:     r.suite,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("\n")
+	return err
+}
+
+func (r *junitReporter) SetResult(result testresult.TestResult) {}
+
+// nestedSuite returns the suite holding the tests directly under parent,
+// "" for the top level, among the sorted names. The subtests of each test
+// are in a suite of their own. Counts include those of nested suites.
+func (r *junitReporter) nestedSuite(name, parent string, names []string) junitTestSuite {
+	prefix := ""
+	if parent != "" {
+		prefix = parent + "/"
+	}
+	suite := junitTestSuite{Name: name}
+	var duration time.Duration
+	for _, full := range names {
+		if !strings.HasPrefix(full, prefix) || strings.Contains(full[len(prefix):], "/") {
+			continue
+		}
+		tc := r.tests[full]
+		suite.TestCases = append(suite.TestCases, tc)
+		suite.Tests++
+		duration += tc.duration
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+
+		var subtests []string
+		for _, sub := range names {
+			if strings.HasPrefix(sub, full+"/") {
+				subtests = append(subtests, sub)
+			}
+		}
+		if len(subtests) > 0 {
+			nested := r.nestedSuite(full, full, subtests)
+			suite.Suites = append(suite.Suites, nested)
+			suite.Tests += nested.Tests
+			suite.Failures += nested.Failures
+			suite.Skipped += nested.Skipped
+		}
+	}
+	suite.Time = junitSeconds(duration)
+	return suite
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// firstLine returns the first non-empty line of s, or def if there is none.
+func firstLine(s, def string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return def
+}
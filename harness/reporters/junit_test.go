@@ -0,0 +1,88 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+const expectedJUnit = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="kola" tests="6" failures="2" skipped="1" time="10.000">
+	<testsuite name="kola" tests="6" failures="2" skipped="1" time="10.000" timestamp="2018-01-02T03:04:05">
+		<properties>
+			<property name="platform" value="qemu"></property>
+			<property name="version" value="1576.4.0"></property>
+		</properties>
+		<testcase name="basic" classname="kola" time="1.500">
+			<system-out>ok</system-out>
+		</testcase>
+		<testcase name="cluster" classname="kola" time="3.000">
+			<failure message="subtest failed">subtest failed&#xA;</failure>
+			<system-out>subtest failed&#xA;</system-out>
+		</testcase>
+		<testcase name="skipped" classname="kola" time="0.000">
+			<skipped message="not on qemu"></skipped>
+			<system-out>not on qemu</system-out>
+		</testcase>
+		<testsuite name="cluster" tests="3" failures="1" skipped="0" time="2.500">
+			<testcase name="join" classname="cluster" time="1.000"></testcase>
+			<testcase name="leave" classname="cluster" time="1.500">
+				<failure message="timed out">&#xA;timed out</failure>
+				<system-out>&#xA;timed out</system-out>
+			</testcase>
+			<testsuite name="cluster/leave" tests="1" failures="0" skipped="0" time="0.500">
+				<testcase name="drain" classname="cluster/leave" time="0.500"></testcase>
+			</testsuite>
+		</testsuite>
+	</testsuite>
+</testsuites>
+`
+
+func TestJUnitReporter(t *testing.T) {
+	started := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := NewJUnitReporter("junit.xml", "kola", "qemu", "1576.4.0")
+	r.started = started
+	r.now = func() time.Time { return started.Add(10 * time.Second) }
+
+	// subtests are reported before their parents
+	r.ReportTest("cluster/join", testresult.Pass, time.Second, nil)
+	r.ReportTest("cluster/leave/drain", testresult.Pass, time.Second/2, nil)
+	r.ReportTest("cluster/leave", testresult.Fail, 3*time.Second/2, []byte("\ntimed out"))
+	r.ReportTest("skipped", testresult.Skip, 0, []byte("not on qemu"))
+	r.ReportTest("cluster", testresult.Fail, 3*time.Second, []byte("subtest failed\n"))
+	r.ReportTest("basic", testresult.Pass, 3*time.Second/2, []byte("ok"))
+
+	dir, err := ioutil.TempDir("", "junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := r.Output(dir); err != nil {
+		t.Fatalf("Output failed: %v", err)
+	}
+	actual, err := ioutil.ReadFile(filepath.Join(dir, "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expectedJUnit {
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedJUnit, actual)
+	}
+}
@@ -16,6 +16,7 @@
 package harness
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -41,6 +42,8 @@ const (
 var (
 	SuiteEmpty  = errors.New("harness: no tests to run")
 	SuiteFailed = errors.New("harness: test suite failed")
+	// SuiteAborted is returned when FailFast stopped the run early.
+	SuiteAborted = errors.New("harness: test suite aborted after a failure")
 )
 
 // Options
@@ -74,6 +77,14 @@ type Options struct {
 	// Limit number of tests to run in parallel (0 means GOMAXPROCS).
 	Parallel int
 
+	// Stop at the first failure, cancelling the contexts of running
+	// tests and skipping those not yet started.
+	FailFast bool
+
+	// Redact lists secrets which are replaced with "[redacted]"
+	// wherever tests log them.
+	Redact []string
+
 	Reporters reporters.Reporters
 }
 
@@ -106,6 +117,8 @@ func (o *Options) FlagSet(prefix string, errorHandling flag.ErrorHandling) *flag
 		"fail test binary execution after duration `d` (0 means unlimited)")
 	f.IntVar(&o.Parallel, prefix+"parallel", o.Parallel,
 		"run at most `n` tests in parallel")
+	f.BoolVar(&o.FailFast, prefix+"failfast", o.FailFast,
+		"do not start new tests after the first test failure")
 	return f
 }
 
@@ -145,6 +158,15 @@ type Suite struct {
 
 	// waiting is the number tests waiting to be run in parallel.
 	waiting int
+
+	// ctx is the parent of all test contexts, cancelled to abort the
+	// run when FailFast is set.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// redactor replaces Options.Redact in test logs, nil if there
+	// is nothing to redact.
+	redactor *strings.Replacer
 }
 
 func (c *Suite) waitParallel() {
@@ -159,6 +181,18 @@ func (c *Suite) waitParallel() {
 	<-c.startParallel
 }
 
+// abort stops the run if FailFast is set.
+func (c *Suite) abort() {
+	if c.opts.FailFast && c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// aborted reports whether the run was stopped by abort.
+func (c *Suite) aborted() bool {
+	return c.ctx != nil && c.ctx.Err() != nil
+}
+
 func (c *Suite) release() {
 	c.mu.Lock()
 	if c.waiting == 0 {
@@ -175,12 +209,22 @@ func (c *Suite) release() {
 // All parameters in Options cannot be modified once given to Suite.
 func NewSuite(opts Options, tests Tests) *Suite {
 	opts.init()
-	return &Suite{
+	s := &Suite{
 		opts:          opts,
 		tests:         tests,
 		match:         newMatcher(opts.Match, "Match"),
 		startParallel: make(chan bool),
 	}
+	var pairs []string
+	for _, secret := range opts.Redact {
+		if secret != "" {
+			pairs = append(pairs, secret, "[redacted]")
+		}
+	}
+	if len(pairs) > 0 {
+		s.redactor = strings.NewReplacer(pairs...)
+	}
+	return s
 }
 
 // Run runs the tests. Returns SuiteFailed for any test failure.
@@ -275,6 +319,8 @@ func (s *Suite) Run() (err error) {
 
 func (s *Suite) runTests(out, tap io.Writer) error {
 	s.running = 1 // Set the count to 1 for the main (sequential) test.
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer s.cancel()
 	t := &H{
 		signal:    make(chan bool),
 		barrier:   make(chan bool),
@@ -297,6 +343,9 @@ func (s *Suite) runTests(out, tap io.Writer) error {
 	}
 	if t.Failed() {
 		s.opts.Reporters.SetResult(testresult.Fail)
+		if s.aborted() {
+			return SuiteAborted
+		}
 		return SuiteFailed
 	}
 
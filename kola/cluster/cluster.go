@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/coreos/mantle/harness"
@@ -31,15 +32,33 @@ type TestCluster struct {
 	*harness.H
 	platform.Cluster
 	NativeFuncs []string
+
+	// Env holds the kola --env variables the test lists in its Env.
+	Env map[string]string
+
+	// GroupValues holds the values set up for the test's group.
+	GroupValues map[string]string
 }
 
 // Run runs f as a subtest and reports whether f succeeded.
 func (t *TestCluster) Run(name string, f func(c TestCluster)) bool {
 	return t.H.Run(name, func(h *harness.H) {
-		f(TestCluster{H: h, Cluster: t.Cluster})
+		f(TestCluster{H: h, Cluster: t.Cluster, Env: t.Env, GroupValues: t.GroupValues})
 	})
 }
 
+// Getenv returns the value of the kola --env variable key, or "" if it
+// wasn't given or the test doesn't list it in its Env.
+func (t *TestCluster) Getenv(key string) string {
+	return t.Env[key]
+}
+
+// GroupValue returns the value the setup of the test's group set for key,
+// or "" if it wasn't set.
+func (t *TestCluster) GroupValue(key string) string {
+	return t.GroupValues[key]
+}
+
 // RunNative runs a registered NativeFunc on a remote machine
 func (t *TestCluster) RunNative(funcName string, m platform.Machine) bool {
 	command := fmt.Sprintf("./kolet run %q %q", t.Name(), funcName)
@@ -95,9 +114,9 @@ func (t *TestCluster) DropFile(localPath string) error {
 // SSH runs a ssh command on the given machine in the cluster. It differs from
 // Machine.SSH in that stderr is written to the test's output as a 'Log' line.
 // This ensures the output will be correctly accumulated under the correct
-// test.
+// test. The command is interrupted if the test's context is cancelled.
 func (t *TestCluster) SSH(m platform.Machine, cmd string) ([]byte, error) {
-	stdout, stderr, err := m.SSH(cmd)
+	stdout, stderr, err := m.SSHContext(t.Context(), cmd)
 
 	if len(stderr) > 0 {
 		for _, line := range strings.Split(string(stderr), "\n") {
@@ -108,6 +127,27 @@ func (t *TestCluster) SSH(m platform.Machine, cmd string) ([]byte, error) {
 	return stdout, err
 }
 
+// SSHWithEnv is like SSH, but exports the test's environment variables
+// to cmd.
+func (t *TestCluster) SSHWithEnv(m platform.Machine, cmd string) ([]byte, error) {
+	var keys []string
+	for key := range t.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var exports string
+	for _, key := range keys {
+		exports += fmt.Sprintf("export %s=%s; ", key, ShellQuote(t.Env[key]))
+	}
+	return t.SSH(m, exports+cmd)
+}
+
+// ShellQuote quotes s as a single word for the shell.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 // MustSSH runs a ssh command on the given machine in the cluster, writes
 // its stderr to the test's output as a 'Log' line, fails the test if the
 // command is unsuccessful, and returns the command's stdout.
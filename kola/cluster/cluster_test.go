@@ -0,0 +1,39 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		"with spaces",
+		"it's",
+		`$HOME "$(id)" ` + "`id`",
+		`back\slash`,
+		"new\nline",
+	} {
+		out, err := exec.Command("sh", "-c", "printf %s "+ShellQuote(s)).Output()
+		if err != nil {
+			t.Errorf("%q: sh failed: %v", s, err)
+		} else if string(out) != s {
+			t.Errorf("%q: shell expanded it to %q", s, out)
+		}
+	}
+}
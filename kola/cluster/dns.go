@@ -0,0 +1,63 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
+)
+
+// PublicDNSName returns the public DNS name the platform assigned to m.
+func (t *TestCluster) PublicDNSName(m platform.Machine) (string, error) {
+	dm, ok := m.(platform.DNSMachine)
+	if !ok {
+		return "", fmt.Errorf("platform %s doesn't assign DNS names", t.Platform())
+	}
+	if name := dm.PublicDNSName(); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("machine %s has no public DNS name", m.ID())
+}
+
+// PrivateDNSName returns the DNS name the platform assigned to m within
+// its network.
+func (t *TestCluster) PrivateDNSName(m platform.Machine) (string, error) {
+	dm, ok := m.(platform.DNSMachine)
+	if !ok {
+		return "", fmt.Errorf("platform %s doesn't assign DNS names", t.Platform())
+	}
+	if name := dm.PrivateDNSName(); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("machine %s has no private DNS name", m.ID())
+}
+
+// WaitForHostname waits until hostname resolves on m, and returns the
+// addresses it resolves to as printed by getent.
+func (t *TestCluster) WaitForHostname(m platform.Machine, hostname string, timeout time.Duration) (string, error) {
+	var out []byte
+	err := util.WaitUntilReady(timeout, 5*time.Second, func() (bool, error) {
+		var err error
+		out, _, err = m.SSH(fmt.Sprintf("getent hosts %q", hostname))
+		return err == nil, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("waiting for %s to resolve on %s: %v", hostname, m.ID(), err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// Journal frame types.
+const (
+	JournalFrameStatus = "status"
+	JournalFrameLog    = "log"
+)
+
+// JournalFrame is one line of output from "kolet journal", encoded as
+// JSON. Status frames report the state of the unit, and log frames carry
+// one journal message.
+type JournalFrame struct {
+	Type    string    `json:"type"`
+	Unit    string    `json:"unit"`
+	Time    time.Time `json:"time"`
+	State   string    `json:"state,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (f *JournalFrame) String() string {
+	if f.Type == JournalFrameStatus {
+		return fmt.Sprintf("%s is %s", f.Unit, f.State)
+	}
+	return fmt.Sprintf("%s %s: %s", f.Time.Format(time.StampMicro), f.Unit, f.Message)
+}
+
+// StreamJournal follows the status and journal of unit on m, using kolet,
+// and writes each message to the test's output as it arrives. Streaming
+// continues until the returned function is called.
+func (t *TestCluster) StreamJournal(m platform.Machine, unit string) (func(), error) {
+	client, err := m.SSHClient()
+	if err != nil {
+		return nil, fmt.Errorf("kolet SSH client: %v", err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("kolet SSH session: %v", err)
+	}
+
+	// kolet exits once its stdin is closed
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, err
+	}
+
+	command := fmt.Sprintf("./kolet journal --unit %s --follow", ShellQuote(unit))
+	if err := session.Start(command); err != nil {
+		session.Close()
+		client.Close()
+		return nil, fmt.Errorf("starting %q: %v", command, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dec := json.NewDecoder(stdout)
+		for {
+			var frame JournalFrame
+			if err := dec.Decode(&frame); err == io.EOF {
+				return
+			} else if err != nil {
+				t.Logf("%s: bad journal frame: %v", m.ID(), err)
+				return
+			}
+			t.Logf("%s: %s", m.ID(), &frame)
+		}
+	}()
+
+	stop := func() {
+		stdin.Close()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Logf("%s: kolet journal didn't exit, closing session", m.ID())
+		}
+		session.Close()
+		client.Close()
+		<-done
+	}
+	return stop, nil
+}
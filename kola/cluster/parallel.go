@@ -0,0 +1,86 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/coreos/pkg/multierror"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// ParallelLimit is the number of machines RunParallel and RunParallelFunc
+// work on at once.
+var ParallelLimit = 10
+
+// SSHResult is the outcome of running a command on one machine.
+type SSHResult struct {
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+// RunParallel runs cmd on every machine in the cluster and returns each
+// machine's output. The returned error combines the errors of all
+// machines the command failed on; the results include every machine
+// either way.
+func (t *TestCluster) RunParallel(cmd string) (map[platform.Machine]SSHResult, error) {
+	var mu sync.Mutex
+	results := make(map[platform.Machine]SSHResult)
+	err := t.RunParallelFunc(func(m platform.Machine) error {
+		stdout, stderr, err := m.SSH(cmd)
+		mu.Lock()
+		results[m] = SSHResult{Stdout: stdout, Stderr: stderr, Err: err}
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("%q failed: %s: %v", cmd, stderr, err)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// RunParallelFunc calls f for every machine in the cluster, at most
+// ParallelLimit at a time, and waits for all of them to return. The
+// returned error combines the errors from all machines.
+func (t *TestCluster) RunParallelFunc(f func(platform.Machine) error) error {
+	machines := t.Machines()
+	errs := make([]error, len(machines))
+	limit := make(chan struct{}, ParallelLimit)
+
+	var wg sync.WaitGroup
+	for i, m := range machines {
+		wg.Add(1)
+		go func(i int, m platform.Machine) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			if err := f(m); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", m.ID(), err)
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	var merr multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			merr = append(merr, err)
+		}
+	}
+	return merr.AsError()
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// ErrPartitionNotSupported is returned by Partition and Heal on platforms
+// which can't block traffic between machines.
+var ErrPartitionNotSupported = errors.New("network partitions are not supported on this platform")
+
+// Partition blocks all traffic between machines a and b until Heal is
+// called. Rules are applied inside the machines with iptables by kolet, so
+// they go away when the machines are destroyed. Only qemu is supported;
+// cloud platforms will need security group or firewall rules instead.
+func (t *TestCluster) Partition(a, b platform.Machine) error {
+	if t.Platform() != "qemu" {
+		return ErrPartitionNotSupported
+	}
+	if err := t.blockPeer(a, b); err != nil {
+		return err
+	}
+	return t.blockPeer(b, a)
+}
+
+// Heal removes all partitions between machines in the cluster.
+func (t *TestCluster) Heal() error {
+	if t.Platform() != "qemu" {
+		return ErrPartitionNotSupported
+	}
+	for _, m := range t.Machines() {
+		if out, err := t.SSH(m, "sudo ./kolet firewall heal"); err != nil {
+			return fmt.Errorf("healing partitions on %s: %s: %v", m.ID(), out, err)
+		}
+	}
+	return nil
+}
+
+// blockPeer drops traffic between m and each address of peer, on m.
+func (t *TestCluster) blockPeer(m, peer platform.Machine) error {
+	cmd := "sudo ./kolet firewall block " + strings.Join(peerAddresses(peer), " ")
+	if out, err := t.SSH(m, cmd); err != nil {
+		return fmt.Errorf("blocking %s on %s: %s: %v", peer.ID(), m.ID(), out, err)
+	}
+	return nil
+}
+
+// peerAddresses returns the distinct IPv4 and IPv6 addresses of m.
+func peerAddresses(m platform.Machine) []string {
+	return distinctAddresses(m.IP(), m.PrivateIP(), m.PublicIPv6(), m.PrivateIPv6())
+}
+
+// distinctAddresses returns the addresses which are set, without
+// duplicates. Single-stack machines may report the same address twice.
+func distinctAddresses(addrs ...string) []string {
+	var ips []string
+	seen := make(map[string]bool)
+	for _, ip := range addrs {
+		if ip != "" && !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
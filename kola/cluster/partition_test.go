@@ -0,0 +1,53 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// addrMachine is a platform.Machine with only its addresses implemented.
+type addrMachine struct {
+	platform.Machine
+	ip, privateIP, publicIPv6, privateIPv6 string
+}
+
+func (m addrMachine) IP() string          { return m.ip }
+func (m addrMachine) PrivateIP() string   { return m.privateIP }
+func (m addrMachine) PublicIPv6() string  { return m.publicIPv6 }
+func (m addrMachine) PrivateIPv6() string { return m.privateIPv6 }
+
+func TestPeerAddresses(t *testing.T) {
+	for _, tt := range []struct {
+		m        addrMachine
+		expected []string
+	}{
+		{addrMachine{ip: "10.0.0.2", privateIP: "10.0.0.2"}, []string{"10.0.0.2"}},
+		{addrMachine{ip: "203.0.113.2", privateIP: "10.0.0.2"}, []string{"203.0.113.2", "10.0.0.2"}},
+		{
+			addrMachine{ip: "10.0.0.2", privateIP: "10.0.0.2", publicIPv6: "2001:db8::2", privateIPv6: "fd00::2"},
+			[]string{"10.0.0.2", "2001:db8::2", "fd00::2"},
+		},
+		// IPv6-only machines report their IPv6 address as IP
+		{addrMachine{ip: "fd00::2", privateIP: "fd00::2", privateIPv6: "fd00::2"}, []string{"fd00::2"}},
+	} {
+		if actual := peerAddresses(tt.m); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%+v: expected %v, got %v", tt.m, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// ErrShapingNotSupported is returned by ShapeLink and ClearShaping on
+// platforms which can't shape traffic between machines.
+var ErrShapingNotSupported = errors.New("traffic shaping is not supported on this platform")
+
+// ShapeLink delays traffic sent from one machine to another by latency
+// and limits it to rateKbps kbit/s. Zero disables either. Only that
+// direction is shaped; call it again with the machines swapped to shape
+// both. Shaping is applied inside the sender with tc by kolet, so it goes
+// away when the machines are destroyed. Only qemu is supported; cloud
+// platforms return ErrShapingNotSupported.
+func (t *TestCluster) ShapeLink(from, to platform.Machine, latency time.Duration, rateKbps int) error {
+	if t.Platform() != "qemu" {
+		return ErrShapingNotSupported
+	}
+	if latency <= 0 && rateKbps <= 0 {
+		return fmt.Errorf("shaping %s to %s: no latency or rate given", from.ID(), to.ID())
+	}
+	ips := shapeAddresses(to)
+	if len(ips) == 0 {
+		return fmt.Errorf("shaping %s to %s: %s has no private address", from.ID(), to.ID(), to.ID())
+	}
+	for _, ip := range ips {
+		cmd := fmt.Sprintf("sudo ./kolet shape add %s --latency %s --rate %d", ip, latency, rateKbps)
+		if out, err := t.SSH(from, cmd); err != nil {
+			return fmt.Errorf("shaping %s to %s: %s: %v", from.ID(), to.ID(), out, err)
+		}
+	}
+	return nil
+}
+
+// shapeAddresses returns the private IPv4 and IPv6 addresses machines
+// in the cluster reach m on.
+func shapeAddresses(m platform.Machine) []string {
+	return distinctAddresses(m.PrivateIP(), m.PrivateIPv6())
+}
+
+// ClearShaping removes all shaping between machines in the cluster.
+func (t *TestCluster) ClearShaping() error {
+	if t.Platform() != "qemu" {
+		return ErrShapingNotSupported
+	}
+	for _, m := range t.Machines() {
+		if out, err := t.SSH(m, "sudo ./kolet shape clear"); err != nil {
+			return fmt.Errorf("clearing shaping on %s: %s: %v", m.ID(), out, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+)
+
+// containerAuthDir is where registry credentials for container tests are
+// placed on the machine. It is readable only by root.
+const containerAuthDir = "/run/kola/docker"
+
+// runContainer runs a container test on the first machine of the cluster,
+// logging the container's output to the test. The test fails if the
+// container exits unsuccessfully. The image is removed afterward.
+func runContainer(c cluster.TestCluster, ct *register.Container) {
+	m := c.Machines()[0]
+
+	docker := "sudo docker"
+	if ct.AuthFile != "" {
+		if err := installContainerAuth(m, ct.AuthFile); err != nil {
+			c.Fatalf("Installing registry credentials: %v", err)
+		}
+		defer c.SSH(m, "sudo rm -rf "+containerAuthDir)
+		docker += " --config " + containerAuthDir
+	}
+
+	if out, err := c.SSH(m, fmt.Sprintf("%s pull %s", docker, cluster.ShellQuote(ct.Image))); err != nil {
+		c.Fatalf("Pulling %s: %s: %v", ct.Image, out, err)
+	}
+	defer func() {
+		if out, err := c.SSH(m, fmt.Sprintf("%s rmi %s", docker, cluster.ShellQuote(ct.Image))); err != nil {
+			c.Logf("Removing %s: %s: %v", ct.Image, out, err)
+		}
+	}()
+
+	cmd := fmt.Sprintf("%s run --rm --net=host %s", docker, cluster.ShellQuote(ct.Image))
+	for _, arg := range ct.Command {
+		cmd += " " + cluster.ShellQuote(arg)
+	}
+	err := streamSSH(c, m, cmd)
+	if exit, ok := err.(*ssh.ExitError); ok {
+		c.Fatalf("Container %s exited with status %d", ct.Image, exit.ExitStatus())
+	} else if err != nil {
+		c.Fatalf("Running container %s: %v", ct.Image, err)
+	}
+}
+
+// installContainerAuth copies a local Docker config.json to the machine.
+func installContainerAuth(m platform.Machine, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := platform.InstallFile(f, m, containerAuthDir+"/config.json"); err != nil {
+		return err
+	}
+	if _, stderr, err := m.SSH("sudo chmod -R go= " + containerAuthDir); err != nil {
+		return fmt.Errorf("restricting %s: %s: %v", containerAuthDir, stderr, err)
+	}
+	return nil
+}
+
+// streamSSH runs cmd on m, logging its stdout and stderr to the test line
+// by line as they are written.
+func streamSSH(c cluster.TestCluster, m platform.Machine, cmd string) error {
+	client, err := m.SSHClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	r, w := io.Pipe()
+	session.Stdout = w
+	session.Stderr = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			c.Log(strings.TrimRight(scanner.Text(), "\r"))
+		}
+		// keep the session from blocking on a full pipe
+		io.Copy(ioutil.Discard, r)
+	}()
+
+	err = session.Run(cmd)
+	w.Close()
+	<-done
+	return err
+}
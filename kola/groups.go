@@ -0,0 +1,184 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform"
+)
+
+// groupRuns runs the setup and teardown hooks of the groups of the
+// selected tests, keyed by group name.
+type groupRuns map[string]*groupRun
+
+// groupRun tracks one group during a run. It is set up by the first of
+// its tests to start and torn down once the last has finished.
+type groupRun struct {
+	group *register.Group
+	ctx   *register.GroupContext
+
+	setup sync.Once
+	err   error
+
+	mu        sync.Mutex
+	remaining int // tests which haven't finished
+	started   bool
+	tornDown  bool
+	clusters  []platform.Cluster
+}
+
+// newGroupRuns prepares the groups of tests. It fails if a test names a
+// group that isn't registered.
+func newGroupRuns(tests map[string]*register.Test, pltfrm, outputDir string) (groupRuns, error) {
+	runs := make(groupRuns)
+	for _, t := range tests {
+		if t.Group == "" {
+			continue
+		}
+		r, ok := runs[t.Group]
+		if !ok {
+			g, ok := register.Groups[t.Group]
+			if !ok {
+				return nil, fmt.Errorf("test %v is in unregistered group %q", t.Name, t.Group)
+			}
+			r = &groupRun{
+				group: g,
+				ctx: &register.GroupContext{
+					Name:      g.Name,
+					Platform:  pltfrm,
+					OutputDir: filepath.Join(outputDir, "_groups", g.Name),
+					Values:    make(map[string]string),
+				},
+			}
+			r.ctx.NewCluster = r.newCluster
+			runs[t.Group] = r
+		}
+		r.remaining++
+	}
+	return runs, nil
+}
+
+// enter sets up the group of t the first time one of its tests starts,
+// and returns the values set up for the group or the setup error.
+func (runs groupRuns) enter(t *register.Test) (map[string]string, error) {
+	r, ok := runs[t.Group]
+	if !ok {
+		return nil, nil
+	}
+	r.setup.Do(func() {
+		r.mu.Lock()
+		r.started = true
+		r.mu.Unlock()
+
+		// a panicking Setup must still fail the group's other tests
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+			}
+		}()
+
+		plog.Noticef("Setting up group %s", r.group.Name)
+		if err := os.MkdirAll(r.ctx.OutputDir, 0777); err != nil {
+			r.err = err
+		} else if r.group.Setup != nil {
+			r.err = r.group.Setup(r.ctx)
+		}
+	})
+	if r.err != nil {
+		return nil, fmt.Errorf("setting up group %s: %v", r.group.Name, r.err)
+	}
+	return r.ctx.Values, nil
+}
+
+// leave is called once t has finished, whether or not it entered its
+// group, and tears the group down after its last test.
+func (runs groupRuns) leave(t *register.Test) {
+	r, ok := runs[t.Group]
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	r.remaining--
+	last := r.remaining == 0
+	r.mu.Unlock()
+	if last {
+		r.teardown()
+	}
+}
+
+// teardownAll tears down the groups whose tests didn't all finish, such
+// as when the run was aborted.
+func (runs groupRuns) teardownAll() {
+	for _, r := range runs {
+		r.teardown()
+	}
+}
+
+// teardown runs the Teardown hook of a group which was set up, even if
+// its setup failed, and destroys the clusters the group created.
+func (r *groupRun) teardown() {
+	r.mu.Lock()
+	if !r.started || r.tornDown {
+		r.mu.Unlock()
+		return
+	}
+	r.tornDown = true
+	r.mu.Unlock()
+
+	// wait for a setup still in progress
+	r.setup.Do(func() {})
+
+	plog.Noticef("Tearing down group %s", r.group.Name)
+	if r.group.Teardown != nil {
+		r.group.Teardown(r.ctx)
+	}
+
+	r.mu.Lock()
+	clusters := r.clusters
+	r.clusters = nil
+	r.mu.Unlock()
+	for _, c := range clusters {
+		c.Destroy()
+	}
+}
+
+// newCluster creates a cluster for the group's hooks, logging to a
+// directory of its own under the group's.
+func (r *groupRun) newCluster() (platform.Cluster, error) {
+	r.mu.Lock()
+	dir := filepath.Join(r.ctx.OutputDir, fmt.Sprintf("cluster%d", len(r.clusters)))
+	r.mu.Unlock()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	c, err := NewCluster(r.ctx.Platform, &platform.RuntimeConfig{
+		OutputDir: dir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating cluster for group %s: %v", r.group.Name, err)
+	}
+
+	r.mu.Lock()
+	r.clusters = append(r.clusters, c)
+	r.mu.Unlock()
+	return c, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+// testGroup registers a group counting its setups and teardowns, and
+// returns tests in it.
+type testGroup struct {
+	setups    int
+	teardowns int
+	setupErr  error
+	panics    bool
+}
+
+func (g *testGroup) register(name string, tests ...string) map[string]*register.Test {
+	register.Groups[name] = &register.Group{
+		Name: name,
+		Setup: func(ctx *register.GroupContext) error {
+			g.setups++
+			if g.panics {
+				panic("setup panicked")
+			}
+			ctx.Values["key"] = "value"
+			return g.setupErr
+		},
+		Teardown: func(ctx *register.GroupContext) {
+			g.teardowns++
+		},
+	}
+	m := make(map[string]*register.Test)
+	for _, test := range tests {
+		m[test] = &register.Test{Name: test, Group: name}
+	}
+	return m
+}
+
+func newTestGroupRuns(t *testing.T, tests map[string]*register.Test) (groupRuns, func()) {
+	dir, err := ioutil.TempDir("", "kola-groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := newGroupRuns(tests, "qemu", dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("newGroupRuns failed: %v", err)
+	}
+	return runs, func() {
+		for name := range runs {
+			delete(register.Groups, name)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGroupTeardownAfterLast(t *testing.T) {
+	g := &testGroup{}
+	tests := g.register("test.last", "a", "b")
+	tests["ungrouped"] = &register.Test{Name: "ungrouped"}
+	runs, cleanup := newTestGroupRuns(t, tests)
+	defer cleanup()
+
+	for _, name := range []string{"a", "b", "ungrouped"} {
+		values, err := runs.enter(tests[name])
+		if err != nil {
+			t.Fatalf("%s: enter failed: %v", name, err)
+		}
+		if name != "ungrouped" && values["key"] != "value" {
+			t.Errorf("%s: expected the group's values, got %v", name, values)
+		}
+	}
+	if g.setups != 1 {
+		t.Errorf("expected 1 setup, got %d", g.setups)
+	}
+
+	runs.leave(tests["ungrouped"])
+	runs.leave(tests["a"])
+	if g.teardowns != 0 {
+		t.Errorf("torn down before the last test finished")
+	}
+	runs.leave(tests["b"])
+	if g.teardowns != 1 {
+		t.Errorf("expected 1 teardown after the last test, got %d", g.teardowns)
+	}
+	runs.teardownAll()
+	if g.teardowns != 1 {
+		t.Errorf("teardownAll tore down a group again")
+	}
+}
+
+func TestGroupSetupFailure(t *testing.T) {
+	for _, g := range []*testGroup{
+		{setupErr: errors.New("setup failed")},
+		{panics: true},
+	} {
+		tests := g.register("test.failure", "a", "b")
+		runs, cleanup := newTestGroupRuns(t, tests)
+
+		for _, name := range []string{"a", "b"} {
+			if _, err := runs.enter(tests[name]); err == nil {
+				t.Errorf("%s: entered a group whose setup failed", name)
+			} else if g.panics && !strings.Contains(err.Error(), "setup panicked") {
+				t.Errorf("%s: expected the panic in the error, got %v", name, err)
+			}
+			runs.leave(tests[name])
+		}
+		if g.setups != 1 {
+			t.Errorf("expected 1 setup, got %d", g.setups)
+		}
+		// teardown undoes whatever setup did before failing
+		if g.teardowns != 1 {
+			t.Errorf("expected 1 teardown, got %d", g.teardowns)
+		}
+		cleanup()
+	}
+}
+
+func TestGroupTeardownAll(t *testing.T) {
+	started := &testGroup{}
+	unstarted := &testGroup{}
+	tests := started.register("test.started", "a", "b")
+	for name, test := range unstarted.register("test.unstarted", "c") {
+		tests[name] = test
+	}
+	runs, cleanup := newTestGroupRuns(t, tests)
+	defer cleanup()
+
+	// fail fast: a fails and the suite aborts before b and c run
+	if _, err := runs.enter(tests["a"]); err != nil {
+		t.Fatalf("enter failed: %v", err)
+	}
+	runs.leave(tests["a"])
+	if started.teardowns != 0 {
+		t.Errorf("torn down before b finished")
+	}
+
+	runs.teardownAll()
+	if started.teardowns != 1 {
+		t.Errorf("expected started group to be torn down once, got %d", started.teardowns)
+	}
+	if unstarted.setups != 0 || unstarted.teardowns != 0 {
+		t.Errorf("unstarted group was set up %d and torn down %d times", unstarted.setups, unstarted.teardowns)
+	}
+}
@@ -15,18 +15,24 @@
 package kola
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-semver/semver"
 	"github.com/coreos/pkg/capnslog"
+	"github.com/satori/go.uuid"
 
 	"github.com/coreos/mantle/harness"
 	"github.com/coreos/mantle/harness/reporters"
@@ -39,12 +45,14 @@ import (
 	esxapi "github.com/coreos/mantle/platform/api/esx"
 	gcloudapi "github.com/coreos/mantle/platform/api/gcloud"
 	packetapi "github.com/coreos/mantle/platform/api/packet"
+	"github.com/coreos/mantle/platform/conf"
 	"github.com/coreos/mantle/platform/machine/aws"
 	"github.com/coreos/mantle/platform/machine/do"
 	"github.com/coreos/mantle/platform/machine/esx"
 	"github.com/coreos/mantle/platform/machine/gcloud"
 	"github.com/coreos/mantle/platform/machine/packet"
 	"github.com/coreos/mantle/platform/machine/qemu"
+	"github.com/coreos/mantle/platform/machine/sshhost"
 	"github.com/coreos/mantle/system"
 )
 
@@ -58,15 +66,64 @@ var (
 	GCEOptions    = gcloudapi.Options{Options: &Options} // glue to set platform options from main
 	PacketOptions = packetapi.Options{Options: &Options} // glue to set platform options from main
 	QEMUOptions   = qemu.Options{Options: &Options}      // glue to set platform options from main
+	SSHOptions    = sshhost.Options{Options: &Options}   // glue to set platform options from main
 
 	TestParallelism   int    //glue var to set test parallelism from main
+	MaxMachines       int    // limit on machines used by parallel tests and kept clusters, 0 for unlimited
+	Retries           int    // times to retry a test after an infrastructure failure or preemption
 	TAPFile           string // if not "", write TAP results here
+	JUnitFile         string // if not "", write JUnit XML results here
 	TorcxManifestFile string // torcx manifest to expose to tests, if set
+	ResultsDB         string // if not "", record test results in this file
+
+	// SkipPassed skips tests which ResultsDB records as having passed on
+	// the same image and platform within SkipPassedWithin, or at any
+	// time if that is zero.
+	SkipPassed       bool
+	SkipPassedWithin time.Duration
+
 	// TorcxManifest is the unmarshalled torcx manifest file. It is available for
 	// tests to access via `kola.TorcxManifest`. It will be nil if there was no
 	// manifest given to kola.
 	TorcxManifest *torcx.Manifest = nil
 
+	// Capabilities are added to those of the platform, and tests
+	// requiring any of ExcludeCapabilities are skipped.
+	Capabilities        []register.Capability
+	ExcludeCapabilities []register.Capability
+
+	// KeepFailed delays the teardown of clusters of failed tests by this
+	// long, so they can be inspected, unless the release file printed for
+	// the cluster is created first. Zero disables.
+	KeepFailed time.Duration
+
+	// SnapshotOnFailure saves the boot disks of the machines of failed
+	// tests on platforms which support it. The snapshots are kept.
+	SnapshotOnFailure bool
+
+	// FailFast stops the run at the first test failure, cancelling
+	// running tests and skipping the rest.
+	FailFast bool
+
+	// NetworkMode selects IPv4/IPv6 connectivity for all clusters.
+	NetworkMode = platform.NetworkDual
+
+	// ReadinessCheck and ReadinessTimeout configure the check that
+	// machines have finished booting. Tests may replace the check.
+	ReadinessCheck   string
+	ReadinessTimeout time.Duration
+
+	// Env holds the variables given with --env, and SecretEnv those
+	// given with --secret-env. Each test sees only those it lists in
+	// register.Test.Env, and the values of SecretEnv are redacted from
+	// test output.
+	Env       map[string]string
+	SecretEnv map[string]string
+
+	// JournalExportLimit caps the size of journals saved from failed
+	// tests, 0 for unlimited.
+	JournalExportLimit int64 = 100 * 1024 * 1024
+
 	consoleChecks = []struct {
 		desc     string
 		match    *regexp.Regexp
@@ -134,6 +191,17 @@ var (
 type NativeRunner func(funcName string, m platform.Machine) error
 
 func NewCluster(pltfrm string, rconf *platform.RuntimeConfig) (cluster platform.Cluster, err error) {
+	rconf.NetworkMode = NetworkMode
+	if NetworkMode != platform.NetworkDual && pltfrm != "qemu" {
+		return nil, fmt.Errorf("network mode %s is not supported on platform %s", NetworkMode, pltfrm)
+	}
+	if len(rconf.ExtraDisks) > 0 && pltfrm != "qemu" && pltfrm != "gce" {
+		return nil, fmt.Errorf("extra disks are not supported on platform %s", pltfrm)
+	}
+	if rconf.NoIgnition && pltfrm != "aws" && pltfrm != "gce" && pltfrm != "ssh" {
+		return nil, fmt.Errorf("machines without Ignition are not supported on platform %s", pltfrm)
+	}
+
 	switch pltfrm {
 	case "aws":
 		cluster, err = aws.NewCluster(&AWSOptions, rconf)
@@ -147,6 +215,8 @@ func NewCluster(pltfrm string, rconf *platform.RuntimeConfig) (cluster platform.
 		cluster, err = packet.NewCluster(&PacketOptions, rconf)
 	case "qemu":
 		cluster, err = qemu.NewCluster(&QEMUOptions, rconf)
+	case "ssh":
+		cluster, err = sshhost.NewCluster(&SSHOptions, rconf)
 	default:
 		err = fmt.Errorf("invalid platform %q", pltfrm)
 	}
@@ -201,12 +271,23 @@ func filterTests(tests map[string]*register.Test, pattern, platform string, vers
 			continue
 		}
 
+		if !hasCapabilities(platform, t.Requires) {
+			continue
+		}
+
 		r[name] = t
 	}
 
 	return r, nil
 }
 
+// FilterTests returns the registered tests matching pattern that would be
+// run on platform. Version constraints aren't checked since they depend
+// on the image.
+func FilterTests(pattern, platform string) (map[string]*register.Test, error) {
+	return filterTests(register.Tests, pattern, platform, semver.Version{})
+}
+
 // versionOutsideRange checks to see if version is outside [min, end). If end
 // is a zero value, it is ignored and there is no upper bound. If version is a
 // zero value, the bounds are ignored.
@@ -233,6 +314,12 @@ func versionOutsideRange(version, minVersion, endVersion semver.Version) bool {
 // outputDir is where various test logs and data will be written for
 // analysis after the test run. If it already exists it will be erased!
 func RunTests(pattern, pltfrm, outputDir string) error {
+	return runTests(pattern, pltfrm, outputDir, "")
+}
+
+// runTests runs the selected tests, reporting results to the default
+// reporters and any extra ones. image is recorded in the JSON report.
+func runTests(pattern, pltfrm, outputDir, image string, extra ...reporters.Reporter) error {
 	var versionStr string
 
 	// Avoid incurring cost of starting machine in getClusterSemver when
@@ -281,25 +368,99 @@ func RunTests(pattern, pltfrm, outputDir string) error {
 		}
 	}
 
+	if err := checkCapacity(pltfrm, tests); err != nil {
+		return err
+	}
+
+	var db *resultsDB
+	dbImage := image
+	if dbImage == "" {
+		dbImage = platformImage(pltfrm)
+	}
+	if ResultsDB != "" {
+		db, err = loadResultsDB(ResultsDB)
+		if err != nil {
+			return fmt.Errorf("loading results database: %v", err)
+		}
+	} else if SkipPassed {
+		return errors.New("skipping passed tests requires a results database")
+	}
+
+	newReporters := func() reporters.Reporters {
+		reps := reporters.Reporters{
+			reporters.NewJSONReporter("report.json", pltfrm, versionStr, image),
+			reporters.NewJUnitReporter("junit.xml", "kola", pltfrm, versionStr),
+		}
+		if db != nil {
+			reps = append(reps, &dbReporter{db: db, image: dbImage, platform: pltfrm})
+		}
+		return reps
+	}
+
 	opts := harness.Options{
 		OutputDir: outputDir,
 		Parallel:  TestParallelism,
 		Verbose:   true,
-		Reporters: reporters.Reporters{
-			reporters.NewJSONReporter("report.json", pltfrm, versionStr),
-		},
+		FailFast:  FailFast,
+		Reporters: append(newReporters(), extra...),
 	}
-	var htests harness.Tests
-	for _, test := range tests {
-		test := test // for the closure
-		run := func(h *harness.H) {
-			runTest(h, test, pltfrm)
+	for _, value := range SecretEnv {
+		opts.Redact = append(opts.Redact, value)
+	}
+	sched := newScheduler(MaxMachines)
+	defer sched.waitKept()
+	start := time.Now()
+	err = runSuite(opts, tests, pltfrm, sched, db, dbImage)
+
+	// a preempted machine fails its test without any fault of the OS, so
+	// such tests are run again in a suite of their own
+	for attempt := 1; attempt <= Retries && err != nil && err != harness.SuiteAborted; attempt++ {
+		rerun := make(map[string]*register.Test)
+		for _, name := range sched.preempted.names() {
+			rerun[name] = tests[name]
+			sched.preempted.delete(name)
+		}
+		if len(rerun) == 0 {
+			break
+		}
+		// the run only passes if every failure is redeemed
+		redeemable := len(rerun) == len(sched.failed.names())
+		for name := range rerun {
+			sched.failed.delete(name)
+		}
+
+		ropts := opts
+		ropts.OutputDir = filepath.Join(outputDir, fmt.Sprintf("rerun%d", attempt))
+		ropts.Reporters = newReporters()
+		fmt.Printf("Rerunning %d tests whose machines were preempted, output in %v\n", len(rerun), ropts.OutputDir)
+		rerr := runSuite(ropts, rerun, pltfrm, sched, db, dbImage)
+		if redeemable {
+			err = rerr
 		}
-		htests.Add(test.Name, run)
 	}
 
-	suite := harness.NewSuite(opts, htests)
-	err = suite.Run()
+	if wall := time.Since(start); wall > 0 {
+		fmt.Printf("Test time %v, wall-clock time %v (%.1fx with parallelism %d)\n",
+			sched.times.total, wall, float64(sched.times.total)/float64(wall), TestParallelism)
+	}
+	for _, name := range sched.retried.names() {
+		fmt.Printf("%s needed %d attempts\n", name, sched.retried.get(name))
+	}
+	for _, name := range sched.preempted.names() {
+		fmt.Printf("%s failed after %d machines were preempted\n", name, sched.preempted.get(name))
+	}
+	for _, name := range sched.maintained.names() {
+		fmt.Printf("%s failed after %d host maintenance events, rerun it\n", name, sched.maintained.get(name))
+	}
+
+	if db != nil {
+		if err2 := db.save(); err2 != nil {
+			plog.Errorf("Saving results database: %v", err2)
+			if err == nil {
+				err = err2
+			}
+		}
+	}
 
 	if TAPFile != "" {
 		src := filepath.Join(outputDir, "test.tap")
@@ -308,7 +469,16 @@ func RunTests(pattern, pltfrm, outputDir string) error {
 		}
 	}
 
-	if err != nil {
+	if JUnitFile != "" {
+		src := filepath.Join(outputDir, "reports", "junit.xml")
+		if err2 := system.CopyRegularFile(src, JUnitFile); err == nil && err2 != nil {
+			err = err2
+		}
+	}
+
+	if err == harness.SuiteAborted {
+		fmt.Printf("FAIL, aborted after the first failure, output in %v\n", outputDir)
+	} else if err != nil {
 		fmt.Printf("FAIL, output in %v\n", outputDir)
 	} else {
 		fmt.Printf("PASS, output in %v\n", outputDir)
@@ -317,6 +487,81 @@ func RunTests(pattern, pltfrm, outputDir string) error {
 	return err
 }
 
+// runSuite runs tests in a harness suite and records which of them fail
+// in sched.
+func runSuite(opts harness.Options, tests map[string]*register.Test, pltfrm string, sched *scheduler, db *resultsDB, dbImage string) error {
+	groups, err := newGroupRuns(tests, pltfrm, opts.OutputDir)
+	if err != nil {
+		return err
+	}
+	var htests harness.Tests
+	for _, test := range tests {
+		test := test // for the closure
+		run := func(h *harness.H) {
+			defer groups.leave(test)
+			defer func() {
+				if h.Failed() {
+					sched.failed.set(test.Name, 1)
+				}
+			}()
+			if SkipPassed && db.passed(test.Name, dbImage, pltfrm, SkipPassedWithin) {
+				h.Skip("already passed according to results database")
+			}
+			runTest(h, test, pltfrm, sched, groups)
+		}
+		htests.Add(test.Name, run)
+	}
+
+	suite := harness.NewSuite(opts, htests)
+	err = suite.Run()
+	groups.teardownAll()
+	return err
+}
+
+// checkCapacity fails if the platform doesn't have enough quota left to
+// run the selected tests at the requested parallelism, so runs fail
+// before launching any machines rather than midway through.
+func checkCapacity(pltfrm string, tests map[string]*register.Test) error {
+	size := 0
+	for _, t := range tests {
+		if t.ClusterSize > size {
+			size = t.ClusterSize
+		}
+	}
+	needed := TestParallelism * size
+	if MaxMachines > 0 && needed > MaxMachines {
+		needed = MaxMachines
+	}
+	if needed == 0 {
+		return nil
+	}
+
+	var checker interface {
+		CheckCapacity(platform.ResourceRequest) error
+	}
+	var err error
+	switch pltfrm {
+	case "aws":
+		checker, err = awsapi.New(&AWSOptions)
+	case "gce":
+		checker, err = gcloudapi.New(&GCEOptions)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checker.CheckCapacity(platform.ResourceRequest{Instances: needed})
+	if _, ok := err.(*platform.CapacityError); ok {
+		return fmt.Errorf("%d machines needed: %v", needed, err)
+	} else if err != nil {
+		// don't block runs on accounts that can't read quotas
+		plog.Warningf("Skipping capacity check: %v", err)
+	}
+	return nil
+}
+
 // getClusterSemVer returns the CoreOS semantic version via starting a
 // machine and checking
 func getClusterSemver(pltfrm, outputDir string) (*semver.Version, error) {
@@ -356,9 +601,17 @@ func getClusterSemver(pltfrm, outputDir string) (*semver.Version, error) {
 // runTest is a harness for running a single test.
 // outputDir is where various test logs and data will be written for
 // analysis after the test run. It should already exist.
-func runTest(h *harness.H, t *register.Test, pltfrm string) {
+func runTest(h *harness.H, t *register.Test, pltfrm string, sched *scheduler, groups groupRuns) {
 	h.Parallel()
 
+	groupValues, err := groups.enter(t)
+	if err != nil {
+		h.Fatalf("Group setup failed: %v", err)
+	}
+
+	res := sched.start(t)
+	defer res.done()
+
 	// don't go too fast, in case we're talking to a rate limiting api like AWS EC2.
 	// FIXME(marineam): API requests must do their own
 	// backoff due to rate limiting, this is unreliable.
@@ -366,44 +619,87 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 	splay := time.Duration(rand.Int63n(max))
 	time.Sleep(splay)
 
+	if t.UserData != nil {
+		if err := t.UserData.Validate(); err != nil {
+			h.Fatalf("Test userdata is invalid: %v", err)
+		}
+	}
+
 	rconf := &platform.RuntimeConfig{
 		OutputDir:          h.OutputDir(),
 		NoSSHKeyInUserData: t.HasFlag(register.NoSSHKeyInUserData),
 		NoSSHKeyInMetadata: t.HasFlag(register.NoSSHKeyInMetadata),
 		NoEnableSelinux:    t.HasFlag(register.NoEnableSelinux),
+		CPUs:               t.CPUs,
+		Memory:             t.Memory,
+		ExtraDisks:         t.ExtraDisks,
+		Ports:              t.Ports,
+		ReadinessCheck:     ReadinessCheck,
+		ReadinessTimeout:   ReadinessTimeout,
+		NoIgnition:         t.HasFlag(register.NoIgnition),
+		Connectivity:       t.Connectivity,
+		SSHUser:            t.SSHUser,
 	}
-	c, err := NewCluster(pltfrm, rconf)
-	if err != nil {
-		h.Fatalf("Cluster failed: %v", err)
+	if t.ReadinessCheck != "" {
+		rconf.ReadinessCheck = t.ReadinessCheck
 	}
+	var c platform.Cluster
 	defer func() {
-		c.Destroy()
-		for id, output := range c.ConsoleOutput() {
-			for _, badness := range CheckConsole([]byte(output), t) {
-				h.Errorf("Found %s on machine %s console", badness, id)
-			}
+		if c == nil {
+			return
+		}
+		checkMaintenance(h, c, t, sched)
+		if h.Failed() {
+			checkPreempted(h, c, t, sched)
 		}
+		if h.Failed() && SnapshotOnFailure {
+			snapshotMachines(h, c)
+		}
+		if h.Failed() && KeepFailed > 0 {
+			// the test is over by the time the cluster is destroyed,
+			// so check the console output gathered so far
+			checkConsoles(c, t, h.Errorf)
+			keepCluster(h, c, t, sched, res.keep())
+			return
+		}
+		c.Destroy()
+		checkConsoles(c, t, h.Errorf)
 	}()
 
-	if t.ClusterSize > 0 {
-		userdata := t.UserData
-		if userdata != nil && userdata.Contains("$discovery") {
-			url, err := c.GetDiscoveryURL(t.ClusterSize)
-			if err != nil {
-				// Skip instead of failing since the harness not being able to
-				// get a discovery url is likely an outage (e.g
-				// 503 Service Unavailable: Back-end server is at capacity)
-				// not a problem with the OS
-				h.Skipf("Failed to create discovery endpoint: %v", err)
+	// failures setting up the cluster are retried if the platform
+	// attributes them to the infrastructure
+	for attempt := 1; ; attempt++ {
+		var msg string
+		var err error
+		if c, err = NewCluster(pltfrm, rconf); err != nil {
+			msg = "Cluster failed"
+		} else if err = startMachines(h, c, t); err != nil {
+			msg = "Cluster failed starting machines"
+		} else {
+			if attempt > 1 {
+				h.Logf("Cluster started after %d attempts", attempt)
+				sched.retried.set(t.Name, attempt)
 			}
-			userdata = userdata.Subst("$discovery", url)
+			break
 		}
 
-		if _, err := platform.NewMachines(c, userdata, t.ClusterSize); err != nil {
-			h.Fatalf("Cluster failed starting machines: %v", err)
+		if !platform.IsInfraError(err) || attempt > Retries {
+			h.Fatalf("%s: %v", msg, err)
+		}
+		h.Logf("%s on attempt %d, retrying: %v", msg, attempt, err)
+		if c != nil {
+			c.Destroy()
+			c = nil
 		}
 	}
 
+	// save the full journal of each machine if the test fails
+	defer func() {
+		if h.Failed() && !rconf.NoIgnition {
+			collectJournals(h, c)
+		}
+	}()
+
 	// pass along all registered native functions
 	var names []string
 	for k := range t.NativeFuncs {
@@ -415,6 +711,19 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 		H:           h,
 		Cluster:     c,
 		NativeFuncs: names,
+		GroupValues: groupValues,
+	}
+	for _, key := range t.Env {
+		value, ok := Env[key]
+		if !ok {
+			value, ok = SecretEnv[key]
+		}
+		if ok {
+			if tcluster.Env == nil {
+				tcluster.Env = make(map[string]string)
+			}
+			tcluster.Env[key] = value
+		}
 	}
 
 	// drop kolet binary on machines
@@ -422,6 +731,12 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 		scpKolet(tcluster, architecture(pltfrm))
 	}
 
+	for _, a := range t.Artifacts {
+		if err := stageArtifact(tcluster, a); err != nil {
+			h.Fatalf("Staging artifact %s: %v", a.Source, err)
+		}
+	}
+
 	defer func() {
 		// give some time for the remote journal to be flushed so it can be read
 		// before we run the deferred machine destruction
@@ -429,7 +744,224 @@ func runTest(h *harness.H, t *register.Test, pltfrm string) {
 	}()
 
 	// run test
-	t.Run(tcluster)
+	if t.Container != nil {
+		runContainer(tcluster, t.Container)
+	} else {
+		t.Run(tcluster)
+	}
+}
+
+// checkPreempted reports which machines of a failed test were preempted
+// by the platform, in which case the failure is probably not the fault of
+// the test and it should be run again.
+func checkPreempted(h *harness.H, c platform.Cluster, t *register.Test, sched *scheduler) {
+	n := 0
+	for _, m := range c.Machines() {
+		pm, ok := m.(platform.PreemptibleMachine)
+		if !ok {
+			continue
+		}
+		reason, err := pm.Preempted()
+		if err != nil {
+			h.Logf("Checking preemption of %s failed: %v", m.ID(), err)
+		} else if reason != "" {
+			h.Logf("Machine %s was %s", m.ID(), reason)
+			n++
+		}
+	}
+	if n > 0 {
+		sched.preempted.set(t.Name, n)
+	}
+}
+
+// checkMaintenance logs the host maintenance events which affected the
+// machines of a test, such as live migrations, which can disrupt it
+// without any fault of the OS. Failed tests with such events are called
+// out in the summary.
+func checkMaintenance(h *harness.H, c platform.Cluster, t *register.Test, sched *scheduler) {
+	n := 0
+	for _, m := range c.Machines() {
+		mm, ok := m.(platform.MaintenanceMachine)
+		if !ok {
+			continue
+		}
+		events, err := mm.MaintenanceEvents()
+		if err != nil {
+			h.Logf("Checking maintenance events of %s failed: %v", m.ID(), err)
+		}
+		for _, event := range events {
+			h.Logf("Machine %s was %s", m.ID(), event)
+			n++
+		}
+	}
+	if n > 0 && h.Failed() {
+		sched.maintained.set(t.Name, n)
+	}
+}
+
+// snapshotMachines saves the boot disks of the machines of a failed test
+// for offline inspection.
+func snapshotMachines(h *harness.H, c platform.Cluster) {
+	description := fmt.Sprintf("kola test %s failed at %s", h.Name(), time.Now().UTC().Format(time.RFC3339))
+	for _, m := range c.Machines() {
+		sm, ok := m.(platform.SnapshotMachine)
+		if !ok {
+			h.Logf("Can't snapshot machine %s on this platform", m.ID())
+			continue
+		}
+		id, err := sm.Snapshot(description)
+		if err != nil {
+			h.Logf("Snapshotting machine %s failed: %v", m.ID(), err)
+			continue
+		}
+		h.Logf("Saved the disk of machine %s as snapshot %s", m.ID(), id)
+	}
+}
+
+// checkConsoles reports badness in the console output of the machines of
+// a cluster.
+func checkConsoles(c platform.Cluster, t *register.Test, report func(format string, args ...interface{})) {
+	for id, output := range c.ConsoleOutput() {
+		for _, badness := range CheckConsole([]byte(output), t) {
+			report("Found %s on machine %s console", badness, id)
+		}
+	}
+}
+
+// keepReleasePoll is how often keepCluster checks for its release file.
+const keepReleasePoll = 5 * time.Second
+
+// keepCluster leaves a failed test's cluster running for KeepFailed, or
+// until a release file in the test's output directory is created, and
+// then destroys it in the background and calls done, so that its machines
+// count against the quota until they are gone.
+func keepCluster(h *harness.H, c platform.Cluster, t *register.Test, sched *scheduler, done func()) {
+	expiry := time.Now().Add(KeepFailed)
+	release := filepath.Join(h.OutputDir(), "release")
+	var sock string
+	if a, ok := c.(interface {
+		AgentSocket() string
+	}); ok {
+		sock = a.AgentSocket()
+	}
+	for _, m := range c.Machines() {
+		if Options.SSHKeyFile != "" {
+			fmt.Printf("%s: kept machine %s until %s: ssh -i %s core@%s\n",
+				h.Name(), m.ID(), expiry.Format(time.RFC3339), Options.SSHKeyFile, m.IP())
+		} else if sock != "" {
+			// the agent is kept with the cluster while kola runs
+			fmt.Printf("%s: kept machine %s until %s: SSH_AUTH_SOCK=%s ssh core@%s\n",
+				h.Name(), m.ID(), expiry.Format(time.RFC3339), sock, m.IP())
+		} else {
+			fmt.Printf("%s: kept machine %s (%s) until %s\n",
+				h.Name(), m.ID(), m.IP(), expiry.Format(time.RFC3339))
+		}
+	}
+
+	fmt.Printf("%s: touch %s to destroy the kept cluster sooner\n", h.Name(), release)
+
+	name := h.Name()
+	sched.kept.Add(1)
+	go func() {
+		defer sched.kept.Done()
+		timer := time.NewTimer(KeepFailed)
+		defer timer.Stop()
+		ticker := time.NewTicker(keepReleasePoll)
+		defer ticker.Stop()
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-ticker.C:
+				if _, err := os.Stat(release); err == nil {
+					break wait
+				}
+			}
+		}
+		plog.Noticef("Destroying kept cluster of %s", name)
+		c.Destroy()
+		done()
+		checkConsoles(c, t, func(format string, args ...interface{}) {
+			plog.Warningf("%s: "+format, append([]interface{}{name}, args...)...)
+		})
+	}()
+}
+
+// collectJournals saves the journal of every machine in the cluster to the
+// test's output directory. This is best-effort; errors are only logged.
+func collectJournals(h *harness.H, c platform.Cluster) {
+	for _, m := range c.Machines() {
+		dir := filepath.Join(h.OutputDir(), m.ID())
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			plog.Errorf("Creating journal directory for %s: %v", m.ID(), err)
+			continue
+		}
+		path := filepath.Join(dir, "journal-export.gz")
+		if err := platform.SaveJournalExport(m, path, JournalExportLimit); err != nil {
+			plog.Errorf("Saving journal of %s: %v", m.ID(), err)
+		}
+	}
+}
+
+// startMachines starts the test's initial machines.
+func startMachines(h *harness.H, c platform.Cluster, t *register.Test) error {
+	if t.ClusterSize == 0 {
+		return nil
+	}
+
+	userdata := t.UserData
+	if userdata != nil && userdata.Contains("$discovery") {
+		url, err := c.GetDiscoveryURL(t.ClusterSize)
+		if err != nil {
+			// Skip instead of failing since the harness not being able to
+			// get a discovery url is likely an outage (e.g
+			// 503 Service Unavailable: Back-end server is at capacity)
+			// not a problem with the OS
+			h.Skipf("Failed to create discovery endpoint: %v", err)
+		}
+		userdata = userdata.Subst("$discovery", url)
+	}
+
+	// $machine_index and $cluster_token are known before boot; peer
+	// addresses are only known afterward so they're passed separately
+	token := uuid.NewV4().String()
+	machines, err := platform.NewIndexedMachines(c, t.ClusterSize, func(i int) *conf.UserData {
+		if userdata == nil {
+			return nil
+		}
+		return userdata.Subst("$machine_index", strconv.Itoa(i)).Subst("$cluster_token", token)
+	})
+	if err != nil {
+		return err
+	}
+
+	if t.HasFlag(register.ClusterEnvironment) {
+		return writeClusterEnv(machines, token)
+	}
+	return nil
+}
+
+// clusterEnvPath is where writeClusterEnv places the cluster environment.
+const clusterEnvPath = "/etc/kola/cluster.env"
+
+// writeClusterEnv writes a systemd environment file to each machine
+// describing its index and its peers, in the same order on every machine.
+func writeClusterEnv(machines []platform.Machine, token string) error {
+	var public, private []string
+	for _, m := range machines {
+		public = append(public, m.IP())
+		private = append(private, m.PrivateIP())
+	}
+
+	for i, m := range machines {
+		env := fmt.Sprintf("KOLA_MACHINE_INDEX=%d\nKOLA_CLUSTER_SIZE=%d\nKOLA_CLUSTER_TOKEN=%s\nKOLA_PEER_PUBLIC_IPS=%q\nKOLA_PEER_PRIVATE_IPS=%q\n",
+			i, len(machines), token, strings.Join(public, " "), strings.Join(private, " "))
+		if err := platform.InstallFile(strings.NewReader(env), m, clusterEnvPath); err != nil {
+			return platform.NewInfraError(fmt.Errorf("writing %s to %s: %v", clusterEnvPath, m.ID(), err))
+		}
+	}
+	return nil
 }
 
 // architecture returns the machine architecture of the given platform.
@@ -444,6 +976,42 @@ func architecture(pltfrm string) string {
 	return nativeArch
 }
 
+// capabilities returns the capabilities of the given platform, including
+// those added from the command line.
+func capabilities(pltfrm string) map[register.Capability]bool {
+	caps := make(map[register.Capability]bool)
+	switch pltfrm {
+	case "aws", "do", "esx", "gce":
+		caps[register.Internet] = true
+	case "packet":
+		caps[register.Internet] = true
+		caps[register.NestedVirt] = true
+		caps[register.ManyCPUs] = true
+	case "qemu":
+		caps[register.NestedVirt] = QEMUOptions.NestedVirt
+		caps[register.ManyCPUs] = runtime.NumCPU() > 4
+	}
+	for _, c := range Capabilities {
+		caps[c] = true
+	}
+	for _, c := range ExcludeCapabilities {
+		caps[c] = false
+	}
+	return caps
+}
+
+// hasCapabilities reports whether the given platform has all of the
+// required capabilities.
+func hasCapabilities(pltfrm string, required []register.Capability) bool {
+	caps := capabilities(pltfrm)
+	for _, c := range required {
+		if !caps[c] {
+			return false
+		}
+	}
+	return true
+}
+
 // returns the arch part of an sdk board name
 func boardToArch(board string) string {
 	return strings.SplitN(board, "-", 2)[0]
@@ -468,6 +1036,65 @@ func scpKolet(c cluster.TestCluster, mArch string) {
 	c.Fatalf("Unable to locate kolet binary for %s", mArch)
 }
 
+// stageArtifact copies an artifact to every machine in the cluster. Local
+// files are copied over SSH while URLs are downloaded on the machine.
+func stageArtifact(c cluster.TestCluster, a register.Artifact) error {
+	if !strings.HasPrefix(a.Destination, "/") {
+		return fmt.Errorf("destination %q is not an absolute path", a.Destination)
+	}
+	remote := strings.HasPrefix(a.Source, "http://") || strings.HasPrefix(a.Source, "https://")
+
+	var in *os.File
+	if !remote {
+		var err error
+		in, err = os.Open(a.Source)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		if a.SHA256 != "" {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, in); err != nil {
+				return err
+			}
+			if sum := hex.EncodeToString(hash.Sum(nil)); sum != strings.ToLower(a.SHA256) {
+				return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, a.SHA256)
+			}
+		}
+	}
+
+	for _, m := range c.Machines() {
+		if remote {
+			cmd := fmt.Sprintf("sudo mkdir -p %s && sudo curl -fsSL --retry 3 -o %s %s",
+				cluster.ShellQuote(filepath.Dir(a.Destination)),
+				cluster.ShellQuote(a.Destination), cluster.ShellQuote(a.Source))
+			if out, err := c.SSH(m, cmd); err != nil {
+				return fmt.Errorf("downloading on %s: %v: %s", m.ID(), err, out)
+			}
+			if a.SHA256 != "" {
+				out, err := c.SSH(m, "sha256sum "+cluster.ShellQuote(a.Destination))
+				if err != nil {
+					return fmt.Errorf("checksumming on %s: %v: %s", m.ID(), err, out)
+				}
+				fields := strings.Fields(string(out))
+				if len(fields) == 0 || fields[0] != strings.ToLower(a.SHA256) {
+					return fmt.Errorf("checksum mismatch on %s: got %q, expected %s", m.ID(), out, a.SHA256)
+				}
+			}
+			continue
+		}
+
+		if _, err := in.Seek(0, 0); err != nil {
+			return err
+		}
+		if err := platform.InstallFile(in, m, a.Destination); err != nil {
+			return fmt.Errorf("copying to %s: %v", m.ID(), err)
+		}
+	}
+	return nil
+}
+
 // CheckConsole checks some console output for badness and returns short
 // descriptions of any badness it finds. If t is specified, its flags are
 // respected.
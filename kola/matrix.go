@@ -0,0 +1,207 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/mantle/harness"
+	"github.com/coreos/mantle/harness/testresult"
+	awsapi "github.com/coreos/mantle/platform/api/aws"
+	gcloudapi "github.com/coreos/mantle/platform/api/gcloud"
+)
+
+// gceFamilyPrefix marks GCE images given as an image family, which are
+// resolved to the family's latest image.
+const gceFamilyPrefix = "family/"
+
+// RunImageMatrix runs the selected tests once for each image, with the
+// output for each image in its own subdirectory of outputDir, and prints
+// the results of each test side by side. TAPFile and JUnitFile are
+// written once per image, with an -image-N suffix.
+func RunImageMatrix(pattern, pltfrm, outputDir string, images []string) error {
+	tapFile, junitFile := TAPFile, JUnitFile
+	defer func() {
+		TAPFile, JUnitFile = tapFile, junitFile
+	}()
+
+	resolvedImages := make([]string, len(images))
+	results := make([]*resultCollector, len(images))
+	var failed []string
+	for i, image := range images {
+		resolved, err := setImage(pltfrm, image)
+		if err != nil {
+			return err
+		}
+		if resolved != image {
+			plog.Noticef("Resolved image %s to %s", image, resolved)
+		}
+		resolvedImages[i] = resolved
+
+		results[i] = &resultCollector{results: make(map[string]testresult.TestResult)}
+		dir := filepath.Join(outputDir, fmt.Sprintf("image-%d", i))
+		TAPFile, JUnitFile = imageResultFile(tapFile, i), imageResultFile(junitFile, i)
+		fmt.Printf("=== Image %s\n", resolved)
+		err = runTests(pattern, pltfrm, dir, resolved, results[i])
+		if err != nil {
+			failed = append(failed, resolved)
+		}
+		if err == harness.SuiteAborted {
+			// leave the remaining images out of the matrix
+			resolvedImages = resolvedImages[:i+1]
+			results = results[:i+1]
+			break
+		}
+	}
+
+	printMatrix(resolvedImages, results)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed on images: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// imageResultFile returns the name of a results file for the i-th image,
+// e.g. results-image-0.xml for results.xml, or "" if path is "".
+func imageResultFile(path string, i int) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-image-%d%s", strings.TrimSuffix(path, ext), i, ext)
+}
+
+// setImage makes image the one launched by the platform, and returns
+// the image resolved by the platform's usual rules.
+func setImage(pltfrm, image string) (string, error) {
+	switch pltfrm {
+	case "aws":
+		AWSOptions.AMI = image
+		// resolves release channels to AMIs
+		if _, err := awsapi.New(&AWSOptions); err != nil {
+			return "", err
+		}
+		return AWSOptions.AMI, nil
+	case "do":
+		DOOptions.Image = image
+	case "esx":
+		ESXOptions.BaseVMName = image
+	case "gce":
+		if strings.HasPrefix(image, gceFamilyPrefix) {
+			// the image option isn't valid until resolved, so
+			// look up the family with a copy of the options
+			opts := GCEOptions
+			opts.Image = ""
+			api, err := gcloudapi.New(&opts)
+			if err != nil {
+				return "", err
+			}
+			latest, err := api.GetLatestImage(strings.TrimPrefix(image, gceFamilyPrefix))
+			if err != nil {
+				return "", err
+			}
+			image = latest.SelfLink
+		}
+		GCEOptions.Image = image
+	case "packet":
+		PacketOptions.ImageURL = image
+	case "qemu":
+		QEMUOptions.DiskImage = image
+	default:
+		return "", fmt.Errorf("platform %s doesn't support running against multiple images", pltfrm)
+	}
+	return image, nil
+}
+
+// platformImage returns the image the platform is configured to launch.
+func platformImage(pltfrm string) string {
+	switch pltfrm {
+	case "aws":
+		return AWSOptions.AMI
+	case "do":
+		return DOOptions.Image
+	case "esx":
+		return ESXOptions.BaseVMName
+	case "gce":
+		return GCEOptions.Image
+	case "packet":
+		return PacketOptions.ImageURL
+	case "qemu":
+		return QEMUOptions.DiskImage
+	default:
+		return ""
+	}
+}
+
+// printMatrix prints a table of test results, with a column per image.
+func printMatrix(images []string, results []*resultCollector) {
+	names := make(map[string]bool)
+	for _, r := range results {
+		for name := range r.results {
+			names[name] = true
+		}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprint(w, "TEST")
+	for i, image := range images {
+		fmt.Fprintf(w, "\t[%d] %s", i, filepath.Base(image))
+	}
+	fmt.Fprintln(w)
+	for _, name := range sorted {
+		fmt.Fprint(w, name)
+		for _, r := range results {
+			result, ok := r.results[name]
+			if !ok {
+				result = "-"
+			}
+			fmt.Fprintf(w, "\t%s", result)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// resultCollector is a harness reporter remembering the result of each
+// test.
+type resultCollector struct {
+	mu      sync.Mutex
+	results map[string]testresult.TestResult
+}
+
+func (r *resultCollector) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[name] = result
+}
+
+func (r *resultCollector) Output(path string) error {
+	return nil
+}
+
+func (r *resultCollector) SetResult(result testresult.TestResult) {}
@@ -20,6 +20,7 @@ import (
 	"github.com/coreos/go-semver/semver"
 
 	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/platform/conf"
 )
 
@@ -30,8 +31,73 @@ const (
 	NoSSHKeyInMetadata                // don't add SSH key to platform metadata
 	NoEmergencyShellCheck             // don't check console output for emergency shell invocation
 	NoEnableSelinux                   // don't enable selinux when starting or rebooting a machine
+	ClusterEnvironment                // write the cluster's addresses to /etc/kola/cluster.env after boot
+	NoIgnition                        // machines don't run Container Linux or Ignition, see Test.UserData
 )
 
+// Capability is a property of the test environment that a test may
+// depend on.
+type Capability string
+
+const (
+	NestedVirt Capability = "nested-virt" // guests can run hardware-accelerated VMs
+	Internet   Capability = "internet"    // guests have outbound internet access
+	ManyCPUs   Capability = "many-cpus"   // guests can have more than 4 vCPUs
+)
+
+// Capabilities lists all known capabilities.
+var Capabilities = []Capability{NestedVirt, Internet, ManyCPUs}
+
+// Artifact is a file staged onto every machine of a test's initial
+// cluster before the test runs.
+type Artifact struct {
+	Source      string // local path, or http(s) URL fetched on the machine
+	Destination string // absolute path on the machine
+	SHA256      string // expected hex-encoded SHA-256 checksum, optional
+}
+
+// Container is an OCI image run with the OS's container runtime as the
+// body of a test. The test passes if the container exits successfully.
+type Container struct {
+	Image   string   // image to pull and run
+	Command []string // command to run in the container, default is the image's
+
+	// AuthFile is a local Docker config.json holding credentials for
+	// pulling Image, optional.
+	AuthFile string
+}
+
+// Group is setup shared by the tests which name it in their Group, such
+// as a local service their machines use, so each test doesn't repeat it.
+type Group struct {
+	Name string // should be unique
+
+	// Setup runs once before the first of the group's tests that kola
+	// runs. If it fails, the group's tests fail without running.
+	Setup func(*GroupContext) error
+
+	// Teardown runs once after the last of the group's tests has
+	// finished. It also runs if Setup failed, so it must cope with
+	// whatever Setup left behind.
+	Teardown func(*GroupContext)
+}
+
+// GroupContext is passed to the hooks of a Group.
+type GroupContext struct {
+	Name      string
+	Platform  string
+	OutputDir string // where the group's clusters write their logs
+
+	// NewCluster creates a cluster on the platform kola is testing,
+	// for services the group's tests use. Clusters are destroyed after
+	// Teardown.
+	NewCluster func() (platform.Cluster, error)
+
+	// Values set by Setup are available to the group's tests through
+	// TestCluster.GroupValue.
+	Values map[string]string
+}
+
 // Test provides the main test abstraction for kola. The run function is
 // the actual testing function while the other fields provide ways to
 // statically declare state of the platform.TestCluster before the test
@@ -42,10 +108,55 @@ type Test struct {
 	NativeFuncs      map[string]func() error
 	UserData         *conf.UserData
 	ClusterSize      int
-	Platforms        []string // whitelist of platforms to run test against -- defaults to all
-	ExcludePlatforms []string // blacklist of platforms to ignore -- defaults to none
-	Architectures    []string // whitelist of machine architectures supported -- defaults to all
-	Flags            []Flag   // special-case options for this test
+	Platforms        []string     // whitelist of platforms to run test against -- defaults to all
+	ExcludePlatforms []string     // blacklist of platforms to ignore -- defaults to none
+	Architectures    []string     // whitelist of machine architectures supported -- defaults to all
+	Flags            []Flag       // special-case options for this test
+	Requires         []Capability // capabilities the test environment must have
+	Group            string       // name of the registered Group the test belongs to, optional
+
+	// Artifacts are copied to the machines before the test runs.
+	Artifacts []Artifact
+
+	// Container, if set, is run on the first machine of the cluster
+	// instead of Run, with its output logged to the test.
+	Container *Container
+
+	// ExclusiveResources names shared resources, such as a fixed IP
+	// or a DNS record, that the test must not use concurrently with
+	// other tests.
+	ExclusiveResources []string
+
+	// ReadinessCheck, if set, replaces the command which must succeed
+	// on each machine before the test starts.
+	ReadinessCheck string
+
+	// CPUs and Memory (in MiB) size the machines on platforms that
+	// support it, currently only qemu. Zero uses the platform default.
+	CPUs   int
+	Memory int
+
+	// ExtraDisks are blank disks attached to each machine, currently
+	// on gce and qemu. Their device paths are available from machines
+	// implementing platform.DiskMachine.
+	ExtraDisks []platform.DiskSpec
+
+	// Ports are inbound TCP ports the test needs reachable on its
+	// machines, from the test controller and from each other. On gce,
+	// kola opens them with a firewall rule for the cluster.
+	Ports []int
+
+	// Env lists the kola --env and --secret-env variables the test may read, through
+	// TestCluster.Getenv and TestCluster.SSHWithEnv. Tests can't see
+	// variables they don't list.
+	Env []string
+
+	// SSHUser and Connectivity say how to reach the machines of
+	// NoIgnition tests, by default as core over SSH. NoIgnition tests
+	// run on aws, gce and ssh, get their UserData verbatim (see
+	// conf.Raw), and can't use native functions or containers.
+	SSHUser      string
+	Connectivity platform.Connectivity
 
 	// MinVersion prevents the test from executing on CoreOS machines
 	// less than MinVersion. This will be ignored if the name fully
@@ -61,6 +172,21 @@ type Test struct {
 // Registered tests live here. Mapping of names to tests.
 var Tests = map[string]*Test{}
 
+// Registered groups live here. Mapping of names to groups.
+var Groups = map[string]*Group{}
+
+// RegisterGroup is called in init() functions like Register. Panics if
+// existing name is registered.
+func RegisterGroup(g *Group) {
+	if _, ok := Groups[g.Name]; ok {
+		panic(fmt.Sprintf("group %v already registered", g.Name))
+	}
+	if g.Setup == nil && g.Teardown == nil {
+		panic(fmt.Sprintf("group %v has no setup or teardown", g.Name))
+	}
+	Groups[g.Name] = g
+}
+
 // Register is usually called in init() functions and is how kola test
 // harnesses knows which tests it can choose from. Panics if existing
 // name is registered
@@ -74,6 +200,35 @@ func Register(t *Test) {
 		panic(fmt.Sprintf("test %v has an invalid version range", t.Name))
 	}
 
+	if t.Container != nil {
+		if t.Run != nil {
+			panic(fmt.Sprintf("test %v has both a container and a run function", t.Name))
+		}
+		if t.Container.Image == "" || t.ClusterSize < 1 {
+			panic(fmt.Sprintf("test %v needs a container image and at least one machine", t.Name))
+		}
+	}
+
+	if t.HasFlag(NoIgnition) {
+		if t.NativeFuncs != nil || t.Container != nil || t.HasFlag(ClusterEnvironment) {
+			panic(fmt.Sprintf("test %v needs Container Linux but doesn't use Ignition", t.Name))
+		}
+	} else if t.SSHUser != "" || t.Connectivity != "" || (t.UserData != nil && t.UserData.IsRaw()) {
+		panic(fmt.Sprintf("test %v launches non-Container Linux machines without the NoIgnition flag", t.Name))
+	}
+
+	for _, d := range t.ExtraDisks {
+		if d.SizeGB < 1 {
+			panic(fmt.Sprintf("test %v has an extra disk smaller than 1 GiB", t.Name))
+		}
+	}
+
+	for _, p := range t.Ports {
+		if p < 1 || p > 65535 {
+			panic(fmt.Sprintf("test %v needs invalid port %d", t.Name, p))
+		}
+	}
+
 	Tests[t.Name] = t
 }
 
@@ -0,0 +1,161 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+// resultsDB is the file recording the latest result of each test on
+// each image and platform, so later runs can skip tests that passed.
+type resultsDB struct {
+	path string
+
+	mu      sync.Mutex
+	results map[resultKey]dbResult
+}
+
+type resultKey struct {
+	Test     string `json:"test"`
+	Image    string `json:"image"`
+	Platform string `json:"platform"`
+}
+
+type dbResult struct {
+	resultKey
+	Result testresult.TestResult `json:"result"`
+	Time   time.Time             `json:"time"`
+}
+
+// loadResultsDB reads the results database at path. A missing file is
+// an empty database.
+func loadResultsDB(path string) (*resultsDB, error) {
+	db := &resultsDB{
+		path:    path,
+		results: make(map[resultKey]dbResult),
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var results []dbResult
+	if err := json.Unmarshal(buf, &results); err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		db.results[r.resultKey] = r
+	}
+	return db, nil
+}
+
+// passed reports whether the test last passed on image and platform
+// within the given window. Zero means any age.
+func (db *resultsDB) passed(test, image, platform string, within time.Duration) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	r, ok := db.results[resultKey{test, image, platform}]
+	if !ok || r.Result != testresult.Pass {
+		return false
+	}
+	return within == 0 || time.Since(r.Time) <= within
+}
+
+// record saves the result of a test. Skipped tests aren't recorded so
+// that skipping a test doesn't hide its earlier result.
+func (db *resultsDB) record(test, image, platform string, result testresult.TestResult) {
+	if result == testresult.Skip {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := resultKey{test, image, platform}
+	db.results[key] = dbResult{
+		resultKey: key,
+		Result:    result,
+		Time:      time.Now(),
+	}
+}
+
+// save atomically writes the database back to its file.
+func (db *resultsDB) save() error {
+	db.mu.Lock()
+	results := make([]dbResult, 0, len(db.results))
+	for _, r := range db.results {
+		results = append(results, r)
+	}
+	db.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].resultKey, results[j].resultKey
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		return a.Test < b.Test
+	})
+
+	buf, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(db.path), ".results-db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(buf, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), db.path)
+}
+
+// dbReporter is a harness reporter recording results in a resultsDB.
+type dbReporter struct {
+	db       *resultsDB
+	image    string
+	platform string
+}
+
+func (r *dbReporter) ReportTest(name string, result testresult.TestResult, duration time.Duration, b []byte) {
+	r.db.record(name, r.image, r.platform, result)
+}
+
+func (r *dbReporter) Output(path string) error {
+	return nil
+}
+
+func (r *dbReporter) SetResult(result testresult.TestResult) {}
@@ -0,0 +1,104 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/mantle/harness/testresult"
+)
+
+func TestResultsDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kola-resultsdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.json")
+
+	// a missing database is empty
+	db, err := loadResultsDB(path)
+	if err != nil {
+		t.Fatalf("loading missing database: %v", err)
+	}
+	if db.passed("a", "img", "qemu", 0) {
+		t.Error("empty database has a passed test")
+	}
+
+	rep := &dbReporter{db: db, image: "img", platform: "qemu"}
+	rep.ReportTest("a", testresult.Pass, time.Second, nil)
+	rep.ReportTest("b", testresult.Fail, time.Second, nil)
+	rep.ReportTest("c", testresult.Pass, time.Second, nil)
+	// skipping a test keeps its earlier result
+	rep.ReportTest("c", testresult.Skip, 0, nil)
+	db.record("d", "other", "qemu", testresult.Pass)
+	if err := db.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	db, err = loadResultsDB(path)
+	if err != nil {
+		t.Fatalf("loading saved database: %v", err)
+	}
+	for _, tt := range []struct {
+		test, image, platform string
+		within                time.Duration
+		expected              bool
+	}{
+		{"a", "img", "qemu", 0, true},
+		{"a", "img", "qemu", time.Hour, true},
+		{"b", "img", "qemu", 0, false},
+		{"c", "img", "qemu", 0, true},
+		{"new", "img", "qemu", 0, false},
+		// results are per image and platform
+		{"a", "other", "qemu", 0, false},
+		{"a", "img", "gce", 0, false},
+		{"d", "other", "qemu", 0, true},
+		{"d", "img", "qemu", 0, false},
+	} {
+		if actual := db.passed(tt.test, tt.image, tt.platform, tt.within); actual != tt.expected {
+			t.Errorf("%s on %s/%s: expected passed %v, got %v", tt.test, tt.platform, tt.image, tt.expected, actual)
+		}
+	}
+
+	// stale passes are run again
+	key := resultKey{"a", "img", "qemu"}
+	r := db.results[key]
+	r.Time = time.Now().Add(-2 * time.Hour)
+	db.results[key] = r
+	if db.passed("a", "img", "qemu", time.Hour) {
+		t.Error("pass from 2h ago counted within 1h")
+	}
+	if !db.passed("a", "img", "qemu", 0) {
+		t.Error("pass from 2h ago didn't count without a window")
+	}
+
+	// a later failure replaces the pass
+	db.record("a", "img", "qemu", testresult.Fail)
+	if db.passed("a", "img", "qemu", 0) {
+		t.Error("failed test still counted as passed")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadResultsDB(path); err == nil {
+		t.Error("loaded a corrupt database")
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+// scheduler gates tests running in parallel on the machine quota and on
+// exclusive resources, and tracks how long they take.
+type scheduler struct {
+	quota   *machineQuota
+	locks   resourceLocks
+	times   testTimes
+	retried attempts
+	// failed tests
+	failed attempts
+	// preempted machines of failed tests
+	preempted attempts
+	// host maintenance events during failed tests
+	maintained attempts
+
+	// clusters of failed tests awaiting destruction
+	kept sync.WaitGroup
+}
+
+// waitKept blocks until all kept clusters have been destroyed.
+func (s *scheduler) waitKept() {
+	s.kept.Wait()
+}
+
+func newScheduler(maxMachines int) *scheduler {
+	return &scheduler{quota: newMachineQuota(maxMachines)}
+}
+
+// start blocks until the test may run and returns its reservation, whose
+// done method is to be called once it has finished.
+func (s *scheduler) start(t *register.Test) *reservation {
+	// take the resources first so a waiting test doesn't hold quota
+	unlock := s.locks.lock(t.ExclusiveResources)
+
+	machines := t.ClusterSize
+	if machines < 1 {
+		machines = 1
+	}
+	return &reservation{
+		sched:    s,
+		machines: s.quota.acquire(machines),
+		unlock:   unlock,
+		begin:    time.Now(),
+	}
+}
+
+// reservation is the machine quota and exclusive resources held by a
+// running test.
+type reservation struct {
+	sched    *scheduler
+	machines int
+	unlock   func()
+	begin    time.Time
+	kept     bool
+}
+
+// done releases the reservation once the test has finished, except for
+// the machine quota of a kept cluster.
+func (r *reservation) done() {
+	r.sched.times.add(time.Since(r.begin))
+	if !r.kept {
+		r.sched.quota.release(r.machines)
+	}
+	r.unlock()
+}
+
+// keep holds the test's machine quota past done, while its cluster is
+// kept, and returns a function releasing it once the cluster has been
+// destroyed.
+func (r *reservation) keep() func() {
+	r.kept = true
+	return func() {
+		r.sched.quota.release(r.machines)
+	}
+}
+
+// machineQuota limits the number of machines used by concurrently
+// running tests. A limit of 0 means unlimited.
+type machineQuota struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	used  int
+}
+
+func newMachineQuota(limit int) *machineQuota {
+	q := &machineQuota{limit: limit}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// acquire blocks until n machines are available. Requests larger than
+// the limit are clamped so that they can run once nothing else is.
+func (q *machineQuota) acquire(n int) int {
+	if q.limit <= 0 {
+		return 0
+	}
+	if n > q.limit {
+		n = q.limit
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.used+n > q.limit {
+		q.cond.Wait()
+	}
+	q.used += n
+	return n
+}
+
+func (q *machineQuota) release(n int) {
+	if n == 0 {
+		return
+	}
+	q.mu.Lock()
+	q.used -= n
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// resourceLocks serializes tests which need exclusive access to a named
+// resource.
+type resourceLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (r *resourceLocks) get(name string) *sync.Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locks == nil {
+		r.locks = make(map[string]*sync.Mutex)
+	}
+	l, ok := r.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		r.locks[name] = l
+	}
+	return l
+}
+
+// lock acquires all named resources, in sorted order to avoid deadlock,
+// and returns a function releasing them.
+func (r *resourceLocks) lock(names []string) func() {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	var held []*sync.Mutex
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		l := r.get(name)
+		l.Lock()
+		held = append(held, l)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+// testTimes accumulates the time spent in tests, for comparison with the
+// wall-clock duration of the run.
+type testTimes struct {
+	mu    sync.Mutex
+	total time.Duration
+}
+
+func (t *testTimes) add(d time.Duration) {
+	t.mu.Lock()
+	t.total += d
+	t.mu.Unlock()
+}
+
+// attempts records a count per test, such as how many attempts it needed.
+type attempts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (a *attempts) set(name string, n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.counts == nil {
+		a.counts = make(map[string]int)
+	}
+	a.counts[name] = n
+}
+
+func (a *attempts) get(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.counts[name]
+}
+
+func (a *attempts) delete(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.counts, name)
+}
+
+// names returns the names of the recorded tests, sorted.
+func (a *attempts) names() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var names []string
+	for name := range a.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"testing"
+
+	"github.com/coreos/mantle/kola/register"
+)
+
+func TestReservationKeep(t *testing.T) {
+	sched := newScheduler(3)
+	test := &register.Test{Name: "test", ClusterSize: 2}
+
+	res := sched.start(test)
+	res.done()
+	if sched.quota.used != 0 {
+		t.Errorf("expected the quota to be released, %d machines used", sched.quota.used)
+	}
+
+	// a kept cluster's machines count until it is destroyed
+	res = sched.start(test)
+	release := res.keep()
+	res.done()
+	if sched.quota.used != 2 {
+		t.Errorf("expected the kept machines to be held, %d machines used", sched.quota.used)
+	}
+	release()
+	if sched.quota.used != 0 {
+		t.Errorf("expected the quota to be released, %d machines used", sched.quota.used)
+	}
+}
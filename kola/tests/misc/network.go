@@ -15,11 +15,15 @@
 package misc
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/coreos/mantle/kola/cluster"
 	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform/machine/qemu"
+	"github.com/coreos/mantle/util"
 )
 
 func init() {
@@ -34,6 +38,12 @@ func init() {
 		Name:             "coreos.network.initramfs.second-boot",
 		ExcludePlatforms: []string{"do"},
 	})
+	register.Register(&register.Test{
+		Run:         NetworkMultipleNICs,
+		ClusterSize: 0,
+		Name:        "coreos.network.multi-nic",
+		Platforms:   []string{"qemu"},
+	})
 }
 
 type listener struct {
@@ -136,3 +146,43 @@ func NetworkInitramfsSecondBoot(c cluster.TestCluster) {
 		c.Fatal("networkd started in initramfs")
 	}
 }
+
+// NetworkMultipleNICs boots a machine with two NICs and checks that both
+// come up with the addresses handed out by the platform.
+func NetworkMultipleNICs(c cluster.TestCluster) {
+	options := qemu.MachineOptions{
+		NetworkInterfaces: 2,
+	}
+	m, err := c.Cluster.(*qemu.Cluster).NewMachineWithOptions(nil, options)
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	nics := m.(interface {
+		NetworkInterfaces() []qemu.NetworkInterface
+	}).NetworkInterfaces()
+	if len(nics) != 2 {
+		c.Fatalf("expected 2 network interfaces, got %d", len(nics))
+	}
+
+	links := string(c.MustSSH(m, "ip -o link"))
+	for _, nic := range nics {
+		if !strings.Contains(links, nic.HardwareAddr.String()) {
+			c.Fatalf("no link with address %s on %s: %q", nic.HardwareAddr, nic.Bridge, links)
+		}
+	}
+
+	// the second NIC may still be waiting for DHCP
+	err = util.Retry(10, 3*time.Second, func() error {
+		addrs := string(c.MustSSH(m, "ip -o addr"))
+		for _, nic := range nics {
+			if !strings.Contains(addrs, " "+nic.IP.String()+"/") {
+				return fmt.Errorf("address %s on %s not configured: %q", nic.IP, nic.Bridge, addrs)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Fatal(err)
+	}
+}
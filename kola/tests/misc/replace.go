@@ -0,0 +1,75 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"strings"
+
+	"github.com/coreos/mantle/kola/cluster"
+	"github.com/coreos/mantle/kola/register"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+func init() {
+	register.Register(&register.Test{
+		Run:         ReplaceMachine,
+		ClusterSize: 2,
+		Name:        "cl.cluster.replace-machine",
+		UserData: conf.ContainerLinuxConfig(`
+storage:
+  files:
+  - filesystem: root
+    path: /etc/kola-replace
+    contents:
+      inline: configured
+    mode: 0644
+`),
+	})
+}
+
+// ReplaceMachine checks that a replaced machine is relaunched with the
+// same config, and that the cluster lists it in place of the old one.
+func ReplaceMachine(c cluster.TestCluster) {
+	old := c.Machines()[0]
+	newm, err := c.ReplaceMachine(old)
+	if err != nil {
+		c.Fatalf("Replacing machine %s: %v", old.ID(), err)
+	}
+	if newm.ID() == old.ID() {
+		c.Fatalf("Replacement has the same ID %s", old.ID())
+	}
+
+	machines := c.Machines()
+	if len(machines) != 2 {
+		c.Fatalf("Expected 2 machines after the replacement, got %d", len(machines))
+	}
+	found := false
+	for _, m := range machines {
+		if m.ID() == old.ID() {
+			c.Fatalf("Replaced machine %s is still in the cluster", old.ID())
+		}
+		if m.ID() == newm.ID() {
+			found = true
+		}
+	}
+	if !found {
+		c.Fatalf("Replacement %s isn't in the cluster", newm.ID())
+	}
+
+	out := c.MustSSH(newm, "cat /etc/kola-replace")
+	if strings.TrimSpace(string(out)) != "configured" {
+		c.Fatalf("Replacement has /etc/kola-replace %q, expected \"configured\"", out)
+	}
+}
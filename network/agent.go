@@ -0,0 +1,101 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoSystemAgent is returned by SystemAgent if SSH_AUTH_SOCK is unset.
+var ErrNoSystemAgent = errors.New("SSH_AUTH_SOCK is not set")
+
+// SystemAgent connects to the user's SSH agent at $SSH_AUTH_SOCK.
+func SystemAgent() (agent.Agent, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, ErrNoSystemAgent
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SSH agent: %v", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// multiAgent offers the keys of several agents. Keys are added to the
+// first one.
+type multiAgent []agent.Agent
+
+func (m multiAgent) List() ([]*agent.Key, error) {
+	var keys []*agent.Key
+	for _, a := range m {
+		k, err := a.List()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+func (m multiAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	var err error
+	for _, a := range m {
+		var sig *ssh.Signature
+		sig, err = a.Sign(key, data)
+		if err == nil {
+			return sig, nil
+		}
+	}
+	return nil, err
+}
+
+func (m multiAgent) Add(key agent.AddedKey) error {
+	return m[0].Add(key)
+}
+
+func (m multiAgent) Remove(key ssh.PublicKey) error {
+	return m[0].Remove(key)
+}
+
+func (m multiAgent) RemoveAll() error {
+	return m[0].RemoveAll()
+}
+
+func (m multiAgent) Lock(passphrase []byte) error {
+	return m[0].Lock(passphrase)
+}
+
+func (m multiAgent) Unlock(passphrase []byte) error {
+	return m[0].Unlock(passphrase)
+}
+
+func (m multiAgent) Signers() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, a := range m {
+		s, err := a.Signers()
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, s...)
+	}
+	return signers, nil
+}
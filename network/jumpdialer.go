@@ -0,0 +1,137 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpDialer connects to remote addresses through an SSH jump host, in the
+// manner of ssh's ProxyJump option. Like RetryDialer it retries failed
+// connections, which is useful for waiting on a booting machine.
+type JumpDialer struct {
+	// Dialer is used to connect to the jump host itself.
+	Dialer
+	Host    string
+	User    string
+	Auth    []ssh.AuthMethod
+	Retries int
+	// Pause is the delay between attempts.
+	Pause time.Duration
+	// HostKeyCallback verifies the key of the jump host. It must be
+	// set; use InsecureIgnoreHostKey to skip verification.
+	HostKeyCallback HostKeyCallback
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewJumpDialer initializes a JumpDialer with reasonable default settings.
+func NewJumpDialer(host, user string, auth []ssh.AuthMethod, hostKey HostKeyCallback) *JumpDialer {
+	if user == "" {
+		user = defaultUser
+	}
+	return &JumpDialer{
+		Dialer:  NewRetryDialer(),
+		Host:    ensurePortSuffix(host, defaultPort),
+		User:    user,
+		Auth:    auth,
+		Retries: DefaultRetries,
+		Pause:   DefaultTimeout,
+
+		HostKeyCallback: hostKey,
+	}
+}
+
+// Dial connects to a remote address via the jump host, retrying on failure.
+// The connection to the jump host is reestablished if it was lost.
+func (d *JumpDialer) Dial(network, address string) (c net.Conn, err error) {
+	for i := 0; i < d.Retries; i++ {
+		if i > 0 {
+			time.Sleep(d.Pause)
+		}
+
+		var client *ssh.Client
+		client, err = d.jumpClient()
+		if err != nil {
+			continue
+		}
+
+		c, err = client.Dial(network, address)
+		if err == nil {
+			return
+		}
+		err = fmt.Errorf("dialing %s via %s: %v", address, d.Host, err)
+
+		// distinguish an unreachable target from a dead jump host
+		if _, _, kerr := client.SendRequest("keepalive@openssh.com", true, nil); kerr != nil {
+			d.resetClient(client)
+		}
+	}
+	return
+}
+
+// Close closes the connection to the jump host.
+func (d *JumpDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == nil {
+		return nil
+	}
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
+
+func (d *JumpDialer) jumpClient() (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	if d.HostKeyCallback == nil {
+		return nil, fmt.Errorf("connecting to jump host %s: no host key callback", d.Host)
+	}
+	conn, err := d.Dialer.Dial("tcp", d.Host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to jump host %s: %v", d.Host, err)
+	}
+	sshconn, chans, reqs, err := ssh.NewClientConn(conn, d.Host, &ssh.ClientConfig{
+		User:            d.User,
+		Auth:            d.Auth,
+		HostKeyCallback: d.HostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to jump host %s: %v", d.Host, err)
+	}
+	d.client = ssh.NewClient(sshconn, chans, reqs)
+	return d.client, nil
+}
+
+func (d *JumpDialer) resetClient(client *ssh.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == client {
+		d.client.Close()
+		d.client = nil
+	}
+}
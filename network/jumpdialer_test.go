@@ -0,0 +1,144 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serveJumpHost runs a minimal SSH server which accepts any password and
+// forwards direct-tcpip channels, until the listener is closed.
+func serveJumpHost(t *testing.T, listener net.Listener) {
+	hostKey, err := ssh.ParsePrivateKey(testHostKeyBytes)
+	if err != nil {
+		t.Errorf("ParsePrivateKey failed: %v", err)
+		return
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChan := range chans {
+				if newChan.ChannelType() != "direct-tcpip" {
+					newChan.Reject(ssh.UnknownChannelType, "unsupported")
+					continue
+				}
+				var msg struct {
+					Host     string
+					Port     uint32
+					OrigHost string
+					OrigPort uint32
+				}
+				ssh.Unmarshal(newChan.ExtraData(), &msg)
+				target, err := net.Dial("tcp", net.JoinHostPort(msg.Host, strconv.Itoa(int(msg.Port))))
+				if err != nil {
+					newChan.Reject(ssh.ConnectionFailed, err.Error())
+					continue
+				}
+				ch, reqs, err := newChan.Accept()
+				if err != nil {
+					target.Close()
+					continue
+				}
+				go ssh.DiscardRequests(reqs)
+				go func() {
+					io.Copy(ch, target)
+					ch.Close()
+				}()
+				go func() {
+					io.Copy(target, ch)
+					target.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func TestJumpDialer(t *testing.T) {
+	jump, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer jump.Close()
+	go serveJumpHost(t, jump)
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	d := NewJumpDialer(jump.Addr().String(), "", []ssh.AuthMethod{ssh.Password("")}, InsecureIgnoreHostKey())
+	d.Pause = time.Millisecond
+	defer d.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.Dial("tcp", target.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		buf, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if string(buf) != "hello" {
+			t.Errorf("got %q, expected %q", buf, "hello")
+		}
+	}
+
+	// a closed port fails after retrying
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := closed.Addr().String()
+	closed.Close()
+	if _, err := d.Dial("tcp", addr); err == nil {
+		t.Errorf("Dial to closed port succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyCallback validates the host key of an SSH server during the
+// handshake, like ssh.ClientConfig.HostKeyCallback.
+type HostKeyCallback func(hostname string, remote net.Addr, key ssh.PublicKey) error
+
+// InsecureIgnoreHostKey returns a HostKeyCallback accepting any host key.
+// It should only be used for hosts whose keys can't be known in advance.
+func InsecureIgnoreHostKey() HostKeyCallback {
+	return func(string, net.Addr, ssh.PublicKey) error {
+		return nil
+	}
+}
+
+// DefaultKnownHostsFile returns the path of the user's known_hosts file.
+func DefaultKnownHostsFile() string {
+	home := os.Getenv("HOME")
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// knownHost is a line of a known_hosts file.
+type knownHost struct {
+	revoked  bool
+	patterns []string
+	key      ssh.PublicKey
+}
+
+// KnownHosts returns a HostKeyCallback accepting the host keys listed for
+// a server in OpenSSH known_hosts files. Hashed names, [host]:port
+// entries, wildcards, negated patterns and @revoked keys are supported;
+// @cert-authority lines are ignored.
+func KnownHosts(files ...string) (HostKeyCallback, error) {
+	var hosts []knownHost
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// parse line by line, so that lines this SSH library can't
+		// parse, such as newer key types, are skipped
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			marker, patterns, key, _, _, err := ssh.ParseKnownHosts(line)
+			if err != nil {
+				// io.EOF for blank lines and comments
+				continue
+			}
+			if marker == "cert-authority" {
+				continue
+			}
+			hosts = append(hosts, knownHost{
+				revoked:  marker == "revoked",
+				patterns: patterns,
+				key:      key,
+			})
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		names := []string{knownHostName(hostname)}
+		if tcp, ok := remote.(*net.TCPAddr); ok {
+			names = append(names, knownHostName(tcp.String()))
+		}
+
+		marshaled := key.Marshal()
+		known := false
+		for _, h := range hosts {
+			if !h.matches(names) {
+				continue
+			}
+			same := bytes.Equal(h.key.Marshal(), marshaled)
+			if h.revoked && same {
+				return fmt.Errorf("ssh: host key of %s is revoked", hostname)
+			} else if same {
+				known = true
+			}
+		}
+		if known {
+			return nil
+		}
+		for _, h := range hosts {
+			if !h.revoked && h.matches(names) {
+				return fmt.Errorf("ssh: host key of %s doesn't match known_hosts", hostname)
+			}
+		}
+		return fmt.Errorf("ssh: %s isn't in known_hosts, connect with ssh first to add it", hostname)
+	}, nil
+}
+
+// knownHostName returns the name of host:port in known_hosts files.
+func knownHostName(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if port == strconv.Itoa(defaultPort) {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// matches reports whether any of names matches the patterns of the line,
+// and none matches its negated patterns.
+func (h *knownHost) matches(names []string) bool {
+	matched := false
+	for _, name := range names {
+		for _, pattern := range h.patterns {
+			negated := strings.HasPrefix(pattern, "!")
+			if negated {
+				pattern = pattern[1:]
+			}
+			if !matchHostPattern(pattern, name) {
+				continue
+			}
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchHostPattern matches a name against a plain, wildcard or hashed
+// (|1|salt|hash) known_hosts pattern.
+func matchHostPattern(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	if strings.ContainsAny(pattern, "*?") {
+		// only * and ? are special in known_hosts patterns
+		escaped := strings.NewReplacer("[", `\[`, `\`, `\\`).Replace(pattern)
+		ok, _ := path.Match(escaped, name)
+		return ok
+	}
+	return strings.EqualFold(pattern, name)
+}
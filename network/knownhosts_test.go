@@ -0,0 +1,145 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("NewPublicKey failed: %v", err)
+	}
+	return pub
+}
+
+func hashHostName(name string) string {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestKnownHosts(t *testing.T) {
+	known := newTestPublicKey(t)
+	other := newTestPublicKey(t)
+	revoked := newTestPublicKey(t)
+	line := func(hosts string, key ssh.PublicKey) string {
+		return hosts + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+
+	dir, err := ioutil.TempDir("", "knownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "known_hosts")
+	contents := strings.Join([]string{
+		"# comment",
+		"",
+		line("bastion.example.com,192.0.2.1", known),
+		line("[bastion.example.com]:2222", other),
+		line(hashHostName("hashed.example.com"), known),
+		line("*.test.example.com,!bad.test.example.com", known),
+		"@revoked " + line("*", revoked),
+		"@cert-authority " + line("*.example.com", other),
+		"unparseable line",
+	}, "\n")
+	if err := ioutil.WriteFile(file, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := KnownHosts(file)
+	if err != nil {
+		t.Fatalf("KnownHosts failed: %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 22}
+	for _, tt := range []struct {
+		hostname string
+		remote   net.Addr
+		key      ssh.PublicKey
+		valid    bool
+		contains string
+	}{
+		{"bastion.example.com:22", remote, known, true, ""},
+		{"bastion.example.com:22", remote, other, false, "doesn't match"},
+		{"bastion.example.com:2222", remote, other, true, ""},
+		{"bastion.example.com:2222", remote, known, false, "doesn't match"},
+		// the remote address is checked too
+		{"unknown.example.com:22", &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}, known, true, ""},
+		{"hashed.example.com:22", remote, known, true, ""},
+		{"a.test.example.com:22", remote, known, true, ""},
+		{"bad.test.example.com:22", remote, known, false, "isn't in known_hosts"},
+		{"unknown.example.com:22", remote, known, false, "isn't in known_hosts"},
+		{"bastion.example.com:22", remote, revoked, false, "revoked"},
+	} {
+		err := callback(tt.hostname, tt.remote, tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.hostname, err)
+		} else if !tt.valid {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.hostname)
+			} else if !strings.Contains(err.Error(), tt.contains) {
+				t.Errorf("%s: error %q doesn't contain %q", tt.hostname, err, tt.contains)
+			}
+		}
+	}
+
+	if _, err := KnownHosts(filepath.Join(dir, "missing")); err == nil {
+		t.Error("loaded a missing known_hosts file")
+	}
+}
+
+func TestJumpDialerHostKey(t *testing.T) {
+	jump, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer jump.Close()
+	go serveJumpHost(t, jump)
+
+	reject := func(string, net.Addr, ssh.PublicKey) error {
+		return errors.New("untrusted host key")
+	}
+	for _, hostKey := range []HostKeyCallback{nil, reject} {
+		d := NewJumpDialer(jump.Addr().String(), "", []ssh.AuthMethod{ssh.Password("")}, hostKey)
+		d.Retries = 1
+		if conn, err := d.Dial("tcp", jump.Addr().String()); err == nil {
+			conn.Close()
+			t.Error("connected without verifying the jump host's key")
+		}
+		d.Close()
+	}
+}
@@ -15,9 +15,11 @@
 package network
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -43,27 +45,36 @@ type Dialer interface {
 type SSHAgent struct {
 	agent.Agent
 	Dialer
-	User     string
-	Socket   string
+	User   string
+	Socket string
+	// Upstream, if set, is another agent whose keys are also offered to
+	// machines requesting agent forwarding, such as SystemAgent().
+	Upstream agent.Agent
 	sockDir  string
 	listener *net.UnixListener
 }
 
 // NewSSHAgent constructs a new SSHAgent using dialer to create ssh
-// connections.
+// connections, holding a newly generated key.
 func NewSSHAgent(dialer Dialer) (*SSHAgent, error) {
 	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
 	if err != nil {
 		return nil, err
 	}
+	return NewSSHAgentWithKey(dialer, key)
+}
 
+// NewSSHAgentWithKey constructs a new SSHAgent using dialer to create ssh
+// connections, holding key, a private key such as one returned by
+// LoadSSHKey.
+func NewSSHAgentWithKey(dialer Dialer, key interface{}) (*SSHAgent, error) {
 	addedkey := agent.AddedKey{
 		PrivateKey: key,
 		Comment:    "core@default",
 	}
 
 	keyring := agent.NewKeyring()
-	err = keyring.Add(addedkey)
+	err := keyring.Add(addedkey)
 	if err != nil {
 		return nil, err
 	}
@@ -104,9 +115,45 @@ func NewSSHAgent(dialer Dialer) (*SSHAgent, error) {
 	return a, nil
 }
 
-// Close closes the unix socket of the agent.
+// LoadSSHKey reads an unencrypted private key from path. If the public
+// key file path.pub exists, it must hold the matching public key.
+func LoadSSHKey(path string) (interface{}, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key %s: %v", path, err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key %s: %v", path, err)
+	}
+
+	pubBytes, err := ioutil.ReadFile(path + ".pub")
+	if os.IsNotExist(err) {
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH public key %s.pub: %v", path, err)
+	}
+	if !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("SSH public key %s.pub doesn't match %s", path, path)
+	}
+	return key, nil
+}
+
+// Close closes the unix socket of the agent, and the dialer if it holds
+// a connection such as to a jump host.
 func (a *SSHAgent) Close() error {
 	a.listener.Close()
+	if c, ok := a.Dialer.(io.Closer); ok {
+		c.Close()
+	}
 	return os.RemoveAll(a.sockDir)
 }
 
@@ -143,7 +190,11 @@ func (a *SSHAgent) newClient(host string, user string, auth []ssh.AuthMethod) (*
 	}
 
 	client := ssh.NewClient(sshconn, chans, reqs)
-	err = agent.ForwardToAgent(client, a)
+	var forwarded agent.Agent = a.Agent
+	if a.Upstream != nil {
+		forwarded = multiAgent{a.Agent, a.Upstream}
+	}
+	err = agent.ForwardToAgent(client, forwarded)
 	if err != nil {
 		client.Close()
 		return nil, err
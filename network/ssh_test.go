@@ -16,8 +16,13 @@ package network
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
@@ -93,3 +98,60 @@ func TestSSHNewClient(t *testing.T) {
 	// Oh god... I give up for now.
 	t.Skip("Implementation incomplete")
 }
+
+func TestLoadSSHKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mantle-ssh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(path, testHostKeyBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadSSHKey(path)
+	if err != nil {
+		t.Fatalf("LoadSSHKey failed: %v", err)
+	}
+
+	m, err := NewSSHAgentWithKey(&net.Dialer{}, key)
+	if err != nil {
+		t.Fatalf("NewSSHAgentWithKey failed: %v", err)
+	}
+	defer m.Close()
+	keys, err := m.List()
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(testHostKeyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0].Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("agent doesn't hold the loaded key")
+	}
+
+	pub := ssh.MarshalAuthorizedKey(signer.PublicKey())
+	if err := ioutil.WriteFile(path+".pub", pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSSHKey(path); err != nil {
+		t.Errorf("LoadSSHKey with matching public key failed: %v", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := ssh.NewPublicKey(&other.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(otherPub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSSHKey(path); err == nil {
+		t.Errorf("LoadSSHKey with mismatched public key succeeded")
+	}
+}
@@ -45,8 +45,8 @@ var amiCache struct {
 // resolveAMI is used to minimize network requests while allowing resolution of
 // release channels to specific AMI ids.
 // If any issue occurs attempting to resolve a given AMI, e.g. a network error,
-// this method panics.
-func resolveAMI(ami string, region string) string {
+// this method panics. A release without an AMI in region is an error.
+func resolveAMI(ami string, region string) (string, error) {
 	resolveChannel := func(channel string) *releaseAMIs {
 		resp, err := http.DefaultClient.Get(fmt.Sprintf("https://%s.release.core-os.net/amd64-usr/current/coreos_production_ami_all.json", channel))
 		if err != nil {
@@ -79,13 +79,13 @@ func resolveAMI(ami string, region string) string {
 		})
 		channelAmis = amiCache.stableAMIs
 	default:
-		return ami
+		return ami, nil
 	}
 
-	for _, ami := range channelAmis.AMIS {
-		if ami.Name == region {
-			return ami.HVM
+	for _, a := range channelAmis.AMIS {
+		if a.Name == region {
+			return a.HVM, nil
 		}
 	}
-	panic(fmt.Sprintf("could not find %v ami in %+v", ami, amiCache.alphaAMIs.AMIS))
+	return "", fmt.Errorf("the current %v release has no AMI in region %v", ami, region)
 }
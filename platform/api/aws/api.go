@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -28,6 +29,7 @@ import (
 	"github.com/coreos/pkg/capnslog"
 
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
 )
 
 var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/api/aws")
@@ -54,6 +56,32 @@ type Options struct {
 	InstanceType       string
 	SecurityGroup      string
 	IAMInstanceProfile string
+
+	// SpotPrice is the maximum hourly price to pay for spot instances.
+	// If empty, on-demand instances are launched.
+	SpotPrice string
+	// SpotFallback launches on-demand instances if spot instances
+	// can't be launched.
+	SpotFallback bool
+
+	// Tags are additional tags to apply to launched instances.
+	Tags map[string]string
+
+	// VolumeType is the EBS volume type of the root volume, e.g. gp3 or
+	// io2. If empty, the AMI's default is used.
+	VolumeType string
+	// VolumeIOPS is the provisioned IOPS of the root volume, for volume
+	// types that support it.
+	VolumeIOPS int64
+
+	// PlacementGroup is the name of a cluster placement group to launch
+	// instances into. It is created by EnsurePlacementGroup if it
+	// doesn't exist.
+	PlacementGroup string
+
+	// Progress is told about the progress of S3 uploads, nil for
+	// util.DefaultReporter.
+	Progress util.ProgressReporter
 }
 
 type API struct {
@@ -86,7 +114,9 @@ func New(opts *Options) (*API, error) {
 		return nil, err
 	}
 
-	opts.AMI = resolveAMI(opts.AMI, opts.Region)
+	if opts.AMI, err = resolveAMI(opts.AMI, opts.Region); err != nil {
+		return nil, err
+	}
 
 	api := &API{
 		session: sess,
@@ -113,3 +143,38 @@ func (a *API) PreflightCheck() error {
 
 	return err
 }
+
+// pollBackoff returns the backoff used when waiting for resources to
+// change state, starting at initial and giving up after maxElapsed.
+func pollBackoff(initial, maxElapsed time.Duration) util.Backoff {
+	return util.Backoff{
+		Initial:    initial,
+		Max:        time.Minute,
+		Jitter:     0.2,
+		MaxElapsed: maxElapsed,
+	}
+}
+
+// isRetryable reports whether a failed request should be retried: it was
+// throttled, failed on the server, or never reached AWS at all. Any of
+// codes, such as "not found" errors caused by eventual consistency, are
+// also retried.
+func isRetryable(err error, codes ...string) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "RequestLimitExceeded", "Throttling", "ThrottlingException", "RequestError":
+		return true
+	}
+	for _, code := range codes {
+		if aerr.Code() == code {
+			return true
+		}
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		return util.IsRetryableStatus(rerr.StatusCode())
+	}
+	return false
+}
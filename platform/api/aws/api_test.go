@@ -0,0 +1,46 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for _, tt := range []struct {
+		err   error
+		retry bool
+	}{
+		{awserr.NewRequestFailure(awserr.New("TooManyRequests", "slow down", nil), 429, "1"), true},
+		{awserr.NewRequestFailure(awserr.New("Unavailable", "try later", nil), 503, "2"), true},
+		{awserr.NewRequestFailure(awserr.New("RequestLimitExceeded", "slow down", nil), 400, "3"), true},
+		{awserr.NewRequestFailure(awserr.New("InvalidParameterValue", "bad", nil), 400, "4"), false},
+		{awserr.NewRequestFailure(awserr.New("InvalidInstanceID.NotFound", "missing", nil), 400, "5"), false},
+		{awserr.New("RequestError", "connection reset", nil), true},
+		{fmt.Errorf("something else"), false},
+	} {
+		if retry := isRetryable(tt.err); retry != tt.retry {
+			t.Errorf("%v: expected retry %v, got %v", tt.err, tt.retry, retry)
+		}
+	}
+
+	notFound := awserr.NewRequestFailure(awserr.New("InvalidInstanceID.NotFound", "missing", nil), 400, "6")
+	if !isRetryable(notFound, "InvalidInstanceID.NotFound") {
+		t.Errorf("expected extra code to be retried")
+	}
+}
@@ -17,6 +17,7 @@ package aws
 import (
 	"encoding/base64"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -43,7 +44,7 @@ func (a *API) DeleteKey(name string) error {
 	return err
 }
 
-// CreateInstances creates EC2 instances with a given name tag, optional ssh key name, user data. The image ID, instance type, and security group set in the API will be used. CreateInstances will block until all instances are running and have an IP address.
+// CreateInstances creates EC2 instances with a given name tag, optional ssh key name, user data. The image ID, instance type, and security group set in the API will be used. If a spot price is set in the API, spot instances are requested instead of on-demand instances. CreateInstances will block until all instances are running and have an IP address.
 func (a *API) CreateInstances(name, keyname, userdata string, count uint64) ([]*ec2.Instance, error) {
 	cnt := int64(count)
 
@@ -62,65 +63,103 @@ func (a *API) CreateInstances(name, keyname, userdata string, count uint64) ([]*
 	if err != nil {
 		return nil, fmt.Errorf("error resolving security group: %v", err)
 	}
+	blockDevices, err := a.rootBlockDeviceMappings()
+	if err != nil {
+		return nil, err
+	}
+
 	key := &keyname
 	if keyname == "" {
 		key = nil
 	}
 	inst := ec2.RunInstancesInput{
-		ImageId:          &a.opts.AMI,
-		MinCount:         &cnt,
-		MaxCount:         &cnt,
-		KeyName:          key,
-		InstanceType:     &a.opts.InstanceType,
-		SecurityGroupIds: []*string{&sgId},
-		UserData:         ud,
+		ImageId:             &a.opts.AMI,
+		MinCount:            &cnt,
+		MaxCount:            &cnt,
+		KeyName:             key,
+		InstanceType:        &a.opts.InstanceType,
+		SecurityGroupIds:    []*string{&sgId},
+		UserData:            ud,
+		BlockDeviceMappings: blockDevices,
 		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{
 			Name: &a.opts.IAMInstanceProfile,
 		},
 		TagSpecifications: []*ec2.TagSpecification{
 			&ec2.TagSpecification{
 				ResourceType: aws.String(ec2.ResourceTypeInstance),
-				Tags: []*ec2.Tag{
-					&ec2.Tag{
-						Key:   aws.String("Name"),
-						Value: aws.String(name),
-					},
-					&ec2.Tag{
-						Key:   aws.String("CreatedBy"),
-						Value: aws.String("mantle"),
-					},
-				},
+				Tags:         instanceTags(name, a.opts.Tags),
 			},
 		},
 	}
 
-	reservations, err := a.ec2.RunInstances(&inst)
-	if err != nil {
-		return nil, fmt.Errorf("error running instances: %v", err)
+	if a.opts.PlacementGroup != "" {
+		inst.Placement = &ec2.Placement{
+			GroupName: aws.String(a.opts.PlacementGroup),
+		}
 	}
 
-	ids := make([]string, len(reservations.Instances))
-	for i, inst := range reservations.Instances {
-		ids[i] = *inst.InstanceId
+	var ids []string
+	if a.opts.SpotPrice != "" {
+		ids, err = a.requestSpotInstances(&inst)
+		if err != nil {
+			if !a.opts.SpotFallback {
+				return nil, err
+			}
+			plog.Warningf("Falling back to on-demand instances: %v", err)
+		}
+	}
+
+	if ids == nil {
+		reservations, err := a.ec2.RunInstances(&inst)
+		if err != nil {
+			return nil, fmt.Errorf("error running instances: %v", err)
+		}
+
+		ids = make([]string, len(reservations.Instances))
+		for i, inst := range reservations.Instances {
+			ids[i] = *inst.InstanceId
+		}
 	}
 
 	// loop until all machines are online
 	var insts []*ec2.Instance
 
-	// 10 minutes is a pretty reasonable timeframe for AWS instances to work.
-	timeout := 10 * time.Minute
-	// don't make api calls too quickly, or we will hit the rate limit
-	delay := 10 * time.Second
-	err = util.WaitUntilReady(timeout, delay, func() (bool, error) {
+	// 10 minutes is a pretty reasonable timeframe for AWS instances to
+	// work. Back off between polls so that we don't hit the rate limit.
+	backoff := pollBackoff(5*time.Second, 10*time.Minute)
+	retry := func(err error) bool {
+		// new instances may not be visible yet
+		return isRetryable(err, "InvalidInstanceID.NotFound")
+	}
+	err = util.PollBackoff(backoff, retry, func() (bool, error) {
 		desc, err := a.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
 			InstanceIds: aws.StringSlice(ids),
 		})
 		if err != nil {
 			return false, err
 		}
-		insts = desc.Reservations[0].Instances
+		// instances may span several reservations
+		insts = nil
+		for _, r := range desc.Reservations {
+			insts = append(insts, r.Instances...)
+		}
+		if len(insts) < len(ids) {
+			return false, nil
+		}
 
 		for _, i := range insts {
+			switch *i.State.Name {
+			case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+				// spot instances can be interrupted before they run;
+				// fail now so the caller can retry
+				if i.SpotInstanceRequestId != nil {
+					status, err := a.GetSpotInterruption(*i.InstanceId)
+					if err == nil && status != "" {
+						return false, fmt.Errorf("spot instance %v was interrupted: %v", *i.InstanceId, status)
+					}
+				}
+				return false, fmt.Errorf("instance %v is %v", *i.InstanceId, *i.State.Name)
+			}
 			if *i.State.Name != ec2.InstanceStateNameRunning || i.PublicIpAddress == nil {
 				return false, nil
 			}
@@ -135,6 +174,93 @@ func (a *API) CreateInstances(name, keyname, userdata string, count uint64) ([]*
 	return insts, nil
 }
 
+// volumeIOPSRanges are the allowed provisioned IOPS for each EBS volume
+// type that supports them.
+var volumeIOPSRanges = map[string][2]int64{
+	"io1": {100, 64000},
+	"io2": {100, 64000},
+	"gp3": {3000, 16000},
+}
+
+// validateVolume checks that the root volume options are supported by
+// the volume type.
+func validateVolume(volumeType string, iops int64) error {
+	if iops == 0 {
+		return nil
+	}
+	limits, ok := volumeIOPSRanges[volumeType]
+	if !ok {
+		return fmt.Errorf("volume type %q doesn't support provisioned IOPS", volumeType)
+	}
+	if iops < limits[0] || iops > limits[1] {
+		return fmt.Errorf("%d IOPS out of range %d-%d for volume type %q", iops, limits[0], limits[1], volumeType)
+	}
+	return nil
+}
+
+// rootBlockDeviceMappings returns the block device mappings overriding the
+// AMI's root volume type, or nil if no volume type is set.
+func (a *API) rootBlockDeviceMappings() ([]*ec2.BlockDeviceMapping, error) {
+	if a.opts.VolumeType == "" {
+		if a.opts.VolumeIOPS != 0 {
+			return nil, fmt.Errorf("volume IOPS requires a volume type")
+		}
+		return nil, nil
+	}
+	if err := validateVolume(a.opts.VolumeType, a.opts.VolumeIOPS); err != nil {
+		return nil, err
+	}
+
+	image, err := a.describeImage(a.opts.AMI)
+	if err != nil {
+		return nil, err
+	}
+	if image.RootDeviceName == nil {
+		return nil, fmt.Errorf("image %v has no root device", a.opts.AMI)
+	}
+
+	ebs := &ec2.EbsBlockDevice{
+		DeleteOnTermination: aws.Bool(true),
+		VolumeType:          aws.String(a.opts.VolumeType),
+	}
+	if a.opts.VolumeIOPS != 0 {
+		ebs.Iops = aws.Int64(a.opts.VolumeIOPS)
+	}
+	return []*ec2.BlockDeviceMapping{
+		&ec2.BlockDeviceMapping{
+			DeviceName: image.RootDeviceName,
+			Ebs:        ebs,
+		},
+	}, nil
+}
+
+// instanceTags returns the tags for an instance with the given name tag,
+// merged with extra. The Name and CreatedBy tags take precedence, since
+// they are needed to identify the instance and clean it up.
+func instanceTags(name string, extra map[string]string) []*ec2.Tag {
+	tags := map[string]string{}
+	for k, v := range extra {
+		tags[k] = v
+	}
+	tags["Name"] = name
+	tags["CreatedBy"] = "mantle"
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tagObjs := make([]*ec2.Tag, 0, len(tags))
+	for _, k := range keys {
+		tagObjs = append(tagObjs, &ec2.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return tagObjs
+}
+
 // gcEC2 will terminate ec2 instances older than gracePeriod.
 // It will only operate on ec2 instances tagged with 'mantle' to avoid stomping
 // on other resources in the account.
@@ -214,6 +340,25 @@ func (a *API) CreateTags(resources []string, tags map[string]string) error {
 	return err
 }
 
+// DeleteTags removes the tags with the given keys from resources. Keys the
+// resources don't have are ignored.
+func (a *API) DeleteTags(resources []string, keys []string) error {
+	tagObjs := make([]*ec2.Tag, 0, len(keys))
+	for _, key := range keys {
+		tagObjs = append(tagObjs, &ec2.Tag{
+			Key: aws.String(key),
+		})
+	}
+	_, err := a.ec2.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: aws.StringSlice(resources),
+		Tags:      tagObjs,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting tags: %v", err)
+	}
+	return nil
+}
+
 // GetConsoleOutput returns the console output. Returns "", nil if no logs
 // are available.
 func (a *API) GetConsoleOutput(instanceID string) (string, error) {
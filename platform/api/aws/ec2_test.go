@@ -0,0 +1,70 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInstanceTags(t *testing.T) {
+	for _, tt := range []struct {
+		extra    map[string]string
+		expected string
+	}{
+		{nil, "CreatedBy=mantle Name=kola-1"},
+		{
+			map[string]string{"test": "coreos.basic", "ttl": "1h"},
+			"CreatedBy=mantle Name=kola-1 test=coreos.basic ttl=1h",
+		},
+		{
+			map[string]string{"Name": "other", "CreatedBy": "someone", "pipeline": "42"},
+			"CreatedBy=mantle Name=kola-1 pipeline=42",
+		},
+	} {
+		var tags []string
+		for _, tag := range instanceTags("kola-1", tt.extra) {
+			tags = append(tags, fmt.Sprintf("%s=%s", *tag.Key, *tag.Value))
+		}
+		if actual := strings.Join(tags, " "); actual != tt.expected {
+			t.Errorf("expected tags %q, got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestValidateVolume(t *testing.T) {
+	for _, tt := range []struct {
+		volumeType string
+		iops       int64
+		ok         bool
+	}{
+		{"gp2", 0, true},
+		{"gp3", 0, true},
+		{"gp3", 3000, true},
+		{"gp3", 100, false},
+		{"io2", 64000, true},
+		{"io2", 64001, false},
+		{"gp2", 3000, false},
+		{"standard", 100, false},
+	} {
+		err := validateVolume(tt.volumeType, tt.iops)
+		if tt.ok && err != nil {
+			t.Errorf("%s with %d IOPS: unexpected error: %v", tt.volumeType, tt.iops, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s with %d IOPS: expected error", tt.volumeType, tt.iops)
+		}
+	}
+}
@@ -28,6 +28,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/coreos/pkg/multierror"
+
+	"github.com/coreos/mantle/util"
 )
 
 var (
@@ -242,21 +245,19 @@ func (a *API) finishSnapshotTask(snapshotTaskID, imageName string) (*Snapshot, e
 
 	// TODO(euank): write a waiter for import snapshot
 	var snapshotID string
-	for {
+	retry := func(err error) bool { return isRetryable(err) }
+	err := util.PollBackoff(pollBackoff(20*time.Second, 0), retry, func() (bool, error) {
 		var done bool
 		var err error
 		done, snapshotID, err = snapshotDone(snapshotTaskID)
-		if err != nil {
-			return nil, err
-		}
-		if done {
-			break
-		}
-		time.Sleep(20 * time.Second)
+		return done, err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// post-process
-	err := a.CreateTags([]string{snapshotID}, map[string]string{
+	err = a.CreateTags([]string{snapshotID}, map[string]string{
 		"Name": imageName,
 	})
 	if err != nil {
@@ -380,18 +381,22 @@ func (a *API) createImage(params *ec2.RegisterImageInput) (string, error) {
 	if awserr, ok := err.(awserr.Error); ok && awserr.Code() == "InvalidAMIName.Duplicate" {
 		// The AMI already exists. Get its ID. Due to races, this
 		// may take several attempts.
-		for {
-			imageID, err := a.FindImage(*params.Name)
-			if err != nil {
-				return "", err
-			}
-			if imageID != "" {
-				plog.Infof("found existing image %v, reusing", imageID)
-				return imageID, nil
+		var imageID string
+		retry := func(err error) bool { return isRetryable(err) }
+		err := util.PollBackoff(pollBackoff(5*time.Second, 0), retry, func() (bool, error) {
+			var err error
+			imageID, err = a.FindImage(*params.Name)
+			if err != nil || imageID != "" {
+				return true, err
 			}
 			plog.Debugf("failed to locate image %q, retrying...", *params.Name)
-			time.Sleep(10 * time.Second)
+			return false, nil
+		})
+		if err != nil {
+			return "", err
 		}
+		plog.Infof("found existing image %v, reusing", imageID)
+		return imageID, nil
 	}
 	return "", fmt.Errorf("error creating AMI: %v", err)
 }
@@ -441,27 +446,158 @@ func (a *API) GrantLaunchPermission(imageID string, userIDs []string) error {
 	return nil
 }
 
+// ImageSharing describes who may launch an image.
+type ImageSharing struct {
+	AccountIDs []string
+	Public     bool
+}
+
+// GetImageSharing returns the launch permissions of an image.
+func (a *API) GetImageSharing(amiID string) (*ImageSharing, error) {
+	res, err := a.ec2.DescribeImageAttribute(&ec2.DescribeImageAttributeInput{
+		Attribute: aws.String("launchPermission"),
+		ImageId:   aws.String(amiID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't describe launch permissions of %v: %v", amiID, err)
+	}
+
+	sharing := &ImageSharing{}
+	for _, perm := range res.LaunchPermissions {
+		if perm.Group != nil && *perm.Group == "all" {
+			sharing.Public = true
+		}
+		if perm.UserId != nil {
+			sharing.AccountIDs = append(sharing.AccountIDs, *perm.UserId)
+		}
+	}
+	return sharing, nil
+}
+
+// ShareImage sets the launch permissions of an image to exactly the given
+// account IDs, or to everyone if public is true. Accounts that currently
+// have launch permission but aren't listed have it revoked.
+func (a *API) ShareImage(amiID string, accountIDs []string, public bool) error {
+	if public && len(accountIDs) > 0 {
+		return fmt.Errorf("can't share image %v both publicly and with specific accounts", amiID)
+	}
+
+	current, err := a.GetImageSharing(amiID)
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool)
+	for _, id := range accountIDs {
+		desired[id] = true
+	}
+
+	mods := &ec2.LaunchPermissionModifications{}
+	for _, id := range current.AccountIDs {
+		if !desired[id] {
+			mods.Remove = append(mods.Remove, &ec2.LaunchPermission{
+				UserId: aws.String(id),
+			})
+		}
+		delete(desired, id)
+	}
+	for _, id := range accountIDs {
+		if desired[id] {
+			mods.Add = append(mods.Add, &ec2.LaunchPermission{
+				UserId: aws.String(id),
+			})
+			delete(desired, id)
+		}
+	}
+	allGroup := &ec2.LaunchPermission{
+		Group: aws.String("all"),
+	}
+	if public && !current.Public {
+		mods.Add = append(mods.Add, allGroup)
+	} else if !public && current.Public {
+		mods.Remove = append(mods.Remove, allGroup)
+	}
+
+	if len(mods.Add) == 0 && len(mods.Remove) == 0 {
+		return nil
+	}
+	_, err = a.ec2.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+		Attribute:        aws.String("launchPermission"),
+		ImageId:          aws.String(amiID),
+		LaunchPermission: mods,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't modify launch permissions of %v: %v", amiID, err)
+	}
+	return nil
+}
+
+// CopyImage copies the image sourceImageID from the configured region to
+// each of regions, returning a map from region to the ID of the copied image.
 func (a *API) CopyImage(sourceImageID string, regions []string) (map[string]string, error) {
+	return a.CopyImageToRegions(a.opts.Region, sourceImageID, regions, nil)
+}
+
+// CopyImageOptions are the options of CopyImageToRegions.
+type CopyImageOptions struct {
+	// KMSKeyIDs maps target regions to the KMS key the copy in that
+	// region is encrypted with.
+	KMSKeyIDs map[string]string
+	// KMSKeyID encrypts the copies to regions missing from KMSKeyIDs.
+	// KMS keys are regional, so with more than one such region this
+	// only works for an alias, e.g. "alias/coreos", present in each.
+	KMSKeyID string
+}
+
+// kmsKeyID returns the KMS key to encrypt the copy to region with, or ""
+// to leave it unencrypted.
+func (o *CopyImageOptions) kmsKeyID(region string) string {
+	if o == nil {
+		return ""
+	}
+	if key, ok := o.KMSKeyIDs[region]; ok {
+		return key
+	}
+	return o.KMSKeyID
+}
+
+// CopyImageToRegions copies the image amiID from sourceRegion to each of
+// targets, returning a map from region to the ID of the copied image.
+// The copies are encrypted as configured by copyOpts, which may be nil.
+// Failures in individual regions are aggregated into the returned error;
+// images successfully copied to other regions are still returned.
+func (a *API) CopyImageToRegions(sourceRegion, amiID string, targets []string, copyOpts *CopyImageOptions) (map[string]string, error) {
 	type result struct {
 		region  string
 		imageID string
 		err     error
 	}
 
-	image, err := a.describeImage(sourceImageID)
+	src := a
+	if sourceRegion != a.opts.Region {
+		opts := *a.opts
+		opts.Region = sourceRegion
+		var err error
+		src, err = New(&opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	image, err := src.describeImage(amiID)
 	if err != nil {
 		return nil, err
 	}
 
 	if *image.VirtualizationType == ec2.VirtualizationTypeParavirtual {
-		for _, region := range regions {
+		for _, region := range targets {
 			if !RegionSupportsPV(region) {
 				return nil, NoRegionPVSupport
 			}
 		}
 	}
 
-	describeSnapshotRes, err := a.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+	describeSnapshotRes, err := src.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
 		SnapshotIds: []*string{image.BlockDeviceMappings[0].Ebs.SnapshotId},
 	})
 	if err != nil {
@@ -469,9 +605,9 @@ func (a *API) CopyImage(sourceImageID string, regions []string) (map[string]stri
 	}
 	snapshot := describeSnapshotRes.Snapshots[0]
 
-	describeAttributeRes, err := a.ec2.DescribeImageAttribute(&ec2.DescribeImageAttributeInput{
+	describeAttributeRes, err := src.ec2.DescribeImageAttribute(&ec2.DescribeImageAttributeInput{
 		Attribute: aws.String("launchPermission"),
-		ImageId:   aws.String(sourceImageID),
+		ImageId:   aws.String(amiID),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("couldn't describe launch permissions: %v", err)
@@ -479,22 +615,23 @@ func (a *API) CopyImage(sourceImageID string, regions []string) (map[string]stri
 	launchPermissions := describeAttributeRes.LaunchPermissions
 
 	var wg sync.WaitGroup
-	ch := make(chan result, len(regions))
-	for _, region := range regions {
+	ch := make(chan result, len(targets))
+	for _, region := range targets {
 		opts := *a.opts
 		opts.Region = region
 		aa, err := New(&opts)
 		if err != nil {
-			break
+			ch <- result{region: region, err: err}
+			continue
 		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			res := result{region: aa.opts.Region}
-			res.imageID, res.err = aa.copyImageIn(a.opts.Region, sourceImageID,
+			res.imageID, res.err = aa.copyImageIn(sourceRegion, amiID,
 				*image.Name, *image.Description,
 				image.Tags, snapshot.Tags,
-				launchPermissions)
+				launchPermissions, copyOpts.kmsKeyID(aa.opts.Region))
 			ch <- res
 		}()
 	}
@@ -502,30 +639,36 @@ func (a *API) CopyImage(sourceImageID string, regions []string) (map[string]stri
 	close(ch)
 
 	amis := make(map[string]string)
+	var errs multierror.Error
 	for res := range ch {
 		if res.imageID != "" {
 			amis[res.region] = res.imageID
 		}
-		if err == nil {
-			err = res.err
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", res.region, res.err))
 		}
 	}
-	return amis, err
+	return amis, errs.AsError()
 }
 
-func (a *API) copyImageIn(sourceRegion, sourceImageID, name, description string, imageTags, snapshotTags []*ec2.Tag, launchPermissions []*ec2.LaunchPermission) (string, error) {
+func (a *API) copyImageIn(sourceRegion, sourceImageID, name, description string, imageTags, snapshotTags []*ec2.Tag, launchPermissions []*ec2.LaunchPermission, kmsKeyID string) (string, error) {
 	imageID, err := a.FindImage(name)
 	if err != nil {
 		return "", err
 	}
 
 	if imageID == "" {
-		copyRes, err := a.ec2.CopyImage(&ec2.CopyImageInput{
+		input := &ec2.CopyImageInput{
 			SourceRegion:  aws.String(sourceRegion),
 			SourceImageId: aws.String(sourceImageID),
 			Name:          aws.String(name),
 			Description:   aws.String(description),
-		})
+		}
+		if kmsKeyID != "" {
+			input.Encrypted = aws.Bool(true)
+			input.KmsKeyId = aws.String(kmsKeyID)
+		}
+		copyRes, err := a.ec2.CopyImage(input)
 		if err != nil {
 			return "", fmt.Errorf("couldn't initiate image copy to %v: %v", a.opts.Region, err)
 		}
@@ -619,6 +762,71 @@ func (a *API) FindImage(name string) (string, error) {
 	return "", nil
 }
 
+// ListImages returns the images owned by this account whose names start
+// with prefix.
+func (a *API) ListImages(prefix string) ([]*ec2.Image, error) {
+	describeRes, err := a.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("name"),
+				Values: aws.StringSlice([]string{prefix + "*"}),
+			},
+		},
+		Owners: aws.StringSlice([]string{"self"}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't describe images: %v", err)
+	}
+	return describeRes.Images, nil
+}
+
+// FindImagesByTag returns the images owned by this account with the tag
+// key set to value.
+func (a *API) FindImagesByTag(key, value string) ([]*ec2.Image, error) {
+	describeRes, err := a.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("tag:" + key),
+				Values: aws.StringSlice([]string{value}),
+			},
+		},
+		Owners: aws.StringSlice([]string{"self"}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't describe images: %v", err)
+	}
+	return describeRes.Images, nil
+}
+
+// DeleteImage deregisters the specified image and deletes its EBS
+// snapshots.
+func (a *API) DeleteImage(imageID string) error {
+	image, err := a.describeImage(imageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.ec2.DeregisterImage(&ec2.DeregisterImageInput{
+		ImageId: aws.String(imageID),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't deregister image %v: %v", imageID, err)
+	}
+
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+			continue
+		}
+		_, err := a.ec2.DeleteSnapshot(&ec2.DeleteSnapshotInput{
+			SnapshotId: mapping.Ebs.SnapshotId,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't delete snapshot %v of image %v: %v", *mapping.Ebs.SnapshotId, imageID, err)
+		}
+	}
+	return nil
+}
+
 func (a *API) describeImage(imageID string) (*ec2.Image, error) {
 	describeRes, err := a.ec2.DescribeImages(&ec2.DescribeImagesInput{
 		ImageIds: aws.StringSlice([]string{imageID}),
@@ -626,9 +834,31 @@ func (a *API) describeImage(imageID string) (*ec2.Image, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't describe image: %v", err)
 	}
+	if len(describeRes.Images) == 0 {
+		return nil, fmt.Errorf("couldn't describe image: %v not found", imageID)
+	}
 	return describeRes.Images[0], nil
 }
 
+// CheckImage verifies that the configured AMI is available in the
+// region, since launching instances of a missing AMI fails with an
+// obscure error.
+func (a *API) CheckImage() error {
+	describeRes, err := a.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: aws.StringSlice([]string{a.opts.AMI}),
+	})
+	if awserr, ok := err.(awserr.Error); (ok && strings.HasPrefix(awserr.Code(), "InvalidAMIID.")) ||
+		(err == nil && len(describeRes.Images) == 0) {
+		return fmt.Errorf("AMI %v isn't available in region %v; it may need to be copied there", a.opts.AMI, a.opts.Region)
+	} else if err != nil {
+		return fmt.Errorf("couldn't describe AMI %v in region %v: %v", a.opts.AMI, a.opts.Region, err)
+	}
+	if state := aws.StringValue(describeRes.Images[0].State); state != ec2.ImageStateAvailable {
+		return fmt.Errorf("AMI %v in region %v is %v, not %v", a.opts.AMI, a.opts.Region, state, ec2.ImageStateAvailable)
+	}
+	return nil
+}
+
 // Grant everyone launch permission on the specified image and create-volume
 // permission on its underlying snapshot.
 func (a *API) PublishImage(imageID string) error {
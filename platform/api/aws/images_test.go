@@ -0,0 +1,41 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"testing"
+)
+
+func TestCopyImageKMSKeyID(t *testing.T) {
+	opts := &CopyImageOptions{
+		KMSKeyIDs: map[string]string{"us-east-1": "arn:aws:kms:us-east-1:123456789012:key/east"},
+		KMSKeyID:  "alias/coreos",
+	}
+	for _, tt := range []struct {
+		opts     *CopyImageOptions
+		region   string
+		expected string
+	}{
+		{nil, "us-east-1", ""},
+		{&CopyImageOptions{}, "us-east-1", ""},
+		{opts, "us-east-1", "arn:aws:kms:us-east-1:123456789012:key/east"},
+		{opts, "eu-west-1", "alias/coreos"},
+		{&CopyImageOptions{KMSKeyIDs: opts.KMSKeyIDs}, "eu-west-1", ""},
+	} {
+		if actual := tt.opts.kmsKeyID(tt.region); actual != tt.expected {
+			t.Errorf("%+v: expected key %q for %s, got %q", tt.opts, tt.expected, tt.region, actual)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/coreos/mantle/util"
+)
+
+// noPlacementGroupFamilies are the instance families that can't be
+// launched into cluster placement groups.
+var noPlacementGroupFamilies = map[string]bool{
+	"c1":   true,
+	"m1":   true,
+	"m2":   true,
+	"m3":   true,
+	"mac1": true,
+	"t1":   true,
+	"t2":   true,
+	"t3":   true,
+	"t3a":  true,
+	"t4g":  true,
+}
+
+// validatePlacementGroup checks that instances of instanceType can be
+// launched into a cluster placement group.
+func validatePlacementGroup(instanceType string) error {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	if noPlacementGroupFamilies[family] {
+		return fmt.Errorf("instance type %q doesn't support cluster placement groups", instanceType)
+	}
+	return nil
+}
+
+// EnsurePlacementGroup creates the cluster placement group set in the
+// options if it doesn't already exist, and reports whether it created
+// the group.
+func (a *API) EnsurePlacementGroup() (bool, error) {
+	name := a.opts.PlacementGroup
+	if err := validatePlacementGroup(a.opts.InstanceType); err != nil {
+		return false, err
+	}
+
+	exists, err := a.PlacementGroupExists(name)
+	if err != nil || exists {
+		return false, err
+	}
+
+	_, err = a.ec2.CreatePlacementGroup(&ec2.CreatePlacementGroupInput{
+		GroupName: aws.String(name),
+		Strategy:  aws.String(ec2.PlacementStrategyCluster),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidPlacementGroup.Duplicate" {
+		// created concurrently by another cluster
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("error creating placement group %v: %v", name, err)
+	}
+	return true, nil
+}
+
+// PlacementGroupExists reports whether the named cluster placement group
+// exists. It fails if a group of that name uses another strategy.
+func (a *API) PlacementGroupExists(name string) (bool, error) {
+	desc, err := a.ec2.DescribePlacementGroups(&ec2.DescribePlacementGroupsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("group-name"),
+				Values: aws.StringSlice([]string{name}),
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("error describing placement group %v: %v", name, err)
+	}
+	if len(desc.PlacementGroups) == 0 {
+		return false, nil
+	}
+	group := desc.PlacementGroups[0]
+	if aws.StringValue(group.Strategy) != ec2.PlacementStrategyCluster {
+		return false, fmt.Errorf("placement group %v has strategy %v, not %v", name, aws.StringValue(group.Strategy), ec2.PlacementStrategyCluster)
+	}
+	return true, nil
+}
+
+// DeletePlacementGroup deletes the placement group set in the options,
+// waiting for terminating instances to leave it.
+func (a *API) DeletePlacementGroup() error {
+	name := a.opts.PlacementGroup
+	inUse := func(err error) bool {
+		return isRetryable(err, "InvalidPlacementGroup.InUse")
+	}
+	return util.RetryConditional(30, 10*time.Second, inUse, func() error {
+		_, err := a.ec2.DeletePlacementGroup(&ec2.DeletePlacementGroupInput{
+			GroupName: aws.String(name),
+		})
+		return err
+	})
+}
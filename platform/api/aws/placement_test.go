@@ -0,0 +1,52 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestValidatePlacementGroup(t *testing.T) {
+	for _, tt := range []struct {
+		instanceType string
+		ok           bool
+	}{
+		{"m4.large", true},
+		{"c5n.18xlarge", true},
+		{"t2.micro", false},
+		{"t3a.small", false},
+		{"m3.medium", false},
+	} {
+		err := validatePlacementGroup(tt.instanceType)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.instanceType, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: expected error", tt.instanceType)
+		}
+	}
+}
+
+func TestSpotPlacement(t *testing.T) {
+	if p := spotPlacement(nil); p != nil {
+		t.Errorf("expected no spot placement, got %v", p)
+	}
+	p := spotPlacement(&ec2.Placement{GroupName: aws.String("kola")})
+	if p == nil || aws.StringValue(p.GroupName) != "kola" {
+		t.Errorf("expected spot placement in group kola, got %v", p)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// CheckCapacity warns if the region's on-demand instance limit doesn't
+// leave room for the requested number of instances. It never fails the
+// run: EC2 limits are now counted in vCPUs through Service Quotas, so the
+// max-instances account attribute is at best an approximation.
+func (a *API) CheckCapacity(needed platform.ResourceRequest) error {
+	// spot instances have separate limits which EC2 doesn't expose
+	if needed.Instances <= 0 || (a.opts.SpotPrice != "" && !a.opts.SpotFallback) {
+		return nil
+	}
+
+	limit, err := a.maxInstances()
+	if err != nil {
+		return err
+	}
+
+	running := 0
+	err = a.ec2.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, res := range page.Reservations {
+			for _, inst := range res.Instances {
+				if aws.StringValue(inst.InstanceLifecycle) != ec2.InstanceLifecycleTypeSpot {
+					running++
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("counting running instances: %v", err)
+	}
+
+	if available := limit - running; needed.Instances > available {
+		plog.Warningf("Launches may fail: %v", &platform.CapacityError{
+			Region:    a.opts.Region,
+			Resource:  "on-demand instance",
+			Needed:    float64(needed.Instances),
+			Available: float64(available),
+		})
+	}
+	return nil
+}
+
+// maxInstances returns the account's on-demand instance limit in the region.
+func (a *API) maxInstances() (int, error) {
+	resp, err := a.ec2.DescribeAccountAttributes(&ec2.DescribeAccountAttributesInput{
+		AttributeNames: aws.StringSlice([]string{"max-instances"}),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("getting instance limit: %v", err)
+	}
+	for _, attr := range resp.AccountAttributes {
+		if aws.StringValue(attr.AttributeName) != "max-instances" || len(attr.AttributeValues) == 0 {
+			continue
+		}
+		value := aws.StringValue(attr.AttributeValues[0].AttributeValue)
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("parsing instance limit %q: %v", value, err)
+		}
+		return limit, nil
+	}
+	return 0, fmt.Errorf("instance limit not found in account attributes")
+}
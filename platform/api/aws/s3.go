@@ -15,13 +15,20 @@
 package aws
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/coreos/mantle/util"
 )
 
 const (
@@ -42,8 +49,10 @@ func s3IsNotFound(err error) bool {
 	return false
 }
 
-// UploadObject uploads an object to S3
-func (a *API) UploadObject(r io.Reader, bucket, path string, force bool) error {
+// UploadObject uploads an object to S3. The SHA-256 of the data is stored
+// in the object's "sha256" metadata, and the upload is verified against
+// it and, unless the bucket encrypts with KMS, the ETag computed by S3.
+func (a *API) UploadObject(r io.ReadSeeker, bucket, path string, force bool) error {
 	s3uploader := s3manager.NewUploaderWithClient(a.s3)
 
 	if !force {
@@ -61,15 +70,195 @@ func (a *API) UploadObject(r io.Reader, bucket, path string, force bool) error {
 		}
 	}
 
-	_, err := s3uploader.Upload(&s3manager.UploadInput{
-		Body:   r,
-		Bucket: aws.String(bucket),
-		Key:    aws.String(path),
+	sum, err := s3Checksum(r, s3uploader.PartSize, s3uploader.MaxUploadParts)
+	if err != nil {
+		return fmt.Errorf("error checksumming upload for s3://%v/%v: %v", bucket, path, err)
+	}
+	// use the part size the ETag was computed with
+	s3uploader.PartSize = sum.partSize
+
+	reporter := a.opts.Progress
+	if reporter == nil {
+		reporter = util.DefaultReporter
+	}
+	_, err = s3uploader.Upload(&s3manager.UploadInput{
+		Body:     newUploadProgress(r, fmt.Sprintf("Uploading s3://%v/%v", bucket, path), sum.size, reporter),
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(path),
+		Metadata: map[string]*string{"sha256": aws.String(sum.sha256)},
 	})
 	if err != nil {
 		return fmt.Errorf("error uploading s3://%v/%v: %v", bucket, path, err)
 	}
-	return err
+
+	head, err := a.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &path,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to head uploaded object s3://%v/%v: %v", bucket, path, err)
+	}
+	if err := sum.verify(head); err != nil {
+		return fmt.Errorf("uploaded object s3://%v/%v %v", bucket, path, err)
+	}
+	return nil
+}
+
+// verify checks the size and checksums of an uploaded object. The ETag
+// is only the MD5 of the data for unencrypted or SSE-S3 objects; objects
+// encrypted with KMS or customer keys are checked against their stored
+// SHA-256 alone.
+func (sum *s3Sum) verify(head *s3.HeadObjectOutput) error {
+	if head.ContentLength == nil || *head.ContentLength != sum.size {
+		return fmt.Errorf("has size %v, expected %v", aws.Int64Value(head.ContentLength), sum.size)
+	}
+	var stored string
+	for key, value := range head.Metadata {
+		// S3 returns metadata keys capitalized
+		if strings.EqualFold(key, "sha256") {
+			stored = aws.StringValue(value)
+		}
+	}
+	if stored != sum.sha256 {
+		return fmt.Errorf("has SHA-256 %q, expected %q", stored, sum.sha256)
+	}
+	encryption := aws.StringValue(head.ServerSideEncryption)
+	if head.SSECustomerAlgorithm != nil || (encryption != "" && encryption != s3.ServerSideEncryptionAes256) {
+		return nil
+	}
+	if etag := strings.Trim(aws.StringValue(head.ETag), `"`); etag != sum.etag {
+		return fmt.Errorf("has ETag %q, expected %q", etag, sum.etag)
+	}
+	return nil
+}
+
+// uploadProgress reports how much of an upload has been read, which the
+// uploader does a few parts ahead of what S3 has received. Parts read
+// more than once, e.g. to sign them, count once.
+type uploadProgress struct {
+	io.ReadSeeker
+	desc     string
+	total    int64
+	reporter util.ProgressReporter
+
+	mu   sync.Mutex
+	pos  int64
+	done int64
+}
+
+// uploadProgressAt is an uploadProgress for readers the uploader can read
+// parts from concurrently.
+type uploadProgressAt struct {
+	*uploadProgress
+	at io.ReaderAt
+}
+
+func newUploadProgress(r io.ReadSeeker, desc string, total int64, reporter util.ProgressReporter) io.ReadSeeker {
+	p := &uploadProgress{ReadSeeker: r, desc: desc, total: total, reporter: reporter}
+	if at, ok := r.(io.ReaderAt); ok {
+		return &uploadProgressAt{p, at}
+	}
+	return p
+}
+
+func (p *uploadProgress) Read(b []byte) (int, error) {
+	n, err := p.ReadSeeker.Read(b)
+	p.mu.Lock()
+	p.pos += int64(n)
+	end := p.pos
+	p.mu.Unlock()
+	p.mark(end)
+	return n, err
+}
+
+func (p *uploadProgress) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		p.mu.Lock()
+		p.pos = pos
+		p.mu.Unlock()
+	}
+	return pos, err
+}
+
+// mark records that the upload has been read up to end.
+func (p *uploadProgress) mark(end int64) {
+	p.mu.Lock()
+	if end <= p.done {
+		p.mu.Unlock()
+		return
+	}
+	p.done = end
+	p.mu.Unlock()
+	p.reporter.Bytes(p.desc, end, p.total)
+}
+
+func (p *uploadProgressAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.at.ReadAt(b, off)
+	p.mark(off + int64(n))
+	return n, err
+}
+
+type s3Sum struct {
+	size     int64
+	partSize int64
+	sha256   string
+	etag     string
+}
+
+// s3Checksum computes the SHA-256 of r and the ETag S3 will report for it
+// when uploaded by s3manager, then rewinds r. Single-part uploads have the
+// MD5 of the data as ETag; multipart uploads have the MD5 of the parts'
+// MD5s followed by the number of parts.
+func s3Checksum(r io.ReadSeeker, partSize int64, maxParts int) (*s3Sum, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// same adjustment as s3manager makes for large objects
+	if size/partSize >= int64(maxParts) {
+		partSize = size/int64(maxParts) + 1
+	}
+
+	whole := sha256.New()
+	var partSums []byte
+	var parts int
+	for {
+		part := md5.New()
+		n, err := io.Copy(io.MultiWriter(whole, part), io.LimitReader(r, partSize))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 && parts > 0 {
+			break
+		}
+		partSums = part.Sum(partSums)
+		parts++
+		if n < partSize {
+			break
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	sum := &s3Sum{
+		size:     size,
+		partSize: partSize,
+		sha256:   hex.EncodeToString(whole.Sum(nil)),
+	}
+	if size <= partSize {
+		sum.etag = hex.EncodeToString(partSums)
+	} else {
+		multi := md5.Sum(partSums)
+		sum.etag = fmt.Sprintf("%s-%d", hex.EncodeToString(multi[:]), parts)
+	}
+	return sum, nil
 }
 
 func (a *API) DeleteObject(bucket, path string) error {
@@ -0,0 +1,158 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func multipartETag(parts ...string) string {
+	var sums []byte
+	for _, part := range parts {
+		sum := md5.Sum([]byte(part))
+		sums = append(sums, sum[:]...)
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(parts))
+}
+
+func TestS3Checksum(t *testing.T) {
+	empty := md5.Sum(nil)
+	short := md5.Sum([]byte("abc"))
+	for _, tt := range []struct {
+		data     string
+		partSize int64
+		maxParts int
+		etag     string
+		realSize int64
+	}{
+		{"", 4, 100, hex.EncodeToString(empty[:]), 4},
+		{"abc", 4, 100, hex.EncodeToString(short[:]), 4},
+		{"abcdefghij", 4, 100, multipartETag("abcd", "efgh", "ij"), 4},
+		{"abcdefgh", 4, 100, multipartETag("abcd", "efgh"), 4},
+		{"abcdefghij", 1, 4, multipartETag("abc", "def", "ghi", "j"), 3},
+	} {
+		r := strings.NewReader(tt.data)
+		sum, err := s3Checksum(r, tt.partSize, tt.maxParts)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.data, err)
+		}
+		if sum.etag != tt.etag {
+			t.Errorf("%q: expected ETag %q, got %q", tt.data, tt.etag, sum.etag)
+		}
+		if sum.partSize != tt.realSize {
+			t.Errorf("%q: expected part size %d, got %d", tt.data, tt.realSize, sum.partSize)
+		}
+		if sum.size != int64(len(tt.data)) {
+			t.Errorf("%q: expected size %d, got %d", tt.data, len(tt.data), sum.size)
+		}
+		whole := sha256.Sum256([]byte(tt.data))
+		if sum.sha256 != hex.EncodeToString(whole[:]) {
+			t.Errorf("%q: wrong SHA-256 %q", tt.data, sum.sha256)
+		}
+		if r.Len() != len(tt.data) {
+			t.Errorf("%q: reader not rewound", tt.data)
+		}
+	}
+}
+
+// byteRecorder records the progress reported in bytes.
+type byteRecorder struct {
+	done []int64
+}
+
+func (r *byteRecorder) Bytes(desc string, done, total int64) { r.done = append(r.done, done) }
+func (r *byteRecorder) Percent(desc string, percent int)     {}
+
+func TestUploadProgress(t *testing.T) {
+	data := strings.NewReader("0123456789")
+
+	// parts read again, e.g. to sign them, don't count twice
+	progress := &byteRecorder{}
+	r := newUploadProgress(data, "upload", 10, progress).(io.ReaderAt)
+	buf := make([]byte, 4)
+	for _, off := range []int64{0, 0, 4, 8, 4} {
+		r.ReadAt(buf, off)
+	}
+	if expected := []int64{4, 8, 10}; !reflect.DeepEqual(progress.done, expected) {
+		t.Errorf("ReadAt: expected progress %v, got %v", expected, progress.done)
+	}
+
+	// sequential readers count from where they were seeked to
+	progress = &byteRecorder{}
+	seq := newUploadProgress(struct{ io.ReadSeeker }{data}, "upload", 10, progress)
+	if _, ok := seq.(io.ReaderAt); ok {
+		t.Fatal("sequential reader has ReadAt")
+	}
+	seq.Seek(0, io.SeekStart)
+	seq.Read(buf)
+	seq.Seek(0, io.SeekStart)
+	seq.Read(buf)
+	seq.Read(buf)
+	if expected := []int64{4, 8}; !reflect.DeepEqual(progress.done, expected) {
+		t.Errorf("Read: expected progress %v, got %v", expected, progress.done)
+	}
+}
+
+func TestS3SumVerify(t *testing.T) {
+	sum := &s3Sum{size: 3, sha256: "abc-sha256", etag: "abc-md5"}
+	head := func(size int64, sha, etag, encryption string, customer bool) *s3.HeadObjectOutput {
+		h := &s3.HeadObjectOutput{
+			ContentLength: aws.Int64(size),
+			ETag:          aws.String(`"` + etag + `"`),
+			Metadata:      map[string]*string{"Sha256": aws.String(sha)},
+		}
+		if encryption != "" {
+			h.ServerSideEncryption = aws.String(encryption)
+		}
+		if customer {
+			h.SSECustomerAlgorithm = aws.String("AES256")
+		}
+		return h
+	}
+
+	for i, tt := range []struct {
+		head  *s3.HeadObjectOutput
+		valid bool
+	}{
+		{head(3, "abc-sha256", "abc-md5", "", false), true},
+		{head(3, "abc-sha256", "abc-md5", s3.ServerSideEncryptionAes256, false), true},
+		{head(4, "abc-sha256", "abc-md5", "", false), false},
+		{head(3, "other", "abc-md5", "", false), false},
+		{head(3, "abc-sha256", "other", "", false), false},
+		{head(3, "abc-sha256", "other", s3.ServerSideEncryptionAes256, false), false},
+		// the ETag of encrypted objects isn't their MD5
+		{head(3, "abc-sha256", "other", s3.ServerSideEncryptionAwsKms, false), true},
+		{head(3, "abc-sha256", "other", "", true), true},
+		{head(3, "other", "other", s3.ServerSideEncryptionAwsKms, false), false},
+	} {
+		err := sum.verify(tt.head)
+		if tt.valid && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/coreos/mantle/util"
+)
+
+// spotRequestTimeout is how long to wait for spot requests to be fulfilled.
+const spotRequestTimeout = 5 * time.Minute
+
+// spotPlacement converts the placement of a RunInstances request to that
+// of a spot launch specification.
+func spotPlacement(placement *ec2.Placement) *ec2.SpotPlacement {
+	if placement == nil {
+		return nil
+	}
+	return &ec2.SpotPlacement{
+		GroupName: placement.GroupName,
+	}
+}
+
+// requestSpotInstances requests spot instances matching the given
+// RunInstances parameters and waits for the requests to be fulfilled,
+// returning the IDs of the launched instances. If the requests are not
+// fulfilled within spotRequestTimeout, they are cancelled.
+func (a *API) requestSpotInstances(inst *ec2.RunInstancesInput) ([]string, error) {
+	validUntil := time.Now().Add(spotRequestTimeout)
+	res, err := a.ec2.RequestSpotInstances(&ec2.RequestSpotInstancesInput{
+		SpotPrice:     aws.String(a.opts.SpotPrice),
+		InstanceCount: inst.MaxCount,
+		ValidUntil:    &validUntil,
+		LaunchSpecification: &ec2.RequestSpotLaunchSpecification{
+			ImageId:             inst.ImageId,
+			InstanceType:        inst.InstanceType,
+			KeyName:             inst.KeyName,
+			SecurityGroupIds:    inst.SecurityGroupIds,
+			UserData:            inst.UserData,
+			IamInstanceProfile:  inst.IamInstanceProfile,
+			BlockDeviceMappings: inst.BlockDeviceMappings,
+			Placement:           spotPlacement(inst.Placement),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error requesting spot instances: %v", err)
+	}
+
+	var reqIDs []string
+	for _, req := range res.SpotInstanceRequests {
+		reqIDs = append(reqIDs, *req.SpotInstanceRequestId)
+	}
+
+	var ids []string
+	retry := func(err error) bool {
+		return isRetryable(err, "InvalidSpotInstanceRequestID.NotFound")
+	}
+	err = util.PollBackoff(pollBackoff(5*time.Second, spotRequestTimeout), retry, func() (bool, error) {
+		desc, err := a.ec2.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: aws.StringSlice(reqIDs),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		ids = nil
+		for _, req := range desc.SpotInstanceRequests {
+			switch *req.State {
+			case ec2.SpotInstanceStateActive:
+				ids = append(ids, *req.InstanceId)
+			case ec2.SpotInstanceStateOpen:
+			default:
+				var status string
+				if req.Status != nil && req.Status.Code != nil {
+					status = *req.Status.Code
+				}
+				return false, fmt.Errorf("spot request %v is %v: %v", *req.SpotInstanceRequestId, *req.State, status)
+			}
+		}
+		return len(ids) == len(reqIDs), nil
+	})
+
+	// Cancelling a request doesn't terminate instances it launched, so
+	// the requests can always be cancelled once we're done waiting.
+	if _, cerr := a.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: aws.StringSlice(reqIDs),
+	}); cerr != nil {
+		plog.Warningf("Error cancelling spot requests %v: %v", reqIDs, cerr)
+	}
+
+	if err != nil {
+		// A request may have been fulfilled after it was last described
+		// but before it was cancelled. Its instance isn't tagged, so
+		// garbage collection would never find it; look it up again.
+		if launched, derr := a.spotRequestInstances(reqIDs); derr != nil {
+			plog.Warningf("Error describing cancelled spot requests %v: %v", reqIDs, derr)
+		} else {
+			ids = launched
+		}
+		if len(ids) > 0 {
+			if terr := a.TerminateInstances(ids); terr != nil {
+				plog.Warningf("Error terminating spot instances %v: %v", ids, terr)
+			}
+		}
+		return nil, fmt.Errorf("waiting for spot requests: %v", err)
+	}
+
+	// Spot requests don't support tag specifications, so tag the
+	// instances now that they exist.
+	for _, spec := range inst.TagSpecifications {
+		_, err := a.ec2.CreateTags(&ec2.CreateTagsInput{
+			Resources: aws.StringSlice(ids),
+			Tags:      spec.Tags,
+		})
+		if err != nil {
+			a.TerminateInstances(ids)
+			return nil, fmt.Errorf("error tagging spot instances: %v", err)
+		}
+	}
+
+	return ids, nil
+}
+
+// spotRequestInstances returns the IDs of the instances launched by the
+// given spot requests, whatever their state.
+func (a *API) spotRequestInstances(reqIDs []string) ([]string, error) {
+	desc, err := a.ec2.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: aws.StringSlice(reqIDs),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, req := range desc.SpotInstanceRequests {
+		if req.InstanceId != nil && *req.InstanceId != "" {
+			ids = append(ids, *req.InstanceId)
+		}
+	}
+	return ids, nil
+}
+
+// GetSpotInterruption returns the status code of the spot request that
+// launched instanceID if the instance has been or is about to be
+// interrupted, or "" otherwise.
+func (a *API) GetSpotInterruption(instanceID string) (string, error) {
+	desc, err := a.ec2.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("instance-id"),
+				Values: aws.StringSlice([]string{instanceID}),
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing spot requests for %v: %v", instanceID, err)
+	}
+
+	for _, req := range desc.SpotInstanceRequests {
+		if req.Status == nil || req.Status.Code == nil {
+			continue
+		}
+		switch *req.Status.Code {
+		case "marked-for-termination", "marked-for-stop",
+			"instance-terminated-by-price", "instance-terminated-no-capacity",
+			"instance-terminated-capacity-oversubscribed",
+			"instance-terminated-launch-group-constraint":
+			return *req.Status.Code, nil
+		}
+	}
+	return "", nil
+}
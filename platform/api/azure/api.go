@@ -16,16 +16,29 @@ package azure
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/management"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/coreos/pkg/capnslog"
+
+	"github.com/coreos/mantle/util"
 )
 
 var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/api/azure")
 )
 
+// operationBackoff is how long waitForOperation waits between polls of an
+// operation's status.
+var operationBackoff = util.Backoff{
+	Initial: 5 * time.Second,
+	Max:     time.Minute,
+	Jitter:  0.2,
+}
+
 type API struct {
 	client management.Client
 	opts   *Options
@@ -57,3 +70,51 @@ func New(opts *Options) (*API, error) {
 
 	return api, nil
 }
+
+// waitForOperation polls an asynchronous operation until it completes,
+// backing off between polls and retrying transient failures to fetch its
+// status.
+func (a *API) waitForOperation(op management.OperationID) error {
+	var opErr error
+	err := util.PollBackoff(operationBackoff, isTransient, func() (bool, error) {
+		status, err := a.client.GetOperationStatus(op)
+		if err != nil {
+			plog.Debugf("Getting status of operation %s failed: %v", op, err)
+			return false, err
+		}
+		switch status.Status {
+		case management.OperationStatusSucceeded:
+			return true, nil
+		case management.OperationStatusFailed:
+			if status.Error != nil {
+				opErr = *status.Error
+			} else {
+				opErr = fmt.Errorf("Azure operation %s failed", op)
+			}
+			return true, nil
+		case management.OperationStatusInProgress:
+			return false, nil
+		default:
+			return false, fmt.Errorf("unknown status %q for Azure operation %s", status.Status, op)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for Azure operation %s: %v", op, err)
+	}
+	return opErr
+}
+
+// isTransient reports whether a failed request should be retried.
+func isTransient(err error) bool {
+	switch err := err.(type) {
+	case management.AzureError:
+		switch err.Code {
+		case "InternalError", "ServiceUnavailable", "ServerBusy", "OperationTimedOut", "TooManyRequests":
+			return true
+		}
+		return false
+	case *url.Error, net.Error:
+		return true
+	}
+	return false
+}
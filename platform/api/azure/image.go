@@ -59,7 +59,7 @@ func (a *API) ShareImage(image, permission string) error {
 		return err
 	}
 
-	return a.client.WaitForOperation(op, nil)
+	return a.waitForOperation(op)
 }
 
 func IsConflictError(err error) bool {
@@ -72,7 +72,7 @@ func (a *API) ReplicateImage(image, offer, sku, version string, regions ...strin
 		return err
 	}
 
-	return a.client.WaitForOperation(op, nil)
+	return a.waitForOperation(op)
 }
 
 func (a *API) UnreplicateImage(image string) error {
@@ -82,5 +82,5 @@ func (a *API) UnreplicateImage(image string) error {
 		return err
 	}
 
-	return a.client.WaitForOperation(op, nil)
+	return a.waitForOperation(op)
 }
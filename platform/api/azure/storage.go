@@ -44,7 +44,7 @@ func (a *API) AddOSImage(md *OSImage) error {
 		return err
 	}
 
-	return a.client.WaitForOperation(op, nil)
+	return a.waitForOperation(op)
 }
 
 func (a *API) OSImageExists(name string) (bool, error) {
@@ -71,6 +71,44 @@ func (a *API) OSImageExists(name string) (bool, error) {
 	return false, nil
 }
 
+type osImageList struct {
+	XMLName  xml.Name  `xml:"http://schemas.microsoft.com/windowsazure Images"`
+	OSImages []OSImage `xml:"OSImage"`
+}
+
+// https://msdn.microsoft.com/en-us/library/azure/jj157191.aspx
+func (a *API) ListOSImages() ([]OSImage, error) {
+	response, err := a.client.SendAzureGetRequest(azureImageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var list osImageList
+	if err := xml.Unmarshal(response, &list); err != nil {
+		return nil, err
+	}
+
+	return list.OSImages, nil
+}
+
+// DeleteOSImage deletes the named OS image, and its underlying blob if
+// deleteMedia is set.
+//
+// https://msdn.microsoft.com/en-us/library/azure/jj157203.aspx
+func (a *API) DeleteOSImage(name string, deleteMedia bool) error {
+	url := fmt.Sprintf("%s/%s", azureImageURL, name)
+	if deleteMedia {
+		url += "?comp=media"
+	}
+
+	op, err := a.client.SendAzureDeleteRequest(url)
+	if err != nil {
+		return err
+	}
+
+	return a.waitForOperation(op)
+}
+
 func (a *API) UrlOfBlob(account, container, blob string) *url.URL {
 	return &url.URL{
 		Scheme: "https",
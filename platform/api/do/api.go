@@ -201,16 +201,62 @@ func (a *API) GetDroplet(ctx context.Context, dropletID int) (*godo.Droplet, err
 	return droplet, nil
 }
 
-// SnapshotDroplet creates a snapshot of a droplet and waits until complete.
-// The Snapshot API doesn't return the snapshot ID, so we don't either.
-func (a *API) SnapshotDroplet(ctx context.Context, dropletID int, name string) error {
+// SnapshotDroplet creates a snapshot of a droplet, optionally powering it
+// off first, waits until complete, and returns the snapshot ID.
+func (a *API) SnapshotDroplet(ctx context.Context, dropletID int, name string, powerOff bool) (int, error) {
+	if powerOff {
+		action, _, err := a.c.DropletActions.PowerOff(ctx, dropletID)
+		if err != nil {
+			return 0, fmt.Errorf("powering off droplet %d: %v", dropletID, err)
+		}
+		if err := a.waitForAction(ctx, action.ID, 5*time.Minute); err != nil {
+			return 0, fmt.Errorf("powering off droplet %d: %v", dropletID, err)
+		}
+	}
+
 	action, _, err := a.c.DropletActions.Snapshot(ctx, dropletID, name)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if err := a.waitForAction(ctx, action.ID, 30*time.Minute); err != nil {
+		return 0, fmt.Errorf("snapshotting droplet %d: %v", dropletID, err)
+	}
+
+	// The Snapshot action doesn't return the snapshot ID, so look it up
+	// by name.
+	page := godo.ListOptions{
+		Page:    1,
+		PerPage: 200,
+	}
+	for {
+		snapshots, _, err := a.c.Droplets.Snapshots(ctx, dropletID, &page)
+		if err != nil {
+			return 0, fmt.Errorf("listing snapshots of droplet %d: %v", dropletID, err)
+		}
+		for _, snapshot := range snapshots {
+			if snapshot.Name == name {
+				return snapshot.ID, nil
+			}
+		}
+		if len(snapshots) < page.PerPage {
+			break
+		}
+		page.Page += 1
+	}
+	return 0, fmt.Errorf("couldn't find snapshot %q of droplet %d", name, dropletID)
+}
+
+// DeleteSnapshot deletes a droplet snapshot.
+func (a *API) DeleteSnapshot(ctx context.Context, snapshotID int) error {
+	_, err := a.c.Images.Delete(ctx, snapshotID)
+	if err != nil {
+		return fmt.Errorf("deleting snapshot %d: %v", snapshotID, err)
 	}
-	actionID := action.ID
+	return nil
+}
 
-	err = util.WaitUntilReady(30*time.Minute, 15*time.Second, func() (bool, error) {
+func (a *API) waitForAction(ctx context.Context, actionID int, timeout time.Duration) error {
+	return util.WaitUntilReady(timeout, 15*time.Second, func() (bool, error) {
 		action, _, err := a.c.Actions.Get(ctx, actionID)
 		if err != nil {
 			return false, err
@@ -221,14 +267,9 @@ func (a *API) SnapshotDroplet(ctx context.Context, dropletID int, name string) e
 		case "completed":
 			return true, nil
 		default:
-			return false, fmt.Errorf("snapshot failed")
+			return false, fmt.Errorf("action %d %s", actionID, action.Status)
 		}
 	})
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func (a *API) DeleteDroplet(ctx context.Context, dropletID int) error {
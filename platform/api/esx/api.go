@@ -32,6 +32,7 @@ import (
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/progress"
 	"github.com/vmware/govmomi/vim25/soap"
@@ -54,6 +55,12 @@ type Options struct {
 	User       string
 	Password   string
 	BaseVMName string
+
+	// Inventory paths for VM placement. Defaults are used if empty.
+	ResourcePool string
+	Folder       string
+	// Datastore or datastore cluster name
+	Datastore string
 }
 
 var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/api/esx")
@@ -79,6 +86,7 @@ type serverResources struct {
 	finder       *find.Finder
 	datacenter   *object.Datacenter
 	resourcePool *object.ResourcePool
+	folder       *object.Folder
 	datastore    *object.Datastore
 	network      object.NetworkReference
 }
@@ -329,11 +337,7 @@ func (a *API) CreateDevice(name string, conf *conf.Conf) (*ESXMachine, error) {
 		return nil, fmt.Errorf("couldn't find base VM: %v", err)
 	}
 
-	folders, err := defaults.datacenter.Folders(a.ctx)
-	if err != nil {
-		return nil, fmt.Errorf("getting datacenter folders: %v", err)
-	}
-	folder := folders.VmFolder
+	folder := defaults.folder
 
 	cloneSpec, err := a.buildCloneSpec(baseVM, folder, defaults.network, defaults.resourcePool, defaults.datastore, userdata)
 	if err != nil {
@@ -393,13 +397,7 @@ func (a *API) CreateBaseDevice(name, ovaPath string) error {
 		return fmt.Errorf("building CreateImportSpecRequest: %v", err)
 	}
 
-	folders, err := defaults.datacenter.Folders(a.ctx)
-	if err != nil {
-		return fmt.Errorf("getting datacenter folders: %v", err)
-	}
-	folder := folders.VmFolder
-
-	entity, err := a.uploadToResourcePool(arch, defaults.resourcePool, cisr, folder)
+	entity, err := a.uploadToResourcePool(arch, defaults.resourcePool, cisr, defaults.folder)
 	if err != nil {
 		return fmt.Errorf("uploading disks to ResourcePool: %v", err)
 	}
@@ -486,13 +484,17 @@ func (a *API) getServerDefaults() (serverResources, error) {
 		return serverResources{}, err
 	}
 	finder.SetDatacenter(datacenter)
-	resourcePool, err := finder.DefaultResourcePool(a.ctx)
+	resourcePool, err := finder.ResourcePoolOrDefault(a.ctx, a.options.ResourcePool)
 	if err != nil {
-		return serverResources{}, err
+		return serverResources{}, fmt.Errorf("resolving resource pool: %v", err)
 	}
-	datastore, err := finder.DefaultDatastore(a.ctx)
+	folder, err := finder.FolderOrDefault(a.ctx, a.options.Folder)
 	if err != nil {
-		return serverResources{}, err
+		return serverResources{}, fmt.Errorf("resolving VM folder: %v", err)
+	}
+	datastore, err := a.findDatastore(finder)
+	if err != nil {
+		return serverResources{}, fmt.Errorf("resolving datastore: %v", err)
 	}
 
 	defaultNetwork, err := finder.DefaultNetwork(a.ctx)
@@ -504,11 +506,71 @@ func (a *API) getServerDefaults() (serverResources, error) {
 		finder:       finder,
 		datacenter:   datacenter,
 		resourcePool: resourcePool,
+		folder:       folder,
 		datastore:    datastore,
 		network:      defaultNetwork,
 	}, nil
 }
 
+// findDatastore resolves the configured datastore. If the name refers to a
+// datastore cluster, the member datastore with the most free space is used.
+func (a *API) findDatastore(finder *find.Finder) (*object.Datastore, error) {
+	if a.options.Datastore == "" {
+		return finder.DefaultDatastore(a.ctx)
+	}
+
+	datastore, err := finder.Datastore(a.ctx, a.options.Datastore)
+	if err == nil {
+		return datastore, nil
+	}
+	if _, ok := err.(*find.NotFoundError); !ok {
+		return nil, err
+	}
+
+	pod, err := finder.DatastoreCluster(a.ctx, a.options.Datastore)
+	if err != nil {
+		if _, ok := err.(*find.NotFoundError); ok {
+			return nil, fmt.Errorf("no datastore or datastore cluster named %q", a.options.Datastore)
+		}
+		return nil, err
+	}
+
+	children, err := pod.Children(a.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing datastore cluster %q: %v", a.options.Datastore, err)
+	}
+	var refs []types.ManagedObjectReference
+	for _, child := range children {
+		if ref := child.Reference(); ref.Type == "Datastore" {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("datastore cluster %q has no datastores", a.options.Datastore)
+	}
+
+	var datastores []mo.Datastore
+	err = property.DefaultCollector(a.client.Client).Retrieve(a.ctx, refs, []string{"summary"}, &datastores)
+	if err != nil {
+		return nil, fmt.Errorf("getting datastore summaries: %v", err)
+	}
+	var best *mo.Datastore
+	for i := range datastores {
+		ds := &datastores[i]
+		if !ds.Summary.Accessible {
+			continue
+		}
+		if best == nil || ds.Summary.FreeSpace > best.Summary.FreeSpace {
+			best = ds
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("datastore cluster %q has no accessible datastores", a.options.Datastore)
+	}
+
+	return object.NewDatastore(a.client.Client, best.Reference()), nil
+}
+
 func (a *API) uploadToResourcePool(arch *archive, resourcePool *object.ResourcePool, cisr *types.OvfCreateImportSpecResult, folder *object.Folder) (*types.ManagedObjectReference, error) {
 	lease, err := resourcePool.ImportVApp(a.ctx, cisr.ImportSpec, folder, nil)
 	if err != nil {
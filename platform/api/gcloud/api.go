@@ -19,14 +19,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/coreos/pkg/capnslog"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/compute/v1"
 
 	"github.com/coreos/mantle/auth"
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/util"
 )
 
 var (
@@ -34,14 +37,81 @@ var (
 )
 
 type Options struct {
-	Image       string
-	Project     string
-	Zone        string
+	Image   string
+	Project string
+	Zone    string
+	// Zones, if set, spreads a cluster's instances round-robin across
+	// these zones instead of launching them all in Zone. The zones must
+	// be in one region.
+	Zones       []string
 	MachineType string
-	DiskType    string
-	Network     string
-	JSONKeyFile string
-	ServiceAuth bool
+	// MachineTypes are tried in order if Zone is out of capacity for
+	// MachineType. Patterns like e2-standard-* match the types of the
+	// zone, smallest first.
+	MachineTypes []string
+	DiskType     string
+	Network      string
+	// Subnetwork is an optional subnetwork of Network in the region of
+	// Zone. Like Network, it may be a short name or a self-link.
+	Subnetwork string
+	// NoExternalIP launches instances without an external IP address.
+	NoExternalIP bool
+	JSONKeyFile  string
+	ServiceAuth  bool
+	// TokenSource, if set, authenticates requests instead of
+	// ServiceAuth, JSONKeyFile, or the interactive token, e.g. for
+	// workload identity or impersonated credentials. Tokens are cached
+	// and refreshed from it when they expire.
+	TokenSource oauth2.TokenSource
+	// Endpoint overrides the base URL of the compute API, e.g. to use
+	// an emulator or a private endpoint. Requests to a plain http://
+	// endpoint aren't authenticated unless TokenSource is set.
+	Endpoint string
+
+	// LocalSSDCount is the number of local SSDs to attach to
+	// instances, using LocalSSDInterface (SCSI or NVME).
+	LocalSSDCount     int
+	LocalSSDInterface string
+
+	// Preemptible launches preemptible instances, which are cheaper
+	// but may be stopped by GCE at any time.
+	Preemptible bool
+	// ProvisioningModel is STANDARD or SPOT. The vendored compute API
+	// predates the provisioningModel field, so Spot VMs are requested as
+	// preemptible instances, which are priced and reclaimed the same way.
+	ProvisioningModel string
+	// OnHostMaintenance is MIGRATE to live-migrate instances when their
+	// host needs maintenance, or TERMINATE to stop them. Empty uses the
+	// GCE default, MIGRATE, except for preemptible instances.
+	OnHostMaintenance string
+
+	// Metadata holds additional instance metadata, such as
+	// enable-oslogin or startup-script. The created-by, ssh-keys, and
+	// user-data keys are set by mantle and can't be overridden.
+	Metadata map[string]string
+	// SSHUser is the user the keys in the ssh-keys metadata are for.
+	// Container Linux adds every key to core regardless, but the guest
+	// agent of other images creates the named user.
+	SSHUser string
+	// Tags are additional network tags for instances, which firewall
+	// rules can target.
+	Tags []string
+	// FirewallSourceRanges are the CIDR ranges, besides the network's
+	// own, allowed to reach the ports tests open, such as the address
+	// of the test controller.
+	FirewallSourceRanges []string
+
+	// ServiceAccount is the email of the service account to attach to
+	// instances, or "default" for the project's default account. If
+	// empty and Scopes is empty, no account is attached.
+	ServiceAccount string
+	// Scopes are the OAuth scopes of the service account, as URLs or
+	// aliases like storage-ro. Defaults to cloud-platform.
+	Scopes []string
+
+	// Progress is told about the progress of running operations, nil
+	// for util.DefaultReporter.
+	Progress util.ProgressReporter
 	*platform.Options
 }
 
@@ -51,9 +121,9 @@ type API struct {
 	options *Options
 }
 
-func New(opts *Options) (*API, error) {
-	const endpointPrefix = "https://www.googleapis.com/compute/v1/"
+const endpointPrefix = "https://www.googleapis.com/compute/v1/"
 
+func New(opts *Options) (*API, error) {
 	// If the image name isn't a full api endpoint accept a name beginning
 	// with "projects/" to specify a different project from the instance.
 	// Also accept a short name and use instance project.
@@ -65,12 +135,58 @@ func New(opts *Options) (*API, error) {
 		return nil, fmt.Errorf("GCE Image argument must be the full api endpoint, begin with 'projects/', or use the short name")
 	}
 
-	var (
-		client *http.Client
-		err    error
-	)
+	if err := applyZones(opts); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if opts.Network, err = resourceLink(opts.Project, "global/networks", opts.Network); err != nil {
+		return nil, fmt.Errorf("GCE network: %v", err)
+	}
+	if opts.Subnetwork != "" {
+		region := zoneRegion(opts.Zone)
+		collection := "regions/" + region + "/subnetworks"
+		if opts.Subnetwork, err = resourceLink(opts.Project, collection, opts.Subnetwork); err != nil {
+			return nil, fmt.Errorf("GCE subnetwork: %v", err)
+		}
+		if !strings.Contains(opts.Subnetwork, "/"+collection+"/") {
+			return nil, fmt.Errorf("GCE subnetwork %s is not in region %s of zone %s", opts.Subnetwork, region, opts.Zone)
+		}
+	}
+
+	if opts.Scopes, err = resolveScopes(opts.Scopes); err != nil {
+		return nil, err
+	}
 
-	if opts.ServiceAuth {
+	if err := applyProvisioningModel(opts); err != nil {
+		return nil, err
+	}
+	if err := applyOnHostMaintenance(opts); err != nil {
+		return nil, err
+	}
+	if err := validateLocalSSD(opts.MachineType, opts.LocalSSDCount, opts.LocalSSDInterface); err != nil {
+		return nil, err
+	}
+	if err := validateMachineTypes(opts.MachineTypes); err != nil {
+		return nil, err
+	}
+	if err := validateSourceRanges(opts.FirewallSourceRanges); err != nil {
+		return nil, err
+	}
+
+	if opts.Endpoint != "" {
+		if opts.Endpoint, err = normalizeEndpoint(opts.Endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	var client *http.Client
+
+	if opts.TokenSource != nil {
+		client = oauth2.NewClient(oauth2.NoContext, oauth2.ReuseTokenSource(nil, opts.TokenSource))
+	} else if strings.HasPrefix(opts.Endpoint, "http://") {
+		client = http.DefaultClient
+	} else if opts.ServiceAuth {
 		client = auth.GoogleServiceClient()
 	} else if opts.JSONKeyFile != "" {
 		b, err := ioutil.ReadFile(opts.JSONKeyFile)
@@ -90,6 +206,9 @@ func New(opts *Options) (*API, error) {
 	if err != nil {
 		return nil, err
 	}
+	if opts.Endpoint != "" {
+		capi.BasePath = opts.Endpoint
+	}
 
 	api := &API{
 		client:  client,
@@ -100,10 +219,72 @@ func New(opts *Options) (*API, error) {
 	return api, nil
 }
 
+// resourceLink returns the self-link of a resource given its short name,
+// a path beginning with "projects/", or its self-link. Short names refer
+// to the collection in project, e.g. "global/networks".
+func resourceLink(project, collection, name string) (string, error) {
+	switch {
+	case name == "":
+		return "", nil
+	case strings.HasPrefix(name, endpointPrefix):
+		return name, nil
+	case strings.HasPrefix(name, "projects/"):
+		return endpointPrefix + name, nil
+	case !strings.Contains(name, "/"):
+		return fmt.Sprintf("%sprojects/%s/%s/%s", endpointPrefix, project, collection, name), nil
+	default:
+		return "", fmt.Errorf("%q must be the full api endpoint, begin with 'projects/', or be a short name", name)
+	}
+}
+
+// normalizeEndpoint checks that endpoint is an http or https URL, and
+// returns it with the trailing slash the compute API expects.
+func normalizeEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("GCE endpoint: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("GCE endpoint %q must be an http or https URL", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
+
+// zoneRegion returns the region containing zone.
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// applyZones checks that Zones are all in one region, and sets Zone to
+// the first of them so per-region resources like subnetworks resolve
+// against it.
+func applyZones(opts *Options) error {
+	if len(opts.Zones) == 0 {
+		return nil
+	}
+	region := zoneRegion(opts.Zones[0])
+	for _, zone := range opts.Zones[1:] {
+		if zoneRegion(zone) != region {
+			return fmt.Errorf("GCE zones %s and %s are in different regions", opts.Zones[0], zone)
+		}
+	}
+	opts.Zone = opts.Zones[0]
+	return nil
+}
+
 func (a *API) Client() *http.Client {
 	return a.client
 }
 
 func (a *API) GC(gracePeriod time.Duration) error {
-	return a.gcInstances(gracePeriod)
+	if err := a.gcInstances(gracePeriod); err != nil {
+		return err
+	}
+	return a.gcFirewallRules(gracePeriod)
 }
@@ -17,13 +17,205 @@ package gcloud
 import (
 	"crypto/rand"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/net/context"
 	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// localSSDLimit returns the maximum number of local SSDs which can be
+// attached to an instance of the given machine type.
+func localSSDLimit(machineType string) int {
+	switch {
+	case machineType == "f1-micro", machineType == "g1-small", strings.HasPrefix(machineType, "e2-"):
+		// shared-core and E2 machine types don't support local SSDs
+		return 0
+	case strings.HasPrefix(machineType, "n2-"), strings.HasPrefix(machineType, "n2d-"):
+		return 24
+	default:
+		return 8
+	}
+}
+
+func validateLocalSSD(machineType string, count int, iface string) error {
+	if count == 0 {
+		return nil
+	}
+	if count < 0 {
+		return fmt.Errorf("invalid local SSD count %d", count)
+	}
+	if limit := localSSDLimit(machineType); count > limit {
+		return fmt.Errorf("machine type %q supports at most %d local SSDs, not %d", machineType, limit, count)
+	}
+	switch iface {
+	case "", "SCSI", "NVME":
+	default:
+		return fmt.Errorf("invalid local SSD interface %q: must be SCSI or NVME", iface)
+	}
+	return nil
+}
+
+// applyProvisioningModel validates opts.ProvisioningModel and requests
+// preemptible instances for Spot VMs.
+func applyProvisioningModel(opts *Options) error {
+	switch opts.ProvisioningModel {
+	case "", "STANDARD":
+	case "SPOT":
+		opts.Preemptible = true
+	default:
+		return fmt.Errorf("invalid provisioning model %q: must be STANDARD or SPOT", opts.ProvisioningModel)
+	}
+	return nil
+}
+
+// applyOnHostMaintenance validates opts.OnHostMaintenance. Preemptible
+// instances can't be migrated, so they default to TERMINATE.
+func applyOnHostMaintenance(opts *Options) error {
+	switch opts.OnHostMaintenance {
+	case "":
+		if opts.Preemptible {
+			opts.OnHostMaintenance = "TERMINATE"
+		}
+	case "MIGRATE":
+		if opts.Preemptible {
+			return fmt.Errorf("preemptible instances can't be migrated on host maintenance")
+		}
+	case "TERMINATE":
+	default:
+		return fmt.Errorf("invalid host maintenance policy %q: must be MIGRATE or TERMINATE", opts.OnHostMaintenance)
+	}
+	return nil
+}
+
+const (
+	bootDiskSizeGB = 12
+	localSSDSizeGB = 375
 )
 
+const scopePrefix = "https://www.googleapis.com/auth/"
+
+// scopeAliases are the scope aliases accepted by gcloud.
+var scopeAliases = map[string]string{
+	"bigquery":              "bigquery",
+	"cloud-platform":        "cloud-platform",
+	"cloud-source-repos":    "source.full_control",
+	"cloud-source-repos-ro": "source.read_only",
+	"compute-ro":            "compute.readonly",
+	"compute-rw":            "compute",
+	"datastore":             "datastore",
+	"default":               "cloud-platform",
+	"logging-write":         "logging.write",
+	"monitoring":            "monitoring",
+	"monitoring-write":      "monitoring.write",
+	"pubsub":                "pubsub",
+	"service-control":       "servicecontrol",
+	"service-management":    "service.management.readonly",
+	"sql-admin":             "sqlservice.admin",
+	"storage-full":          "devstorage.full_control",
+	"storage-ro":            "devstorage.read_only",
+	"storage-rw":            "devstorage.read_write",
+	"taskqueue":             "taskqueue",
+	"trace":                 "trace.append",
+	"userinfo-email":        "userinfo.email",
+}
+
+// resolveScopes expands scope aliases to URLs and rejects anything else
+// which isn't a Google OAuth scope URL.
+func resolveScopes(scopes []string) ([]string, error) {
+	var urls []string
+	for _, scope := range scopes {
+		if scope, ok := scopeAliases[scope]; ok {
+			urls = append(urls, scopePrefix+scope)
+			continue
+		}
+		if !strings.HasPrefix(scope, scopePrefix) || len(scope) == len(scopePrefix) || strings.ContainsAny(scope, " ,") {
+			return nil, fmt.Errorf("invalid scope %q: must be a known alias or begin with %s", scope, scopePrefix)
+		}
+		urls = append(urls, scope)
+	}
+	return urls, nil
+}
+
+// serviceAccounts returns the service accounts to attach to an instance.
+func (a *API) serviceAccounts() []*compute.ServiceAccount {
+	if a.options.ServiceAccount == "" && len(a.options.Scopes) == 0 {
+		return nil
+	}
+	email := a.options.ServiceAccount
+	if email == "" {
+		email = "default"
+	}
+	scopes := a.options.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{scopePrefix + "cloud-platform"}
+	}
+	return []*compute.ServiceAccount{{
+		Email:  email,
+		Scopes: scopes,
+	}}
+}
+
+// extraDiskDevice returns the device name of the i'th extra disk of an
+// instance, which appears in the guest as /dev/disk/by-id/google-<name>.
+func extraDiskDevice(i int) string {
+	return fmt.Sprintf("kola-disk-%d", i)
+}
+
+// ExtraDiskPath returns the guest device path of the i'th extra disk
+// attached by CreateInstance.
+func ExtraDiskPath(i int) string {
+	return "/dev/disk/by-id/google-" + extraDiskDevice(i)
+}
+
+// extraDisks returns the blank persistent disks to attach to the named
+// instance. They're deleted along with the instance.
+func (a *API) extraDisks(name string, specs []platform.DiskSpec) []*compute.AttachedDisk {
+	var disks []*compute.AttachedDisk
+	for i, spec := range specs {
+		diskType := spec.Type
+		if diskType == "" {
+			diskType = a.options.DiskType
+		}
+		disks = append(disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			Type:       "PERSISTENT",
+			DeviceName: extraDiskDevice(i),
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskName:   fmt.Sprintf("%s-%s", name, extraDiskDevice(i)),
+				DiskType:   "/zones/" + a.options.Zone + "/diskTypes/" + diskType,
+				DiskSizeGb: int64(spec.SizeGB),
+			},
+		})
+	}
+	return disks
+}
+
+// localSSDDisks returns the scratch disks to attach to an instance.
+func (a *API) localSSDDisks() []*compute.AttachedDisk {
+	iface := a.options.LocalSSDInterface
+	if iface == "" {
+		iface = "SCSI"
+	}
+	var disks []*compute.AttachedDisk
+	for i := 0; i < a.options.LocalSSDCount; i++ {
+		disks = append(disks, &compute.AttachedDisk{
+			AutoDelete: true,
+			Type:       "SCRATCH",
+			Interface:  iface,
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskType: "/zones/" + a.options.Zone + "/diskTypes/local-ssd",
+			},
+		})
+	}
+	return disks
+}
+
 func (a *API) vmname() string {
 	b := make([]byte, 10)
 	rand.Read(b)
@@ -44,7 +236,11 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 	if len(keys) > 0 {
 		var sshKeys string
 		for i, key := range keys {
-			sshKeys += fmt.Sprintf("%d:%s\n", i, key)
+			user := a.options.SSHUser
+			if user == "" {
+				user = strconv.Itoa(i)
+			}
+			sshKeys += fmt.Sprintf("%s:%s\n", user, key)
 		}
 
 		metadataItems = append(metadataItems, &compute.MetadataItems{
@@ -53,11 +249,9 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 		})
 	}
 
-	instancePrefix := "https://www.googleapis.com/compute/v1/projects/" + a.options.Project
-
 	instance := &compute.Instance{
 		Name:        name,
-		MachineType: instancePrefix + "/zones/" + a.options.Zone + "/machineTypes/" + a.options.MachineType,
+		MachineType: a.machineTypeLink(a.options.MachineType),
 		Metadata: &compute.Metadata{
 			Items: metadataItems,
 		},
@@ -65,7 +259,7 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 			// Apparently you need this tag in addition to the
 			// firewall rules to open the port because these ports
 			// are special?
-			Items: []string{"https-server", "http-server"},
+			Items: append([]string{"https-server", "http-server"}, a.options.Tags...),
 		},
 		Disks: []*compute.AttachedDisk{
 			{
@@ -76,22 +270,40 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 					DiskName:    name,
 					SourceImage: a.options.Image,
 					DiskType:    "/zones/" + a.options.Zone + "/diskTypes/" + a.options.DiskType,
-					DiskSizeGb:  12,
+					DiskSizeGb:  bootDiskSizeGB,
 				},
 			},
 		},
 		NetworkInterfaces: []*compute.NetworkInterface{
 			&compute.NetworkInterface{
-				AccessConfigs: []*compute.AccessConfig{
-					&compute.AccessConfig{
-						Type: "ONE_TO_ONE_NAT",
-						Name: "External NAT",
-					},
-				},
-				Network: instancePrefix + "/global/networks/" + a.options.Network,
+				Network:    a.options.Network,
+				Subnetwork: a.options.Subnetwork,
 			},
 		},
 	}
+	if !a.options.NoExternalIP {
+		instance.NetworkInterfaces[0].AccessConfigs = []*compute.AccessConfig{
+			&compute.AccessConfig{
+				Type: "ONE_TO_ONE_NAT",
+				Name: "External NAT",
+			},
+		}
+	}
+	instance.Disks = append(instance.Disks, a.localSSDDisks()...)
+	instance.ServiceAccounts = a.serviceAccounts()
+	if a.options.Preemptible {
+		// preemptible instances can't be restarted or migrated
+		instance.Scheduling = &compute.Scheduling{
+			Preemptible:       true,
+			OnHostMaintenance: "TERMINATE",
+			AutomaticRestart:  false,
+			ForceSendFields:   []string{"AutomaticRestart"},
+		}
+	} else if a.options.OnHostMaintenance != "" {
+		instance.Scheduling = &compute.Scheduling{
+			OnHostMaintenance: a.options.OnHostMaintenance,
+		}
+	}
 	// add cloud config
 	if userdata != "" {
 		instance.Metadata.Items = append(instance.Metadata.Items, &compute.MetadataItems{
@@ -99,36 +311,235 @@ func (a *API) mkinstance(userdata, name string, keys []*agent.Key) *compute.Inst
 			Value: &userdata,
 		})
 	}
+	instance.Metadata.Items = mergeMetadata(instance.Metadata.Items, a.options.Metadata)
 
 	return instance
 
 }
 
-// CreateInstance creates a Google Compute Engine instance.
-func (a *API) CreateInstance(userdata string, keys []*agent.Key) (*compute.Instance, error) {
-	name := a.vmname()
-	inst := a.mkinstance(userdata, name, keys)
+// mergeMetadata appends the extra metadata to items. Keys already in items,
+// i.e. those set by mantle itself, take precedence.
+func mergeMetadata(items []*compute.MetadataItems, extra map[string]string) []*compute.MetadataItems {
+	set := make(map[string]bool)
+	for _, item := range items {
+		set[item.Key] = true
+	}
 
-	plog.Debugf("Creating instance %q", name)
+	var keys []string
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
+	for _, key := range keys {
+		if set[key] {
+			plog.Warningf("Ignoring GCE metadata key %q, which is set by mantle", key)
+			continue
+		}
+		value := extra[key]
+		items = append(items, &compute.MetadataItems{
+			Key:   key,
+			Value: &value,
+		})
+	}
+	return items
+}
+
+// CreateInstance creates a Google Compute Engine instance, with blank
+// disks attached as described by extraDisks. Their guest device paths
+// are given by ExtraDiskPath.
+func (a *API) CreateInstance(userdata string, keys []*agent.Key, extraDisks ...platform.DiskSpec) (*compute.Instance, error) {
+	if err := a.checkSubnetwork(); err != nil {
+		return nil, err
+	}
+
+	machineType := a.options.MachineType
+	var fallbacks []string
+	resolved := false
+	for {
+		name := a.vmname()
+		inst := a.mkinstance(userdata, name, keys)
+		inst.MachineType = a.machineTypeLink(machineType)
+		inst.Disks = append(inst.Disks, a.extraDisks(name, extraDisks)...)
+
+		plog.Debugf("Creating instance %q", name)
+
+		err := a.insertInstance(inst)
+		if err == nil {
+			inst, err = a.compute.Instances.Get(a.options.Project, a.options.Zone, name).Do()
+			if err != nil {
+				return nil, fmt.Errorf("failed getting instance %s details after creation: %v", name, err)
+			}
+			if machineType != a.options.MachineType {
+				plog.Noticef("Created instance %q with fallback machine type %s", name, machineType)
+			} else {
+				plog.Debugf("Created instance %q", name)
+			}
+			return inst, nil
+		}
+		if !isCapacityError(err) || len(a.options.MachineTypes) == 0 {
+			return nil, err
+		}
+
+		if !resolved {
+			resolved = true
+			var ferr error
+			if fallbacks, ferr = a.fallbackMachineTypes(); ferr != nil {
+				return nil, ferr
+			}
+		}
+		if len(fallbacks) == 0 {
+			return nil, fmt.Errorf("zone %s is out of capacity for all acceptable machine types: %v", a.options.Zone, err)
+		}
+		plog.Warningf("Zone %s is out of capacity for machine type %s, trying %s", a.options.Zone, machineType, fallbacks[0])
+		machineType, fallbacks = fallbacks[0], fallbacks[1:]
+	}
+}
+
+// insertInstance creates inst and waits for it to start.
+func (a *API) insertInstance(inst *compute.Instance) error {
 	op, err := a.compute.Instances.Insert(a.options.Project, a.options.Zone, inst).Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to request new GCE instance: %v\n", err)
+	if isCapacityError(err) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to request new GCE instance: %v\n", err)
 	}
 
 	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
-	if err := a.NewPending(op.Name, doable).Wait(); err != nil {
-		return nil, err
+	return a.NewPending(op.Name, doable).Wait()
+}
+
+// machineTypeLink returns the URL of a machine type in the API's zone.
+func (a *API) machineTypeLink(machineType string) string {
+	return "https://www.googleapis.com/compute/v1/projects/" + a.options.Project + "/zones/" + a.options.Zone + "/machineTypes/" + machineType
+}
+
+// checkSubnetwork verifies that the configured subnetwork exists, since
+// the error from creating an instance in a missing one is obscure.
+func (a *API) checkSubnetwork() error {
+	if a.options.Subnetwork == "" {
+		return nil
+	}
+	// projects/<project>/regions/<region>/subnetworks/<name>
+	parts := strings.Split(strings.TrimPrefix(a.options.Subnetwork, endpointPrefix), "/")
+	if len(parts) != 6 {
+		return fmt.Errorf("malformed subnetwork %q", a.options.Subnetwork)
+	}
+	project, region, name := parts[1], parts[3], parts[5]
+	_, err := a.compute.Subnetworks.Get(project, region, name).Do()
+	if isNotFound(err) {
+		return fmt.Errorf("GCE subnetwork %q not found in project %q region %q", name, project, region)
+	} else if err != nil {
+		return fmt.Errorf("getting GCE subnetwork %q: %v", name, err)
+	}
+	return nil
+}
+
+// Zone returns the zone instances are created and managed in.
+func (a *API) Zone() string {
+	return a.options.Zone
+}
+
+// InZone returns a copy of the API which creates and manages instances
+// in zone rather than the configured Zone.
+func (a *API) InZone(zone string) *API {
+	opts := *a.options
+	opts.Zone = zone
+	return &API{
+		client:  a.client,
+		compute: a.compute,
+		options: &opts,
+	}
+}
+
+// CheckZones verifies that the configured machine type is available in
+// each of Zones, so a cluster spread across them doesn't fail partway
+// through launching.
+func (a *API) CheckZones() error {
+	for _, zone := range a.options.Zones {
+		_, err := a.compute.MachineTypes.Get(a.options.Project, zone, a.options.MachineType).Do()
+		if isNotFound(err) {
+			return fmt.Errorf("GCE machine type %s is not available in zone %s", a.options.MachineType, zone)
+		} else if err != nil {
+			return fmt.Errorf("getting GCE machine type %s in zone %s: %v", a.options.MachineType, zone, err)
+		}
 	}
+	return nil
+}
 
-	inst, err = a.compute.Instances.Get(a.options.Project, a.options.Zone, name).Do()
+// InstancePreempted reports whether GCE preempted the named instance.
+func (a *API) InstancePreempted(name string) (bool, error) {
+	req := a.compute.ZoneOperations.List(a.options.Project, a.options.Zone)
+	req.Filter(fmt.Sprintf("(operationType eq compute.instances.preempted) (targetLink eq .*/instances/%s)", name))
+	ops, err := req.Do()
 	if err != nil {
-		return nil, fmt.Errorf("failed getting instance %s details after creation: %v", name, err)
+		return false, fmt.Errorf("listing preemptions of %s: %v", name, err)
 	}
+	return len(ops.Items) > 0, nil
+}
 
-	plog.Debugf("Created instance %q", name)
+// maintenanceOperations are the operations GCE runs on instances whose
+// host needs maintenance or has failed.
+var maintenanceOperations = []string{
+	"compute.instances.migrateOnHostMaintenance",
+	"compute.instances.terminateOnHostMaintenance",
+	"compute.instances.hostError",
+}
 
-	return inst, nil
+// InstanceMaintenanceEvents returns the operations GCE ran on the named
+// instance because of maintenance or failure of its host, oldest first.
+func (a *API) InstanceMaintenanceEvents(name string) ([]*compute.Operation, error) {
+	req := a.compute.ZoneOperations.List(a.options.Project, a.options.Zone)
+	req.Filter(fmt.Sprintf("(operationType eq %s) (targetLink eq .*/instances/%s)", strings.Join(maintenanceOperations, "|"), name))
+	var events []*compute.Operation
+	err := req.Pages(context.Background(), func(ops *compute.OperationList) error {
+		events = append(events, ops.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing maintenance events of %s: %v", name, err)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].InsertTime < events[j].InsertTime
+	})
+	return events, nil
+}
+
+// InternalDNSName returns the zonal internal DNS name of the named
+// instance. Projects scoped to a domain, like "example.com:project", use
+// "project.example.com" in place of the project.
+func (a *API) InternalDNSName(name string) string {
+	project := a.options.Project
+	if parts := strings.SplitN(project, ":", 2); len(parts) == 2 {
+		project = parts[1] + "." + parts[0]
+	}
+	return fmt.Sprintf("%s.%s.c.%s.internal", name, a.options.Zone, project)
+}
+
+// snapshotTimeout bounds the upload of a boot disk snapshot.
+const snapshotTimeout = 15 * time.Minute
+
+// SnapshotInstance snapshots the boot disk of the named instance and
+// waits until the snapshot is complete, returning the snapshot's name.
+// The snapshot isn't deleted along with the instance.
+func (a *API) SnapshotInstance(name, description string) (string, error) {
+	snapshot := &compute.Snapshot{
+		Name:        fmt.Sprintf("%s-%d", name, time.Now().Unix()),
+		Description: description,
+	}
+
+	plog.Debugf("Creating snapshot %q of instance %q", snapshot.Name, name)
+
+	// the boot disk is named after the instance
+	op, err := a.compute.Disks.CreateSnapshot(a.options.Project, a.options.Zone, name, snapshot).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to request snapshot of instance %s: %v", name, err)
+	}
+	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+	if err := a.NewPending(op.Name, doable).WithTimeout(snapshotTimeout).Wait(); err != nil {
+		return "", err
+	}
+	return snapshot.Name, nil
 }
 
 func (a *API) TerminateInstance(name string) error {
@@ -157,18 +568,33 @@ func (a *API) ListInstances(prefix string) ([]*compute.Instance, error) {
 	return instances, nil
 }
 
-func (a *API) GetConsoleOutput(name string) (string, error) {
-	out, err := a.compute.Instances.GetSerialPortOutput(a.options.Project, a.options.Zone, name).Do()
-	if err != nil {
-		return "", fmt.Errorf("failed to retrieve console output for %q: %v", name, err)
+// GetConsoleOutput returns the output of the given serial port of an
+// instance, from the oldest output GCE still buffers. Port 1 is the
+// console.
+func (a *API) GetConsoleOutput(name string, port int64) (string, error) {
+	var contents []string
+	var start int64
+	for {
+		out, err := a.compute.Instances.GetSerialPortOutput(a.options.Project, a.options.Zone, name).Port(port).Start(start).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to retrieve console output for %q: %v", name, err)
+		}
+		contents = append(contents, out.Contents)
+		// each response is limited in size; Next is the offset of
+		// the first byte not yet returned
+		if out.Contents == "" || out.Next <= start {
+			break
+		}
+		start = out.Next
 	}
-	return out.Contents, nil
+	return strings.Join(contents, ""), nil
 }
 
 // Taken from: https://github.com/golang/build/blob/master/buildlet/gce.go
 func InstanceIPs(inst *compute.Instance) (intIP, extIP string) {
 	for _, iface := range inst.NetworkInterfaces {
-		if strings.HasPrefix(iface.NetworkIP, "10.") {
+		// custom subnetworks needn't be in 10.0.0.0/8
+		if intIP == "" {
 			intIP = iface.NetworkIP
 		}
 		for _, accessConfig := range iface.AccessConfigs {
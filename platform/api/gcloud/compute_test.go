@@ -0,0 +1,606 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func testInstanceOptions() *Options {
+	return &Options{
+		Project:     "test",
+		Zone:        "us-central1-a",
+		MachineType: "n1-standard-1",
+		DiskType:    "pd-ssd",
+		Network:     "default",
+		Options:     &platform.Options{BaseName: "kola"},
+	}
+}
+
+func TestLocalSSDDisks(t *testing.T) {
+	for _, tt := range []struct {
+		count    int
+		iface    string
+		expected string
+	}{
+		{0, "", ""},
+		{1, "", "SCSI"},
+		{2, "NVME", "NVME"},
+	} {
+		opts := testInstanceOptions()
+		opts.LocalSSDCount = tt.count
+		opts.LocalSSDInterface = tt.iface
+		api := &API{options: opts}
+		inst := api.mkinstance("", "kola-1", nil)
+
+		if len(inst.Disks) != tt.count+1 {
+			t.Fatalf("%d SSDs: expected %d disks, got %d", tt.count, tt.count+1, len(inst.Disks))
+		}
+		if boot := inst.Disks[0]; !boot.Boot || boot.Type != "PERSISTENT" {
+			t.Errorf("%d SSDs: first disk isn't the boot disk: %+v", tt.count, boot)
+		}
+		for _, disk := range inst.Disks[1:] {
+			if disk.Boot || !disk.AutoDelete || disk.Type != "SCRATCH" || disk.Interface != tt.expected {
+				t.Errorf("%d SSDs: bad scratch disk %+v", tt.count, disk)
+			}
+			if disk.InitializeParams == nil || disk.InitializeParams.DiskType != "/zones/us-central1-a/diskTypes/local-ssd" {
+				t.Errorf("%d SSDs: bad scratch disk params %+v", tt.count, disk.InitializeParams)
+			}
+		}
+	}
+}
+
+func TestValidateLocalSSD(t *testing.T) {
+	for _, tt := range []struct {
+		machineType string
+		count       int
+		iface       string
+		ok          bool
+	}{
+		{"n1-standard-1", 0, "", true},
+		{"f1-micro", 0, "", true},
+		{"n1-standard-1", 1, "NVME", true},
+		{"n1-standard-1", 8, "SCSI", true},
+		{"n1-standard-1", 9, "SCSI", false},
+		{"n2-standard-8", 24, "NVME", true},
+		{"f1-micro", 1, "", false},
+		{"e2-medium", 1, "", false},
+		{"n1-standard-1", -1, "", false},
+		{"n1-standard-1", 1, "IDE", false},
+	} {
+		err := validateLocalSSD(tt.machineType, tt.count, tt.iface)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s with %d %q SSDs: unexpected result %v", tt.machineType, tt.count, tt.iface, err)
+		}
+	}
+}
+
+func TestPreemptibleInstance(t *testing.T) {
+	for _, tt := range []struct {
+		preemptible bool
+		model       string
+		expected    bool
+		ok          bool
+	}{
+		{false, "", false, true},
+		{false, "STANDARD", false, true},
+		{true, "", true, true},
+		{false, "SPOT", true, true},
+		{false, "RESERVED", false, false},
+	} {
+		opts := testInstanceOptions()
+		opts.Preemptible = tt.preemptible
+		opts.ProvisioningModel = tt.model
+		if err := applyProvisioningModel(opts); tt.ok != (err == nil) {
+			t.Errorf("%q: unexpected result %v", tt.model, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		inst := (&API{options: opts}).mkinstance("", "kola-1", nil)
+		if !tt.expected {
+			if inst.Scheduling != nil {
+				t.Errorf("%q: unexpected scheduling %+v", tt.model, inst.Scheduling)
+			}
+			continue
+		}
+		s := inst.Scheduling
+		if s == nil || !s.Preemptible || s.AutomaticRestart || s.OnHostMaintenance != "TERMINATE" {
+			t.Errorf("%q: bad scheduling %+v", tt.model, s)
+		}
+	}
+}
+
+func TestOnHostMaintenance(t *testing.T) {
+	for _, tt := range []struct {
+		preemptible bool
+		policy      string
+		expected    string
+		ok          bool
+	}{
+		{false, "", "", true},
+		{false, "MIGRATE", "MIGRATE", true},
+		{false, "TERMINATE", "TERMINATE", true},
+		{true, "", "TERMINATE", true},
+		{true, "TERMINATE", "TERMINATE", true},
+		{true, "MIGRATE", "", false},
+		{false, "RESTART", "", false},
+	} {
+		opts := testInstanceOptions()
+		opts.Preemptible = tt.preemptible
+		opts.OnHostMaintenance = tt.policy
+		if err := applyOnHostMaintenance(opts); tt.ok != (err == nil) {
+			t.Errorf("%v %q: unexpected result %v", tt.preemptible, tt.policy, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		inst := (&API{options: opts}).mkinstance("", "kola-1", nil)
+		var policy string
+		if inst.Scheduling != nil {
+			policy = inst.Scheduling.OnHostMaintenance
+			if inst.Scheduling.Preemptible != tt.preemptible {
+				t.Errorf("%v %q: bad scheduling %+v", tt.preemptible, tt.policy, inst.Scheduling)
+			}
+		}
+		if policy != tt.expected {
+			t.Errorf("%v %q: expected policy %q, got %q", tt.preemptible, tt.policy, tt.expected, policy)
+		}
+	}
+}
+
+func TestInstanceMaintenanceEvents(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/zones/us-central1-a/operations") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		filter := r.URL.Query().Get("filter")
+		for _, op := range maintenanceOperations {
+			if !strings.Contains(filter, op) {
+				t.Errorf("filter %q is missing %s", filter, op)
+			}
+		}
+		list := &compute.OperationList{}
+		switch {
+		case strings.HasSuffix(filter, "/instances/kola-1)") && r.URL.Query().Get("pageToken") == "":
+			list.Items = []*compute.Operation{
+				{Name: "op-2", OperationType: "compute.instances.hostError", InsertTime: "2018-03-02T00:00:00Z"},
+			}
+			list.NextPageToken = "next"
+		case strings.HasSuffix(filter, "/instances/kola-1)"):
+			list.Items = []*compute.Operation{
+				{Name: "op-1", OperationType: "compute.instances.migrateOnHostMaintenance", InsertTime: "2018-03-01T00:00:00Z"},
+			}
+		}
+		writeJSON(t, w, list)
+	}))
+	defer srv.Close()
+	api.options.Zone = "us-central1-a"
+
+	for name, expected := range map[string][]string{"kola-1": {"op-1", "op-2"}, "kola-2": nil} {
+		events, err := api.InstanceMaintenanceEvents(name)
+		if err != nil {
+			t.Fatalf("InstanceMaintenanceEvents failed: %v", err)
+		}
+		var got []string
+		for _, op := range events {
+			got = append(got, op.Name)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: expected events %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestSnapshotInstance(t *testing.T) {
+	var snapshot compute.Snapshot
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/zones/us-central1-a/disks/kola-1/createSnapshot":
+			if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+				t.Errorf("decoding snapshot failed: %v", err)
+			}
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		case "/test/zones/us-central1-a/operations/op":
+			writeJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+	api.options.Zone = "us-central1-a"
+
+	name, err := api.SnapshotInstance("kola-1", "kola test failed")
+	if err != nil {
+		t.Fatalf("SnapshotInstance failed: %v", err)
+	}
+	if name != snapshot.Name || !strings.HasPrefix(name, "kola-1-") {
+		t.Errorf("unexpected snapshot name %q, requested %q", name, snapshot.Name)
+	}
+	if snapshot.Description != "kola test failed" {
+		t.Errorf("unexpected description %q", snapshot.Description)
+	}
+}
+
+func TestInstancePreempted(t *testing.T) {
+	preempted := map[string]bool{"kola-1": true}
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/zones/us-central1-a/operations") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		filter := r.URL.Query().Get("filter")
+		if !strings.Contains(filter, "compute.instances.preempted") {
+			t.Errorf("unexpected filter %q", filter)
+		}
+		list := &compute.OperationList{}
+		for name := range preempted {
+			if strings.HasSuffix(filter, "/instances/"+name+")") {
+				list.Items = append(list.Items, &compute.Operation{Name: "op", OperationType: "compute.instances.preempted"})
+			}
+		}
+		writeJSON(t, w, list)
+	}))
+	defer srv.Close()
+	api.options.Zone = "us-central1-a"
+
+	for name, expected := range map[string]bool{"kola-1": true, "kola-2": false} {
+		got, err := api.InstancePreempted(name)
+		if err != nil {
+			t.Fatalf("InstancePreempted failed: %v", err)
+		}
+		if got != expected {
+			t.Errorf("%s: expected preempted %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestNetworkInterface(t *testing.T) {
+	opts := testInstanceOptions()
+	opts.Network = endpointPrefix + "projects/test/global/networks/vpc"
+	opts.Subnetwork = endpointPrefix + "projects/test/regions/us-central1/subnetworks/isolated"
+	opts.NoExternalIP = true
+
+	inst := (&API{options: opts}).mkinstance("", "kola-1", nil)
+	iface := inst.NetworkInterfaces[0]
+	if iface.Network != opts.Network || iface.Subnetwork != opts.Subnetwork {
+		t.Errorf("unexpected network %q subnetwork %q", iface.Network, iface.Subnetwork)
+	}
+	if len(iface.AccessConfigs) != 0 {
+		t.Errorf("unexpected access configs %+v", iface.AccessConfigs)
+	}
+
+	opts.NoExternalIP = false
+	inst = (&API{options: opts}).mkinstance("", "kola-1", nil)
+	if ac := inst.NetworkInterfaces[0].AccessConfigs; len(ac) != 1 || ac[0].Type != "ONE_TO_ONE_NAT" {
+		t.Errorf("expected external NAT, got %+v", ac)
+	}
+}
+
+func TestResourceLink(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"", "", true},
+		{"vpc", endpointPrefix + "projects/test/global/networks/vpc", true},
+		{"projects/other/global/networks/vpc", endpointPrefix + "projects/other/global/networks/vpc", true},
+		{endpointPrefix + "projects/other/global/networks/vpc", endpointPrefix + "projects/other/global/networks/vpc", true},
+		{"global/networks/vpc", "", false},
+	} {
+		link, err := resourceLink("test", "global/networks", tt.name)
+		if tt.ok != (err == nil) {
+			t.Errorf("%q: unexpected result %v", tt.name, err)
+		}
+		if link != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.name, tt.expected, link)
+		}
+	}
+
+	if region := zoneRegion("us-central1-a"); region != "us-central1" {
+		t.Errorf("unexpected region %q", region)
+	}
+}
+
+func TestCheckSubnetwork(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/regions/us-central1/subnetworks/isolated":
+			writeJSON(t, w, &compute.Subnetwork{Name: "isolated"})
+		default:
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	api.options.Subnetwork = endpointPrefix + "projects/test/regions/us-central1/subnetworks/isolated"
+	if err := api.checkSubnetwork(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	api.options.Subnetwork = endpointPrefix + "projects/test/regions/us-central1/subnetworks/missing"
+	if err := api.checkSubnetwork(); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	opts := testInstanceOptions()
+	opts.Metadata = map[string]string{
+		"enable-oslogin":         "TRUE",
+		"block-project-ssh-keys": "true",
+		"user-data":              "overridden",
+	}
+	inst := (&API{options: opts}).mkinstance("{\"ignition\": {}}", "kola-1", nil)
+
+	metadata := make(map[string]string)
+	for _, item := range inst.Metadata.Items {
+		if _, ok := metadata[item.Key]; ok {
+			t.Errorf("duplicate metadata key %q", item.Key)
+		}
+		metadata[item.Key] = *item.Value
+	}
+	expected := map[string]string{
+		"created-by":             "mantle",
+		"user-data":              "{\"ignition\": {}}",
+		"enable-oslogin":         "TRUE",
+		"block-project-ssh-keys": "true",
+	}
+	if len(metadata) != len(expected) {
+		t.Errorf("expected %d metadata keys, got %v", len(expected), metadata)
+	}
+	for key, value := range expected {
+		if metadata[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, metadata[key])
+		}
+	}
+}
+
+func TestSSHKeysMetadata(t *testing.T) {
+	keys := []*agent.Key{
+		{Format: "ssh-rsa", Blob: []byte("one"), Comment: "a"},
+		{Format: "ssh-rsa", Blob: []byte("two"), Comment: "b"},
+	}
+	sshKeys := func(opts *Options) string {
+		for _, item := range (&API{options: opts}).mkinstance("", "kola-1", keys).Metadata.Items {
+			if item.Key == "ssh-keys" {
+				return *item.Value
+			}
+		}
+		return ""
+	}
+
+	opts := testInstanceOptions()
+	expected := "0:" + keys[0].String() + "\n1:" + keys[1].String() + "\n"
+	if got := sshKeys(opts); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	opts.SSHUser = "kola"
+	expected = "kola:" + keys[0].String() + "\nkola:" + keys[1].String() + "\n"
+	if got := sshKeys(opts); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestGetConsoleOutput(t *testing.T) {
+	chunks := map[string]*compute.SerialPortOutput{
+		"0":  {Contents: "first ", Start: 0, Next: 6},
+		"6":  {Contents: "second ", Start: 6, Next: 13},
+		"13": {Contents: "third", Start: 13, Next: 18},
+		"18": {Start: 18, Next: 18},
+	}
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/instances/kola-1/serialPort") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if port := r.URL.Query().Get("port"); port != "1" {
+			t.Errorf("unexpected port %q", port)
+		}
+		chunk, ok := chunks[r.URL.Query().Get("start")]
+		if !ok {
+			t.Errorf("unexpected start %q", r.URL.Query().Get("start"))
+			writeError(t, w, http.StatusBadRequest, "badRequest")
+			return
+		}
+		writeJSON(t, w, chunk)
+	}))
+	defer srv.Close()
+
+	out, err := api.GetConsoleOutput("kola-1", 1)
+	if err != nil {
+		t.Fatalf("GetConsoleOutput failed: %v", err)
+	}
+	if out != "first second third" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestResolveScopes(t *testing.T) {
+	for _, tt := range []struct {
+		scopes   []string
+		expected []string
+		ok       bool
+	}{
+		{nil, nil, true},
+		{[]string{"storage-ro", "cloud-platform"}, []string{scopePrefix + "devstorage.read_only", scopePrefix + "cloud-platform"}, true},
+		{[]string{scopePrefix + "compute"}, []string{scopePrefix + "compute"}, true},
+		{[]string{"storage"}, nil, false},
+		{[]string{"http://www.googleapis.com/auth/compute"}, nil, false},
+		{[]string{scopePrefix}, nil, false},
+	} {
+		urls, err := resolveScopes(tt.scopes)
+		if tt.ok != (err == nil) {
+			t.Errorf("%v: unexpected result %v", tt.scopes, err)
+		}
+		if tt.ok && !reflect.DeepEqual(urls, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.scopes, tt.expected, urls)
+		}
+	}
+}
+
+func TestServiceAccounts(t *testing.T) {
+	for _, tt := range []struct {
+		account  string
+		scopes   []string
+		expected []*compute.ServiceAccount
+	}{
+		{"", nil, nil},
+		{"default", nil, []*compute.ServiceAccount{{Email: "default", Scopes: []string{scopePrefix + "cloud-platform"}}}},
+		{"", []string{scopePrefix + "compute"}, []*compute.ServiceAccount{{Email: "default", Scopes: []string{scopePrefix + "compute"}}}},
+		{"kola@test.iam.gserviceaccount.com", []string{scopePrefix + "compute"}, []*compute.ServiceAccount{{Email: "kola@test.iam.gserviceaccount.com", Scopes: []string{scopePrefix + "compute"}}}},
+	} {
+		opts := testInstanceOptions()
+		opts.ServiceAccount = tt.account
+		opts.Scopes = tt.scopes
+		api := &API{options: opts}
+		inst := api.mkinstance("", "kola-1", nil)
+
+		if !reflect.DeepEqual(inst.ServiceAccounts, tt.expected) {
+			t.Errorf("%q %v: expected %+v, got %+v", tt.account, tt.scopes, tt.expected, inst.ServiceAccounts)
+		}
+	}
+}
+
+func TestInternalDNSName(t *testing.T) {
+	for _, tt := range []struct {
+		project  string
+		expected string
+	}{
+		{"test", "kola-1.us-central1-a.c.test.internal"},
+		{"example.com:test", "kola-1.us-central1-a.c.test.example.com.internal"},
+	} {
+		opts := testInstanceOptions()
+		opts.Project = tt.project
+		api := &API{options: opts}
+		if name := api.InternalDNSName("kola-1"); name != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.project, tt.expected, name)
+		}
+	}
+}
+
+func TestApplyZones(t *testing.T) {
+	for _, tt := range []struct {
+		zones []string
+		zone  string
+		ok    bool
+	}{
+		{nil, "us-central1-a", true},
+		{[]string{"us-central1-b", "us-central1-c"}, "us-central1-b", true},
+		{[]string{"us-central1-b", "us-east1-b"}, "", false},
+	} {
+		opts := testInstanceOptions()
+		opts.Zones = tt.zones
+		err := applyZones(opts)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.zones)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.zones, err)
+		} else if opts.Zone != tt.zone {
+			t.Errorf("%v: expected zone %q, got %q", tt.zones, tt.zone, opts.Zone)
+		}
+	}
+}
+
+func TestInZone(t *testing.T) {
+	api := &API{options: testInstanceOptions()}
+	zoned := api.InZone("us-central1-f")
+	if zoned.Zone() != "us-central1-f" {
+		t.Errorf("expected zone us-central1-f, got %q", zoned.Zone())
+	}
+	if api.Zone() != "us-central1-a" {
+		t.Errorf("original API changed zone to %q", api.Zone())
+	}
+	inst := zoned.mkinstance("", "kola-1", nil)
+	if !strings.Contains(inst.MachineType, "/zones/us-central1-f/") {
+		t.Errorf("instance machine type %q not in zone", inst.MachineType)
+	}
+}
+
+func TestCheckZones(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/zones/us-central1-a/machineTypes/n1-standard-1",
+			"/test/zones/us-central1-b/machineTypes/n1-standard-1":
+			writeJSON(t, w, &compute.MachineType{Name: "n1-standard-1"})
+		default:
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	api.options.MachineType = "n1-standard-1"
+	api.options.Zones = []string{"us-central1-a", "us-central1-b"}
+	if err := api.CheckZones(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	api.options.Zones = append(api.options.Zones, "us-central1-f")
+	if err := api.CheckZones(); err == nil || !strings.Contains(err.Error(), "us-central1-f") {
+		t.Errorf("expected error for us-central1-f, got %v", err)
+	}
+}
+
+func TestExtraDisks(t *testing.T) {
+	api := &API{options: testInstanceOptions()}
+	disks := api.extraDisks("kola-1", []platform.DiskSpec{
+		{SizeGB: 10},
+		{SizeGB: 20, Type: "pd-standard"},
+	})
+	expected := []*compute.AttachedDisk{
+		{
+			AutoDelete: true,
+			Type:       "PERSISTENT",
+			DeviceName: "kola-disk-0",
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskName:   "kola-1-kola-disk-0",
+				DiskType:   "/zones/us-central1-a/diskTypes/pd-ssd",
+				DiskSizeGb: 10,
+			},
+		},
+		{
+			AutoDelete: true,
+			Type:       "PERSISTENT",
+			DeviceName: "kola-disk-1",
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskName:   "kola-1-kola-disk-1",
+				DiskType:   "/zones/us-central1-a/diskTypes/pd-standard",
+				DiskSizeGb: 20,
+			},
+		},
+	}
+	if !reflect.DeepEqual(disks, expected) {
+		t.Errorf("expected %+v, got %+v", expected, disks)
+	}
+	if path := ExtraDiskPath(1); path != "/dev/disk/by-id/google-kola-disk-1" {
+		t.Errorf("unexpected disk path %q", path)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+)
+
+// firewallDescription marks the firewall rules created by mantle, since
+// firewall rules can't have labels.
+const firewallDescription = "created-by: mantle"
+
+// EnsureFirewallRule creates or updates a firewall rule allowing inbound
+// TCP connections to ports from sourceRanges, on the instances tagged
+// name in the network of the API. Ports are numbers or ranges like
+// "8000-8080". Rules not created by mantle are never changed.
+func (a *API) EnsureFirewallRule(name string, ports []string, sourceRanges []string) error {
+	network := a.network()
+	rule := &compute.Firewall{
+		Name:        name,
+		Description: firewallDescription,
+		Network:     network,
+		Allowed: []*compute.FirewallAllowed{
+			{
+				IPProtocol: "tcp",
+				Ports:      ports,
+			},
+		},
+		SourceRanges: sourceRanges,
+		TargetTags:   []string{name},
+	}
+
+	existing, err := a.compute.Firewalls.Get(a.options.Project, name).Do()
+	var op *compute.Operation
+	switch {
+	case isNotFound(err):
+		plog.Debugf("Creating firewall rule %q", name)
+		op, err = a.compute.Firewalls.Insert(a.options.Project, rule).Do()
+	case err != nil:
+		return fmt.Errorf("Getting firewall rule %s failed: %v", name, err)
+	case existing.Description != firewallDescription:
+		return fmt.Errorf("Refusing to change firewall rule %s: it wasn't created by mantle", name)
+	default:
+		plog.Debugf("Updating firewall rule %q", name)
+		op, err = a.compute.Firewalls.Update(a.options.Project, name, rule).Do()
+	}
+	if err != nil {
+		return fmt.Errorf("Creating firewall rule %s failed: %v", name, err)
+	}
+
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	return a.NewPending(op.Name, doable).Wait()
+}
+
+// validateSourceRanges checks the CIDR ranges allowed through firewall
+// rules.
+func validateSourceRanges(ranges []string) error {
+	for _, r := range ranges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("GCE firewall source range: %v", err)
+		}
+	}
+	return nil
+}
+
+// network returns the self-link of the network of the API.
+func (a *API) network() string {
+	if a.options.Network == "" {
+		return endpointPrefix + "projects/" + a.options.Project + "/global/networks/default"
+	}
+	return a.options.Network
+}
+
+// InternalRanges returns the IPv4 ranges of the network of the API: the
+// ranges of its subnetworks in every region, or the range of a legacy
+// network.
+func (a *API) InternalRanges() ([]string, error) {
+	// projects/<project>/global/networks/<name>
+	network := strings.TrimPrefix(a.network(), endpointPrefix)
+	parts := strings.Split(network, "/")
+	if len(parts) != 5 || parts[0] != "projects" || parts[2] != "global" || parts[3] != "networks" {
+		return nil, fmt.Errorf("malformed network %q", a.network())
+	}
+	project, name := parts[1], parts[4]
+
+	nw, err := a.compute.Networks.Get(project, name).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Getting network %s failed: %v", name, err)
+	}
+	if nw.IPv4Range != "" {
+		return []string{nw.IPv4Range}, nil
+	}
+
+	var ranges []string
+	err = a.compute.Subnetworks.AggregatedList(project).Pages(context.Background(), func(list *compute.SubnetworkAggregatedList) error {
+		for _, scoped := range list.Items {
+			for _, subnet := range scoped.Subnetworks {
+				// compare paths, since links use the API's endpoint
+				if strings.HasSuffix(subnet.Network, "/"+network) && subnet.IpCidrRange != "" {
+					ranges = append(ranges, subnet.IpCidrRange)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Listing subnetworks of network %s failed: %v", name, err)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("Network %s has no subnetworks", name)
+	}
+	sort.Strings(ranges)
+	return ranges, nil
+}
+
+// DeleteFirewallRule deletes a firewall rule created by
+// EnsureFirewallRule. Deleting a missing rule succeeds.
+func (a *API) DeleteFirewallRule(name string) error {
+	plog.Debugf("Deleting firewall rule %q", name)
+
+	op, err := a.compute.Firewalls.Delete(a.options.Project, name).Do()
+	if isNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Deleting firewall rule %s failed: %v", name, err)
+	}
+
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	return a.NewPending(op.Name, doable).Wait()
+}
+
+// gcFirewallRules deletes the firewall rules created by mantle more than
+// gracePeriod ago, which a crashed run may have left behind.
+func (a *API) gcFirewallRules(gracePeriod time.Duration) error {
+	threshold := time.Now().Add(-gracePeriod)
+
+	var expired []string
+	err := a.compute.Firewalls.List(a.options.Project).Pages(context.Background(), func(list *compute.FirewallList) error {
+		for _, rule := range list.Items {
+			if rule.Description != firewallDescription {
+				continue
+			}
+
+			created, err := time.Parse(time.RFC3339, rule.CreationTimestamp)
+			if err != nil {
+				return fmt.Errorf("couldn't parse %q: %v", rule.CreationTimestamp, err)
+			}
+			if !created.After(threshold) {
+				expired = append(expired, rule.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// delete after listing, so deletions don't shift the pages
+	for _, name := range expired {
+		if err := a.DeleteFirewallRule(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestEnsureFirewallRule(t *testing.T) {
+	var existing *compute.Firewall
+	var written *compute.Firewall
+	var deleted bool
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/test/global/operations/op":
+			writeJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		case r.URL.Path == "/test/global/firewalls" && r.Method == "POST",
+			r.URL.Path == "/test/global/firewalls/kola-1" && r.Method == "PUT":
+			if existing == nil && r.Method == "PUT" {
+				t.Errorf("updating missing rule")
+			}
+			written = &compute.Firewall{}
+			if err := json.NewDecoder(r.Body).Decode(written); err != nil {
+				t.Errorf("decoding rule failed: %v", err)
+			}
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		case r.URL.Path == "/test/global/firewalls/kola-1" && r.Method == "GET":
+			if existing == nil {
+				writeError(t, w, http.StatusNotFound, "notFound")
+				return
+			}
+			writeJSON(t, w, existing)
+		case r.URL.Path == "/test/global/firewalls/kola-1" && r.Method == "DELETE":
+			if existing == nil {
+				writeError(t, w, http.StatusNotFound, "notFound")
+				return
+			}
+			deleted = true
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	// create
+	if err := api.EnsureFirewallRule("kola-1", []string{"80", "8000-8080"}, []string{"0.0.0.0/0"}); err != nil {
+		t.Fatalf("EnsureFirewallRule failed: %v", err)
+	}
+	if written == nil {
+		t.Fatal("rule wasn't created")
+	}
+	if written.Description != firewallDescription || written.Network != endpointPrefix+"projects/test/global/networks/default" {
+		t.Errorf("bad rule %+v", written)
+	}
+	if !reflect.DeepEqual(written.TargetTags, []string{"kola-1"}) || !reflect.DeepEqual(written.SourceRanges, []string{"0.0.0.0/0"}) {
+		t.Errorf("bad rule targets %v, sources %v", written.TargetTags, written.SourceRanges)
+	}
+	if len(written.Allowed) != 1 || written.Allowed[0].IPProtocol != "tcp" || !reflect.DeepEqual(written.Allowed[0].Ports, []string{"80", "8000-8080"}) {
+		t.Errorf("bad allowed ports %+v", written.Allowed)
+	}
+
+	// update
+	existing, written = written, nil
+	if err := api.EnsureFirewallRule("kola-1", []string{"443"}, []string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("EnsureFirewallRule failed: %v", err)
+	}
+	if written == nil || !reflect.DeepEqual(written.Allowed[0].Ports, []string{"443"}) {
+		t.Errorf("rule wasn't updated: %+v", written)
+	}
+
+	// rules created by others are left alone
+	existing, written = &compute.Firewall{Name: "kola-1"}, nil
+	if err := api.EnsureFirewallRule("kola-1", []string{"443"}, nil); err == nil {
+		t.Error("changed a rule not created by mantle")
+	}
+	if written != nil {
+		t.Errorf("rule was written: %+v", written)
+	}
+
+	if err := api.DeleteFirewallRule("kola-1"); err != nil || !deleted {
+		t.Errorf("DeleteFirewallRule failed: %v", err)
+	}
+	existing = nil
+	if err := api.DeleteFirewallRule("kola-1"); err != nil {
+		t.Errorf("deleting a missing rule failed: %v", err)
+	}
+}
+
+func TestInternalRanges(t *testing.T) {
+	legacy := false
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/global/networks/default":
+			if legacy {
+				writeJSON(t, w, &compute.Network{Name: "default", IPv4Range: "10.240.0.0/16"})
+			} else {
+				writeJSON(t, w, &compute.Network{Name: "default"})
+			}
+		case "/test/aggregated/subnetworks":
+			writeJSON(t, w, &compute.SubnetworkAggregatedList{
+				Items: map[string]compute.SubnetworksScopedList{
+					"regions/us-east1": {Subnetworks: []*compute.Subnetwork{
+						{Network: endpointPrefix + "projects/test/global/networks/default", IpCidrRange: "10.142.0.0/20"},
+					}},
+					"regions/us-central1": {Subnetworks: []*compute.Subnetwork{
+						{Network: endpointPrefix + "projects/test/global/networks/default", IpCidrRange: "10.128.0.0/20"},
+						{Network: endpointPrefix + "projects/test/global/networks/other", IpCidrRange: "192.168.0.0/24"},
+					}},
+				},
+			})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	ranges, err := api.InternalRanges()
+	if err != nil {
+		t.Fatalf("InternalRanges failed: %v", err)
+	}
+	if expected := []string{"10.128.0.0/20", "10.142.0.0/20"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+
+	legacy = true
+	ranges, err = api.InternalRanges()
+	if err != nil {
+		t.Fatalf("InternalRanges failed: %v", err)
+	}
+	if expected := []string{"10.240.0.0/16"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+
+	if err := validateSourceRanges([]string{"203.0.113.7/32", "203.0.113.7"}); err == nil {
+		t.Error("accepted a source range without a prefix length")
+	}
+}
+
+func TestGCFirewallRules(t *testing.T) {
+	var deleted []string
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/test/global/firewalls" && r.Method == "GET":
+			old := "2018-01-01T00:00:00Z"
+			if r.URL.Query().Get("pageToken") == "" {
+				writeJSON(t, w, &compute.FirewallList{
+					Items: []*compute.Firewall{
+						{Name: "kola-1", Description: firewallDescription, CreationTimestamp: old},
+						{Name: "default-allow-ssh", CreationTimestamp: old},
+					},
+					NextPageToken: "next",
+				})
+			} else {
+				writeJSON(t, w, &compute.FirewallList{
+					Items: []*compute.Firewall{
+						{Name: "kola-2", Description: firewallDescription, CreationTimestamp: old},
+						{Name: "kola-3", Description: firewallDescription, CreationTimestamp: time.Now().Format(time.RFC3339)},
+					},
+				})
+			}
+		case strings.HasPrefix(r.URL.Path, "/test/global/firewalls/") && r.Method == "DELETE":
+			deleted = append(deleted, path.Base(r.URL.Path))
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		case r.URL.Path == "/test/global/operations/op":
+			writeJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	if err := api.gcFirewallRules(time.Hour); err != nil {
+		t.Fatalf("gcFirewallRules failed: %v", err)
+	}
+	if expected := []string{"kola-1", "kola-2"}; !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected deleted rules %v, got %v", expected, deleted)
+	}
+}
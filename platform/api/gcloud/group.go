@@ -0,0 +1,167 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/ssh/agent"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/util"
+)
+
+// instanceGroupTimeout is how long CreateInstanceGroup waits for the
+// group's instances to be running.
+const instanceGroupTimeout = 10 * time.Minute
+
+// templateProperties converts an instance created by mkinstance to the
+// properties of an instance template. Templates take resource names
+// rather than zonal URLs, and can't name disks since each instance needs
+// its own.
+func templateProperties(inst *compute.Instance) *compute.InstanceProperties {
+	for _, disk := range inst.Disks {
+		if disk.InitializeParams != nil {
+			disk.InitializeParams.DiskName = ""
+			disk.InitializeParams.DiskType = path.Base(disk.InitializeParams.DiskType)
+		}
+	}
+	return &compute.InstanceProperties{
+		MachineType:       path.Base(inst.MachineType),
+		Metadata:          inst.Metadata,
+		Tags:              inst.Tags,
+		Disks:             inst.Disks,
+		NetworkInterfaces: inst.NetworkInterfaces,
+		Scheduling:        inst.Scheduling,
+		ServiceAccounts:   inst.ServiceAccounts,
+	}
+}
+
+// CreateInstanceTemplate creates an instance template for instances like
+// those created by CreateInstance. The boot disk is created from the image
+// made from spec by CreateImage, or the configured image if spec is nil.
+func (a *API) CreateInstanceTemplate(name string, spec *ImageSpec, userdata string, keys []*agent.Key) (*compute.InstanceTemplate, error) {
+	if err := a.checkSubnetwork(); err != nil {
+		return nil, err
+	}
+
+	props := templateProperties(a.mkinstance(userdata, name, keys))
+	if spec != nil {
+		props.Disks[0].InitializeParams.SourceImage = "projects/" + a.options.Project + "/global/images/" + spec.Name
+	}
+	template := &compute.InstanceTemplate{
+		Name:       name,
+		Properties: props,
+	}
+
+	plog.Debugf("Creating instance template %q", name)
+
+	op, err := a.compute.InstanceTemplates.Insert(a.options.Project, template).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to request new GCE instance template: %v", err)
+	}
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	if err := a.NewPending(op.Name, doable).Wait(); err != nil {
+		return nil, err
+	}
+
+	return a.compute.InstanceTemplates.Get(a.options.Project, name).Do()
+}
+
+// CreateInstanceGroup creates a managed instance group of size instances
+// from the given template, and waits until all of them are running. The
+// group's instances are returned.
+func (a *API) CreateInstanceGroup(name string, template *compute.InstanceTemplate, size int64) ([]*compute.Instance, error) {
+	group := &compute.InstanceGroupManager{
+		Name:             name,
+		BaseInstanceName: name,
+		InstanceTemplate: template.SelfLink,
+		TargetSize:       size,
+	}
+
+	plog.Debugf("Creating instance group %q of %d instances", name, size)
+
+	op, err := a.compute.InstanceGroupManagers.Insert(a.options.Project, a.options.Zone, group).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to request new GCE instance group: %v", err)
+	}
+	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+	if err := a.NewPending(op.Name, doable).Wait(); err != nil {
+		return nil, err
+	}
+
+	err = util.WaitUntilReady(instanceGroupTimeout, 10*time.Second, func() (bool, error) {
+		managed, err := a.compute.InstanceGroupManagers.ListManagedInstances(a.options.Project, a.options.Zone, name).Do()
+		if err != nil {
+			return false, err
+		}
+		if int64(len(managed.ManagedInstances)) != size {
+			return false, nil
+		}
+		for _, m := range managed.ManagedInstances {
+			if m.InstanceStatus != "RUNNING" || m.CurrentAction != "NONE" {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("waiting for instance group %s: %v", name, err)
+	}
+
+	plog.Debugf("Created instance group %q", name)
+
+	return a.InstanceGroupInstances(name)
+}
+
+// InstanceGroupInstances returns the instances of a managed instance
+// group.
+func (a *API) InstanceGroupInstances(name string) ([]*compute.Instance, error) {
+	managed, err := a.compute.InstanceGroupManagers.ListManagedInstances(a.options.Project, a.options.Zone, name).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing instances of group %s: %v", name, err)
+	}
+
+	var insts []*compute.Instance
+	for _, m := range managed.ManagedInstances {
+		inst, err := a.compute.Instances.Get(a.options.Project, a.options.Zone, path.Base(m.Instance)).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed getting instance %s of group %s: %v", path.Base(m.Instance), name, err)
+		}
+		insts = append(insts, inst)
+	}
+	return insts, nil
+}
+
+// DeleteInstanceGroup deletes a managed instance group along with its
+// instances, and then the template the instances were created from.
+func (a *API) DeleteInstanceGroup(name string, template *compute.InstanceTemplate) error {
+	op, err := a.compute.InstanceGroupManagers.Delete(a.options.Project, a.options.Zone, name).Do()
+	if err != nil {
+		return fmt.Errorf("failed deleting instance group %s: %v", name, err)
+	}
+	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+	if err := a.NewPending(op.Name, doable).WithTimeout(instanceGroupTimeout).Wait(); err != nil {
+		return err
+	}
+
+	op, err = a.compute.InstanceTemplates.Delete(a.options.Project, template.Name).Do()
+	if err != nil {
+		return fmt.Errorf("failed deleting instance template %s: %v", template.Name, err)
+	}
+	return a.NewPending(op.Name, a.compute.GlobalOperations.Get(a.options.Project, op.Name)).Wait()
+}
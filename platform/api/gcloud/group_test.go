@@ -0,0 +1,80 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestTemplateProperties(t *testing.T) {
+	opts := testInstanceOptions()
+	opts.LocalSSDCount = 1
+	api := &API{options: opts}
+	props := templateProperties(api.mkinstance("", "kola-1", nil))
+
+	if props.MachineType != "n1-standard-1" {
+		t.Errorf("expected machine type n1-standard-1, got %q", props.MachineType)
+	}
+	if len(props.Disks) != 2 {
+		t.Fatalf("expected 2 disks, got %d", len(props.Disks))
+	}
+	for i, expected := range []string{"pd-ssd", "local-ssd"} {
+		params := props.Disks[i].InitializeParams
+		if params.DiskName != "" {
+			t.Errorf("disk %d: expected no disk name, got %q", i, params.DiskName)
+		}
+		if params.DiskType != expected {
+			t.Errorf("disk %d: expected disk type %q, got %q", i, expected, params.DiskType)
+		}
+	}
+}
+
+func TestInstanceGroupInstances(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/instanceGroupManagers/kola/listManagedInstances"):
+			writeJSON(t, w, &compute.InstanceGroupManagersListManagedInstancesResponse{
+				ManagedInstances: []*compute.ManagedInstance{
+					{Instance: "https://www.googleapis.com/compute/v1/projects/test/zones/us-central1-a/instances/kola-a"},
+					{Instance: "https://www.googleapis.com/compute/v1/projects/test/zones/us-central1-a/instances/kola-b"},
+				},
+			})
+		case strings.Contains(r.URL.Path, "/zones/us-central1-a/instances/"):
+			writeJSON(t, w, &compute.Instance{Name: path.Base(r.URL.Path)})
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+	api.options.Zone = "us-central1-a"
+
+	insts, err := api.InstanceGroupInstances("kola")
+	if err != nil {
+		t.Fatalf("InstanceGroupInstances failed: %v", err)
+	}
+	var names []string
+	for _, inst := range insts {
+		names = append(names, inst.Name)
+	}
+	if got := strings.Join(names, " "); got != "kola-a kola-b" {
+		t.Errorf("expected instances kola-a kola-b, got %q", got)
+	}
+}
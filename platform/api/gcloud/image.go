@@ -15,11 +15,25 @@
 package gcloud
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/coreos/pkg/multierror"
 	"golang.org/x/net/context"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/mantle/util"
 )
 
 type DeprecationState string
@@ -31,85 +45,531 @@ const (
 	DeprecationStateDeleted    DeprecationState = "DELETED"
 )
 
+// ImageError is returned by CreateImage when GCE rejects an image.
+type ImageError struct {
+	Name   string
+	Code   int    // HTTP status code, if known
+	Reason string // GCE error reason, e.g. "forbidden" or "quotaExceeded"
+	Err    error
+}
+
+func newImageError(name string, err error) *ImageError {
+	ierr := &ImageError{
+		Name: name,
+		Err:  err,
+	}
+	if gerr, ok := err.(*googleapi.Error); ok {
+		ierr.Code = gerr.Code
+		if len(gerr.Errors) > 0 {
+			ierr.Reason = gerr.Errors[0].Reason
+		}
+	}
+	return ierr
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("Creating image %s failed: %v", e.Name, e.Err)
+}
+
+// Temporary reports whether the failure is transient, so that the
+// request may succeed if retried later.
+func (e *ImageError) Temporary() bool {
+	switch e.Reason {
+	case "quotaExceeded", "rateLimitExceeded", "userRateLimitExceeded", "backendError":
+		return true
+	}
+	return e.Code == http.StatusTooManyRequests || e.Code >= http.StatusInternalServerError
+}
+
+// ErrImageNotFound is returned by DeleteImage for images which don't
+// exist.
+var ErrImageNotFound = errors.New("image not found")
+
+// imageReadyInterval is how long WaitForImageReady first waits between
+// polls of the image status, backing off to imageReadyMaxInterval.
+var (
+	imageReadyInterval    = 10 * time.Second
+	imageReadyMaxInterval = time.Minute
+)
+
+// guestOsFeatures is the set of guest OS feature types accepted in
+// ImageSpec.GuestOsFeatures.
+var guestOsFeatures = map[string]bool{
+	"GVNIC":                  true,
+	"MULTI_IP_SUBNET":        true,
+	"SECURE_BOOT":            true,
+	"SEV_CAPABLE":            true,
+	"UEFI_COMPATIBLE":        true,
+	"VIRTIO_SCSI_MULTIQUEUE": true,
+	"WINDOWS":                true,
+}
+
+// ImageSpec describes an image to create. Exactly one of SourceImage (a
+// Cloud Storage URL of a tarball containing a raw disk) and SourceDisk (a
+// link to an existing persistent disk) must be set.
 type ImageSpec struct {
-	SourceImage string
-	Family      string
-	Name        string
-	Description string
-	Licenses    []string // short names
+	SourceImage     string
+	SourceDisk      string
+	Family          string
+	Name            string
+	Description     string
+	Licenses        []string // short names
+	GuestOsFeatures []string // nil for the default VIRTIO_SCSI_MULTIQUEUE
+	// ProtectionLabel is the key of the label which, set to "true" on
+	// an existing image, prevents it from being overwritten. Empty for
+	// DefaultProtectionLabel.
+	ProtectionLabel string
+	Labels          map[string]string // set on the new image
 }
 
+// maxImageLabels is the number of labels GCE allows on an image.
+const maxImageLabels = 64
+
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRegexp = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// validateLabels checks labels against the GCE label constraints: keys
+// start with a lowercase letter, and keys and values have at most 63
+// lowercase letters, digits, underscores or dashes.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxImageLabels {
+		return fmt.Errorf("Too many GCE labels: %d > %d", len(labels), maxImageLabels)
+	}
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("Invalid GCE label key %q: must start with a lowercase letter and have at most 63 lowercase letters, digits, underscores or dashes", k)
+		}
+		if !labelValueRegexp.MatchString(v) {
+			return fmt.Errorf("Invalid GCE label value %q for key %q: must have at most 63 lowercase letters, digits, underscores or dashes", v, k)
+		}
+	}
+	return nil
+}
+
+// DefaultProtectionLabel marks images which CreateImage and CopyImage must
+// not overwrite.
+const DefaultProtectionLabel = "mantle-protected"
+
 // CreateImage creates an image on GCE and returns operation details and
 // a Pending. If overwrite is true, an existing image will be overwritten
-// if it exists.
+// if it exists, unless it has the protection label of spec. Errors from
+// GCE when inserting the image are returned as an *ImageError.
 func (a *API) CreateImage(spec *ImageSpec, overwrite bool) (*compute.Operation, *Pending, error) {
+	return a.CreateImageContext(context.Background(), spec, overwrite)
+}
+
+// CreateImageContext is like CreateImage but aborts if ctx is cancelled
+// before the image creation has been requested.
+func (a *API) CreateImageContext(ctx context.Context, spec *ImageSpec, overwrite bool) (*compute.Operation, *Pending, error) {
+	if (spec.SourceImage == "") == (spec.SourceDisk == "") {
+		return nil, nil, fmt.Errorf("Exactly one of SourceImage and SourceDisk must be specified for image %s", spec.Name)
+	}
+	if err := validateLabels(spec.Labels); err != nil {
+		return nil, nil, err
+	}
+
+	features := []*compute.GuestOsFeature{
+		&compute.GuestOsFeature{
+			Type: "VIRTIO_SCSI_MULTIQUEUE",
+		},
+	}
+	if spec.GuestOsFeatures != nil {
+		features = make([]*compute.GuestOsFeature, len(spec.GuestOsFeatures))
+		for i, f := range spec.GuestOsFeatures {
+			if !guestOsFeatures[f] {
+				return nil, nil, fmt.Errorf("Unknown GCE guest OS feature %q", f)
+			}
+			features[i] = &compute.GuestOsFeature{
+				Type: f,
+			}
+		}
+	}
+
 	licenses := make([]string, len(spec.Licenses))
 	for i, l := range spec.Licenses {
-		license, err := a.compute.Licenses.Get(a.options.Project, l).Do()
+		license, err := a.compute.Licenses.Get(a.options.Project, l).Context(ctx).Do()
 		if err != nil {
 			return nil, nil, fmt.Errorf("Invalid GCE license %s: %v", l, err)
 		}
 		licenses[i] = license.SelfLink
 	}
 
+	image := &compute.Image{
+		Family:          spec.Family,
+		Name:            spec.Name,
+		Description:     spec.Description,
+		Licenses:        licenses,
+		GuestOsFeatures: features,
+	}
+	if spec.SourceDisk != "" {
+		image.SourceDisk = spec.SourceDisk
+		plog.Debugf("Creating image %q from disk %q", spec.Name, spec.SourceDisk)
+	} else {
+		image.RawDisk = &compute.ImageRawDisk{
+			Source: spec.SourceImage,
+		}
+		plog.Debugf("Creating image %q from %q", spec.Name, spec.SourceImage)
+	}
+
+	return a.insertImage(ctx, image, spec.Labels, overwrite, spec.ProtectionLabel)
+}
+
+func (a *API) insertImage(ctx context.Context, image *compute.Image, labels map[string]string, overwrite bool, protectionLabel string) (*compute.Operation, *Pending, error) {
 	if overwrite {
-		plog.Debugf("Overwriting image %q", spec.Name)
-		// delete existing image, ignore error since it might not exist.
-		op, err := a.compute.Images.Delete(a.options.Project, spec.Name).Do()
+		if protectionLabel == "" {
+			protectionLabel = DefaultProtectionLabel
+		}
+		existing, err := a.imageLabels(ctx, image.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if existing[protectionLabel] == "true" {
+			return nil, nil, fmt.Errorf("Refusing to overwrite image %s: it is protected by label %s=true", image.Name, protectionLabel)
+		}
 
-		if op != nil {
-			doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
-			if err := a.NewPending(op.Name, doable).Wait(); err != nil {
+		plog.Debugf("Overwriting image %q", image.Name)
+		// the image may have been deleted in the meantime
+		_, pending, err := a.DeleteImage(image.Name, false)
+		if err != nil && err != ErrImageNotFound {
+			return nil, nil, err
+		}
+		if pending != nil {
+			if err := pending.WaitContext(ctx); err != nil {
 				return nil, nil, err
 			}
 		}
+	}
+
+	var op *compute.Operation
+	var err error
+	if len(labels) == 0 {
+		op, err = a.compute.Images.Insert(a.options.Project, image).Context(ctx).Do()
+	} else {
+		op, err = a.insertLabeledImage(ctx, image, labels)
+	}
+	if err != nil {
+		return nil, nil, newImageError(image.Name, err)
+	}
+
+	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	return op, a.NewPending(op.Name, doable), nil
+}
+
+// insertLabeledImage inserts image with labels. The vendored compute API
+// predates labels, so the request is made directly.
+func (a *API) insertLabeledImage(ctx context.Context, image *compute.Image, labels map[string]string) (*compute.Operation, error) {
+	b, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+	body["labels"] = labels
+
+	var op compute.Operation
+	if err := a.computeRequest(ctx, "POST", url.PathEscape(a.options.Project)+"/global/images", body, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// labeledImage holds the image fields the vendored compute API lacks.
+type labeledImage struct {
+	Labels           map[string]string `json:"labels"`
+	LabelFingerprint string            `json:"labelFingerprint"`
+}
+
+// getLabeledImage returns the labels of the named image, or nil if it
+// doesn't exist.
+func (a *API) getLabeledImage(ctx context.Context, name string) (*labeledImage, error) {
+	var image labeledImage
+	err := a.computeRequest(ctx, "GET", url.PathEscape(a.options.Project)+"/global/images/"+url.PathEscape(name), nil, &image)
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Getting image %s failed: %v", name, err)
+	}
+	return &image, nil
+}
+
+// imageLabels returns the labels of the named image, or nil if it doesn't
+// exist.
+func (a *API) imageLabels(ctx context.Context, name string) (map[string]string, error) {
+	image, err := a.getLabeledImage(ctx, name)
+	if err != nil || image == nil {
+		return nil, err
+	}
+	return image.Labels, nil
+}
+
+// SetImageLabels replaces the labels of an existing image and returns
+// operation details and a Pending.
+func (a *API) SetImageLabels(name string, labels map[string]string) (*compute.Operation, *Pending, error) {
+	if err := validateLabels(labels); err != nil {
+		return nil, nil, err
+	}
+	ctx := context.Background()
+	image, err := a.getLabeledImage(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	} else if image == nil {
+		return nil, nil, ErrImageNotFound
+	}
+
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	var op compute.Operation
+	err = a.computeRequest(ctx, "POST", url.PathEscape(a.options.Project)+"/global/images/"+url.PathEscape(name)+"/setLabels", &labeledImage{
+		Labels:           labels,
+		LabelFingerprint: image.LabelFingerprint,
+	}, &op)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Setting labels of %s failed: %v", name, err)
+	}
+	opReq := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
+	return &op, a.NewPending(op.Name, opReq), nil
+}
 
-		// don't return error when delete fails because image doesn't exist
-		if err != nil && !strings.HasSuffix(err.Error(), "notFound") {
-			return nil, nil, fmt.Errorf("deleting image: %v", err)
+// computeRequest makes a request to path, relative to the compute API's
+// base, for fields the vendored compute API lacks. in, if not nil, is
+// sent as the JSON body, and the response is decoded into out. Errors
+// from GCE are returned as a *googleapi.Error.
+func (a *API) computeRequest(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
 		}
 	}
+	req, err := http.NewRequest(method, a.compute.BasePath+path, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// copyDiskName returns a name for the temporary disk of a copy to the
+// image dstName, truncating dstName to keep within the 63 characters GCE
+// allows.
+func copyDiskName(dstName string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	suffix := fmt.Sprintf("-copy-%x", b)
+	if max := 63 - len(suffix); len(dstName) > max {
+		dstName = dstName[:max]
+	}
+	return dstName + suffix
+}
 
-	image := &compute.Image{
-		Family:      spec.Family,
-		Name:        spec.Name,
-		Description: spec.Description,
-		Licenses:    licenses,
-		GuestOsFeatures: []*compute.GuestOsFeature{
-			&compute.GuestOsFeature{
-				Type: "VIRTIO_SCSI_MULTIQUEUE",
-			},
-		},
-		RawDisk: &compute.ImageRawDisk{
-			Source: spec.SourceImage,
-		},
+// CopyImage copies the image srcName in srcProject to the image dstName in
+// this API's project, preserving its family, description, licenses, and
+// guest OS features. If overwrite is true, an existing image named dstName
+// will be overwritten unless it has the DefaultProtectionLabel.
+//
+// The image is copied through a temporary disk in the configured zone,
+// which is deleted once the copy is complete, so CopyImage waits for the
+// copy to finish. The returned Pending is provided for symmetry with
+// CreateImage and completes immediately.
+func (a *API) CopyImage(srcProject, srcName, dstName string, overwrite bool) (*compute.Operation, *Pending, error) {
+	if a.options.Zone == "" {
+		return nil, nil, fmt.Errorf("Copying image %s requires a zone", srcName)
 	}
 
-	plog.Debugf("Creating image %q from %q", spec.Name, spec.SourceImage)
+	src, err := a.compute.Images.Get(srcProject, srcName).Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Getting image %s in project %s failed: %v", srcName, srcProject, err)
+	}
 
-	op, err := a.compute.Images.Insert(a.options.Project, image).Do()
+	diskName := copyDiskName(dstName)
+	plog.Debugf("Creating disk %q from image %q", diskName, src.SelfLink)
+	diskOp, err := a.compute.Disks.Insert(a.options.Project, a.options.Zone, &compute.Disk{
+		Name:        diskName,
+		SourceImage: src.SelfLink,
+	}).Do()
 	if err != nil {
+		return nil, nil, fmt.Errorf("Creating disk %s failed: %v", diskName, err)
+	}
+	doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, diskOp.Name)
+	if err := a.NewPending(diskOp.Name, doable).Wait(); err != nil {
 		return nil, nil, err
 	}
+	defer func() {
+		plog.Debugf("Deleting disk %q", diskName)
+		op, err := a.compute.Disks.Delete(a.options.Project, a.options.Zone, diskName).Do()
+		if err == nil {
+			doable := a.compute.ZoneOperations.Get(a.options.Project, a.options.Zone, op.Name)
+			err = a.NewPending(op.Name, doable).Wait()
+		}
+		if err != nil {
+			plog.Errorf("Deleting disk %s failed: %v", diskName, err)
+		}
+	}()
 
-	doable := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
-	return op, a.NewPending(op.Name, doable), nil
+	disk, err := a.compute.Disks.Get(a.options.Project, a.options.Zone, diskName).Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Getting disk %s failed: %v", diskName, err)
+	}
+
+	plog.Debugf("Creating image %q from disk %q", dstName, disk.SelfLink)
+	op, pending, err := a.insertImage(context.Background(), &compute.Image{
+		Family:          src.Family,
+		Name:            dstName,
+		Description:     src.Description,
+		Licenses:        src.Licenses,
+		GuestOsFeatures: src.GuestOsFeatures,
+		SourceDisk:      disk.SelfLink,
+	}, nil, overwrite, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pending.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return op, pending, nil
+}
+
+// ImageFilter selects the images returned by ListImagesByFilter. Empty
+// fields match all images; set fields must all match.
+type ImageFilter struct {
+	Prefix string // image name prefix
+	Family string
+	Labels map[string]string // labels the image must all have
+}
+
+func (f *ImageFilter) String() string {
+	var exprs []string
+	if f.Prefix != "" {
+		exprs = append(exprs, fmt.Sprintf("(name eq ^%s.*)", f.Prefix))
+	}
+	if f.Family != "" {
+		exprs = append(exprs, fmt.Sprintf("(family eq %s)", f.Family))
+	}
+	var keys []string
+	for k := range f.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		exprs = append(exprs, fmt.Sprintf("(labels.%s eq %s)", k, f.Labels[k]))
+	}
+	return strings.Join(exprs, " ")
 }
 
 func (a *API) ListImages(ctx context.Context, prefix string) ([]*compute.Image, error) {
+	return a.ListImagesByFilter(ctx, &ImageFilter{Prefix: prefix})
+}
+
+func (a *API) ListImagesByFilter(ctx context.Context, filter *ImageFilter) ([]*compute.Image, error) {
 	var images []*compute.Image
+	err := a.ListImagesByFilterFunc(ctx, filter, func(image *compute.Image) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// ListImagesFunc calls f for each image whose name starts with prefix, as
+// the pages of the listing arrive, so that callers needn't hold every
+// image in memory.
+func (a *API) ListImagesFunc(ctx context.Context, prefix string, f func(*compute.Image) error) error {
+	return a.ListImagesByFilterFunc(ctx, &ImageFilter{Prefix: prefix}, f)
+}
+
+// ListImagesByFilterFunc is like ListImagesFunc but selects images with
+// filter. The next page isn't fetched until f has returned for each image
+// of the current one. If f returns an error, listing stops and the error
+// is returned.
+func (a *API) ListImagesByFilterFunc(ctx context.Context, filter *ImageFilter, f func(*compute.Image) error) error {
 	listReq := a.compute.Images.List(a.options.Project)
-	if prefix != "" {
-		listReq.Filter(fmt.Sprintf("name eq ^%s.*", prefix))
+	if expr := filter.String(); expr != "" {
+		listReq.Filter(expr)
 	}
+	var ferr error
 	err := listReq.Pages(ctx, func(i *compute.ImageList) error {
-		images = append(images, i.Items...)
+		for _, image := range i.Items {
+			if ferr = f(image); ferr != nil {
+				return ferr
+			}
+		}
 		return nil
 	})
-	if err != nil {
-		return nil, fmt.Errorf("Listing GCE images failed: %v", err)
+	if ferr != nil {
+		return ferr
+	} else if err != nil {
+		return fmt.Errorf("Listing GCE images failed: %v", err)
+	}
+	return nil
+}
+
+// GetLatestImage returns the newest non-deprecated image in family.
+func (a *API) GetLatestImage(family string) (*compute.Image, error) {
+	image, err := a.compute.Images.GetFromFamily(a.options.Project, family).Do()
+	if isNotFound(err) {
+		return nil, fmt.Errorf("No active GCE images in family %q", family)
+	} else if err != nil {
+		return nil, fmt.Errorf("Getting latest GCE image in family %q failed: %v", family, err)
+	}
+	if image.Deprecated != nil && image.Deprecated.State != "" && image.Deprecated.State != string(DeprecationStateActive) {
+		return nil, fmt.Errorf("No active GCE images in family %q: latest image %q is %s", family, image.Name, image.Deprecated.State)
+	}
+	return image, nil
+}
+
+// WaitForImageReady polls the named image until its status is READY and
+// returns it. It gives up if the image fails or ctx is cancelled.
+func (a *API) WaitForImageReady(ctx context.Context, name string) (*compute.Image, error) {
+	backoff := util.Backoff{
+		Initial: imageReadyInterval,
+		Max:     imageReadyMaxInterval,
+		Jitter:  0.2,
+	}
+	failures := 0
+	for attempt := 0; ; attempt++ {
+		image, err := a.compute.Images.Get(a.options.Project, name).Context(ctx).Do()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failures++
+			if failures > 5 || !isTransient(err) {
+				return nil, fmt.Errorf("Getting image %s failed: %v", name, err)
+			}
+			plog.Debugf("Getting image %s failed, retrying: %v", name, err)
+		} else {
+			switch image.Status {
+			case "READY":
+				return image, nil
+			case "FAILED":
+				return nil, fmt.Errorf("Image %s failed", name)
+			}
+			plog.Debugf("Image %q is %q", name, image.Status)
+		}
+
+		select {
+		case <-time.After(backoff.Delay(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	return images, nil
 }
 
 func (a *API) GetPendingForImage(image *compute.Image) (*Pending, error) {
@@ -127,24 +587,168 @@ func (a *API) GetPendingForImage(image *compute.Image) (*Pending, error) {
 	return a.NewPending(pendingOp.Name, doable), nil
 }
 
-func (a *API) DeprecateImage(name string, state DeprecationState, replacement string) (*Pending, error) {
+// DeprecateImage sets the deprecation state of an image on GCE and returns
+// operation details and a Pending. replacement is an optional link to the
+// image that should be used instead.
+func (a *API) DeprecateImage(name string, state DeprecationState, replacement string) (*compute.Operation, *Pending, error) {
+	switch state {
+	case DeprecationStateActive, DeprecationStateDeprecated, DeprecationStateObsolete, DeprecationStateDeleted:
+	default:
+		return nil, nil, fmt.Errorf("Invalid deprecation state %q for %s", state, name)
+	}
+
 	req := a.compute.Images.Deprecate(a.options.Project, name, &compute.DeprecationStatus{
 		State:       string(state),
 		Replacement: replacement,
 	})
 	op, err := req.Do()
 	if err != nil {
-		return nil, fmt.Errorf("Deprecating %s failed: %v", name, err)
+		return nil, nil, fmt.Errorf("Deprecating %s failed: %v", name, err)
 	}
 	opReq := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
-	return a.NewPending(op.Name, opReq), nil
+	return op, a.NewPending(op.Name, opReq), nil
 }
 
-func (a *API) DeleteImage(name string) (*Pending, error) {
+// DeleteImage deletes an image on GCE and returns operation details and
+// a Pending. If dryRun is true, the image is not deleted and a nil
+// operation and Pending are returned. If the image doesn't exist,
+// ErrImageNotFound is returned.
+func (a *API) DeleteImage(name string, dryRun bool) (*compute.Operation, *Pending, error) {
+	if dryRun {
+		_, err := a.compute.Images.Get(a.options.Project, name).Do()
+		if isNotFound(err) {
+			return nil, nil, ErrImageNotFound
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("Getting %s failed: %v", name, err)
+		}
+		plog.Infof("Would delete image %q", name)
+		return nil, nil, nil
+	}
+
+	plog.Debugf("Deleting image %q", name)
 	op, err := a.compute.Images.Delete(a.options.Project, name).Do()
-	if err != nil {
-		return nil, fmt.Errorf("Deleting %s failed: %v", name, err)
+	if isNotFound(err) {
+		return nil, nil, ErrImageNotFound
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Deleting %s failed: %v", name, err)
 	}
 	opReq := a.compute.GlobalOperations.Get(a.options.Project, op.Name)
-	return a.NewPending(op.Name, opReq), nil
+	return op, a.NewPending(op.Name, opReq), nil
+}
+
+// deleteImagesLimit is the number of images DeleteImagesByLabel deletes
+// at once.
+var deleteImagesLimit = 10
+
+// DeleteImagesByLabel deletes every image with all the labels of selector
+// and returns the names of the images it deleted. A failure to delete an
+// image doesn't stop the others from being deleted; the errors are
+// returned together.
+func (a *API) DeleteImagesByLabel(ctx context.Context, selector map[string]string) ([]string, error) {
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("Refusing to delete images without a label selector")
+	}
+	if err := validateLabels(selector); err != nil {
+		return nil, err
+	}
+	images, err := a.ListImagesByFilter(ctx, &ImageFilter{Labels: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(images))
+	pendings := make([]*Pending, len(images))
+	done := make([]bool, len(images))
+	limit := make(chan struct{}, deleteImagesLimit)
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			if err := ctx.Err(); err != nil {
+				errs[i] = fmt.Errorf("Deleting %s failed: %v", name, err)
+				return
+			}
+			_, pending, err := a.DeleteImage(name, false)
+			if err == ErrImageNotFound {
+				// deleted since it was listed
+				return
+			} else if err != nil {
+				errs[i] = err
+				return
+			}
+			progress := pending.Progress
+			pending.Progress = func(desc string, elapsed time.Duration, op *compute.Operation) error {
+				done[i] = op.Status == "DONE" && op.Error == nil
+				return progress(desc, elapsed, op)
+			}
+			pendings[i] = pending
+		}(i, image.Name)
+	}
+	wg.Wait()
+
+	var started []*Pending
+	for _, pending := range pendings {
+		if pending != nil {
+			started = append(started, pending)
+		}
+	}
+	werr := WaitAllContext(ctx, false, started...)
+
+	var deleted []string
+	var merr multierror.Error
+	for i, image := range images {
+		if done[i] {
+			deleted = append(deleted, image.Name)
+		}
+		if errs[i] != nil {
+			merr = append(merr, errs[i])
+		}
+	}
+	if werr != nil {
+		if werrs, ok := werr.(multierror.Error); ok {
+			merr = append(merr, werrs...)
+		} else {
+			merr = append(merr, werr)
+		}
+	}
+	return deleted, merr.AsError()
+}
+
+// CheckImage verifies that the configured image exists, since creating
+// instances from a missing image fails with an obscure error. Images
+// are global, so this doesn't depend on the zone.
+func (a *API) CheckImage() error {
+	// projects/<project>/global/images/<name>, or
+	// projects/<project>/global/images/family/<family>
+	parts := strings.Split(strings.TrimPrefix(a.options.Image, endpointPrefix), "/")
+	if len(parts) < 5 || parts[0] != "projects" || parts[2] != "global" || parts[3] != "images" {
+		return fmt.Errorf("malformed GCE image %q", a.options.Image)
+	}
+	project := parts[1]
+
+	var err error
+	switch {
+	case len(parts) == 5:
+		_, err = a.compute.Images.Get(project, parts[4]).Do()
+	case len(parts) == 6 && parts[4] == "family":
+		_, err = a.compute.Images.GetFromFamily(project, parts[5]).Do()
+	default:
+		return fmt.Errorf("malformed GCE image %q", a.options.Image)
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("GCE image %s not found in project %s", strings.Join(parts[4:], "/"), project)
+	} else if err != nil {
+		return fmt.Errorf("getting GCE image %s: %v", a.options.Image, err)
+	}
+	return nil
+}
+
+// isNotFound reports whether err indicates that the requested resource
+// doesn't exist.
+func isNotFound(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusNotFound
 }
@@ -0,0 +1,724 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/pkg/multierror"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// newTestAPI returns an API for project "test" backed by handler. The
+// caller must close the returned server.
+func newTestAPI(t *testing.T, handler http.Handler) (*API, *httptest.Server) {
+	srv := httptest.NewServer(handler)
+
+	capi, err := compute.New(http.DefaultClient)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("compute.New failed: %v", err)
+	}
+	capi.BasePath = srv.URL + "/"
+
+	api := &API{
+		client:  http.DefaultClient,
+		compute: capi,
+		options: &Options{Project: "test"},
+	}
+	return api, srv
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("encoding response failed: %v", err)
+	}
+}
+
+// writeError writes a GCE API error response.
+func writeError(t *testing.T, w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": reason,
+			"errors":  []map[string]string{{"reason": reason}},
+		},
+	})
+	if err != nil {
+		t.Errorf("encoding response failed: %v", err)
+	}
+}
+
+func TestListImagesByFilter(t *testing.T) {
+	pages := map[string]*compute.ImageList{
+		"": {
+			Items:         []*compute.Image{{Name: "a"}, {Name: "b"}},
+			NextPageToken: "2",
+		},
+		"2": {
+			Items:         []*compute.Image{{Name: "c"}},
+			NextPageToken: "3",
+		},
+		"3": {
+			Items: []*compute.Image{{Name: "d"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		filter ImageFilter
+		expr   string
+	}{
+		{ImageFilter{}, ""},
+		{ImageFilter{Prefix: "coreos-"}, "(name eq ^coreos-.*)"},
+		{ImageFilter{Family: "coreos-stable"}, "(family eq coreos-stable)"},
+		{ImageFilter{Prefix: "coreos-", Family: "coreos-stable"}, "(name eq ^coreos-.*) (family eq coreos-stable)"},
+		{ImageFilter{Labels: map[string]string{"pipeline": "42", "commit": "abc"}}, "(labels.commit eq abc) (labels.pipeline eq 42)"},
+	} {
+		api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/test/global/images" {
+				t.Errorf("unexpected request path %q", r.URL.Path)
+			}
+			if expr := r.URL.Query().Get("filter"); expr != tt.expr {
+				t.Errorf("expected filter %q, got %q", tt.expr, expr)
+			}
+			page, ok := pages[r.URL.Query().Get("pageToken")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(t, w, page)
+		}))
+		defer srv.Close()
+
+		images, err := api.ListImagesByFilter(context.Background(), &tt.filter)
+		if err != nil {
+			t.Fatalf("ListImagesByFilter failed: %v", err)
+		}
+		var names string
+		for _, image := range images {
+			names += image.Name
+		}
+		if names != "abcd" {
+			t.Errorf("expected images abcd, got %q", names)
+		}
+	}
+}
+
+func TestListImagesFunc(t *testing.T) {
+	pages := map[string]*compute.ImageList{
+		"": {
+			Items:         []*compute.Image{{Name: "a"}, {Name: "b"}},
+			NextPageToken: "2",
+		},
+		"2": {
+			Items:         []*compute.Image{{Name: "c"}},
+			NextPageToken: "3",
+		},
+		"3": {
+			Items: []*compute.Image{{Name: "d"}},
+		},
+	}
+	var requested []string
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("pageToken")
+		requested = append(requested, token)
+		writeJSON(t, w, pages[token])
+	}))
+	defer srv.Close()
+
+	var names string
+	err := api.ListImagesFunc(context.Background(), "", func(image *compute.Image) error {
+		names += image.Name
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListImagesFunc failed: %v", err)
+	}
+	if names != "abcd" {
+		t.Errorf("expected images abcd, got %q", names)
+	}
+
+	// an error from the callback stops the listing
+	stop := errors.New("stop")
+	names, requested = "", nil
+	err = api.ListImagesFunc(context.Background(), "", func(image *compute.Image) error {
+		names += image.Name
+		if image.Name == "b" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expected %v, got %v", stop, err)
+	}
+	if names != "ab" || len(requested) != 1 {
+		t.Errorf("expected to stop after the first page, got images %q from pages %q", names, requested)
+	}
+}
+
+func TestGetLatestImage(t *testing.T) {
+	images := map[string]*compute.Image{
+		"active": {Name: "active-v2"},
+		"deprecated": {
+			Name:       "deprecated-v2",
+			Deprecated: &compute.DeprecationStatus{State: "DEPRECATED"},
+		},
+	}
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		image, ok := images[strings.TrimPrefix(r.URL.Path, "/test/global/images/family/")]
+		if !ok {
+			writeError(t, w, http.StatusNotFound, "notFound")
+			return
+		}
+		writeJSON(t, w, image)
+	}))
+	defer srv.Close()
+
+	image, err := api.GetLatestImage("active")
+	if err != nil {
+		t.Fatalf("GetLatestImage failed: %v", err)
+	}
+	if image.Name != "active-v2" {
+		t.Errorf("expected image active-v2, got %q", image.Name)
+	}
+
+	for _, family := range []string{"deprecated", "missing"} {
+		if _, err := api.GetLatestImage(family); err == nil {
+			t.Errorf("GetLatestImage(%q) unexpectedly succeeded", family)
+		}
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/coreos-cloud/global/images/coreos-alpha-1", "/coreos-cloud/global/images/family/coreos-alpha":
+			writeJSON(t, w, &compute.Image{Name: "coreos-alpha-1"})
+		default:
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		image string
+		ok    bool
+	}{
+		{endpointPrefix + "projects/coreos-cloud/global/images/coreos-alpha-1", true},
+		{endpointPrefix + "projects/coreos-cloud/global/images/family/coreos-alpha", true},
+		{endpointPrefix + "projects/coreos-cloud/global/images/missing", false},
+		{endpointPrefix + "projects/coreos-cloud/global/images/family/missing", false},
+		{endpointPrefix + "projects/coreos-cloud/zones/images/missing", false},
+	} {
+		api.options.Image = tt.image
+		if err := api.CheckImage(); (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected result %v", tt.image, err)
+		}
+	}
+}
+
+func TestNewEndpoint(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		writeJSON(t, w, &compute.Image{Name: "coreos-alpha-1"})
+	}))
+	defer srv.Close()
+
+	api, err := New(&Options{
+		Image:    "coreos-alpha-1",
+		Project:  "test",
+		Endpoint: srv.URL + "/emulator",
+		Options:  &platform.Options{},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if api.compute.BasePath != srv.URL+"/emulator/" {
+		t.Errorf("unexpected base path %q", api.compute.BasePath)
+	}
+	if err := api.CheckImage(); err != nil {
+		t.Fatalf("CheckImage failed: %v", err)
+	}
+	if requested != "/emulator/test/global/images/coreos-alpha-1" {
+		t.Errorf("unexpected request for %q", requested)
+	}
+
+	for _, endpoint := range []string{"localhost:8080", "ftp://localhost/", "http://"} {
+		if _, err := New(&Options{Project: "test", Endpoint: endpoint}); err == nil {
+			t.Errorf("%q: expected an error", endpoint)
+		}
+	}
+
+	if _, err := normalizeEndpoint("https://compute.example.com/compute/v1/"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// countingTokenSource returns a new token, valid for less than the
+// oauth2 expiry margin, on every call.
+type countingTokenSource struct {
+	count int
+}
+
+func (c *countingTokenSource) Token() (*oauth2.Token, error) {
+	c.count++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", c.count),
+		Expiry:      time.Now().Add(time.Second),
+	}, nil
+}
+
+func TestNewTokenSource(t *testing.T) {
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		writeJSON(t, w, &compute.Image{Name: "coreos-alpha-1"})
+	}))
+	defer srv.Close()
+
+	ts := &countingTokenSource{}
+	api, err := New(&Options{
+		Image:       "coreos-alpha-1",
+		Project:     "test",
+		Endpoint:    srv.URL,
+		TokenSource: ts,
+		Options:     &platform.Options{},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := api.CheckImage(); err != nil {
+			t.Fatalf("CheckImage failed: %v", err)
+		}
+	}
+
+	// the expiring token is refreshed for the second request
+	expected := []string{"Bearer token-1", "Bearer token-2"}
+	if !reflect.DeepEqual(auths, expected) {
+		t.Errorf("expected %v, got %v", expected, auths)
+	}
+}
+
+func TestCreateImageGuestOsFeatures(t *testing.T) {
+	var features []string
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/test/global/images":
+			var image compute.Image
+			if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+				t.Errorf("decoding image failed: %v", err)
+			}
+			features = nil
+			for _, f := range image.GuestOsFeatures {
+				features = append(features, f.Type)
+			}
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		default:
+			t.Errorf("unexpected request path %q", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		features []string
+		expected string
+	}{
+		{nil, "VIRTIO_SCSI_MULTIQUEUE"},
+		{[]string{}, ""},
+		{[]string{"UEFI_COMPATIBLE", "GVNIC"}, "UEFI_COMPATIBLE,GVNIC"},
+	} {
+		_, _, err := api.CreateImage(&ImageSpec{
+			Name:            "image",
+			SourceImage:     "https://storage.googleapis.com/bucket/image.tar.gz",
+			GuestOsFeatures: tt.features,
+		}, false)
+		if err != nil {
+			t.Fatalf("CreateImage failed: %v", err)
+		}
+		if actual := strings.Join(features, ","); actual != tt.expected {
+			t.Errorf("expected features %q, got %q", tt.expected, actual)
+		}
+	}
+
+	_, _, err := api.CreateImage(&ImageSpec{
+		Name:            "image",
+		SourceImage:     "https://storage.googleapis.com/bucket/image.tar.gz",
+		GuestOsFeatures: []string{"BOGUS"},
+	}, false)
+	if err == nil {
+		t.Errorf("CreateImage accepted unknown guest OS feature")
+	}
+}
+
+func TestCreateImageOverwrite(t *testing.T) {
+	for _, tt := range []struct {
+		labels  map[string]string
+		key     string
+		deleted bool
+	}{
+		{nil, "", true},
+		{map[string]string{"mantle-protected": "false"}, "", true},
+		{map[string]string{"mantle-protected": "true"}, "", false},
+		{map[string]string{"mantle-protected": "true"}, "keep", true},
+		{map[string]string{"keep": "true"}, "keep", false},
+	} {
+		var deleted, inserted bool
+		api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/test/global/images/image":
+				writeJSON(t, w, map[string]interface{}{"name": "image", "labels": tt.labels})
+			case r.Method == "DELETE" && r.URL.Path == "/test/global/images/image":
+				deleted = true
+				writeJSON(t, w, &compute.Operation{Name: "delete"})
+			case r.URL.Path == "/test/global/operations/delete":
+				writeJSON(t, w, &compute.Operation{Name: "delete", Status: "DONE"})
+			case r.Method == "POST" && r.URL.Path == "/test/global/images":
+				inserted = true
+				writeJSON(t, w, &compute.Operation{Name: "insert"})
+			default:
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				http.NotFound(w, r)
+			}
+		}))
+		defer srv.Close()
+
+		_, _, err := api.CreateImage(&ImageSpec{
+			Name:            "image",
+			SourceImage:     "https://storage.googleapis.com/bucket/image.tar.gz",
+			ProtectionLabel: tt.key,
+		}, true)
+		if tt.deleted {
+			if err != nil {
+				t.Errorf("%v: CreateImage failed: %v", tt.labels, err)
+			}
+			if !deleted || !inserted {
+				t.Errorf("%v: expected the image to be replaced", tt.labels)
+			}
+		} else {
+			if err == nil || !strings.Contains(err.Error(), "protected") {
+				t.Errorf("%v: expected a protection error, got %v", tt.labels, err)
+			}
+			if deleted || inserted {
+				t.Errorf("%v: protected image was replaced", tt.labels)
+			}
+		}
+	}
+
+	// a missing image is just created
+	var inserted bool
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/test/global/images":
+			inserted = true
+			writeJSON(t, w, &compute.Operation{Name: "insert"})
+		default:
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+	_, _, err := api.CreateImage(&ImageSpec{
+		Name:        "image",
+		SourceImage: "https://storage.googleapis.com/bucket/image.tar.gz",
+	}, true)
+	if err != nil || !inserted {
+		t.Errorf("expected the image to be created, got %v", err)
+	}
+}
+
+func TestCreateImageError(t *testing.T) {
+	for _, tt := range []struct {
+		code      int
+		reason    string
+		temporary bool
+	}{
+		{http.StatusForbidden, "forbidden", false},
+		{http.StatusConflict, "alreadyExists", false},
+		{http.StatusForbidden, "quotaExceeded", true},
+		{http.StatusServiceUnavailable, "backendError", true},
+	} {
+		api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(t, w, tt.code, tt.reason)
+		}))
+		defer srv.Close()
+
+		_, _, err := api.CreateImage(&ImageSpec{
+			Name:        "image",
+			SourceImage: "https://storage.googleapis.com/bucket/image.tar.gz",
+		}, false)
+		ierr, ok := err.(*ImageError)
+		if !ok {
+			t.Errorf("expected *ImageError, got %T: %v", err, err)
+			continue
+		}
+		if ierr.Code != tt.code || ierr.Reason != tt.reason {
+			t.Errorf("expected %d %q, got %d %q", tt.code, tt.reason, ierr.Code, ierr.Reason)
+		}
+		if ierr.Temporary() != tt.temporary {
+			t.Errorf("%s: expected Temporary() %v", tt.reason, tt.temporary)
+		}
+	}
+}
+
+func TestWaitForImageReady(t *testing.T) {
+	defer func(interval time.Duration) {
+		imageReadyInterval = interval
+	}(imageReadyInterval)
+	imageReadyInterval = time.Millisecond
+
+	statuses := []string{"PENDING", "PENDING", "READY"}
+	polls := 0
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses)-1]
+		if polls < len(statuses) {
+			status = statuses[polls]
+		}
+		polls++
+		writeJSON(t, w, &compute.Image{Name: "image", Status: status})
+	}))
+	defer srv.Close()
+
+	image, err := api.WaitForImageReady(context.Background(), "image")
+	if err != nil {
+		t.Fatalf("WaitForImageReady failed: %v", err)
+	}
+	if image.Status != "READY" || polls != len(statuses) {
+		t.Errorf("expected READY after %d polls, got %q after %d", len(statuses), image.Status, polls)
+	}
+
+	statuses = []string{"PENDING"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := api.WaitForImageReady(ctx, "image"); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCopyDiskName(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	for _, dstName := range []string{"coreos-stable-1576-4-0-v20171206", long} {
+		name := copyDiskName(dstName)
+		if len(name) > 63 {
+			t.Errorf("%s: disk name %s is longer than 63 characters", dstName, name)
+		}
+		prefix := dstName
+		if len(prefix) > 49 {
+			prefix = prefix[:49]
+		}
+		if !strings.HasPrefix(name, prefix+"-copy-") {
+			t.Errorf("%s: unexpected disk name %s", dstName, name)
+		}
+	}
+	if copyDiskName("image") == copyDiskName("image") {
+		t.Error("concurrent copies to the same image share a disk name")
+	}
+}
+
+func TestDeleteImageNotFound(t *testing.T) {
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(t, w, http.StatusNotFound, "notFound")
+	}))
+	defer srv.Close()
+
+	for _, dryRun := range []bool{false, true} {
+		if _, _, err := api.DeleteImage("missing", dryRun); err != ErrImageNotFound {
+			t.Errorf("dry run %v: expected ErrImageNotFound, got %v", dryRun, err)
+		}
+	}
+}
+
+func TestCreateImageLabels(t *testing.T) {
+	var labels map[string]string
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/test/global/images" {
+			t.Errorf("unexpected request %s %q", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		var image struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+			t.Errorf("decoding image failed: %v", err)
+		}
+		if image.Name != "image" {
+			t.Errorf("expected image name image, got %q", image.Name)
+		}
+		labels = image.Labels
+		writeJSON(t, w, &compute.Operation{Name: "op"})
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		labels map[string]string
+		valid  bool
+	}{
+		{nil, true},
+		{map[string]string{"commit": "0123abc", "pipeline": "42", "expiry": ""}, true},
+		{map[string]string{"Commit": "0123abc"}, false},
+		{map[string]string{"1commit": "0123abc"}, false},
+		{map[string]string{"commit": "0123ABC"}, false},
+		{map[string]string{strings.Repeat("k", 64): "v"}, false},
+		{map[string]string{"k": strings.Repeat("v", 64)}, false},
+	} {
+		labels = nil
+		_, _, err := api.CreateImage(&ImageSpec{
+			Name:        "image",
+			SourceImage: "https://storage.googleapis.com/bucket/image.tar.gz",
+			Labels:      tt.labels,
+		}, false)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.labels)
+			} else {
+				for k := range tt.labels {
+					if !strings.Contains(err.Error(), fmt.Sprintf("%q", k)) {
+						t.Errorf("%v: error %q doesn't name the key", tt.labels, err)
+					}
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: CreateImage failed: %v", tt.labels, err)
+		} else if !reflect.DeepEqual(labels, tt.labels) {
+			t.Errorf("expected labels %v, got %v", tt.labels, labels)
+		}
+	}
+}
+
+func TestSetImageLabels(t *testing.T) {
+	var set map[string]interface{}
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/test/global/images/image":
+			writeJSON(t, w, map[string]interface{}{
+				"name":             "image",
+				"labels":           map[string]string{"old": "label"},
+				"labelFingerprint": "fingerprint",
+			})
+		case r.Method == "POST" && r.URL.Path == "/test/global/images/image/setLabels":
+			if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+				t.Errorf("decoding labels failed: %v", err)
+			}
+			writeJSON(t, w, &compute.Operation{Name: "op"})
+		default:
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	defer srv.Close()
+
+	op, pending, err := api.SetImageLabels("image", map[string]string{"commit": "0123abc"})
+	if err != nil {
+		t.Fatalf("SetImageLabels failed: %v", err)
+	}
+	if op.Name != "op" || pending == nil {
+		t.Errorf("unexpected operation %v and pending %v", op, pending)
+	}
+	expected := map[string]interface{}{
+		"labels":           map[string]interface{}{"commit": "0123abc"},
+		"labelFingerprint": "fingerprint",
+	}
+	if !reflect.DeepEqual(set, expected) {
+		t.Errorf("expected %v, got %v", expected, set)
+	}
+
+	if _, _, err := api.SetImageLabels("missing", nil); err != ErrImageNotFound {
+		t.Errorf("expected ErrImageNotFound, got %v", err)
+	}
+	if _, _, err := api.SetImageLabels("image", map[string]string{"Bad": ""}); err == nil {
+		t.Error("SetImageLabels accepted an invalid key")
+	}
+}
+
+func TestDeleteImagesByLabel(t *testing.T) {
+	var mu sync.Mutex
+	var deletes []string
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/test/global/images":
+			if expr := r.URL.Query().Get("filter"); expr != "(labels.expired eq true)" {
+				t.Errorf("unexpected filter %q", expr)
+			}
+			writeJSON(t, w, &compute.ImageList{Items: []*compute.Image{
+				{Name: "ok"}, {Name: "forbidden"}, {Name: "failed"}, {Name: "gone"},
+			}})
+		case r.Method == "DELETE":
+			name := strings.TrimPrefix(r.URL.Path, "/test/global/images/")
+			mu.Lock()
+			deletes = append(deletes, name)
+			mu.Unlock()
+			switch name {
+			case "forbidden":
+				writeError(t, w, http.StatusForbidden, "forbidden")
+			case "gone":
+				writeError(t, w, http.StatusNotFound, "notFound")
+			default:
+				writeJSON(t, w, &compute.Operation{Name: "delete-" + name})
+			}
+		case r.URL.Path == "/test/global/operations/delete-ok":
+			writeJSON(t, w, &compute.Operation{Name: "delete-ok", Status: "DONE"})
+		case r.URL.Path == "/test/global/operations/delete-failed":
+			writeJSON(t, w, &compute.Operation{
+				Name:   "delete-failed",
+				Status: "DONE",
+				Error: &compute.OperationError{
+					Errors: []*compute.OperationErrorErrors{{Code: "RESOURCE_IN_USE", Message: "in use"}},
+				},
+			})
+		default:
+			t.Errorf("unexpected request %s %q", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	deleted, err := api.DeleteImagesByLabel(context.Background(), map[string]string{"expired": "true"})
+	if !reflect.DeepEqual(deleted, []string{"ok"}) {
+		t.Errorf("expected to delete [ok], got %v", deleted)
+	}
+	merr, ok := err.(multierror.Error)
+	if !ok || len(merr) != 2 {
+		t.Errorf("expected 2 errors, got %v", err)
+	}
+	if len(deletes) != 4 {
+		t.Errorf("expected 4 deletes, got %v", deletes)
+	}
+
+	for _, selector := range []map[string]string{nil, {"Expired": "true"}} {
+		if _, err := api.DeleteImagesByLabel(context.Background(), selector); err == nil {
+			t.Errorf("%v: expected an error", selector)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// capacityErrors are the error codes GCE returns when a zone can't fit
+// an instance of the requested machine type.
+var capacityErrors = []string{
+	"ZONE_RESOURCE_POOL_EXHAUSTED",
+	"ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS",
+}
+
+// isCapacityError reports whether err indicates that the zone is out of
+// capacity for the requested machine type.
+func isCapacityError(err error) bool {
+	var codes []string
+	switch err := err.(type) {
+	case *OperationError:
+		for _, e := range err.Errors {
+			codes = append(codes, e.Code)
+		}
+	case *googleapi.Error:
+		codes = append(codes, err.Message)
+		for _, e := range err.Errors {
+			codes = append(codes, e.Message)
+		}
+	}
+	for _, code := range codes {
+		for _, capacity := range capacityErrors {
+			if strings.Contains(code, capacity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateMachineTypes checks the patterns of fallback machine types.
+func validateMachineTypes(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("GCE machine type %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// fallbackMachineTypes expands MachineTypes into the machine types to
+// try in order if the zone is out of capacity for MachineType. Patterns
+// like e2-standard-* expand to the matching types of the zone, smallest
+// first. Types which can't take the requested local SSDs are skipped.
+func (a *API) fallbackMachineTypes() ([]string, error) {
+	var zoneTypes []*compute.MachineType
+	listed := false
+	listZoneTypes := func() error {
+		if listed {
+			return nil
+		}
+		listed = true
+		return a.compute.MachineTypes.List(a.options.Project, a.options.Zone).Pages(context.Background(), func(list *compute.MachineTypeList) error {
+			zoneTypes = append(zoneTypes, list.Items...)
+			return nil
+		})
+	}
+
+	seen := map[string]bool{a.options.MachineType: true}
+	var types []string
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if err := validateLocalSSD(name, a.options.LocalSSDCount, a.options.LocalSSDInterface); err != nil {
+			plog.Debugf("Skipping machine type %s: %v", name, err)
+			return
+		}
+		types = append(types, name)
+	}
+
+	for _, pattern := range a.options.MachineTypes {
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+		if err := listZoneTypes(); err != nil {
+			return nil, fmt.Errorf("listing GCE machine types in zone %s: %v", a.options.Zone, err)
+		}
+		var matched []*compute.MachineType
+		for _, mt := range zoneTypes {
+			if ok, _ := path.Match(pattern, mt.Name); ok {
+				matched = append(matched, mt)
+			}
+		}
+		sort.Slice(matched, func(i, j int) bool {
+			if matched[i].GuestCpus != matched[j].GuestCpus {
+				return matched[i].GuestCpus < matched[j].GuestCpus
+			}
+			if matched[i].MemoryMb != matched[j].MemoryMb {
+				return matched[i].MemoryMb < matched[j].MemoryMb
+			}
+			return matched[i].Name < matched[j].Name
+		})
+		for _, mt := range matched {
+			add(mt.Name)
+		}
+	}
+	return types, nil
+}
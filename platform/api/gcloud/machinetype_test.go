@@ -0,0 +1,164 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// zoneMachineTypes is the machine type list served by
+// newMachineTypeAPI, in no particular order.
+var zoneMachineTypes = []*compute.MachineType{
+	{Name: "e2-standard-8", GuestCpus: 8, MemoryMb: 32768},
+	{Name: "e2-standard-2", GuestCpus: 2, MemoryMb: 8192},
+	{Name: "e2-highmem-2", GuestCpus: 2, MemoryMb: 16384},
+	{Name: "e2-standard-4", GuestCpus: 4, MemoryMb: 16384},
+	{Name: "n1-standard-1", GuestCpus: 1, MemoryMb: 3840},
+}
+
+// newMachineTypeAPI returns an API whose zone is out of capacity for the
+// exhausted machine types. It records the machine types of the
+// instances it was asked to create.
+func newMachineTypeAPI(t *testing.T, exhausted map[string]bool, requested *[]string) (*API, func()) {
+	instances := make(map[string]*compute.Instance)
+	api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/test/zones/us-central1-a/machineTypes":
+			writeJSON(t, w, &compute.MachineTypeList{Items: zoneMachineTypes})
+		case r.URL.Path == "/test/zones/us-central1-a/instances" && r.Method == "POST":
+			var inst compute.Instance
+			if err := json.NewDecoder(r.Body).Decode(&inst); err != nil {
+				t.Errorf("decoding instance failed: %v", err)
+			}
+			machineType := path.Base(inst.MachineType)
+			*requested = append(*requested, machineType)
+			instances[inst.Name] = &inst
+			if exhausted[machineType] {
+				writeJSON(t, w, &compute.Operation{Name: "exhausted"})
+			} else {
+				writeJSON(t, w, &compute.Operation{Name: "op"})
+			}
+		case r.URL.Path == "/test/zones/us-central1-a/operations/exhausted":
+			writeJSON(t, w, &compute.Operation{
+				Name:   "exhausted",
+				Status: "DONE",
+				Error: &compute.OperationError{
+					Errors: []*compute.OperationErrorErrors{{Code: "ZONE_RESOURCE_POOL_EXHAUSTED"}},
+				},
+			})
+		case r.URL.Path == "/test/zones/us-central1-a/operations/op":
+			writeJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		case strings.HasPrefix(r.URL.Path, "/test/zones/us-central1-a/instances/"):
+			inst, ok := instances[path.Base(r.URL.Path)]
+			if !ok {
+				writeError(t, w, http.StatusNotFound, "notFound")
+				return
+			}
+			writeJSON(t, w, inst)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			writeError(t, w, http.StatusNotFound, "notFound")
+		}
+	}))
+	api.options = testInstanceOptions()
+	return api, srv.Close
+}
+
+func TestFallbackMachineTypes(t *testing.T) {
+	var requested []string
+	api, done := newMachineTypeAPI(t, nil, &requested)
+	defer done()
+
+	for _, tt := range []struct {
+		patterns []string
+		ssds     int
+		expected []string
+	}{
+		{nil, 0, nil},
+		{[]string{"n1-standard-2"}, 0, []string{"n1-standard-2"}},
+		// the primary type and duplicates are skipped
+		{[]string{"n1-standard-1", "e2-standard-2", "e2-standard-*"}, 0, []string{"e2-standard-2", "e2-standard-4", "e2-standard-8"}},
+		{[]string{"e2-*-2"}, 0, []string{"e2-standard-2", "e2-highmem-2"}},
+		// E2 machine types can't have local SSDs
+		{[]string{"e2-standard-*", "n1-standard-2"}, 1, []string{"n1-standard-2"}},
+	} {
+		api.options.MachineTypes = tt.patterns
+		api.options.LocalSSDCount = tt.ssds
+		types, err := api.fallbackMachineTypes()
+		if err != nil {
+			t.Fatalf("%v: fallbackMachineTypes failed: %v", tt.patterns, err)
+		}
+		if !reflect.DeepEqual(types, tt.expected) {
+			t.Errorf("%v: expected %v, got %v", tt.patterns, tt.expected, types)
+		}
+	}
+
+	if err := validateMachineTypes([]string{"e2-standard-["}); err == nil {
+		t.Error("accepted a bad pattern")
+	}
+}
+
+func TestCreateInstanceFallback(t *testing.T) {
+	var requested []string
+	api, done := newMachineTypeAPI(t, map[string]bool{"n1-standard-1": true, "e2-standard-2": true}, &requested)
+	defer done()
+	api.options.MachineTypes = []string{"e2-standard-*"}
+
+	inst, err := api.CreateInstance("", nil)
+	if err != nil {
+		t.Fatalf("CreateInstance failed: %v", err)
+	}
+	if machineType := path.Base(inst.MachineType); machineType != "e2-standard-4" {
+		t.Errorf("expected machine type e2-standard-4, got %s", machineType)
+	}
+	if expected := []string{"n1-standard-1", "e2-standard-2", "e2-standard-4"}; !reflect.DeepEqual(requested, expected) {
+		t.Errorf("expected attempts %v, got %v", expected, requested)
+	}
+
+	// without fallbacks, the capacity error is returned
+	requested = nil
+	api.options.MachineTypes = nil
+	if _, err := api.CreateInstance("", nil); !isCapacityError(err) {
+		t.Errorf("expected a capacity error, got %v", err)
+	}
+	if len(requested) != 1 {
+		t.Errorf("expected one attempt, got %v", requested)
+	}
+}
+
+func TestIsCapacityError(t *testing.T) {
+	for _, tt := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&OperationError{Errors: []*compute.OperationErrorErrors{{Code: "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS"}}}, true},
+		{&OperationError{Errors: []*compute.OperationErrorErrors{{Code: "QUOTA_EXCEEDED"}}}, false},
+		{&googleapi.Error{Code: 503, Message: "The zone does not have enough resources (ZONE_RESOURCE_POOL_EXHAUSTED)"}, true},
+		{&googleapi.Error{Code: 400, Message: "Invalid value for field"}, false},
+	} {
+		if actual := isCapacityError(tt.err); actual != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.err, tt.expected, actual)
+		}
+	}
+}
@@ -16,82 +16,260 @@ package gcloud
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/coreos/pkg/multierror"
+	"golang.org/x/net/context"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/mantle/util"
 )
 
 type doable interface {
 	Do(opts ...googleapi.CallOption) (*compute.Operation, error)
 }
 
+// Clock is the source of time used by Pending, so that tests can control
+// how time passes.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel which receives the time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Pending polls a GCE operation until it completes. By default the
+// operation is first polled after 10 seconds, backing off exponentially to
+// once a minute, and Wait gives up after 5 minutes.
 type Pending struct {
-	Interval time.Duration
-	Timeout  time.Duration // for default progress function
-	Progress func(desc string, elapsed time.Duration, op *compute.Operation) error
+	Interval    time.Duration // delay before the first poll
+	MaxInterval time.Duration // longest delay between polls, 0 for Interval
+	Jitter      float64       // fraction of each delay randomly removed
+	Timeout     time.Duration // 0 to wait forever
+	Progress    func(desc string, elapsed time.Duration, op *compute.Operation) error
+	Clock       Clock // nil for the real clock
+	// Reporter is told the progress of running operations by the
+	// default Progress, nil for util.DefaultReporter.
+	Reporter util.ProgressReporter
+
+	desc    string
+	do      doable
+	running bool // seen running by the default Progress
+}
 
-	desc string
-	do   doable
+// TimeoutError is returned by Wait if the operation doesn't complete
+// within the Pending's Timeout.
+type TimeoutError struct {
+	Desc    string
+	Timeout time.Duration
+	Status  string // last known operation status
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timed out after %v waiting for operation %q (status %q)", e.Timeout, e.Desc, e.Status)
+}
+
+// OperationError is returned by Wait if the operation completes with
+// errors.
+type OperationError struct {
+	Desc   string
+	Errors []*compute.OperationErrorErrors
+}
+
+func (e *OperationError) Error() string {
+	var errs []string
+	for _, err := range e.Errors {
+		errs = append(errs, fmt.Sprintf("%s: %s", err.Code, err.Message))
+	}
+	return fmt.Sprintf("Operation %q failed: %s", e.Desc, strings.Join(errs, "; "))
 }
 
 func (a *API) NewPending(desc string, do doable) *Pending {
 	pending := &Pending{
-		Interval: 10 * time.Second,
-		Timeout:  5 * time.Minute,
-		desc:     desc,
-		do:       do,
+		Interval:    10 * time.Second,
+		MaxInterval: time.Minute,
+		Jitter:      0.2,
+		Timeout:     5 * time.Minute,
+		Clock:       realClock{},
+		desc:        desc,
+		do:          do,
+	}
+	if a.options != nil {
+		pending.Reporter = a.options.Progress
 	}
 	pending.Progress = pending.defaultProgress
 	return pending
 }
 
+// WithInterval sets the initial polling interval and returns p.
+func (p *Pending) WithInterval(interval time.Duration) *Pending {
+	p.Interval = interval
+	return p
+}
+
+// WithMaxInterval sets the longest polling interval and returns p.
+func (p *Pending) WithMaxInterval(interval time.Duration) *Pending {
+	p.MaxInterval = interval
+	return p
+}
+
+// WithTimeout sets the time after which Wait gives up and returns p. A
+// timeout of 0 waits forever.
+func (p *Pending) WithTimeout(timeout time.Duration) *Pending {
+	p.Timeout = timeout
+	return p
+}
+
+// Wait polls the operation until it is done, returning an error if it
+// failed. If the operation is not done within p.Timeout, Wait returns a
+// *TimeoutError.
 func (p *Pending) Wait() error {
+	return p.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait but gives up and returns ctx.Err() if ctx is
+// cancelled before the operation completes.
+func (p *Pending) WaitContext(ctx context.Context) error {
 	var op *compute.Operation
 	var err error
+	clock := p.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
 	failures := 0
-	start := time.Now()
-	for {
+	start := clock.Now()
+	backoff := p.backoff()
+	for attempt := 0; ; attempt++ {
 		op, err = p.do.Do()
 		if err == nil {
-			err := p.Progress(p.desc, time.Now().Sub(start), op)
+			err := p.Progress(p.desc, clock.Now().Sub(start), op)
 			if err != nil {
 				return err
 			}
 		} else {
 			failures++
-			if failures > 5 {
+			if failures > 5 || !isTransient(err) {
 				return fmt.Errorf("Fetching %q status failed: %v", p.desc, err)
 			}
 		}
 		if op != nil && op.Status == "DONE" {
 			break
 		}
-		time.Sleep(p.Interval)
+		if p.Timeout > 0 && clock.Now().Sub(start) > p.Timeout {
+			terr := &TimeoutError{
+				Desc:    p.desc,
+				Timeout: p.Timeout,
+			}
+			if op != nil {
+				terr.Status = op.Status
+			}
+			return terr
+		}
+		select {
+		case <-clock.After(backoff.Delay(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	if op.Error != nil {
 		if len(op.Error.Errors) > 0 {
-			return fmt.Errorf("Operation %q failed: %+v", p.desc, op.Error.Errors)
+			return &OperationError{Desc: p.desc, Errors: op.Error.Errors}
 		}
 		return fmt.Errorf("Operation %q failed to start", p.desc)
 	}
 	return nil
 }
 
+// WaitAll waits for the operations concurrently, returning the errors of
+// any that failed together.
+func WaitAll(pendings ...*Pending) error {
+	return WaitAllContext(context.Background(), false, pendings...)
+}
+
+// WaitAllContext is like WaitAll but gives up when ctx is cancelled. With
+// failFast, it returns the first error as soon as an operation fails and
+// stops waiting for the rest, which keep running in GCE.
+func WaitAllContext(ctx context.Context, failFast bool, pendings ...*Pending) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result, len(pendings))
+	for i, p := range pendings {
+		go func(i int, p *Pending) {
+			results <- result{i, p.WaitContext(ctx)}
+		}(i, p)
+	}
+
+	errs := make([]error, len(pendings))
+	for range pendings {
+		r := <-results
+		if r.err != nil && failFast {
+			return r.err
+		}
+		errs[r.index] = r.err
+	}
+
+	var merr multierror.Error
+	for _, err := range errs {
+		if err != nil {
+			merr = append(merr, err)
+		}
+	}
+	return merr.AsError()
+}
+
+func (p *Pending) backoff() util.Backoff {
+	max := p.MaxInterval
+	if max < p.Interval {
+		max = p.Interval
+	}
+	return util.Backoff{
+		Initial: p.Interval,
+		Max:     max,
+		Jitter:  p.Jitter,
+	}
+}
+
+// isTransient reports whether a failed API request should be retried.
+func isTransient(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return util.IsRetryableStatus(e.Code)
+	}
+	// anything else is a network error
+	return true
+}
+
 func (p *Pending) defaultProgress(desc string, elapsed time.Duration, op *compute.Operation) error {
-	var err error
+	reporter := p.Reporter
+	if reporter == nil {
+		reporter = util.DefaultReporter
+	}
 	switch op.Status {
-	case "PENDING", "RUNNING":
-		err = fmt.Errorf("Operation %q is %q", desc, op.Status)
+	case "PENDING":
+		plog.Debugf("Operation %q is %q after %v", desc, op.Status, elapsed)
+	case "RUNNING":
+		plog.Debugf("Operation %q is %q after %v", desc, op.Status, elapsed)
+		p.running = true
+		reporter.Percent(fmt.Sprintf("Operation %q", desc), int(op.Progress))
 	case "DONE":
-		return nil
+		plog.Debugf("Operation %q is %q after %v", desc, op.Status, elapsed)
+		// operations often finish without reporting their last progress,
+		// so complete that of operations which were seen running
+		if p.running {
+			reporter.Percent(fmt.Sprintf("Operation %q", desc), 100)
+		}
 	default:
-		err = fmt.Errorf("Unknown operation status %q for %q: %+v", op.Status, desc, op)
+		plog.Warningf("Unknown operation status %q for %q: %+v", op.Status, desc, op)
 	}
-
-	if p.Timeout > 0 && elapsed > p.Timeout {
-		return fmt.Errorf("Failed to wait for operation %q: %v", desc, err)
-	}
-
 	return nil
 }
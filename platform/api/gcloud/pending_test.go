@@ -0,0 +1,280 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/pkg/multierror"
+	"golang.org/x/net/context"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeClock advances instantly whenever it is waited on.
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// fakeOperation returns the given statuses in turn, repeating the last.
+type fakeOperation struct {
+	statuses []string
+	polls    int
+}
+
+func (f *fakeOperation) Do(opts ...googleapi.CallOption) (*compute.Operation, error) {
+	status := f.statuses[len(f.statuses)-1]
+	if f.polls < len(f.statuses) {
+		status = f.statuses[f.polls]
+	}
+	f.polls++
+	return &compute.Operation{Name: "op", Status: status}, nil
+}
+
+func newFakePending(statuses ...string) (*Pending, *fakeOperation, *fakeClock) {
+	op := &fakeOperation{statuses: statuses}
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	p := (&API{}).NewPending("op", op)
+	p.Jitter = 0
+	p.Clock = clock
+	return p, op, clock
+}
+
+func TestPendingRetries(t *testing.T) {
+	for _, tt := range []struct {
+		code  int
+		polls int
+		ok    bool
+	}{
+		{http.StatusTooManyRequests, 3, true},
+		{http.StatusServiceUnavailable, 3, true},
+		{http.StatusBadRequest, 1, false},
+	} {
+		polls := 0
+		api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			polls++
+			if polls < 3 {
+				writeError(t, w, tt.code, "failure")
+				return
+			}
+			writeJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		}))
+
+		pending := api.NewPending("op", api.compute.GlobalOperations.Get("test", "op"))
+		pending.Clock = &fakeClock{}
+		err := pending.Wait()
+		srv.Close()
+
+		if tt.ok != (err == nil) {
+			t.Errorf("HTTP %d: unexpected result %v", tt.code, err)
+		}
+		if polls != tt.polls {
+			t.Errorf("HTTP %d: expected %d polls, got %d", tt.code, tt.polls, polls)
+		}
+	}
+}
+
+func TestPendingBackoff(t *testing.T) {
+	p := &Pending{
+		Interval:    time.Second,
+		MaxInterval: 4 * time.Second,
+	}
+	b := p.backoff()
+	for i, expected := range []time.Duration{1, 2, 4, 4} {
+		if d := b.Delay(i); d != expected*time.Second {
+			t.Errorf("poll %d: expected %v, got %v", i, expected*time.Second, d)
+		}
+	}
+
+	// without a MaxInterval the interval is fixed
+	p.MaxInterval = 0
+	if d := p.backoff().Delay(3); d != time.Second {
+		t.Errorf("expected fixed interval, got %v", d)
+	}
+}
+
+func TestPendingInterval(t *testing.T) {
+	p, op, clock := newFakePending("PENDING", "RUNNING", "RUNNING", "DONE")
+	var elapsed []time.Duration
+	p.Progress = func(desc string, e time.Duration, op *compute.Operation) error {
+		elapsed = append(elapsed, e)
+		return nil
+	}
+	p.WithInterval(10 * time.Second).WithMaxInterval(30 * time.Second)
+
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if op.polls != 4 {
+		t.Errorf("expected 4 polls, got %d", op.polls)
+	}
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second}
+	if !reflect.DeepEqual(clock.waits, expected) {
+		t.Errorf("expected waits %v, got %v", expected, clock.waits)
+	}
+	expected = []time.Duration{0, 10 * time.Second, 30 * time.Second, time.Minute}
+	if !reflect.DeepEqual(elapsed, expected) {
+		t.Errorf("expected progress at %v, got %v", expected, elapsed)
+	}
+}
+
+func TestPendingTimeout(t *testing.T) {
+	p, op, clock := newFakePending("RUNNING")
+	p.WithInterval(time.Minute).WithTimeout(5 * time.Minute)
+
+	err := p.Wait()
+	terr, ok := err.(*TimeoutError)
+	if !ok {
+		t.Fatalf("expected *TimeoutError, got %v", err)
+	}
+	if terr.Status != "RUNNING" || terr.Timeout != 5*time.Minute {
+		t.Errorf("unexpected timeout error %+v", terr)
+	}
+	// polled at 0, 1, ..., 6 minutes
+	if op.polls != 7 {
+		t.Errorf("expected 7 polls, got %d", op.polls)
+	}
+	if elapsed := clock.now.Sub(time.Unix(0, 0)); elapsed != 6*time.Minute {
+		t.Errorf("expected to give up after 6m, gave up after %v", elapsed)
+	}
+}
+
+func TestPendingNoTimeout(t *testing.T) {
+	statuses := make([]string, 100)
+	for i := range statuses {
+		statuses[i] = "RUNNING"
+	}
+	p, op, _ := newFakePending(append(statuses, "DONE")...)
+	p.WithTimeout(0)
+
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if op.polls != 101 {
+		t.Errorf("expected 101 polls, got %d", op.polls)
+	}
+}
+
+func TestPendingCancel(t *testing.T) {
+	p, _, _ := newFakePending("RUNNING")
+	p.Clock = blockingClock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.WaitContext(ctx); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+// blockingClock never lets any time pass.
+type blockingClock struct{}
+
+func (blockingClock) Now() time.Time                         { return time.Unix(0, 0) }
+func (blockingClock) After(d time.Duration) <-chan time.Time { return nil }
+
+// failedOperation completes with an error.
+type failedOperation struct{}
+
+func (failedOperation) Do(opts ...googleapi.CallOption) (*compute.Operation, error) {
+	return &compute.Operation{
+		Name:   "op",
+		Status: "DONE",
+		Error: &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{Code: "QUOTA_EXCEEDED"}},
+		},
+	}, nil
+}
+
+func TestWaitAll(t *testing.T) {
+	ok1, op1, _ := newFakePending("RUNNING", "DONE")
+	ok2, op2, _ := newFakePending("PENDING", "RUNNING", "DONE")
+	if err := WaitAll(ok1, ok2); err != nil {
+		t.Fatalf("WaitAll failed: %v", err)
+	}
+	if op1.polls != 2 || op2.polls != 3 {
+		t.Errorf("expected 2 and 3 polls, got %d and %d", op1.polls, op2.polls)
+	}
+
+	// every failure is reported
+	ok, _, _ := newFakePending("DONE")
+	err := WaitAll((&API{}).NewPending("a", failedOperation{}), ok, (&API{}).NewPending("b", failedOperation{}))
+	merr, isMulti := err.(multierror.Error)
+	if !isMulti || len(merr) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if !strings.Contains(merr[0].Error(), `"a"`) || !strings.Contains(merr[1].Error(), `"b"`) {
+		t.Errorf("unexpected errors %v", merr)
+	}
+}
+
+func TestWaitAllFailFast(t *testing.T) {
+	// the stuck operation would block WaitAll forever
+	stuck, _, _ := newFakePending("RUNNING")
+	stuck.Clock = blockingClock{}
+	failed := (&API{}).NewPending("failed", failedOperation{})
+
+	err := WaitAllContext(context.Background(), true, stuck, failed)
+	if err == nil || !strings.Contains(err.Error(), `"failed"`) {
+		t.Errorf("expected the failed operation's error, got %v", err)
+	}
+}
+
+// percentRecorder records the percentages reported to it.
+type percentRecorder struct {
+	percents []int
+}
+
+func (r *percentRecorder) Bytes(desc string, done, total int64) {}
+func (r *percentRecorder) Percent(desc string, percent int)     { r.percents = append(r.percents, percent) }
+
+func TestPendingProgress(t *testing.T) {
+	p, _, _ := newFakePending("PENDING", "RUNNING", "DONE")
+	progress := &percentRecorder{}
+	p.Reporter = progress
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	// the fake operation reports no progress while running
+	if expected := []int{0, 100}; !reflect.DeepEqual(progress.percents, expected) {
+		t.Errorf("expected progress %v, got %v", expected, progress.percents)
+	}
+
+	// operations which were never seen running report no progress
+	p, _, _ = newFakePending("PENDING", "DONE")
+	progress = &percentRecorder{}
+	p.Reporter = progress
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if len(progress.percents) != 0 {
+		t.Errorf("expected no progress, got %v", progress.percents)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform"
+)
+
+// CheckCapacity fails with a *platform.CapacityError if the zone's region
+// doesn't have enough quota left to run the requested number of
+// instances with the current options.
+func (a *API) CheckCapacity(needed platform.ResourceRequest) error {
+	if needed.Instances <= 0 {
+		return nil
+	}
+
+	machineType, err := a.compute.MachineTypes.Get(a.options.Project, a.options.Zone, a.options.MachineType).Do()
+	if err != nil {
+		return fmt.Errorf("getting machine type %s: %v", a.options.MachineType, err)
+	}
+
+	regionName := zoneRegion(a.options.Zone)
+	region, err := a.compute.Regions.Get(a.options.Project, regionName).Do()
+	if err != nil {
+		return fmt.Errorf("getting quotas for region %s: %v", regionName, err)
+	}
+
+	quotas := make(map[string]*compute.Quota)
+	for _, quota := range region.Quotas {
+		quotas[quota.Metric] = quota
+	}
+
+	wanted := a.neededQuota(needed.Instances, machineType.GuestCpus, quotas)
+	var metrics []string
+	for metric := range wanted {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	for _, metric := range metrics {
+		quota, ok := quotas[metric]
+		if !ok {
+			continue
+		}
+		if available := quota.Limit - quota.Usage; wanted[metric] > available {
+			return &platform.CapacityError{
+				Region:    regionName,
+				Resource:  metric,
+				Needed:    wanted[metric],
+				Available: available,
+			}
+		}
+	}
+	return nil
+}
+
+// neededQuota returns the regional quota used by n instances, by metric.
+func (a *API) neededQuota(n int, cpus int64, quotas map[string]*compute.Quota) map[string]float64 {
+	count := float64(n)
+	wanted := map[string]float64{
+		"INSTANCES": count,
+	}
+
+	// preemptible instances use their own CPU quota if the project has one
+	cpuMetric := "CPUS"
+	if q, ok := quotas["PREEMPTIBLE_CPUS"]; a.options.Preemptible && ok && q.Limit > 0 {
+		cpuMetric = "PREEMPTIBLE_CPUS"
+	}
+	wanted[cpuMetric] = count * float64(cpus)
+
+	diskMetric := "DISKS_TOTAL_GB"
+	if a.options.DiskType == "pd-ssd" {
+		diskMetric = "SSD_TOTAL_GB"
+	}
+	wanted[diskMetric] = count * bootDiskSizeGB
+
+	if !a.options.NoExternalIP {
+		wanted["IN_USE_ADDRESSES"] = count
+	}
+	if a.options.LocalSSDCount > 0 {
+		wanted["LOCAL_SSD_TOTAL_GB"] = count * float64(a.options.LocalSSDCount*localSSDSizeGB)
+	}
+	return wanted
+}
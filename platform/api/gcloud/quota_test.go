@@ -0,0 +1,79 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcloud
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+
+	"github.com/coreos/mantle/platform"
+)
+
+func TestCheckCapacity(t *testing.T) {
+	quotas := []*compute.Quota{
+		{Metric: "CPUS", Limit: 24, Usage: 8},
+		{Metric: "INSTANCES", Limit: 100, Usage: 10},
+		{Metric: "IN_USE_ADDRESSES", Limit: 8, Usage: 4},
+		{Metric: "SSD_TOTAL_GB", Limit: 500, Usage: 0},
+	}
+
+	for _, tt := range []struct {
+		instances    int
+		noExternalIP bool
+		resource     string
+	}{
+		{0, false, ""},
+		{4, false, ""},
+		// 5 addresses, 4 available
+		{5, false, "IN_USE_ADDRESSES"},
+		// 18 CPUs, 16 available
+		{9, true, "CPUS"},
+	} {
+		api, srv := newTestAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/test/zones/us-central1-a/machineTypes/n1-standard-2":
+				writeJSON(t, w, &compute.MachineType{Name: "n1-standard-2", GuestCpus: 2})
+			case "/test/regions/us-central1":
+				writeJSON(t, w, &compute.Region{Name: "us-central1", Quotas: quotas})
+			default:
+				writeError(t, w, http.StatusNotFound, "not found")
+			}
+		}))
+		opts := testInstanceOptions()
+		opts.MachineType = "n1-standard-2"
+		opts.NoExternalIP = tt.noExternalIP
+		api.options = opts
+
+		err := api.CheckCapacity(platform.ResourceRequest{Instances: tt.instances})
+		srv.Close()
+
+		if tt.resource == "" {
+			if err != nil {
+				t.Errorf("%d instances: unexpected error %v", tt.instances, err)
+			}
+			continue
+		}
+		cerr, ok := err.(*platform.CapacityError)
+		if !ok {
+			t.Errorf("%d instances: expected *platform.CapacityError, got %v", tt.instances, err)
+			continue
+		}
+		if cerr.Resource != tt.resource || cerr.Region != "us-central1" {
+			t.Errorf("%d instances: unexpected error %v", tt.instances, cerr)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -88,6 +89,9 @@ type Options struct {
 	Facility string
 	// Slug of the device type (e.g. "baremetal_0")
 	Plan string
+	// Hardware reservation UUID or "next-available" to provision only
+	// on reserved hardware
+	HardwareReservationID string
 	// The Container Linux board name
 	Board string
 	// e.g. http://alpha.release.core-os.net/amd64-usr/current
@@ -464,11 +468,68 @@ initrd ${base-url}/coreos_production_pxe_image.cpio.gz
 boot`, strings.TrimRight(a.opts.InstallerImageBaseURL, "/"), userdataURL, linuxConsole[a.opts.Board])
 }
 
+// deviceCreateRequest extends packngo.DeviceCreateRequest with fields our
+// vendored packngo doesn't support.
+type deviceCreateRequest struct {
+	packngo.DeviceCreateRequest
+	HardwareReservationID string `json:"hardware_reservation_id,omitempty"`
+}
+
+// hardwareReservation is the subset of a Packet hardware reservation that
+// we care about.
+type hardwareReservation struct {
+	ID            string `json:"id"`
+	Provisionable bool   `json:"provisionable"`
+	Device        *struct {
+		ID string `json:"id"`
+	} `json:"device"`
+	Plan struct {
+		Slug string `json:"slug"`
+	} `json:"plan"`
+	Facility struct {
+		Code string `json:"code"`
+	} `json:"facility"`
+}
+
+// checkHardwareReservation verifies that the configured hardware
+// reservation can be used for a new device, so that a busy reservation
+// is reported clearly rather than failing device creation.
+func (a *API) checkHardwareReservation() error {
+	id := a.opts.HardwareReservationID
+	if id == "" || id == "next-available" {
+		return nil
+	}
+
+	req, err := a.c.NewRequest("GET", "/hardware-reservations/"+id, nil)
+	if err != nil {
+		return err
+	}
+	var reservation hardwareReservation
+	if _, err := a.c.Do(req, &reservation); err != nil {
+		return fmt.Errorf("querying hardware reservation %v: %v", id, err)
+	}
+
+	if reservation.Device != nil {
+		return fmt.Errorf("hardware reservation %v is in use by device %v", id, reservation.Device.ID)
+	}
+	if !reservation.Provisionable {
+		return fmt.Errorf("hardware reservation %v is not provisionable", id)
+	}
+	if reservation.Plan.Slug != a.opts.Plan || reservation.Facility.Code != a.opts.Facility {
+		return fmt.Errorf("hardware reservation %v is for plan %v in %v, not %v in %v", id,
+			reservation.Plan.Slug, reservation.Facility.Code, a.opts.Plan, a.opts.Facility)
+	}
+	return nil
+}
+
 // device creation seems a bit flaky, so try a few times
 func (a *API) createDevice(hostname, ipxeScriptURL string) (device *packngo.Device, err error) {
-	for tries := apiRetries; tries >= 0; tries-- {
-		var response *packngo.Response
-		device, response, err = a.c.Devices.Create(&packngo.DeviceCreateRequest{
+	if err = a.checkHardwareReservation(); err != nil {
+		return
+	}
+
+	createRequest := &deviceCreateRequest{
+		DeviceCreateRequest: packngo.DeviceCreateRequest{
 			ProjectID:     a.opts.Project,
 			Facility:      a.opts.Facility,
 			Plan:          a.opts.Plan,
@@ -477,8 +538,26 @@ func (a *API) createDevice(hostname, ipxeScriptURL string) (device *packngo.Devi
 			OS:            "custom_ipxe",
 			IPXEScriptUrl: ipxeScriptURL,
 			Tags:          []string{"mantle"},
-		})
-		if err == nil || response.StatusCode != 500 {
+		},
+		HardwareReservationID: a.opts.HardwareReservationID,
+	}
+	for tries := apiRetries; tries >= 0; tries-- {
+		var req *http.Request
+		req, err = a.c.NewRequest("POST", "/projects/"+a.opts.Project+"/devices", createRequest)
+		if err != nil {
+			return
+		}
+
+		var response *packngo.Response
+		device = new(packngo.Device)
+		response, err = a.c.Do(req, device)
+		if err == nil || response == nil || response.StatusCode != 500 {
+			if err != nil {
+				device = nil
+				if a.opts.HardwareReservationID != "" {
+					err = fmt.Errorf("couldn't provision on hardware reservation %v: %v", a.opts.HardwareReservationID, err)
+				}
+			}
 			return
 		}
 		if tries > 0 {
@@ -15,7 +15,7 @@
 package platform
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -41,40 +41,104 @@ type BaseCluster struct {
 
 	machlock   sync.Mutex
 	machmap    map[string]Machine
+	relaunch   map[string]func() (Machine, error)
 	consolemap map[string]string
 
+	// held for writing while a machine is replaced, so that Machines
+	// sees either the old or the new machine
+	replacelock sync.RWMutex
+
 	name       string
 	rconf      *RuntimeConfig
 	platform   Name
 	ctPlatform string
 	baseopts   *Options
+
+	forwardAgent bool
 }
 
 func NewBaseCluster(opts *Options, rconf *RuntimeConfig, platform Name, ctPlatform string) (*BaseCluster, error) {
-	return NewBaseClusterWithDialer(opts, rconf, platform, ctPlatform, network.NewRetryDialer())
+	var dialer network.Dialer = network.NewRetryDialer()
+	if opts.SSHJumpHost != "" {
+		sysAgent, err := network.SystemAgent()
+		if err != nil {
+			return nil, fmt.Errorf("SSH jump host requires an SSH agent: %v", err)
+		}
+		auth := []ssh.AuthMethod{ssh.PublicKeysCallback(sysAgent.Signers)}
+		hostKey, err := opts.HostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		dialer = network.NewJumpDialer(opts.SSHJumpHost, opts.SSHJumpUser, auth, hostKey)
+	}
+	return NewBaseClusterWithDialer(opts, rconf, platform, ctPlatform, dialer)
+}
+
+// HostKeyCallback returns the callback verifying the keys of hosts which
+// exist independently of kola, such as the SSH jump host, against
+// SSHKnownHosts.
+func (opts *Options) HostKeyCallback() (network.HostKeyCallback, error) {
+	if opts.SSHInsecureIgnoreHostKeys {
+		return network.InsecureIgnoreHostKey(), nil
+	}
+	file := opts.SSHKnownHosts
+	if file == "" {
+		file = network.DefaultKnownHostsFile()
+	}
+	hostKey, err := network.KnownHosts(file)
+	if err != nil {
+		return nil, fmt.Errorf("loading SSH known hosts: %v", err)
+	}
+	return hostKey, nil
 }
 
 func NewBaseClusterWithDialer(opts *Options, rconf *RuntimeConfig, platform Name, ctPlatform string, dialer network.Dialer) (*BaseCluster, error) {
-	agent, err := network.NewSSHAgent(dialer)
+	var agent *network.SSHAgent
+	var err error
+	if opts.SSHKeyFile != "" {
+		var key interface{}
+		if key, err = network.LoadSSHKey(opts.SSHKeyFile); err != nil {
+			return nil, err
+		}
+		agent, err = network.NewSSHAgentWithKey(dialer, key)
+	} else {
+		agent, err = network.NewSSHAgent(dialer)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.SSHForwardAgent {
+		sysAgent, err := network.SystemAgent()
+		if err == nil {
+			agent.Upstream = sysAgent
+		} else if err != network.ErrNoSystemAgent {
+			agent.Close()
+			return nil, err
+		}
+	}
+
 	bc := &BaseCluster{
 		agent:      agent,
 		machmap:    make(map[string]Machine),
+		relaunch:   make(map[string]func() (Machine, error)),
 		consolemap: make(map[string]string),
 		name:       fmt.Sprintf("%s-%s", opts.BaseName, uuid.NewV4()),
 		rconf:      rconf,
 		platform:   platform,
 		ctPlatform: ctPlatform,
 		baseopts:   opts,
+
+		forwardAgent: opts.SSHForwardAgent,
 	}
 
 	return bc, nil
 }
 
 func (bc *BaseCluster) SSHClient(ip string) (*ssh.Client, error) {
+	if bc.rconf.SSHUser != "" {
+		return bc.UserSSHClient(ip, bc.rconf.SSHUser)
+	}
 	sshClient, err := bc.agent.NewClient(ip)
 	if err != nil {
 		return nil, err
@@ -83,6 +147,12 @@ func (bc *BaseCluster) SSHClient(ip string) (*ssh.Client, error) {
 	return sshClient, nil
 }
 
+// AgentSocket returns the path of the SSH agent socket holding the
+// cluster's key, which remains valid until the cluster is destroyed.
+func (bc *BaseCluster) AgentSocket() string {
+	return bc.agent.Socket
+}
+
 func (bc *BaseCluster) UserSSHClient(ip, user string) (*ssh.Client, error) {
 	sshClient, err := bc.agent.NewUserClient(ip, user)
 	if err != nil {
@@ -105,29 +175,23 @@ func (bc *BaseCluster) PasswordSSHClient(ip string, user string, password string
 // stdout and stderr of the command and an error.
 // Leading and trailing whitespace is trimmed from each.
 func (bc *BaseCluster) SSH(m Machine, cmd string) ([]byte, []byte, error) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
+	return bc.SSHContext(context.Background(), m, cmd)
+}
+
+// SSHContext is like SSH, but gives up when ctx is done.
+func (bc *BaseCluster) SSHContext(ctx context.Context, m Machine, cmd string) ([]byte, []byte, error) {
 	client, err := bc.SSHClient(m.IP())
 	if err != nil {
 		return nil, nil, err
 	}
 	defer client.Close()
 
-	session, err := client.NewSession()
-	if err != nil {
-		return nil, nil, err
-	}
-	defer session.Close()
-
-	session.Stdout = &stdout
-	session.Stderr = &stderr
-	err = session.Run(cmd)
-	outBytes := bytes.TrimSpace(stdout.Bytes())
-	errBytes := bytes.TrimSpace(stderr.Bytes())
-	return outBytes, errBytes, err
+	return RunSSH(ctx, client, cmd, bc.forwardAgent)
 }
 
 func (bc *BaseCluster) Machines() []Machine {
+	bc.replacelock.RLock()
+	defer bc.replacelock.RUnlock()
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	machs := make([]Machine, 0, len(bc.machmap))
@@ -137,27 +201,71 @@ func (bc *BaseCluster) Machines() []Machine {
 	return machs
 }
 
-func (bc *BaseCluster) AddMach(m Machine) {
+// AddMach adds m to the cluster. relaunch is used by ReplaceMachine to
+// launch a new machine with the same configuration as m.
+func (bc *BaseCluster) AddMach(m Machine, relaunch func() (Machine, error)) {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	bc.machmap[m.ID()] = m
+	bc.relaunch[m.ID()] = relaunch
 }
 
 func (bc *BaseCluster) DelMach(m Machine) {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	delete(bc.machmap, m.ID())
+	delete(bc.relaunch, m.ID())
 	bc.consolemap[m.ID()] = m.ConsoleOutput()
 }
 
+// ReplaceMachine destroys m and launches a new machine with the same
+// userdata and options. Machines blocks until the replacement is done,
+// so callers never see the cluster with both or neither machine unless
+// the replacement fails to launch.
+func (bc *BaseCluster) ReplaceMachine(m Machine) (Machine, error) {
+	bc.machlock.Lock()
+	relaunch, ok := bc.relaunch[m.ID()]
+	bc.machlock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("machine %s is not in the cluster", m.ID())
+	}
+
+	bc.replacelock.Lock()
+	defer bc.replacelock.Unlock()
+
+	m.Destroy()
+	newm, err := relaunch()
+	if err != nil {
+		return nil, fmt.Errorf("replacing machine %s: %v", m.ID(), err)
+	}
+	return newm, nil
+}
+
 func (bc *BaseCluster) Keys() ([]*agent.Key, error) {
 	return bc.agent.List()
 }
 
 func (bc *BaseCluster) RenderUserData(userdata *conf.UserData, ignitionVars map[string]string) (*conf.Conf, error) {
+	if bc.rconf.NoIgnition {
+		// the guest is on its own; keys can only come from metadata
+		if userdata == nil {
+			userdata = conf.Empty()
+		}
+		if userdata.IsIgnitionCompatible() {
+			return nil, fmt.Errorf("Ignition userdata given for a machine without Ignition")
+		}
+		return userdata.Render(bc.ctPlatform)
+	}
+
 	if userdata == nil {
 		userdata = conf.Ignition(`{"ignition": {"version": "2.0.0"}}`)
 	}
+	if userdata.IsRaw() {
+		return nil, fmt.Errorf("raw userdata is only supported for machines without Ignition")
+	}
+	if err := userdata.Validate(); err != nil {
+		return nil, err
+	}
 
 	// hacky solution for unified ignition metadata variables
 	if userdata.IsIgnitionCompatible() {
@@ -171,6 +279,12 @@ func (bc *BaseCluster) RenderUserData(userdata *conf.UserData, ignitionVars map[
 		return nil, err
 	}
 
+	if bc.baseopts.IgnitionVersion != "" && conf.IsIgnition() {
+		if err := conf.SetIgnitionVersion(bc.baseopts.IgnitionVersion); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, dropin := range bc.baseopts.SystemdDropins {
 		conf.AddSystemdUnitDropin(dropin.Unit, dropin.Name, dropin.Contents)
 	}
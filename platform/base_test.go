@@ -0,0 +1,161 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeMachine is a Machine which removes itself from its cluster when
+// destroyed, like the platform implementations.
+type fakeMachine struct {
+	Machine
+	id        string
+	bc        *BaseCluster
+	destroyed bool
+}
+
+func (m *fakeMachine) ID() string            { return m.id }
+func (m *fakeMachine) ConsoleOutput() string { return "console of " + m.id }
+func (m *fakeMachine) Destroy() {
+	m.destroyed = true
+	m.bc.DelMach(m)
+}
+
+func newFakeCluster() *BaseCluster {
+	return &BaseCluster{
+		machmap:    make(map[string]Machine),
+		relaunch:   make(map[string]func() (Machine, error)),
+		consolemap: make(map[string]string),
+	}
+}
+
+// addFakeMachine adds a machine whose replacements are named after it,
+// and fail to launch if fail is set.
+func addFakeMachine(bc *BaseCluster, id string, fail *bool) *fakeMachine {
+	m := &fakeMachine{id: id, bc: bc}
+	n := 0
+	var relaunch func() (Machine, error)
+	relaunch = func() (Machine, error) {
+		if fail != nil && *fail {
+			return nil, errors.New("launch failed")
+		}
+		n++
+		newm := &fakeMachine{id: fmt.Sprintf("%s-%d", id, n), bc: bc}
+		bc.AddMach(newm, relaunch)
+		return newm, nil
+	}
+	bc.AddMach(m, relaunch)
+	return m
+}
+
+func machineIDs(bc *BaseCluster) map[string]bool {
+	ids := make(map[string]bool)
+	for _, m := range bc.Machines() {
+		ids[m.ID()] = true
+	}
+	return ids
+}
+
+func TestReplaceMachine(t *testing.T) {
+	bc := newFakeCluster()
+	old := addFakeMachine(bc, "a", nil)
+	addFakeMachine(bc, "b", nil)
+
+	newm, err := bc.ReplaceMachine(old)
+	if err != nil {
+		t.Fatalf("ReplaceMachine failed: %v", err)
+	}
+	if !old.destroyed {
+		t.Error("old machine wasn't destroyed")
+	}
+	if ids := machineIDs(bc); len(ids) != 2 || !ids["a-1"] || !ids["b"] {
+		t.Errorf("expected machines a-1 and b, got %v", ids)
+	}
+	if out := bc.ConsoleOutput()["a"]; out != "console of a" {
+		t.Errorf("console of the old machine wasn't kept: %q", out)
+	}
+
+	// the replacement can be replaced in turn
+	if _, err := bc.ReplaceMachine(newm); err != nil {
+		t.Fatalf("ReplaceMachine of the replacement failed: %v", err)
+	}
+	if ids := machineIDs(bc); len(ids) != 2 || !ids["a-2"] || !ids["b"] {
+		t.Errorf("expected machines a-2 and b, got %v", ids)
+	}
+
+	// machines no longer in the cluster can't be replaced
+	if _, err := bc.ReplaceMachine(old); err == nil {
+		t.Error("replaced a machine which isn't in the cluster")
+	}
+}
+
+func TestReplaceMachineLaunchFailure(t *testing.T) {
+	bc := newFakeCluster()
+	fail := true
+	old := addFakeMachine(bc, "a", &fail)
+	if _, err := bc.ReplaceMachine(old); err == nil {
+		t.Fatal("ReplaceMachine succeeded without a replacement")
+	}
+	if !old.destroyed {
+		t.Error("old machine wasn't destroyed")
+	}
+	if ids := machineIDs(bc); len(ids) != 0 {
+		t.Errorf("expected no machines, got %v", ids)
+	}
+}
+
+func TestReplaceMachineBlocksMachines(t *testing.T) {
+	bc := newFakeCluster()
+	m := addFakeMachine(bc, "a", nil)
+
+	// relaunch slowly, checking Machines doesn't return in between
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	relaunch := bc.relaunch["a"]
+	bc.relaunch["a"] = func() (Machine, error) {
+		close(inside)
+		<-release
+		return relaunch()
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := bc.ReplaceMachine(m)
+		done <- err
+	}()
+	<-inside
+
+	listed := make(chan map[string]bool)
+	go func() {
+		listed <- machineIDs(bc)
+	}()
+	select {
+	case ids := <-listed:
+		t.Fatalf("Machines returned %v during the replacement", ids)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("ReplaceMachine failed: %v", err)
+	}
+	if ids := <-listed; len(ids) != 1 || !ids["a-1"] {
+		t.Errorf("expected machine a-1, got %v", ids)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	v21types "github.com/coreos/ignition/config/v2_1/types"
 	v22 "github.com/coreos/ignition/config/v2_2"
 	v22types "github.com/coreos/ignition/config/v2_2/types"
+	ignreport "github.com/coreos/ignition/config/validate/report"
 	"github.com/coreos/pkg/capnslog"
 	"golang.org/x/crypto/ssh/agent"
 )
@@ -43,6 +44,7 @@ const (
 	kindIgnition
 	kindContainerLinuxConfig
 	kindScript
+	kindRaw
 )
 
 var plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/conf")
@@ -64,6 +66,7 @@ type Conf struct {
 	ignitionV22 *v22types.Config
 	cloudconfig *cci.CloudConfig
 	script      string
+	raw         string
 }
 
 func Empty() *UserData {
@@ -100,6 +103,16 @@ func Script(data string) *UserData {
 	}
 }
 
+// Raw returns userdata which is passed to the platform verbatim, for
+// machines that don't run Container Linux. SSH keys and systemd dropins
+// can't be added to it.
+func Raw(data string) *UserData {
+	return &UserData{
+		kind: kindRaw,
+		data: data,
+	}
+}
+
 func Unknown(data string) *UserData {
 	u := &UserData{
 		data: data,
@@ -151,6 +164,96 @@ func (u *UserData) IsIgnitionCompatible() bool {
 	return u.kind == kindIgnition || u.kind == kindContainerLinuxConfig
 }
 
+// IsRaw returns true if the userdata was created by Raw.
+func (u *UserData) IsRaw() bool {
+	return u.kind == kindRaw
+}
+
+// Validate checks that the userdata can be parsed, so that machines aren't
+// launched with configs that would be ignored. Errors include the line
+// number of the problem where it is known.
+func (u *UserData) Validate() error {
+	switch u.kind {
+	case kindIgnition:
+		return validateIgnition([]byte(u.data))
+	case kindContainerLinuxConfig:
+		if _, _, report := ct.Parse([]byte(u.data)); report.IsFatal() {
+			return fmt.Errorf("invalid Container Linux config: %s", report)
+		}
+	case kindCloudConfig:
+		if _, err := cci.NewCloudConfig(u.data); err != nil {
+			return fmt.Errorf("invalid cloud-config: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateIgnition parses an Ignition config with the parser for the spec
+// version it declares.
+func validateIgnition(data []byte) error {
+	var header struct {
+		IgnitionVersion *int `json:"ignitionVersion"`
+		Ignition        struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		var offset int64
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			offset = err.Offset
+		case *json.UnmarshalTypeError:
+			offset = err.Offset
+		default:
+			return fmt.Errorf("invalid Ignition config: %v", err)
+		}
+		line, col := position(data, offset)
+		return fmt.Errorf("invalid Ignition config at line %d, column %d: %v", line, col, err)
+	}
+
+	version := header.Ignition.Version
+	var report ignreport.Report
+	var err error
+	switch {
+	case header.IgnitionVersion != nil:
+		version = fmt.Sprint(*header.IgnitionVersion)
+		_, report, err = v1.Parse(data)
+	case version == "":
+		return fmt.Errorf("invalid Ignition config: no ignition.version")
+	case strings.HasPrefix(version, "2.0."):
+		_, report, err = v2.Parse(data)
+	case strings.HasPrefix(version, "2.1."):
+		_, report, err = v21.Parse(data)
+	case strings.HasPrefix(version, "2.2."):
+		_, report, err = v22.Parse(data)
+	case strings.HasPrefix(version, "3."):
+		return fmt.Errorf("Ignition spec %s configs aren't supported by Container Linux, use spec 2", version)
+	default:
+		return fmt.Errorf("unsupported Ignition config version %q", version)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid Ignition %s config: %v\n%s", version, err, report)
+	}
+	return nil
+}
+
+// position returns the 1-based line and column of offset in data.
+func position(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, col := 1, 1
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
 // Render parses userdata and returns a new Conf. It returns an error if the
 // userdata can't be parsed.
 func (u *UserData) Render(ctPlatform string) (*Conf, error) {
@@ -212,6 +315,8 @@ func (u *UserData) Render(ctPlatform string) (*Conf, error) {
 	case kindScript:
 		// pass through scripts unmodified, you are on your own.
 		c.script = u.data
+	case kindRaw:
+		c.raw = u.data
 	case kindIgnition:
 		err := renderIgnition()
 		if err != nil {
@@ -245,6 +350,66 @@ func (u *UserData) Render(ctPlatform string) (*Conf, error) {
 	return c, nil
 }
 
+// IgnitionVersions are the Ignition spec versions SetIgnitionVersion can
+// translate configs to, oldest first.
+var IgnitionVersions = []string{"2.0.0", "2.1.0", "2.2.0"}
+
+// CheckIgnitionVersion returns an error if configs can't be translated to
+// Ignition spec version.
+func CheckIgnitionVersion(version string) error {
+	for _, v := range IgnitionVersions {
+		if v == version {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported Ignition spec version %q, expected one of %s", version, strings.Join(IgnitionVersions, ", "))
+}
+
+// SetIgnitionVersion translates an Ignition config to spec version.
+// Configs can only be translated to newer specs. Container Linux configs
+// render to spec 2.1.0.
+func (c *Conf) SetIgnitionVersion(version string) error {
+	if err := CheckIgnitionVersion(version); err != nil {
+		return err
+	}
+	if !c.IsIgnition() {
+		return fmt.Errorf("can't translate a config that isn't Ignition to spec %s", version)
+	}
+
+	// index of the current spec: v1 is -1, 2.0.0 is 0, and so on
+	current := -1
+	switch {
+	case c.ignitionV2 != nil:
+		current = 0
+	case c.ignitionV21 != nil:
+		current = 1
+	case c.ignitionV22 != nil:
+		current = 2
+	}
+	target := 0
+	for IgnitionVersions[target] != version {
+		target++
+	}
+	if current > target {
+		return fmt.Errorf("can't translate Ignition %s config to older spec %s", IgnitionVersions[current], version)
+	}
+
+	for ; current < target; current++ {
+		switch current {
+		case -1:
+			ignc := v2.TranslateFromV1(*c.ignitionV1)
+			c.ignitionV1, c.ignitionV2 = nil, &ignc
+		case 0:
+			ignc := v21.TranslateFromV2_0(*c.ignitionV2)
+			c.ignitionV2, c.ignitionV21 = nil, &ignc
+		case 1:
+			ignc := v22.TranslateFromV2_1(*c.ignitionV21)
+			c.ignitionV21, c.ignitionV22 = nil, &ignc
+		}
+	}
+	return nil
+}
+
 // String returns the string representation of the userdata in Conf.
 func (c *Conf) String() string {
 	if c.ignitionV1 != nil {
@@ -263,6 +428,8 @@ func (c *Conf) String() string {
 		return c.cloudconfig.String()
 	} else if c.script != "" {
 		return c.script
+	} else if c.raw != "" {
+		return c.raw
 	}
 
 	return ""
@@ -564,5 +731,5 @@ func (c *Conf) IsIgnition() bool {
 }
 
 func (c *Conf) IsEmpty() bool {
-	return !c.IsIgnition() && c.cloudconfig == nil && c.script == ""
+	return !c.IsIgnition() && c.cloudconfig == nil && c.script == "" && c.raw == ""
 }
@@ -59,3 +59,92 @@ func TestConfCopyKey(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		userdata *UserData
+		valid    bool
+		contains string
+	}{
+		{Ignition(`{ "ignitionVersion": 1 }`), true, ""},
+		{Ignition(`{ "ignition": { "version": "2.0.0" } }`), true, ""},
+		{Ignition(`{ "ignition": { "version": "2.1.0" } }`), true, ""},
+		{Ignition(`{ "ignition": { "version": "2.2.0" } }`), true, ""},
+		{Ignition("{\n  \"ignition\": {\n    \"version\": \"2.2.0\",\n  }\n}"), false, "line 4"},
+		{Ignition(`{ "ignition": { "version": "3.0.0" } }`), false, "spec 3.0.0"},
+		{Ignition(`{ "ignition": { "version": "9.9.9" } }`), false, "unsupported"},
+		{Ignition(`{ "ignition": {} }`), false, "no ignition.version"},
+		{Ignition(`{ "ignition": { "version": "2.2.0" }, "storage": { "files": [{ "path": "relative" }] } }`), false, "2.2.0"},
+		{ContainerLinuxConfig("systemd:\n  units:\n    - name: foo.service\n"), true, ""},
+		{ContainerLinuxConfig("systemd: [\n"), false, "Container Linux config"},
+		{ContainerLinuxConfig("systemd:\n  units:\n    - name: foo.service\n      enable: maybe\n"), false, "line 4"},
+		{CloudConfig("#cloud-config\nhostname: foo\n"), true, ""},
+		{Script("#!/bin/bash\nexit 0\n"), true, ""},
+		{Raw("<powershell>\nexit 0\n</powershell>"), true, ""},
+	}
+
+	for i, tt := range tests {
+		err := tt.userdata.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		} else if !tt.valid {
+			if err == nil {
+				t.Errorf("test %d: expected an error", i)
+			} else if !strings.Contains(err.Error(), tt.contains) {
+				t.Errorf("test %d: error %q doesn't contain %q", i, err, tt.contains)
+			}
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data := "#cloud-config\nusers: [not, container, linux]\n"
+	conf, err := Raw(data).Render("")
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	conf.AddSystemdUnitDropin("foo.service", "10-foo.conf", "[Service]\n")
+	if conf.String() != data {
+		t.Errorf("raw userdata was modified: %q", conf.String())
+	}
+}
+
+func TestSetIgnitionVersion(t *testing.T) {
+	tests := []struct {
+		userdata *UserData
+		version  string
+		valid    bool
+	}{
+		{Ignition(`{ "ignitionVersion": 1 }`), "2.2.0", true},
+		{Ignition(`{ "ignition": { "version": "2.0.0" } }`), "2.0.0", true},
+		{Ignition(`{ "ignition": { "version": "2.0.0" } }`), "2.1.0", true},
+		{Ignition(`{ "ignition": { "version": "2.2.0" } }`), "2.1.0", false},
+		{Ignition(`{ "ignition": { "version": "2.2.0" } }`), "3.0.0", false},
+		{ContainerLinuxConfig("systemd:\n  units:\n    - name: foo.service\n"), "2.2.0", true},
+		{CloudConfig("#cloud-config"), "2.2.0", false},
+	}
+
+	for i, tt := range tests {
+		conf, err := tt.userdata.Render("")
+		if err != nil {
+			t.Errorf("test %d: Render failed: %v", i, err)
+			continue
+		}
+		err = conf.SetIgnitionVersion(tt.version)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("test %d: expected an error", i)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if err := Ignition(conf.String()).Validate(); err != nil {
+			t.Errorf("test %d: translated config is invalid: %v", i, err)
+		}
+		if !strings.Contains(conf.String(), `"version":"`+tt.version+`"`) {
+			t.Errorf("test %d: config isn't spec %s: %s", i, tt.version, conf.String())
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/coreos/pkg/multierror"
 
@@ -126,3 +127,62 @@ func (j *Journal) Destroy() {
 		plog.Errorf("Failed to close raw journal: %v", err)
 	}
 }
+
+// journalExportTimeout bounds how long SaveJournalExport may take.
+const journalExportTimeout = 5 * time.Minute
+
+// SaveJournalExport writes the machine's complete journal, in journalctl
+// export format, gzipped to path. At most maxBytes of uncompressed journal
+// are saved; zero means no limit.
+func SaveJournalExport(m Machine, path string, maxBytes int64) error {
+	client, err := m.SSHClient()
+	if err != nil {
+		return fmt.Errorf("SSH client failed: %v", err)
+	}
+	defer client.Close()
+	// don't hang forever on an unresponsive machine
+	timer := time.AfterFunc(journalExportTimeout, func() { client.Close() })
+	defer timer.Stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("SSH session failed: %v", err)
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("sudo journalctl --no-pager -o export"); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gz := gzWriteCloser{
+		Writer:     gzip.NewWriter(f),
+		underlying: f,
+	}
+
+	var in io.Reader = stdout
+	if maxBytes > 0 {
+		in = io.LimitReader(stdout, maxBytes)
+	}
+	n, copyErr := io.Copy(gz, in)
+	if err := gz.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if maxBytes > 0 && n >= maxBytes {
+		// journalctl is still writing; stop it rather than waiting
+		plog.Warningf("Journal of %s truncated to %d bytes", m.ID(), maxBytes)
+		return nil
+	}
+	return session.Wait()
+}
@@ -17,6 +17,7 @@ package local
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -70,7 +71,7 @@ func NewLocalCluster(opts *platform.Options, rconf *platform.RuntimeConfig, plat
 	}
 	defer nsExit()
 
-	lc.Dnsmasq, err = NewDnsmasq()
+	lc.Dnsmasq, err = NewDnsmasq(rconf.NetworkMode)
 	if err != nil {
 		lc.Destroy()
 		return nil, err
@@ -114,7 +115,11 @@ func (lc *LocalCluster) etcdEndpoint() string {
 	bridge := "br0"
 	for _, seg := range lc.Dnsmasq.Segments {
 		if bridge == seg.BridgeName {
-			return fmt.Sprintf("http://%s:%d", seg.BridgeIf.DHCPv4[0].IP, lc.SimpleEtcd.Port)
+			ip := seg.BridgeIf.DHCPv4[0].IP
+			if !lc.RuntimeConf().NetworkMode.HasIPv4() {
+				ip = seg.BridgeIf.DHCPv6[0].IP
+			}
+			return fmt.Sprintf("http://%s", net.JoinHostPort(ip.String(), strconv.Itoa(lc.SimpleEtcd.Port)))
 		}
 	}
 	panic("Not a valid bridge!")
@@ -22,6 +22,7 @@ import (
 	"github.com/coreos/pkg/capnslog"
 	"github.com/vishvananda/netlink"
 
+	"github.com/coreos/mantle/platform"
 	"github.com/coreos/mantle/system/exec"
 	"github.com/coreos/mantle/util"
 )
@@ -108,14 +109,34 @@ func newInterface(s, i byte) *Interface {
 	}
 }
 
-func newSegment(s byte) (*Segment, error) {
+// IP returns the interface's primary address: IPv4 if it has one,
+// otherwise IPv6.
+func (i *Interface) IP() net.IP {
+	if len(i.DHCPv4) > 0 {
+		return i.DHCPv4[0].IP
+	}
+	return i.DHCPv6[0].IP
+}
+
+func newSegment(s byte, mode platform.NetworkMode) (*Segment, error) {
 	seg := &Segment{
 		BridgeName: fmt.Sprintf("br%d", s),
 		BridgeIf:   newInterface(s, 1),
 	}
+	// without IPv6 on the bridge there are no router advertisements
+	if !mode.HasIPv6() {
+		seg.BridgeIf.DHCPv6 = nil
+	}
 
 	for i := byte(2); i < 2+numInterfaces; i++ {
-		seg.Interfaces = append(seg.Interfaces, newInterface(s, i))
+		in := newInterface(s, i)
+		if !mode.HasIPv4() {
+			in.DHCPv4 = nil
+		}
+		if !mode.HasIPv6() {
+			in.DHCPv6 = nil
+		}
+		seg.Interfaces = append(seg.Interfaces, in)
 	}
 
 	br := netlink.Bridge{
@@ -150,10 +171,12 @@ func newSegment(s byte) (*Segment, error) {
 	return seg, nil
 }
 
-func NewDnsmasq() (*Dnsmasq, error) {
+// NewDnsmasq sets up the network segments and serves DHCP and router
+// advertisements for the protocols enabled by mode.
+func NewDnsmasq(mode platform.NetworkMode) (*Dnsmasq, error) {
 	dm := &Dnsmasq{}
 	for s := byte(0); s < numSegments; s++ {
-		seg, err := newSegment(s)
+		seg, err := newSegment(s, mode)
 		if err != nil {
 			return nil, fmt.Errorf("Network setup failed: %v", err)
 		}
@@ -221,6 +244,26 @@ func (dm *Dnsmasq) GetInterface(bridge string) (in *Interface) {
 	panic("Not a valid bridge!")
 }
 
+// GetInterfaces returns n interfaces, one on each of the first n
+// segments. They share a host number, so the address of the interface on
+// segment s differs from the first one only in its second byte, e.g.
+// 02:00:00:00:00:05 on br0 and 02:01:00:00:00:05 on br1.
+func (dm *Dnsmasq) GetInterfaces(n int) ([]*Interface, error) {
+	if n < 1 || n > len(dm.Segments) {
+		return nil, fmt.Errorf("%d network interfaces requested, between 1 and %d are supported", n, len(dm.Segments))
+	}
+	first := dm.Segments[0]
+	if first.nextIf >= len(first.Interfaces) {
+		return nil, fmt.Errorf("not enough interfaces on %s", first.BridgeName)
+	}
+	var ifs []*Interface
+	for _, seg := range dm.Segments[:n] {
+		ifs = append(ifs, seg.Interfaces[first.nextIf])
+	}
+	first.nextIf++
+	return ifs, nil
+}
+
 func (dm *Dnsmasq) Destroy() {
 	if err := dm.dnsmasq.Kill(); err != nil {
 		plog.Errorf("Error killing dnsmasq: %v", err)
@@ -37,6 +37,9 @@ var (
 type cluster struct {
 	*platform.BaseCluster
 	api *aws.API
+
+	// whether the placement group was created by this cluster
+	createdPlacementGroup bool
 }
 
 // NewCluster creates an instance of a Cluster suitable for spawning
@@ -50,6 +53,9 @@ func NewCluster(opts *aws.Options, rconf *platform.RuntimeConfig) (platform.Clus
 	if err != nil {
 		return nil, err
 	}
+	if err := api.CheckImage(); err != nil {
+		return nil, err
+	}
 
 	bc, err := platform.NewBaseCluster(opts.Options, rconf, Platform, ctplatform.EC2)
 	if err != nil {
@@ -72,6 +78,26 @@ func NewCluster(opts *aws.Options, rconf *platform.RuntimeConfig) (platform.Clus
 		}
 	}
 
+	// a missing placement group isn't shared between parallel
+	// clusters, since the first to finish would delete it while others
+	// still use it. Each creates and deletes its own instead.
+	if opts.PlacementGroup != "" {
+		exists, err := api.PlacementGroupExists(opts.PlacementGroup)
+		if err == nil && !exists {
+			clusterOpts := *opts
+			clusterOpts.PlacementGroup = bc.Name()
+			var groupAPI *aws.API
+			if groupAPI, err = aws.New(&clusterOpts); err == nil {
+				ac.api = groupAPI
+				ac.createdPlacementGroup, err = ac.api.EnsurePlacementGroup()
+			}
+		}
+		if err != nil {
+			ac.Destroy()
+			return nil, err
+		}
+	}
+
 	return ac, nil
 }
 
@@ -90,7 +116,7 @@ func (ac *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 	}
 	instances, err := ac.api.CreateInstances(ac.Name(), keyname, conf.String(), 1)
 	if err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	mach := &machine{
@@ -120,7 +146,9 @@ func (ac *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	ac.AddMach(mach)
+	ac.AddMach(mach, func() (platform.Machine, error) {
+		return ac.NewMachine(userdata)
+	})
 
 	return mach, nil
 }
@@ -133,4 +161,10 @@ func (ac *cluster) Destroy() {
 	}
 
 	ac.BaseCluster.Destroy()
+
+	if ac.createdPlacementGroup {
+		if err := ac.api.DeletePlacementGroup(); err != nil {
+			plog.Errorf("Error deleting placement group: %v", err)
+		}
+	}
 }
@@ -15,12 +15,14 @@
 package aws
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"golang.org/x/crypto/ssh"
 
@@ -48,6 +50,37 @@ func (am *machine) PrivateIP() string {
 	return *am.mach.PrivateIpAddress
 }
 
+func (am *machine) PublicIP() string {
+	return aws.StringValue(am.mach.PublicIpAddress)
+}
+
+// PrivateIPv6 returns the same address as PublicIPv6, since EC2 IPv6
+// addresses are globally routable.
+func (am *machine) PrivateIPv6() string {
+	return am.PublicIPv6()
+}
+
+func (am *machine) PublicIPv6() string {
+	for _, iface := range am.mach.NetworkInterfaces {
+		for _, addr := range iface.Ipv6Addresses {
+			if addr.Ipv6Address != nil {
+				return *addr.Ipv6Address
+			}
+		}
+	}
+	return ""
+}
+
+// PublicDNSName implements platform.DNSMachine.
+func (am *machine) PublicDNSName() string {
+	return aws.StringValue(am.mach.PublicDnsName)
+}
+
+// PrivateDNSName implements platform.DNSMachine.
+func (am *machine) PrivateDNSName() string {
+	return aws.StringValue(am.mach.PrivateDnsName)
+}
+
 func (am *machine) RuntimeConf() platform.RuntimeConfig {
 	return am.cluster.RuntimeConf()
 }
@@ -64,16 +97,38 @@ func (am *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return am.cluster.SSH(am, cmd)
 }
 
+func (am *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return am.cluster.SSHContext(ctx, am, cmd)
+}
+
+func (am *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(am, cmd, attempts, delay)
+}
+
 func (am *machine) Reboot() error {
 	return platform.RebootMachine(am, am.journal)
 }
 
+// Preempted implements platform.PreemptibleMachine.
+func (am *machine) Preempted() (string, error) {
+	if am.mach.SpotInstanceRequestId == nil {
+		return "", nil
+	}
+	return am.cluster.api.GetSpotInterruption(am.ID())
+}
+
 func (am *machine) Destroy() {
 	origConsole, err := am.cluster.api.GetConsoleOutput(am.ID())
 	if err != nil {
 		plog.Warningf("Error retrieving console log for %v: %v", am.ID(), err)
 	}
 
+	if status, err := am.Preempted(); err != nil {
+		plog.Warningf("Error checking spot interruption of %v: %v", am.ID(), err)
+	} else if status != "" {
+		plog.Errorf("Spot instance %v was interrupted: %v", am.ID(), status)
+	}
+
 	if err := am.cluster.api.TerminateInstances([]string{am.ID()}); err != nil {
 		plog.Errorf("Error terminating instance %v: %v", am.ID(), err)
 	}
@@ -98,7 +153,14 @@ func (am *machine) saveConsole(origConsole string) error {
 	// returned output will be non-empty but won't necessarily include
 	// the most recent log messages. So we loop until the post-termination
 	// logs are different from the pre-termination logs.
-	err := util.WaitUntilReady(5*time.Minute, 5*time.Second, func() (bool, error) {
+	backoff := util.Backoff{
+		Initial:    5 * time.Second,
+		Max:        30 * time.Second,
+		Jitter:     0.2,
+		MaxElapsed: 5 * time.Minute,
+	}
+	never := func(error) bool { return false }
+	err := util.PollBackoff(backoff, never, func() (bool, error) {
 		var err error
 		am.console, err = am.cluster.api.GetConsoleOutput(am.ID())
 		if err != nil {
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/coreos/pkg/capnslog"
 
@@ -41,6 +42,9 @@ type cluster struct {
 	*platform.BaseCluster
 	api      *do.API
 	sshKeyID int
+
+	snapshotLock sync.Mutex
+	snapshots    []int
 }
 
 func NewCluster(opts *do.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
@@ -93,7 +97,7 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 
 	droplet, err := dc.api.CreateDroplet(context.TODO(), dc.vmname(), dc.sshKeyID, conf.String())
 	if err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	mach := &machine{
@@ -110,6 +114,8 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		mach.Destroy()
 		return nil, fmt.Errorf("couldn't get private IP address for droplet: %v", err)
 	}
+	// droplets only have IPv6 if it was enabled
+	mach.publicIPv6, _ = droplet.PublicIPv6()
 
 	dir := filepath.Join(dc.RuntimeConf().OutputDir, mach.ID())
 	if err := os.Mkdir(dir, 0777); err != nil {
@@ -133,7 +139,9 @@ func (dc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	dc.AddMach(mach)
+	dc.AddMach(mach, func() (platform.Machine, error) {
+		return dc.NewMachine(userdata)
+	})
 
 	return mach, nil
 }
@@ -144,10 +152,38 @@ func (dc *cluster) vmname() string {
 	return fmt.Sprintf("%s-%x", dc.Name()[0:13], b)
 }
 
+// SnapshotMachine powers off a machine and snapshots its disk, returning
+// the snapshot ID. The snapshot is deleted when the cluster is destroyed.
+func (dc *cluster) SnapshotMachine(m platform.Machine, name string) (int, error) {
+	mach, ok := m.(*machine)
+	if !ok {
+		return 0, fmt.Errorf("machine %v is not a DigitalOcean machine", m.ID())
+	}
+
+	id, err := dc.api.SnapshotDroplet(context.TODO(), mach.droplet.ID, name, true)
+	if err != nil {
+		return 0, err
+	}
+
+	dc.snapshotLock.Lock()
+	dc.snapshots = append(dc.snapshots, id)
+	dc.snapshotLock.Unlock()
+
+	return id, nil
+}
+
 func (dc *cluster) Destroy() {
 	if err := dc.api.DeleteKey(context.TODO(), dc.sshKeyID); err != nil {
 		plog.Errorf("Error deleting key %v: %v", dc.sshKeyID, err)
 	}
 
 	dc.BaseCluster.Destroy()
+
+	dc.snapshotLock.Lock()
+	defer dc.snapshotLock.Unlock()
+	for _, id := range dc.snapshots {
+		if err := dc.api.DeleteSnapshot(context.TODO(), id); err != nil {
+			plog.Errorf("Error deleting snapshot %v: %v", id, err)
+		}
+	}
 }
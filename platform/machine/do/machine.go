@@ -17,6 +17,7 @@ package do
 import (
 	"context"
 	"strconv"
+	"time"
 
 	"github.com/digitalocean/godo"
 	"golang.org/x/crypto/ssh"
@@ -25,11 +26,12 @@ import (
 )
 
 type machine struct {
-	cluster   *cluster
-	droplet   *godo.Droplet
-	journal   *platform.Journal
-	publicIP  string
-	privateIP string
+	cluster    *cluster
+	droplet    *godo.Droplet
+	journal    *platform.Journal
+	publicIP   string
+	privateIP  string
+	publicIPv6 string
 }
 
 func (dm *machine) ID() string {
@@ -44,6 +46,20 @@ func (dm *machine) PrivateIP() string {
 	return dm.privateIP
 }
 
+func (dm *machine) PublicIP() string {
+	return dm.publicIP
+}
+
+// PrivateIPv6 returns "", since DigitalOcean private networking is
+// IPv4-only.
+func (dm *machine) PrivateIPv6() string {
+	return ""
+}
+
+func (dm *machine) PublicIPv6() string {
+	return dm.publicIPv6
+}
+
 func (dm *machine) RuntimeConf() platform.RuntimeConfig {
 	return dm.cluster.RuntimeConf()
 }
@@ -60,6 +76,14 @@ func (dm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return dm.cluster.SSH(dm, cmd)
 }
 
+func (dm *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return dm.cluster.SSHContext(ctx, dm, cmd)
+}
+
+func (dm *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(dm, cmd, attempts, delay)
+}
+
 func (dm *machine) Reboot() error {
 	return platform.RebootMachine(dm, dm.journal)
 }
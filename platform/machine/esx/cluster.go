@@ -87,7 +87,7 @@ ExecStart=/usr/bin/bash -c 'echo "COREOS_ESX_IPV4_PRIVATE_0=$(ip addr show ens19
 
 	instance, err := ec.api.CreateDevice(ec.vmname(), conf)
 	if err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	mach := &machine{
@@ -117,7 +117,9 @@ ExecStart=/usr/bin/bash -c 'echo "COREOS_ESX_IPV4_PRIVATE_0=$(ip addr show ens19
 		return nil, err
 	}
 
-	ec.AddMach(mach)
+	ec.AddMach(mach, func() (platform.Machine, error) {
+		return ec.NewMachine(userdata)
+	})
 
 	return mach, nil
 }
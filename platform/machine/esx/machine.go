@@ -15,9 +15,11 @@
 package esx
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -45,6 +47,20 @@ func (em *machine) PrivateIP() string {
 	return em.mach.IPAddress
 }
 
+func (em *machine) PublicIP() string {
+	return em.mach.IPAddress
+}
+
+// PrivateIPv6 returns "", since only the guest's IPv4 address is known.
+func (em *machine) PrivateIPv6() string {
+	return ""
+}
+
+// PublicIPv6 returns "", since only the guest's IPv4 address is known.
+func (em *machine) PublicIPv6() string {
+	return ""
+}
+
 func (em *machine) RuntimeConf() platform.RuntimeConfig {
 	return em.cluster.RuntimeConf()
 }
@@ -61,6 +77,14 @@ func (em *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return em.cluster.SSH(em, cmd)
 }
 
+func (em *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return em.cluster.SSHContext(ctx, em, cmd)
+}
+
+func (em *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(em, cmd, attempts, delay)
+}
+
 func (em *machine) Reboot() error {
 	return platform.RebootMachine(em, em.journal)
 }
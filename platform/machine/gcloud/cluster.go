@@ -17,7 +17,10 @@ package gcloud
 
 import (
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	"golang.org/x/crypto/ssh/agent"
 
@@ -32,6 +35,16 @@ import (
 type cluster struct {
 	*platform.BaseCluster
 	api *gcloud.API
+
+	// zoneAPIs has an API for each zone machines are spread across,
+	// which NewMachine uses in turn.
+	zoneAPIs []*gcloud.API
+	zoneMu   sync.Mutex
+	nextZone int
+
+	// firewallRule is the rule opening the ports of
+	// RuntimeConfig.Ports, if any.
+	firewallRule string
 }
 
 const (
@@ -43,19 +56,69 @@ var (
 )
 
 func NewCluster(opts *gcloud.Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
-	api, err := gcloud.New(opts)
+	bc, err := platform.NewBaseCluster(opts.Options, rconf, Platform, ctplatform.GCE)
 	if err != nil {
 		return nil, err
 	}
 
-	bc, err := platform.NewBaseCluster(opts.Options, rconf, Platform, ctplatform.GCE)
+	if rconf.SSHUser != "" || len(rconf.Ports) > 0 {
+		o := *opts
+		if rconf.SSHUser != "" {
+			o.SSHUser = rconf.SSHUser
+		}
+		// the cluster's firewall rule targets instances tagged
+		// with its name
+		if len(rconf.Ports) > 0 {
+			o.Tags = append(append([]string(nil), o.Tags...), bc.Name())
+		}
+		opts = &o
+	}
+	api, err := gcloud.New(opts)
 	if err != nil {
+		bc.Destroy()
+		return nil, err
+	}
+	if err := api.CheckImage(); err != nil {
+		bc.Destroy()
 		return nil, err
 	}
 
+	zoneAPIs := []*gcloud.API{api}
+	if len(opts.Zones) > 0 {
+		if err := api.CheckZones(); err != nil {
+			bc.Destroy()
+			return nil, err
+		}
+		zoneAPIs = nil
+		for _, zone := range opts.Zones {
+			zoneAPIs = append(zoneAPIs, api.InZone(zone))
+		}
+	}
+
 	gc := &cluster{
 		BaseCluster: bc,
 		api:         api,
+		zoneAPIs:    zoneAPIs,
+	}
+
+	if len(rconf.Ports) > 0 {
+		var ports []string
+		for _, p := range rconf.Ports {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		// machines reach each other over the network's ranges; the
+		// test controller's address must be given, since it isn't known
+		sources, err := api.InternalRanges()
+		if err != nil {
+			bc.Destroy()
+			return nil, err
+		}
+		sources = append(sources, opts.FirewallSourceRanges...)
+		if err := api.EnsureFirewallRule(bc.Name(), ports, sources); err != nil {
+			bc.Destroy()
+			return nil, err
+		}
+		gc.firewallRule = bc.Name()
 	}
 
 	return gc, nil
@@ -79,18 +142,26 @@ func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		}
 	}
 
-	instance, err := gc.api.CreateInstance(conf.String(), keys)
+	api := gc.zoneAPI()
+	instance, err := api.CreateInstance(conf.String(), keys, gc.RuntimeConf().ExtraDisks...)
 	if err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	intip, extip := gcloud.InstanceIPs(instance)
 
 	gm := &machine{
 		gc:    gc,
+		api:   api,
 		name:  instance.Name,
 		intIP: intip,
 		extIP: extip,
+
+		machineType: path.Base(instance.MachineType),
+		preemptible: instance.Scheduling != nil && instance.Scheduling.Preemptible,
+	}
+	for i := range gc.RuntimeConf().ExtraDisks {
+		gm.extraDisks = append(gm.extraDisks, gcloud.ExtraDiskPath(i))
 	}
 
 	gm.dir = filepath.Join(gc.RuntimeConf().OutputDir, gm.ID())
@@ -115,7 +186,29 @@ func (gc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	gc.AddMach(gm)
+	gc.AddMach(gm, func() (platform.Machine, error) {
+		return gc.NewMachine(userdata)
+	})
 
 	return gm, nil
 }
+
+func (gc *cluster) Destroy() {
+	gc.BaseCluster.Destroy()
+
+	if gc.firewallRule != "" {
+		if err := gc.api.DeleteFirewallRule(gc.firewallRule); err != nil {
+			plog.Errorf("Error deleting firewall rule %v: %v", gc.firewallRule, err)
+		}
+	}
+}
+
+// zoneAPI returns the API for the zone the next machine should be
+// created in, cycling through the cluster's zones.
+func (gc *cluster) zoneAPI() *gcloud.API {
+	gc.zoneMu.Lock()
+	defer gc.zoneMu.Unlock()
+	api := gc.zoneAPIs[gc.nextZone%len(gc.zoneAPIs)]
+	gc.nextZone++
+	return api
+}
@@ -15,29 +15,53 @@
 package gcloud
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/api/gcloud"
 )
 
 type machine struct {
 	gc      *cluster
+	api     *gcloud.API
 	name    string
 	intIP   string
 	extIP   string
 	dir     string
 	journal *platform.Journal
 	console string
+
+	machineType string
+	preemptible bool
+	extraDisks  []string
 }
 
 func (gm *machine) ID() string {
 	return gm.name
 }
 
+// Zone returns the GCE zone the machine is running in.
+func (gm *machine) Zone() string {
+	return gm.api.Zone()
+}
+
+// MachineType returns the GCE machine type of the instance, which is a
+// fallback type if the zone was out of capacity for the configured one.
+func (gm *machine) MachineType() string {
+	return gm.machineType
+}
+
 func (gm *machine) IP() string {
+	// instances without an external IP are reached via a jump host
+	if gm.extIP == "" {
+		return gm.intIP
+	}
 	return gm.extIP
 }
 
@@ -45,6 +69,31 @@ func (gm *machine) PrivateIP() string {
 	return gm.intIP
 }
 
+func (gm *machine) PublicIP() string {
+	return gm.extIP
+}
+
+// PrivateIPv6 returns "", since the GCE API used doesn't support IPv6.
+func (gm *machine) PrivateIPv6() string {
+	return ""
+}
+
+// PublicIPv6 returns "", since the GCE API used doesn't support IPv6.
+func (gm *machine) PublicIPv6() string {
+	return ""
+}
+
+// PublicDNSName implements platform.DNSMachine. GCE doesn't assign
+// public DNS names.
+func (gm *machine) PublicDNSName() string {
+	return ""
+}
+
+// PrivateDNSName implements platform.DNSMachine.
+func (gm *machine) PrivateDNSName() string {
+	return gm.api.InternalDNSName(gm.name)
+}
+
 func (gm *machine) RuntimeConf() platform.RuntimeConfig {
 	return gm.gc.RuntimeConf()
 }
@@ -61,16 +110,76 @@ func (gm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return gm.gc.SSH(gm, cmd)
 }
 
+func (gm *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return gm.gc.SSHContext(ctx, gm, cmd)
+}
+
+func (gm *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(gm, cmd, attempts, delay)
+}
+
 func (gm *machine) Reboot() error {
 	return platform.RebootMachine(gm, gm.journal)
 }
 
+// ExtraDiskPaths implements platform.DiskMachine.
+func (gm *machine) ExtraDiskPaths() []string {
+	return gm.extraDisks
+}
+
+// Preempted implements platform.PreemptibleMachine.
+func (gm *machine) Preempted() (string, error) {
+	if !gm.preemptible {
+		return "", nil
+	}
+	preempted, err := gm.api.InstancePreempted(gm.name)
+	if err != nil || !preempted {
+		return "", err
+	}
+	return "preempted by GCE", nil
+}
+
+// Snapshot implements platform.SnapshotMachine.
+func (gm *machine) Snapshot(description string) (string, error) {
+	return gm.api.SnapshotInstance(gm.name, description)
+}
+
+// maintenanceDescriptions describe the GCE host maintenance operations.
+var maintenanceDescriptions = map[string]string{
+	"compute.instances.migrateOnHostMaintenance":   "live-migrated for host maintenance",
+	"compute.instances.terminateOnHostMaintenance": "terminated for host maintenance",
+	"compute.instances.hostError":                  "restarted after a host error",
+}
+
+// MaintenanceEvents implements platform.MaintenanceMachine.
+func (gm *machine) MaintenanceEvents() ([]string, error) {
+	ops, err := gm.api.InstanceMaintenanceEvents(gm.name)
+	if err != nil {
+		return nil, err
+	}
+	var events []string
+	for _, op := range ops {
+		desc, ok := maintenanceDescriptions[op.OperationType]
+		if !ok {
+			desc = op.OperationType
+		}
+		events = append(events, fmt.Sprintf("%s at %s", desc, op.InsertTime))
+	}
+	return events, nil
+}
+
 func (gm *machine) Destroy() {
 	if err := gm.saveConsole(); err != nil {
 		plog.Errorf("Error saving console for instance %v: %v", gm.ID(), err)
 	}
 
-	if err := gm.gc.api.TerminateInstance(gm.name); err != nil {
+	if reason, err := gm.Preempted(); err != nil {
+		plog.Warningf("Error checking preemption of %v: %v", gm.ID(), err)
+	} else if reason != "" {
+		plog.Errorf("Instance %v was %s", gm.ID(), reason)
+	}
+
+	if err := gm.api.TerminateInstance(gm.name); err != nil {
 		plog.Errorf("Error terminating instance %v: %v", gm.ID(), err)
 	}
 
@@ -87,7 +196,7 @@ func (gm *machine) ConsoleOutput() string {
 
 func (gm *machine) saveConsole() error {
 	var err error
-	gm.console, err = gm.gc.api.GetConsoleOutput(gm.name)
+	gm.console, err = gm.api.GetConsoleOutput(gm.name, 1)
 	if err != nil {
 		return err
 	}
@@ -106,7 +106,7 @@ func (pc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 	// CreateDevice unconditionally closes console when done with it
 	device, err := pc.api.CreateDevice(vmname, conf, pcons)
 	if err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	mach := &machine{
@@ -116,6 +116,7 @@ func (pc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 	}
 	mach.publicIP = pc.api.GetDeviceAddress(device, 4, true)
 	mach.privateIP = pc.api.GetDeviceAddress(device, 4, false)
+	mach.publicIPv6 = pc.api.GetDeviceAddress(device, 6, true)
 	if mach.publicIP == "" || mach.privateIP == "" {
 		mach.Destroy()
 		return nil, fmt.Errorf("couldn't find IP addresses for device")
@@ -150,7 +151,9 @@ func (pc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 		return nil, err
 	}
 
-	pc.AddMach(mach)
+	pc.AddMach(mach, func() (platform.Machine, error) {
+		return pc.NewMachine(userdata)
+	})
 
 	return mach, nil
 }
@@ -15,7 +15,9 @@
 package packet
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -24,12 +26,13 @@ import (
 )
 
 type machine struct {
-	cluster   *cluster
-	device    *packngo.Device
-	journal   *platform.Journal
-	console   *console
-	publicIP  string
-	privateIP string
+	cluster    *cluster
+	device     *packngo.Device
+	journal    *platform.Journal
+	console    *console
+	publicIP   string
+	privateIP  string
+	publicIPv6 string
 }
 
 func (pm *machine) ID() string {
@@ -44,6 +47,19 @@ func (pm *machine) PrivateIP() string {
 	return pm.privateIP
 }
 
+func (pm *machine) PublicIP() string {
+	return pm.publicIP
+}
+
+// PrivateIPv6 returns "", since Packet private networking is IPv4-only.
+func (pm *machine) PrivateIPv6() string {
+	return ""
+}
+
+func (pm *machine) PublicIPv6() string {
+	return pm.publicIPv6
+}
+
 func (pm *machine) RuntimeConf() platform.RuntimeConfig {
 	return pm.cluster.RuntimeConf()
 }
@@ -60,6 +76,14 @@ func (pm *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return pm.cluster.SSH(pm, cmd)
 }
 
+func (pm *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return pm.cluster.SSHContext(ctx, pm, cmd)
+}
+
+func (pm *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(pm, cmd, attempts, delay)
+}
+
 func (pm *machine) Reboot() error {
 	return platform.RebootMachine(pm, pm.journal)
 }
@@ -15,11 +15,14 @@
 package qemu
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -41,6 +44,8 @@ const (
 // Options contains QEMU-specific options for the cluster.
 type Options struct {
 	// DiskImage is the full path to the disk image to boot in QEMU.
+	// It may be a raw or qcow2 image; it is never modified, since each
+	// machine boots from its own qcow2 overlay backed by it.
 	DiskImage string
 	Board     string
 
@@ -48,6 +53,20 @@ type Options struct {
 	// It can be a plain name, or a full path.
 	BIOSImage string
 
+	// CPUs and Memory (in MiB) are the default machine size. Zero means
+	// one vCPU and 1024 MiB of memory (2048 MiB for arm64 guests).
+	CPUs   int
+	Memory int
+
+	// NestedVirt exposes hardware virtualization to the guest. The host
+	// KVM module must have nested virtualization enabled.
+	NestedVirt bool
+
+	// KeepOverlay saves each machine's overlay as disk.qcow2 in the
+	// machine's output directory instead of discarding it, so the disk
+	// can be inspected after teardown.
+	KeepOverlay bool
+
 	*platform.Options
 }
 
@@ -58,12 +77,25 @@ type Options struct {
 type Cluster struct {
 	opts *Options
 
+	// CPU feature to enable for nested virtualization, if any
+	nestedFlag string
+
 	mu sync.Mutex
 	*local.LocalCluster
 }
 
 type MachineOptions struct {
+	// CPUs and Memory (in MiB) override the size requested by the test
+	// or the cluster options if nonzero.
+	CPUs            int
+	Memory          int
 	AdditionalDisks []Disk
+
+	// NetworkInterfaces is the number of NICs to attach, each on its
+	// own bridge. Zero means one. MAC addresses are derived from the
+	// first NIC's by setting the second byte to the NIC's index, so
+	// Ignition configs can match secondary NICs predictably.
+	NetworkInterfaces int
 }
 
 type Disk struct {
@@ -73,11 +105,25 @@ type Disk struct {
 
 var (
 	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "kola/platform/machine/qemu")
+
+	// sysfs directory containing the KVM module parameters
+	sysModuleDir = "/sys/module"
 )
 
 // NewCluster creates a Cluster instance, suitable for running virtual
 // machines in QEMU.
 func NewCluster(opts *Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	var nestedFlag string
+	if opts.NestedVirt {
+		if combo := runtime.GOARCH + "--" + opts.Board; combo != "amd64--amd64-usr" {
+			return nil, fmt.Errorf("nested virtualization not supported for host-guest combo %s", combo)
+		}
+		var err error
+		if nestedFlag, err = checkNestedVirt(); err != nil {
+			return nil, err
+		}
+	}
+
 	lc, err := local.NewLocalCluster(opts.Options, rconf, Platform)
 	if err != nil {
 		return nil, err
@@ -85,6 +131,7 @@ func NewCluster(opts *Options, rconf *platform.RuntimeConfig) (platform.Cluster,
 
 	qc := &Cluster{
 		opts:         opts,
+		nestedFlag:   nestedFlag,
 		LocalCluster: lc,
 	}
 
@@ -96,6 +143,11 @@ func (qc *Cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error)
 }
 
 func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options MachineOptions) (platform.Machine, error) {
+	cpus, memory, err := qc.machineSize(options)
+	if err != nil {
+		return nil, err
+	}
+
 	id := uuid.NewV4()
 
 	dir := filepath.Join(qc.RuntimeConf().OutputDir, id.String())
@@ -105,9 +157,18 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 
 	// hacky solution for cloud config ip substitution
 	// NOTE: escaping is not supported
+	nics := options.NetworkInterfaces
+	if nics == 0 {
+		nics = 1
+	}
+
 	qc.mu.Lock()
-	netif := qc.Dnsmasq.GetInterface("br0")
-	ip := strings.Split(netif.DHCPv4[0].String(), "/")[0]
+	netifs, err := qc.Dnsmasq.GetInterfaces(nics)
+	if err != nil {
+		qc.mu.Unlock()
+		return nil, err
+	}
+	ip := netifs[0].IP().String()
 
 	conf, err := qc.RenderUserData(userdata, map[string]string{
 		"$public_ipv4":  ip,
@@ -140,7 +201,7 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	qm := &machine{
 		qc:          qc,
 		id:          id.String(),
-		netif:       netif,
+		netifs:      netifs,
 		journal:     journal,
 		consolePath: filepath.Join(dir, "console.txt"),
 	}
@@ -149,41 +210,41 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	combo := runtime.GOARCH + "--" + qc.opts.Board
 	switch combo {
 	case "amd64--amd64-usr":
+		cpu := "host"
+		if qc.nestedFlag != "" {
+			cpu += "," + qc.nestedFlag
+		}
 		qmCmd = []string{
 			"qemu-system-x86_64",
 			"-machine", "accel=kvm",
-			"-cpu", "host",
-			"-m", "1024",
+			"-cpu", cpu,
 		}
 	case "amd64--arm64-usr":
 		qmCmd = []string{
 			"qemu-system-aarch64",
 			"-machine", "virt",
 			"-cpu", "cortex-a57",
-			"-m", "2048",
 		}
 	case "arm64--amd64-usr":
 		qmCmd = []string{
 			"qemu-system-x86_64",
 			"-machine", "pc-q35-2.8",
 			"-cpu", "kvm64",
-			"-m", "1024",
 		}
 	case "arm64--arm64-usr":
 		qmCmd = []string{
 			"qemu-system-aarch64",
 			"-machine", "virt,accel=kvm,gic-version=3",
 			"-cpu", "host",
-			"-m", "2048",
 		}
 	default:
 		panic("host-guest combo not supported: " + combo)
 	}
 
-	qmMac := qm.netif.HardwareAddr.String()
 	qmCmd = append(qmCmd,
 		"-bios", qc.opts.BIOSImage,
-		"-smp", "1",
+		"-m", strconv.Itoa(memory),
+		"-smp", strconv.Itoa(cpus),
 		"-uuid", qm.id,
 		"-display", "none",
 		"-chardev", "file,id=log,path="+qm.consolePath,
@@ -213,7 +274,11 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 		extraFiles = append(extraFiles, file)
 	}
 
-	diskFile, err := setupPrimaryDisk(qc.opts.DiskImage)
+	var overlayPath string
+	if qc.opts.KeepOverlay {
+		overlayPath = filepath.Join(dir, "disk.qcow2")
+	}
+	diskFile, err := setupPrimaryDisk(qc.opts.DiskImage, overlayPath)
 	if err != nil {
 		return nil, err
 	}
@@ -229,18 +294,32 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 		addDisk(optionsDiskFile, disk.Serial)
 	}
 
+	for i, spec := range qc.RuntimeConf().ExtraDisks {
+		serial := fmt.Sprintf("kola-disk-%d", i)
+		extraDiskFile, err := setupDisk(fmt.Sprintf("%dG", spec.SizeGB))
+		if err != nil {
+			return nil, err
+		}
+		defer extraDiskFile.Close()
+		addDisk(extraDiskFile, serial)
+		qm.extraDisks = append(qm.extraDisks, "/dev/disk/by-id/virtio-"+serial)
+	}
+
 	qc.mu.Lock()
 
-	tap, err := qc.NewTap("br0")
-	if err != nil {
-		qc.mu.Unlock()
-		return nil, err
+	for i, netif := range netifs {
+		tap, err := qc.NewTap(qc.Dnsmasq.Segments[i].BridgeName)
+		if err != nil {
+			qc.mu.Unlock()
+			return nil, err
+		}
+		defer tap.Close()
+		id := fmt.Sprintf("tap%d", i)
+		qmCmd = append(qmCmd, "-netdev", fmt.Sprintf("tap,id=%s,fd=%d", id, fdnum),
+			"-device", qc.virtio("net", fmt.Sprintf("netdev=%s,mac=%s", id, netif.HardwareAddr)))
+		fdnum += 1
+		extraFiles = append(extraFiles, tap.File)
 	}
-	defer tap.Close()
-	qmCmd = append(qmCmd, "-netdev", fmt.Sprintf("tap,id=tap,fd=%d", fdnum),
-		"-device", qc.virtio("net", "netdev=tap,mac="+qmMac))
-	fdnum += 1
-	extraFiles = append(extraFiles, tap.File)
 
 	plog.Debugf("NewMachine: (%s) %q", combo, qmCmd)
 
@@ -254,7 +333,7 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 	cmd.ExtraFiles = append(cmd.ExtraFiles, extraFiles...)
 
 	if err = qm.qemu.Start(); err != nil {
-		return nil, err
+		return nil, platform.NewInfraError(err)
 	}
 
 	if err := platform.StartMachine(qm, qm.journal); err != nil {
@@ -262,11 +341,92 @@ func (qc *Cluster) NewMachineWithOptions(userdata *conf.UserData, options Machin
 		return nil, err
 	}
 
-	qc.AddMach(qm)
+	qc.AddMach(qm, func() (platform.Machine, error) {
+		return qc.NewMachineWithOptions(userdata, options)
+	})
 
 	return qm, nil
 }
 
+// machineSize picks the vCPU count and memory size for a new machine and
+// checks them against the host's resources.
+func (qc *Cluster) machineSize(options MachineOptions) (int, int, error) {
+	rconf := qc.RuntimeConf()
+	pick := func(vals ...int) int {
+		for _, v := range vals {
+			if v != 0 {
+				return v
+			}
+		}
+		return 0
+	}
+
+	defaultMemory := 1024
+	if qc.opts.Board == "arm64-usr" {
+		defaultMemory = 2048
+	}
+	cpus := pick(options.CPUs, rconf.CPUs, qc.opts.CPUs, 1)
+	memory := pick(options.Memory, rconf.Memory, qc.opts.Memory, defaultMemory)
+
+	if cpus < 0 || memory < 0 {
+		return 0, 0, fmt.Errorf("invalid machine size: %d vCPUs, %d MiB", cpus, memory)
+	}
+	if cpus > runtime.NumCPU() {
+		return 0, 0, fmt.Errorf("machine needs %d vCPUs but host has %d", cpus, runtime.NumCPU())
+	}
+	hostMemory, err := hostMemoryMiB()
+	if err != nil {
+		return 0, 0, fmt.Errorf("checking host memory: %v", err)
+	}
+	if memory > hostMemory {
+		return 0, 0, fmt.Errorf("machine needs %d MiB of memory but host has %d MiB", memory, hostMemory)
+	}
+
+	return cpus, memory, nil
+}
+
+// hostMemoryMiB returns the host's total memory.
+func hostMemoryMiB() (int, error) {
+	buf, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, err
+			}
+			return kb / 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// checkNestedVirt verifies that the loaded KVM module allows nested
+// virtualization and returns the CPU feature flag to pass to the guest.
+func checkNestedVirt() (string, error) {
+	for _, mod := range []struct{ name, flag string }{
+		{"kvm_intel", "+vmx"},
+		{"kvm_amd", "+svm"},
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(sysModuleDir, mod.name, "parameters", "nested"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		switch strings.TrimSpace(string(buf)) {
+		case "Y", "1":
+			return mod.flag, nil
+		default:
+			return "", fmt.Errorf("nested virtualization is disabled; set the %s module parameter nested=1", mod.name)
+		}
+	}
+	return "", fmt.Errorf("nested virtualization requires the kvm_intel or kvm_amd module")
+}
+
 // The virtio device name differs between machine types but otherwise
 // configuration is the same. Use this to help construct device args.
 func (qc *Cluster) virtio(device, args string) string {
@@ -282,8 +442,10 @@ func (qc *Cluster) virtio(device, args string) string {
 	return fmt.Sprintf("virtio-%s-%s,%s", device, suffix, args)
 }
 
-// Create a nameless temporary qcow2 image file backed by a raw image.
-func setupPrimaryDisk(imageFile string) (*os.File, error) {
+// Create a qcow2 overlay backed by a raw or qcow2 image. The overlay is
+// created at overlayPath if set, and is otherwise a nameless temporary
+// file.
+func setupPrimaryDisk(imageFile, overlayPath string) (*os.File, error) {
 	// a relative path would be interpreted relative to /tmp
 	backingFile, err := filepath.Abs(imageFile)
 	if err != nil {
@@ -295,10 +457,40 @@ func setupPrimaryDisk(imageFile string) (*os.File, error) {
 		return nil, err
 	}
 
-	qcowOpts := fmt.Sprintf("backing_file=%s,backing_fmt=raw,lazy_refcounts=on", backingFile)
+	backingFmt, err := imageFormat(backingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	qcowOpts := fmt.Sprintf("backing_file=%s,backing_fmt=%s,lazy_refcounts=on", backingFile, backingFmt)
+	if overlayPath != "" {
+		return createDisk(overlayPath, "-o", qcowOpts)
+	}
 	return setupDisk("-o", qcowOpts)
 }
 
+// imageFormat returns the qemu format name of a disk image, recognizing
+// qcow2 by its magic number and assuming anything else is raw.
+func imageFormat(imageFile string) (string, error) {
+	f, err := os.Open(imageFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return "raw", nil
+	} else if err != nil {
+		return "", err
+	}
+	if bytes.Equal(magic, []byte("QFI\xfb")) {
+		return "qcow2", nil
+	}
+	return "raw", nil
+}
+
+// Create a nameless temporary qcow2 image file.
 func setupDisk(additionalOptions ...string) (*os.File, error) {
 	dstFile, err := ioutil.TempFile("", "mantle-qemu")
 	if err != nil {
@@ -308,7 +500,12 @@ func setupDisk(additionalOptions ...string) (*os.File, error) {
 	defer os.Remove(dstFileName)
 	dstFile.Close()
 
-	opts := []string{"create", "-f", "qcow2", dstFileName}
+	return createDisk(dstFileName, additionalOptions...)
+}
+
+// Create a qcow2 image file at path and open it.
+func createDisk(path string, additionalOptions ...string) (*os.File, error) {
+	opts := []string{"create", "-f", "qcow2", path}
 	opts = append(opts, additionalOptions...)
 
 	qemuImg := exec.Command("qemu-img", opts...)
@@ -318,5 +515,5 @@ func setupDisk(additionalOptions ...string) (*os.File, error) {
 		return nil, err
 	}
 
-	return os.OpenFile(dstFileName, os.O_RDWR, 0)
+	return os.OpenFile(path, os.O_RDWR, 0)
 }
@@ -0,0 +1,113 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckNestedVirt(t *testing.T) {
+	defer func(dir string) { sysModuleDir = dir }(sysModuleDir)
+
+	for _, tt := range []struct {
+		module string
+		value  string
+		flag   string
+		ok     bool
+	}{
+		{"kvm_intel", "Y\n", "+vmx", true},
+		{"kvm_intel", "N\n", "", false},
+		{"kvm_amd", "1\n", "+svm", true},
+		{"kvm_amd", "0\n", "", false},
+		{"", "", "", false},
+	} {
+		dir, err := ioutil.TempDir("", "mantle-qemu-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		sysModuleDir = dir
+
+		if tt.module != "" {
+			params := filepath.Join(dir, tt.module, "parameters")
+			if err := os.MkdirAll(params, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(params, "nested"), []byte(tt.value), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		flag, err := checkNestedVirt()
+		if tt.ok && err != nil {
+			t.Errorf("%s=%q: unexpected error: %v", tt.module, tt.value, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s=%q: expected error", tt.module, tt.value)
+		}
+		if flag != tt.flag {
+			t.Errorf("%s=%q: got flag %q, expected %q", tt.module, tt.value, flag, tt.flag)
+		}
+	}
+}
+
+func TestCheckNestedVirtHost(t *testing.T) {
+	if _, err := os.Stat("/dev/kvm"); err != nil {
+		t.Skipf("KVM not available: %v", err)
+	}
+
+	flag, err := checkNestedVirt()
+	if err != nil {
+		t.Skipf("nested virtualization not available: %v", err)
+	}
+	if flag != "+vmx" && flag != "+svm" {
+		t.Errorf("unexpected CPU flag %q", flag)
+	}
+}
+
+func TestImageFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mantle-qemu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		contents string
+		format   string
+	}{
+		{"QFI\xfb\x00\x00\x00\x03", "qcow2"},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00", "raw"},
+		{"QF", "raw"},
+		{"", "raw"},
+	} {
+		path := filepath.Join(dir, "image")
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		format, err := imageFormat(path)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.contents, err)
+		} else if format != tt.format {
+			t.Errorf("%q: got %q, expected %q", tt.contents, format, tt.format)
+		}
+	}
+
+	if _, err := imageFormat(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing image: expected an error")
+	}
+}
@@ -15,7 +15,11 @@
 package qemu
 
 import (
+	"context"
 	"io/ioutil"
+	"net"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
@@ -28,22 +32,75 @@ type machine struct {
 	qc          *Cluster
 	id          string
 	qemu        exec.Cmd
-	netif       *local.Interface
+	netifs      []*local.Interface
 	journal     *platform.Journal
 	consolePath string
-	console     string
+	extraDisks  []string
+
+	// mu guards console and destroyed, since ConsoleOutput may be
+	// called while the machine is being destroyed
+	mu        sync.Mutex
+	console   string
+	destroyed bool
 }
 
 func (m *machine) ID() string {
 	return m.id
 }
 
+// ExtraDiskPaths implements platform.DiskMachine.
+func (m *machine) ExtraDiskPaths() []string {
+	return m.extraDisks
+}
+
 func (m *machine) IP() string {
-	return m.netif.DHCPv4[0].IP.String()
+	return m.netifs[0].IP().String()
 }
 
 func (m *machine) PrivateIP() string {
-	return m.netif.DHCPv4[0].IP.String()
+	return m.netifs[0].IP().String()
+}
+
+// PublicIP returns the machine's IPv4 address, since QEMU machines
+// have no separate public network; the host reaches them directly.
+func (m *machine) PublicIP() string {
+	if len(m.netifs[0].DHCPv4) == 0 {
+		return ""
+	}
+	return m.netifs[0].DHCPv4[0].IP.String()
+}
+
+func (m *machine) PrivateIPv6() string {
+	if len(m.netifs[0].DHCPv6) == 0 {
+		return ""
+	}
+	return m.netifs[0].DHCPv6[0].IP.String()
+}
+
+// PublicIPv6 returns the same address as PrivateIPv6.
+func (m *machine) PublicIPv6() string {
+	return m.PrivateIPv6()
+}
+
+// NetworkInterface describes one of a machine's NICs.
+type NetworkInterface struct {
+	Bridge       string
+	HardwareAddr net.HardwareAddr
+	IP           net.IP
+}
+
+// NetworkInterfaces returns the machine's NICs, in the order they are
+// attached to the guest.
+func (m *machine) NetworkInterfaces() []NetworkInterface {
+	var nics []NetworkInterface
+	for i, netif := range m.netifs {
+		nics = append(nics, NetworkInterface{
+			Bridge:       m.qc.Dnsmasq.Segments[i].BridgeName,
+			HardwareAddr: netif.HardwareAddr,
+			IP:           netif.IP(),
+		})
+	}
+	return nics
 }
 
 func (m *machine) RuntimeConf() platform.RuntimeConfig {
@@ -62,6 +119,14 @@ func (m *machine) SSH(cmd string) ([]byte, []byte, error) {
 	return m.qc.SSH(m, cmd)
 }
 
+func (m *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	return m.qc.SSHContext(ctx, m, cmd)
+}
+
+func (m *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(m, cmd, attempts, delay)
+}
+
 func (m *machine) Reboot() error {
 	return platform.RebootMachine(m, m.journal)
 }
@@ -73,15 +138,31 @@ func (m *machine) Destroy() {
 
 	m.journal.Destroy()
 
-	if buf, err := ioutil.ReadFile(m.consolePath); err == nil {
-		m.console = string(buf)
-	} else {
+	buf, err := ioutil.ReadFile(m.consolePath)
+	if err != nil {
 		plog.Errorf("Error reading console for instance %v: %v", m.ID(), err)
 	}
+	m.mu.Lock()
+	m.console = string(buf)
+	m.destroyed = true
+	m.mu.Unlock()
 
 	m.qc.DelMach(m)
 }
 
+// ConsoleOutput returns the serial console log. QEMU writes the log file
+// unbuffered, so for a running machine this is the output up to now.
 func (m *machine) ConsoleOutput() string {
-	return m.console
+	m.mu.Lock()
+	destroyed, console := m.destroyed, m.console
+	m.mu.Unlock()
+	if destroyed {
+		return console
+	}
+	buf, err := ioutil.ReadFile(m.consolePath)
+	if err != nil {
+		plog.Errorf("Error reading console for instance %v: %v", m.ID(), err)
+		return ""
+	}
+	return string(buf)
 }
@@ -0,0 +1,196 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sshhost implements a platform for machines that already exist
+// and are reachable over SSH. Machines are never created or destroyed.
+package sshhost
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/coreos/pkg/capnslog"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/network"
+	"github.com/coreos/mantle/platform"
+	"github.com/coreos/mantle/platform/conf"
+)
+
+const (
+	Platform platform.Name = "ssh"
+)
+
+var (
+	plog = capnslog.NewPackageLogger("github.com/coreos/mantle", "platform/machine/sshhost")
+
+	ErrUnsupported = errors.New("unsupported on ssh platform")
+)
+
+// Options contains options for using existing machines.
+type Options struct {
+	*platform.Options
+
+	// Hosts are the machines' addresses, as host or host:port.
+	Hosts []string
+	// User to log in as. Defaults to the runtime config's SSHUser, or
+	// "core".
+	User string
+	// KeyFile is the private key to authenticate with. If empty, the
+	// agent at $SSH_AUTH_SOCK is used.
+	KeyFile string
+}
+
+type cluster struct {
+	*platform.BaseCluster
+	opts    *Options
+	auth    []ssh.AuthMethod
+	hostKey network.HostKeyCallback
+	dialer  network.Dialer
+	mu      sync.Mutex
+	nextIdx int
+}
+
+// NewCluster creates a Cluster handing out the configured existing hosts.
+func NewCluster(opts *Options, rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, fmt.Errorf("no hosts specified for ssh platform")
+	}
+	if opts.User == "" {
+		opts.User = rconf.SSHUser
+	}
+	if opts.User == "" {
+		opts.User = "core"
+	}
+
+	auth, err := authMethods(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := opts.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := platform.NewBaseCluster(opts.Options, rconf, Platform, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &cluster{
+		BaseCluster: bc,
+		opts:        opts,
+		auth:        auth,
+		hostKey:     hostKey,
+		dialer:      network.NewRetryDialer(),
+	}
+
+	return sc, nil
+}
+
+func authMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	if keyFile != "" {
+		buf, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading SSH key: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(buf)
+		if err != nil {
+			return nil, fmt.Errorf("parsing SSH key %s: %v", keyFile, err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	sysAgent, err := network.SystemAgent()
+	if err == network.ErrNoSystemAgent {
+		return nil, fmt.Errorf("ssh platform needs an SSH key file or SSH_AUTH_SOCK")
+	} else if err != nil {
+		return nil, err
+	}
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(sysAgent.Signers)}, nil
+}
+
+// NewMachine returns the next unused host. The hosts are already
+// provisioned, so userdata cannot be applied.
+func (sc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	sc.mu.Lock()
+	if sc.nextIdx >= len(sc.opts.Hosts) {
+		sc.mu.Unlock()
+		return nil, fmt.Errorf("creating more than %d machines: %v", len(sc.opts.Hosts), ErrUnsupported)
+	}
+	addr := sc.opts.Hosts[sc.nextIdx]
+	sc.nextIdx++
+	sc.mu.Unlock()
+
+	if userdata != nil {
+		plog.Warningf("Ignoring user data for existing host %s", addr)
+	}
+
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	mach := &machine{
+		cluster: sc,
+		addr:    addr,
+		host:    host,
+	}
+
+	mach.dir = filepath.Join(sc.RuntimeConf().OutputDir, mach.ID())
+	if err := os.MkdirAll(mach.dir, 0777); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if mach.journal, err = platform.NewJournal(mach.dir); err != nil {
+		return nil, err
+	}
+
+	if err := platform.StartMachine(mach, mach.journal); err != nil {
+		mach.Destroy()
+		return nil, err
+	}
+
+	sc.AddMach(mach, func() (platform.Machine, error) {
+		return sc.NewMachine(userdata)
+	})
+
+	return mach, nil
+}
+
+func (sc *cluster) newClient(addr string, user string, auth []ssh.AuthMethod) (*ssh.Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	conn, err := sc.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sshconn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: sc.hostKey,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshconn, chans, reqs), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshhost
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/mantle/platform"
+)
+
+type machine struct {
+	cluster *cluster
+	addr    string // host:port as given
+	host    string
+	dir     string
+	journal *platform.Journal
+}
+
+func (sm *machine) ID() string {
+	return sm.addr
+}
+
+func (sm *machine) IP() string {
+	return sm.host
+}
+
+func (sm *machine) PrivateIP() string {
+	return sm.host
+}
+
+// PublicIP returns the host as given, which may be a name.
+func (sm *machine) PublicIP() string {
+	return sm.host
+}
+
+// PrivateIPv6 returns the host if it was given as an IPv6 address.
+func (sm *machine) PrivateIPv6() string {
+	if ip := net.ParseIP(sm.host); ip != nil && ip.To4() == nil {
+		return sm.host
+	}
+	return ""
+}
+
+// PublicIPv6 returns the host if it was given as an IPv6 address.
+func (sm *machine) PublicIPv6() string {
+	return sm.PrivateIPv6()
+}
+
+func (sm *machine) RuntimeConf() platform.RuntimeConfig {
+	return sm.cluster.RuntimeConf()
+}
+
+func (sm *machine) SSHClient() (*ssh.Client, error) {
+	return sm.cluster.newClient(sm.addr, sm.cluster.opts.User, sm.cluster.auth)
+}
+
+func (sm *machine) PasswordSSHClient(user string, password string) (*ssh.Client, error) {
+	return sm.cluster.newClient(sm.addr, user, []ssh.AuthMethod{ssh.Password(password)})
+}
+
+func (sm *machine) SSH(cmd string) ([]byte, []byte, error) {
+	return sm.SSHContext(context.Background(), cmd)
+}
+
+func (sm *machine) SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error) {
+	client, err := sm.SSHClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+
+	return platform.RunSSH(ctx, client, cmd, false)
+}
+
+func (sm *machine) SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	return platform.SSHRetry(sm, cmd, attempts, delay)
+}
+
+func (sm *machine) Reboot() error {
+	return fmt.Errorf("rebooting %s: %v", sm.ID(), ErrUnsupported)
+}
+
+// Destroy stops collecting the journal but leaves the host running.
+func (sm *machine) Destroy() {
+	if sm.journal != nil {
+		sm.journal.Destroy()
+	}
+
+	sm.cluster.DelMach(sm)
+}
+
+func (sm *machine) ConsoleOutput() string {
+	return ""
+}
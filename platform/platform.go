@@ -16,14 +16,16 @@ package platform
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/net/context"
 
 	"github.com/coreos/mantle/platform/conf"
 	"github.com/coreos/mantle/util"
@@ -32,6 +34,12 @@ import (
 const (
 	sshRetries = 30
 	sshTimeout = 10 * time.Second
+
+	// DefaultReadinessTimeout bounds the readiness check when
+	// RuntimeConfig.ReadinessTimeout is unset.
+	DefaultReadinessTimeout = 5 * time.Minute
+
+	winrmPort = "5985"
 )
 
 // Name is a unique identifier for a platform.
@@ -42,12 +50,25 @@ type Machine interface {
 	// ID returns the plaform-specific machine identifier.
 	ID() string
 
-	// IP returns the machine's public IP.
+	// IP returns the address used to reach the machine, which is its
+	// public IP if it has one.
 	IP() string
 
 	// PrivateIP returns the machine's private IP.
 	PrivateIP() string
 
+	// PublicIP returns the machine's public IPv4 address, or "" if it
+	// has none, e.g. on a private subnet.
+	PublicIP() string
+
+	// PrivateIPv6 returns the machine's IPv6 address within the
+	// cluster's network, or "" if it has none.
+	PrivateIPv6() string
+
+	// PublicIPv6 returns the machine's public IPv6 address, or "" if
+	// it has none.
+	PublicIPv6() string
+
 	// RuntimeConf returns the cluster's runtime configuration.
 	RuntimeConf() RuntimeConfig
 
@@ -60,6 +81,14 @@ type Machine interface {
 	// SSH runs a single command over a new SSH connection.
 	SSH(cmd string) ([]byte, []byte, error)
 
+	// SSHContext is like SSH, but gives up when ctx is done.
+	SSHContext(ctx context.Context, cmd string) ([]byte, []byte, error)
+
+	// SSHRetry is like SSH, but makes up to attempts attempts delay
+	// apart if the connection fails. Commands that exit with an error
+	// aren't retried.
+	SSHRetry(cmd string, attempts int, delay time.Duration) ([]byte, []byte, error)
+
 	// Reboot restarts the machine and waits for it to come back.
 	Reboot() error
 
@@ -72,6 +101,70 @@ type Machine interface {
 	ConsoleOutput() string
 }
 
+// DiskMachine is implemented by machines with RuntimeConfig.ExtraDisks
+// attached.
+type DiskMachine interface {
+	Machine
+
+	// ExtraDiskPaths returns the device paths of the extra disks on
+	// the machine, in the order they were requested.
+	ExtraDiskPaths() []string
+}
+
+// PreemptibleMachine is implemented by machines which the platform may
+// reclaim while they are in use, such as spot instances.
+type PreemptibleMachine interface {
+	Machine
+
+	// Preempted returns the reason the machine was preempted, or "" if
+	// it wasn't.
+	Preempted() (string, error)
+}
+
+// MaintenanceMachine is implemented by machines whose platform may
+// migrate, restart, or stop them for maintenance of their host.
+type MaintenanceMachine interface {
+	Machine
+
+	// MaintenanceEvents returns descriptions of the host maintenance
+	// events which affected the machine since it was created.
+	MaintenanceEvents() ([]string, error)
+}
+
+// SnapshotMachine is implemented by machines whose boot disk can be
+// saved for offline inspection.
+type SnapshotMachine interface {
+	Machine
+
+	// Snapshot saves the boot disk of the machine and returns the ID of
+	// the snapshot, which outlives the machine.
+	Snapshot(description string) (string, error)
+}
+
+// TypedMachine is implemented by machines whose platform offers several
+// machine sizes, which may differ from the one requested.
+type TypedMachine interface {
+	Machine
+
+	// MachineType returns the platform's name for the size of the
+	// machine.
+	MachineType() string
+}
+
+// DNSMachine is implemented by machines whose platform assigns them DNS
+// names.
+type DNSMachine interface {
+	Machine
+
+	// PublicDNSName returns the machine's public DNS name, or "" if it
+	// has none.
+	PublicDNSName() string
+
+	// PrivateDNSName returns the machine's DNS name within the
+	// platform's network, or "" if it has none.
+	PrivateDNSName() string
+}
+
 // Cluster represents a cluster of Container Linux machines within a single platform.
 type Cluster interface {
 	// Platform returns the name of the platform.
@@ -83,6 +176,10 @@ type Cluster interface {
 	// Machines returns a slice of the active machines in the Cluster.
 	Machines() []Machine
 
+	// ReplaceMachine destroys m and launches a new machine with the
+	// same userdata and options, returning the new machine.
+	ReplaceMachine(m Machine) (Machine, error)
+
 	// GetDiscoveryURL returns a new etcd discovery URL.
 	GetDiscoveryURL(size int) (string, error)
 
@@ -107,6 +204,26 @@ type SystemdDropin struct {
 type Options struct {
 	BaseName       string
 	SystemdDropins []SystemdDropin
+
+	// SSHJumpHost is a host[:port] through which machines are reached,
+	// authenticating as SSHJumpUser with the agent at $SSH_AUTH_SOCK.
+	SSHJumpHost string
+	SSHJumpUser string
+	// SSHForwardAgent forwards the agent, including the keys at
+	// $SSH_AUTH_SOCK, to sessions started by Cluster.SSH.
+	SSHForwardAgent bool
+	// SSHKeyFile is an unencrypted private key used to reach machines,
+	// instead of a key generated for the cluster. Its public key is
+	// authorized for the core user.
+	SSHKeyFile string
+	// SSHKnownHosts is the known_hosts file verifying the keys of
+	// SSHJumpHost and of the ssh platform's hosts, by default
+	// ~/.ssh/known_hosts. SSHInsecureIgnoreHostKeys skips verification.
+	SSHKnownHosts             string
+	SSHInsecureIgnoreHostKeys bool
+	// IgnitionVersion is the Ignition spec version Ignition configs and
+	// Container Linux configs are translated to, if set.
+	IgnitionVersion string
 }
 
 // RuntimeConfig contains cluster-specific configuration.
@@ -117,6 +234,104 @@ type RuntimeConfig struct {
 	NoSSHKeyInMetadata bool // don't add SSH key to platform metadata
 	NoEnableSelinux    bool // don't enable selinux when starting or rebooting a machine
 	AllowFailedUnits   bool // don't fail CheckMachine if a systemd unit has failed
+
+	CPUs   int // number of vCPUs for platforms with configurable sizing, 0 for default
+	Memory int // memory in MiB for platforms with configurable sizing, 0 for default
+
+	NetworkMode NetworkMode // IP protocols configured on machines, "" for dual-stack
+
+	// ExtraDisks are blank disks attached to each machine in addition
+	// to the boot disk, on platforms that support them.
+	ExtraDisks []DiskSpec
+
+	// Ports are inbound TCP ports opened to machines on platforms which
+	// firewall them, currently gce.
+	Ports []int
+
+	// ReadinessCheck is a command which must succeed on a machine
+	// after SSH connects before the machine is considered started.
+	// If empty, CheckMachine waits for systemd to finish booting.
+	ReadinessCheck string
+	// ReadinessTimeout bounds the readiness check, 0 for
+	// DefaultReadinessTimeout.
+	ReadinessTimeout time.Duration
+
+	// NoIgnition launches machines that don't run Container Linux,
+	// such as Windows. Their userdata is passed to the platform without
+	// adding SSH keys or dropins, and CheckMachine only waits until they
+	// are reachable over Connectivity.
+	NoIgnition bool
+	// Connectivity is how NoIgnition machines are reached, ConnectivitySSH
+	// if empty.
+	Connectivity Connectivity
+	// SSHUser is the user to log in as, "core" if empty.
+	SSHUser string
+}
+
+// Connectivity is a protocol for reaching a machine's guest OS.
+type Connectivity string
+
+const (
+	ConnectivitySSH   Connectivity = "ssh"
+	ConnectivityWinRM Connectivity = "winrm" // the HTTP listener on port 5985
+)
+
+// DiskSpec describes a blank disk to attach to a machine.
+type DiskSpec struct {
+	SizeGB int    // size in GiB
+	Type   string // platform-specific disk type, empty for the default
+}
+
+// ResourceRequest describes the resources a run expects to use at once,
+// for checking against platform quotas before it starts. Per-instance
+// resources such as CPUs and disks are derived from the platform options.
+type ResourceRequest struct {
+	Instances int
+}
+
+// CapacityError is returned by capacity preflights when a request would
+// exceed the remaining quota.
+type CapacityError struct {
+	Region    string
+	Resource  string
+	Needed    float64
+	Available float64
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("insufficient %s quota in %s: need %g, only %g available", e.Resource, e.Region, e.Needed, e.Available)
+}
+
+// NetworkMode selects the IP protocols available to machines.
+type NetworkMode string
+
+const (
+	NetworkDual     NetworkMode = "dual"
+	NetworkIPv4Only NetworkMode = "ipv4-only"
+	NetworkIPv6Only NetworkMode = "ipv6-only"
+)
+
+// HasIPv4 reports whether machines have IPv4 connectivity in this mode.
+func (n NetworkMode) HasIPv4() bool {
+	return n != NetworkIPv6Only
+}
+
+// HasIPv6 reports whether machines have IPv6 connectivity in this mode.
+func (n NetworkMode) HasIPv6() bool {
+	return n != NetworkIPv4Only
+}
+
+// ParseNetworkMode validates a network mode name. The empty string is
+// accepted as dual-stack.
+func ParseNetworkMode(s string) (NetworkMode, error) {
+	switch n := NetworkMode(s); n {
+	case "", NetworkDual:
+		return NetworkDual, nil
+	case NetworkIPv4Only, NetworkIPv6Only:
+		return n, nil
+	default:
+		return "", fmt.Errorf("unknown network mode %q", s)
+	}
 }
 
 // Wrap a StdoutPipe as a io.ReadCloser
@@ -222,54 +437,165 @@ func InstallFile(in io.Reader, m Machine, to string) error {
 	return nil
 }
 
-// NewMachines spawns n instances in cluster c, with
-// each instance passed the same userdata.
-func NewMachines(c Cluster, userdata *conf.UserData, n int) ([]Machine, error) {
+// NewIndexedMachines spawns n instances in cluster c, passing each the
+// userdata returned by userdata for its index. The machines are returned
+// in index order. If any fails to start, all are destroyed.
+func NewIndexedMachines(c Cluster, n int, userdata func(i int) *conf.UserData) ([]Machine, error) {
 	var wg sync.WaitGroup
 
-	mchan := make(chan Machine, n)
-	errchan := make(chan error, n)
+	machs := make([]Machine, n)
+	errs := make([]error, n)
 
 	for i := 0; i < n; i++ {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
-			m, err := c.NewMachine(userdata)
-			if err != nil {
-				errchan <- err
+			machs[i], errs[i] = c.NewMachine(userdata(i))
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, m := range machs {
+				if m != nil {
+					m.Destroy()
+				}
+			}
+			return nil, err
+		}
+	}
+
+	return machs, nil
+}
+
+// NewMachines spawns n instances in cluster c, with
+// each instance passed the same userdata.
+func NewMachines(c Cluster, userdata *conf.UserData, n int) ([]Machine, error) {
+	return NewIndexedMachines(c, n, func(int) *conf.UserData {
+		return userdata
+	})
+}
+
+// checkConnectivity waits until a machine that doesn't run Container Linux
+// can be reached, and then runs the readiness check if there is one. Such
+// guests boot too differently to check anything else.
+func checkConnectivity(ctx context.Context, m Machine) error {
+	rconf := m.RuntimeConf()
+	connectivity := rconf.Connectivity
+	if connectivity == "" {
+		connectivity = ConnectivitySSH
+	}
+	var check func() error
+	switch connectivity {
+	case ConnectivitySSH:
+		check = func() error {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-			if m != nil {
-				mchan <- m
+			client, err := m.SSHClient()
+			if err != nil {
+				return err
 			}
-		}()
+			return client.Close()
+		}
+	case ConnectivityWinRM:
+		check = func() error {
+			return probeWinRM(ctx, m.IP())
+		}
+	default:
+		return fmt.Errorf("unknown connectivity %q", connectivity)
 	}
 
-	wg.Wait()
-	close(mchan)
-	close(errchan)
+	if err := util.Retry(sshRetries, sshTimeout, check); err != nil {
+		return NewInfraError(fmt.Errorf("%s unreachable: %v", connectivity, err))
+	}
 
-	machs := []Machine{}
+	if rconf.ReadinessCheck != "" && connectivity == ConnectivitySSH {
+		return checkReady(ctx, m)
+	}
+	return ctx.Err()
+}
 
-	for m := range mchan {
-		machs = append(machs, m)
+// probeWinRM checks that the WinRM HTTP listener of ip responds. WinRM only
+// serves SOAP requests, so any HTTP response at all means it's up.
+func probeWinRM(ctx context.Context, ip string) error {
+	req, err := http.NewRequest("GET", "http://"+net.JoinHostPort(ip, winrmPort)+"/wsman", nil)
+	if err != nil {
+		return err
 	}
+	client := &http.Client{Timeout: sshTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// checkReady runs the readiness check of the machine's runtime config,
+// by default waiting for systemd to reach a running or degraded state.
+// Failed units are reported by CheckMachine afterward.
+func checkReady(ctx context.Context, m Machine) error {
+	rconf := m.RuntimeConf()
+	timeout := rconf.ReadinessTimeout
+	if timeout == 0 {
+		timeout = DefaultReadinessTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	if firsterr, ok := <-errchan; ok {
-		for _, m := range machs {
-			m.Destroy()
+	if rconf.ReadinessCheck != "" {
+		out, stderr, err := m.SSHContext(ctx, rconf.ReadinessCheck)
+		if err != nil {
+			return fmt.Errorf("readiness check %q failed: %s: %v: %s", rconf.ReadinessCheck, out, err, stderr)
 		}
-		return nil, firsterr
+		return nil
 	}
 
-	return machs, nil
+	// poll rather than use --wait, which needs systemd 240; ctx
+	// bounds the polling
+	backoff := util.Backoff{
+		Initial: time.Second,
+		Max:     10 * time.Second,
+		Jitter:  0.2,
+	}
+	var state string
+	err := util.PollBackoff(backoff, func(error) bool { return false }, func() (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		// exits non-zero unless running, so only the output matters
+		out, _, _ := m.SSHContext(ctx, "systemctl is-system-running")
+		state = string(out)
+		switch state {
+		case "running", "degraded":
+			return true, nil
+		case "initializing", "starting", "":
+			return false, nil
+		}
+		return false, fmt.Errorf("system is %s", state)
+	})
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("system not ready after %v: last state %q", timeout, state)
+	}
+	return fmt.Errorf("system not ready: %v", err)
 }
 
 // CheckMachine tests a machine for various error conditions such as ssh
 // being available and no systemd units failing at the time ssh is reachable.
 // It also ensures the remote system is running Container Linux by CoreOS.
+// Machines with RuntimeConfig.NoIgnition are only checked for connectivity.
 //
 // TODO(mischief): better error messages.
 func CheckMachine(ctx context.Context, m Machine) error {
+	if m.RuntimeConf().NoIgnition {
+		return checkConnectivity(ctx, m)
+	}
+
 	// ensure ssh works and the system is ready
 	sshChecker := func() error {
 		if err := ctx.Err(); err != nil {
@@ -286,7 +612,11 @@ func CheckMachine(ctx context.Context, m Machine) error {
 	}
 
 	if err := util.Retry(sshRetries, sshTimeout, sshChecker); err != nil {
-		return fmt.Errorf("ssh unreachable: %v", err)
+		return NewInfraError(fmt.Errorf("ssh unreachable: %v", err))
+	}
+
+	if err := checkReady(ctx, m); err != nil {
+		return err
 	}
 
 	// ensure we're talking to a Container Linux system
@@ -0,0 +1,88 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/coreos/mantle/util"
+)
+
+// RunSSH runs cmd in a new session on client and returns its stdout and
+// stderr, with leading and trailing whitespace trimmed. If ctx is done
+// before cmd exits, client is closed and ctx.Err() is returned.
+func RunSSH(ctx context.Context, client *ssh.Client, cmd string, forwardAgent bool) ([]byte, []byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+
+	if forwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// unblocks session.Run
+		client.Close()
+		<-done
+		return nil, nil, ctx.Err()
+	}
+
+	outBytes := bytes.TrimSpace(stdout.Bytes())
+	errBytes := bytes.TrimSpace(stderr.Bytes())
+	return outBytes, errBytes, err
+}
+
+// SSHRetry runs cmd on m, making up to attempts attempts delay apart if
+// the connection fails. Commands that exit with an error aren't retried.
+func SSHRetry(m Machine, cmd string, attempts int, delay time.Duration) ([]byte, []byte, error) {
+	var stdout, stderr []byte
+	err := util.RetryConditional(attempts, delay, IsSSHConnectionError, func() error {
+		var err error
+		stdout, stderr, err = m.SSH(cmd)
+		return err
+	})
+	return stdout, stderr, err
+}
+
+// IsSSHConnectionError reports whether err from running a command over
+// SSH is a failure to connect or a dropped connection, rather than the
+// command exiting with an error.
+func IsSSHConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, exited := err.(*ssh.ExitError)
+	return !exited
+}
@@ -23,6 +23,31 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// InfraError is returned for failures of the test infrastructure, such
+// as a cloud API error or a machine that never became reachable, rather
+// than of the system under test.
+type InfraError struct {
+	Err error
+}
+
+func (e *InfraError) Error() string {
+	return e.Err.Error()
+}
+
+// NewInfraError wraps err in an InfraError. It returns nil if err is nil.
+func NewInfraError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &InfraError{Err: err}
+}
+
+// IsInfraError reports whether err is an InfraError.
+func IsInfraError(err error) bool {
+	_, ok := err.(*InfraError)
+	return ok
+}
+
 // Manhole connects os.Stdin, os.Stdout, and os.Stderr to an interactive shell
 // session on the Machine m. Manhole blocks until the shell session has ended.
 // If os.Stdin does not refer to a TTY, Manhole returns immediately with a nil
@@ -113,14 +138,22 @@ func RebootMachine(m Machine, j *Journal) error {
 }
 
 // StartMachine will start a given machine, provided the machine's journal.
+// Machines that don't run Container Linux have no journal to record.
 func StartMachine(m Machine, j *Journal) error {
-	if err := j.Start(context.TODO(), m); err != nil {
-		return fmt.Errorf("machine %q failed to start: %v", m.ID(), err)
+	noIgnition := m.RuntimeConf().NoIgnition
+	if !noIgnition {
+		if err := j.Start(context.TODO(), m); err != nil {
+			return NewInfraError(fmt.Errorf("machine %q failed to start: %v", m.ID(), err))
+		}
 	}
 	if err := CheckMachine(context.TODO(), m); err != nil {
-		return fmt.Errorf("machine %q failed basic checks: %v", m.ID(), err)
+		err2 := fmt.Errorf("machine %q failed basic checks: %v", m.ID(), err)
+		if IsInfraError(err) {
+			return NewInfraError(err2)
+		}
+		return err2
 	}
-	if !m.RuntimeConf().NoEnableSelinux {
+	if !noIgnition && !m.RuntimeConf().NoEnableSelinux {
 		if err := EnableSelinux(m); err != nil {
 			return fmt.Errorf("machine %q failed to enable selinux: %v", m.ID(), err)
 		}
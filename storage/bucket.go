@@ -27,6 +27,8 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/storage/v1"
+
+	"github.com/coreos/mantle/util"
 )
 
 var (
@@ -47,6 +49,8 @@ type Bucket struct {
 	writeAlways bool
 	// writeDryRun blocks any changes, merely logging them instead
 	writeDryRun bool
+	// progress is told about the progress of uploads
+	progress util.ProgressReporter
 }
 
 func NewBucket(client *http.Client, bucketURL string) (*Bucket, error) {
@@ -95,6 +99,12 @@ func (b *Bucket) WriteDryRun(dryrun bool) {
 	b.writeDryRun = dryrun
 }
 
+// ReportProgress sets the reporter told about the progress of uploads,
+// util.DefaultReporter by default.
+func (b *Bucket) ReportProgress(progress util.ProgressReporter) {
+	b.progress = progress
+}
+
 func (b *Bucket) Object(objName string) *storage.Object {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -281,6 +291,14 @@ func (b *Bucket) Upload(ctx context.Context, obj *storage.Object, media io.Reade
 	// but Media's retry support was bad and got temporarily removed.
 	// https://github.com/google/google-api-go-client/commit/9737cc9e103c00d06a8f3993361dec083df3d252
 	req.ResumableMedia(ctx, media, int64(obj.Size), obj.ContentType)
+	progress := b.progress
+	if progress == nil {
+		progress = util.DefaultReporter
+	}
+	desc := "Uploading " + b.mkURL(obj).String()
+	req.ProgressUpdater(func(current, total int64) {
+		progress.Bytes(desc, current, total)
+	})
 
 	// Watch out for unexpected conflicting updates.
 	if old != nil {
@@ -0,0 +1,277 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+
+	"github.com/coreos/mantle/util"
+)
+
+const (
+	// Chunk sizes must be a multiple of this.
+	ChunkGranularity = 256 * 1024
+
+	DefaultChunkSize = 32 * ChunkGranularity
+	DefaultRetries   = 5
+
+	uploadBasePath = "https://www.googleapis.com/upload/storage/v1/"
+)
+
+// ResumableUpload uploads an object in chunks using the Google Storage
+// resumable upload protocol. When a chunk fails because of a network
+// error or a transient server error, the upload is resumed from the last
+// offset acknowledged by the server instead of being restarted.
+//
+// https://cloud.google.com/storage/docs/json_api/v1/how-tos/resumable-upload
+type ResumableUpload struct {
+	Client *http.Client
+	Bucket string
+	// Object holds the metadata of the object to create.
+	Object *storage.Object
+	Media  io.ReaderAt
+	Size   int64
+
+	// ChunkSize is the number of bytes sent per request. It must be a
+	// multiple of ChunkGranularity. Defaults to DefaultChunkSize.
+	ChunkSize int64
+	// Retries is the number of consecutive failed attempts tolerated
+	// without any progress. Defaults to DefaultRetries; a negative value
+	// disables retries.
+	Retries int
+	// PredefinedACL is an optional predefined ACL for the object.
+	PredefinedACL string
+
+	// Backoff is the pause before the first retry, doubled for each
+	// subsequent one. Defaults to one second.
+	Backoff time.Duration
+
+	// Progress is told how much of the upload the server has received.
+	// Defaults to util.DefaultReporter.
+	Progress util.ProgressReporter
+
+	// basePath overrides uploadBasePath for testing.
+	basePath string
+}
+
+// Do performs the upload and returns the created object.
+func (u *ResumableUpload) Do(ctx context.Context) (*storage.Object, error) {
+	chunkSize := u.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize%ChunkGranularity != 0 {
+		return nil, fmt.Errorf("chunk size %d is not a multiple of %d", chunkSize, ChunkGranularity)
+	}
+	retries := u.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	backoff := u.Backoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	session, err := u.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	progress := u.Progress
+	if progress == nil {
+		progress = util.DefaultReporter
+	}
+	desc := fmt.Sprintf("Uploading gs://%s/%s", u.Bucket, u.Object.Name)
+
+	var offset int64
+	failures := 0
+	query := false
+	for {
+		var obj *storage.Object
+		var next int64
+		var err error
+		if query {
+			// find out what the server actually received
+			obj, next, err = u.sendChunk(ctx, session, -1, 0)
+		} else {
+			obj, next, err = u.sendChunk(ctx, session, offset, chunkSize)
+		}
+		if err == nil && obj != nil {
+			progress.Bytes(desc, u.Size, u.Size)
+			return obj, nil
+		}
+		if err == nil {
+			if next > offset {
+				failures = 0
+				progress.Bytes(desc, next, u.Size)
+			} else if !query {
+				// the server accepted none of the chunk
+				failures++
+				if failures > retries {
+					return nil, fmt.Errorf("giving up after %d retries at offset %d: no progress", retries, offset)
+				}
+			}
+			offset = next
+			query = false
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !isTransient(err) {
+			return nil, err
+		}
+		query = true
+		failures++
+		if failures > retries {
+			return nil, fmt.Errorf("giving up after %d retries at offset %d: %v", retries, offset, err)
+		}
+		pause := backoff << uint(failures-1)
+		plog.Warningf("Upload of %s interrupted at offset %d, retrying in %v: %v", u.Object.Name, offset, pause, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
+
+// start initiates the upload session and returns its URI.
+func (u *ResumableUpload) start(ctx context.Context) (string, error) {
+	base := u.basePath
+	if base == "" {
+		base = uploadBasePath
+	}
+	params := url.Values{"uploadType": {"resumable"}}
+	if u.PredefinedACL != "" {
+		params.Set("predefinedAcl", u.PredefinedACL)
+	}
+	urls := base + "b/" + url.PathEscape(u.Bucket) + "/o?" + params.Encode()
+
+	body, err := json.Marshal(u.Object)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", urls, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(u.Size, 10))
+	if u.Object.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", u.Object.ContentType)
+	}
+
+	res, err := u.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", err
+	}
+
+	session := res.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("no session URI in resumable upload response")
+	}
+	return session, nil
+}
+
+// sendChunk sends up to length bytes at offset and returns the created
+// object once the upload is complete, or else the offset to continue
+// from. An offset of -1 only queries the upload status.
+func (u *ResumableUpload) sendChunk(ctx context.Context, session string, offset, length int64) (*storage.Object, int64, error) {
+	var body io.Reader
+	var contentRange string
+	if offset < 0 || u.Size == 0 {
+		body = bytes.NewReader(nil)
+		length = 0
+		contentRange = fmt.Sprintf("bytes */%d", u.Size)
+	} else {
+		if offset+length > u.Size {
+			length = u.Size - offset
+		}
+		body = io.NewSectionReader(u.Media, offset, length)
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, u.Size)
+	}
+
+	req, err := http.NewRequest("PUT", session, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Range", contentRange)
+
+	res, err := u.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == 308:
+		// Resume Incomplete; Range is absent if nothing was received
+		next, err := parseRange(res.Header.Get("Range"))
+		return nil, next, err
+	case res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated:
+		obj := &storage.Object{}
+		if err := json.NewDecoder(res.Body).Decode(obj); err != nil {
+			return nil, 0, err
+		}
+		return obj, u.Size, nil
+	default:
+		return nil, 0, googleapi.CheckResponse(res)
+	}
+}
+
+// parseRange returns the offset following a "bytes=0-N" Range header.
+func parseRange(r string) (int64, error) {
+	if r == "" {
+		return 0, nil
+	}
+	i := strings.LastIndex(r, "-")
+	if !strings.HasPrefix(r, "bytes=0-") || i < 0 {
+		return 0, fmt.Errorf("unexpected Range header %q", r)
+	}
+	end, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected Range header %q: %v", r, err)
+	}
+	return end + 1, nil
+}
+
+// isTransient reports whether a failed request should be retried.
+func isTransient(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code >= 500 || e.Code == 429
+	}
+	// anything else is a network error
+	return true
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/storage/v1"
+)
+
+// fakeUploadServer implements enough of the resumable upload protocol to
+// test ResumableUpload. failChunks lists the chunk requests (counting
+// from 1) that fail after storing half of their data.
+type fakeUploadServer struct {
+	t          *testing.T
+	mu         sync.Mutex
+	data       []byte
+	size       int64
+	requests   int
+	sent       int64
+	failChunks map[int]bool
+	url        string
+}
+
+func (f *fakeUploadServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/b/bucket/o"):
+		if req.URL.Query().Get("uploadType") != "resumable" {
+			f.t.Errorf("unexpected upload type %q", req.URL.Query().Get("uploadType"))
+		}
+		fmt.Sscan(req.Header.Get("X-Upload-Content-Length"), &f.size)
+		w.Header().Set("Location", f.url+"/session")
+	case req.Method == "PUT" && req.URL.Path == "/session":
+		body, _ := ioutil.ReadAll(req.Body)
+		cr := req.Header.Get("Content-Range")
+		if !strings.HasPrefix(cr, "bytes */") {
+			f.requests++
+			f.sent += int64(len(body))
+			var start, end, total int64
+			if _, err := fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+				f.t.Errorf("bad Content-Range %q", cr)
+			}
+			if start != int64(len(f.data)) {
+				f.t.Errorf("chunk starts at %d, have %d bytes", start, len(f.data))
+			}
+			if f.failChunks[f.requests] {
+				f.data = append(f.data, body[:len(body)/2]...)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			f.data = append(f.data, body...)
+		}
+		if int64(len(f.data)) == f.size {
+			json.NewEncoder(w).Encode(&storage.Object{Name: "obj", Size: uint64(f.size)})
+			return
+		}
+		if len(f.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(f.data)-1))
+		}
+		w.WriteHeader(308)
+	default:
+		f.t.Errorf("unexpected request %s %s", req.Method, req.URL)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func testUpload(t *testing.T, size int, failChunks map[int]bool, retries int) (*fakeUploadServer, []byte, error) {
+	fake := &fakeUploadServer{t: t, failChunks: failChunks}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	u := ResumableUpload{
+		Client:    &http.Client{},
+		Bucket:    "bucket",
+		Object:    &storage.Object{Name: "obj"},
+		Media:     bytes.NewReader(payload),
+		Size:      int64(size),
+		ChunkSize: ChunkGranularity,
+		Retries:   retries,
+		Backoff:   time.Millisecond,
+		basePath:  server.URL + "/",
+	}
+	obj, err := u.Do(context.Background())
+	if err == nil && obj.Name != "obj" {
+		t.Errorf("unexpected object %q", obj.Name)
+	}
+	return fake, payload, err
+}
+
+func TestResumableUpload(t *testing.T) {
+	size := 3*ChunkGranularity + 100
+	fake, payload, err := testUpload(t, size, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.data, payload) {
+		t.Errorf("uploaded data differs")
+	}
+	if fake.requests != 4 {
+		t.Errorf("expected 4 chunks, sent %d", fake.requests)
+	}
+}
+
+func TestResumableUploadEmpty(t *testing.T) {
+	fake, _, err := testUpload(t, 0, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.data) != 0 {
+		t.Errorf("uploaded %d bytes", len(fake.data))
+	}
+}
+
+func TestResumableUploadResume(t *testing.T) {
+	size := 3 * ChunkGranularity
+	fake, payload, err := testUpload(t, size, map[int]bool{2: true, 3: true}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.data, payload) {
+		t.Errorf("uploaded data differs")
+	}
+	// each failure re-sends only the half that wasn't stored
+	if expected := int64(size + ChunkGranularity); fake.sent != expected {
+		t.Errorf("expected %d bytes sent, got %d", expected, fake.sent)
+	}
+}
+
+func TestResumableUploadGiveUp(t *testing.T) {
+	fails := make(map[int]bool)
+	for i := 1; i < 20; i++ {
+		fails[i] = true
+	}
+	// halving a chunk each time still counts as progress, so use a
+	// single byte that can't be split
+	_, _, err := testUpload(t, 1, fails, 2)
+	if err == nil || !strings.Contains(err.Error(), "giving up") {
+		t.Errorf("expected to give up, got %v", err)
+	}
+}
+
+// byteRecorder records the progress reported in bytes.
+type byteRecorder struct {
+	done []int64
+}
+
+func (r *byteRecorder) Bytes(desc string, done, total int64) { r.done = append(r.done, done) }
+func (r *byteRecorder) Percent(desc string, percent int)     {}
+
+func TestResumableUploadProgress(t *testing.T) {
+	fake := &fakeUploadServer{t: t}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	fake.url = server.URL
+
+	size := 2*ChunkGranularity + 100
+	progress := &byteRecorder{}
+	u := ResumableUpload{
+		Client:    &http.Client{},
+		Bucket:    "bucket",
+		Object:    &storage.Object{Name: "obj"},
+		Media:     bytes.NewReader(make([]byte, size)),
+		Size:      int64(size),
+		ChunkSize: ChunkGranularity,
+		Progress:  progress,
+		basePath:  server.URL + "/",
+	}
+	if _, err := u.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{ChunkGranularity, 2 * ChunkGranularity, int64(size)}
+	if !reflect.DeepEqual(progress.done, expected) {
+		t.Errorf("expected progress %v, got %v", expected, progress.done)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		header string
+		next   int64
+		ok     bool
+	}{
+		{"", 0, true},
+		{"bytes=0-0", 1, true},
+		{"bytes=0-262143", 262144, true},
+		{"bytes=5-10", 0, false},
+		{"bogus", 0, false},
+	} {
+		next, err := parseRange(tt.header)
+		if tt.ok != (err == nil) {
+			t.Errorf("%q: unexpected error state %v", tt.header, err)
+		}
+		if next != tt.next {
+			t.Errorf("%q: expected %d, got %d", tt.header, tt.next, next)
+		}
+	}
+}
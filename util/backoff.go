@@ -0,0 +1,120 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Backoff describes a capped exponential backoff with jitter, for use
+// when retrying or polling cloud APIs.
+type Backoff struct {
+	Initial    time.Duration // delay after the first attempt
+	Max        time.Duration // longest delay, 0 for no limit
+	Multiplier float64       // growth of the delay per attempt, 2 if 0
+	Jitter     float64       // fraction of each delay randomly removed, 0 to 1
+	MaxElapsed time.Duration // give up after this long, 0 to never give up
+
+	// random returns a number in [0, 1); for tests
+	random func() float64
+	// sleep waits; for tests
+	sleep func(time.Duration)
+}
+
+// Delay returns the delay after the given attempt, counting from 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		random := b.random
+		if random == nil {
+			random = rand.Float64
+		}
+		d -= d * b.Jitter * random()
+	}
+	return time.Duration(d)
+}
+
+func (b Backoff) wait(d time.Duration) {
+	if b.sleep != nil {
+		b.sleep(d)
+	} else {
+		time.Sleep(d)
+	}
+}
+
+// RetryBackoff calls f until it succeeds, shouldRetry returns false for
+// its error, or b.MaxElapsed would be exceeded by waiting again. The error
+// from the last call is returned.
+func RetryBackoff(b Backoff, shouldRetry func(err error) bool, f func() error) error {
+	var elapsed time.Duration
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || !shouldRetry(err) {
+			return err
+		}
+
+		d := b.Delay(attempt)
+		if b.MaxElapsed > 0 && elapsed+d > b.MaxElapsed {
+			return err
+		}
+		b.wait(d)
+		elapsed += d
+	}
+}
+
+// PollBackoff calls check until it reports done, returns an error for
+// which shouldRetry returns false, or b.MaxElapsed would be exceeded by
+// waiting again. Errors for which shouldRetry returns true are treated as
+// not done.
+func PollBackoff(b Backoff, shouldRetry func(err error) bool, check func() (bool, error)) error {
+	var elapsed time.Duration
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		done, err := check()
+		if err != nil && !shouldRetry(err) {
+			return err
+		} else if err == nil && done {
+			return nil
+		}
+		lastErr = err
+
+		d := b.Delay(attempt)
+		if b.MaxElapsed > 0 && elapsed+d > b.MaxElapsed {
+			if lastErr != nil {
+				return fmt.Errorf("time limit exceeded: %v", lastErr)
+			}
+			return fmt.Errorf("time limit exceeded")
+		}
+		b.wait(d)
+		elapsed += d
+	}
+}
+
+// IsRetryableStatus reports whether an HTTP response status indicates a
+// transient failure: rate limiting or a server error.
+func IsRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("HTTP %d", int(e))
+}
+
+func retryStatus(err error) bool {
+	if s, ok := err.(statusError); ok {
+		return IsRetryableStatus(int(s))
+	}
+	return false
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{
+		Initial: time.Second,
+		Max:     10 * time.Second,
+	}
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		delays = append(delays, b.Delay(i))
+	}
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i := range expected {
+		expected[i] *= time.Second
+	}
+	if !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected schedule %v, got %v", expected, delays)
+	}
+
+	b.Multiplier = 3
+	if d := b.Delay(2); d != 9*time.Second {
+		t.Errorf("expected 9s with multiplier 3, got %v", d)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := Backoff{
+		Initial: 10 * time.Second,
+		Jitter:  0.5,
+		random:  func() float64 { return 1 },
+	}
+	if d := b.Delay(0); d != 5*time.Second {
+		t.Errorf("expected maximum jitter to halve the delay, got %v", d)
+	}
+	b.random = func() float64 { return 0 }
+	if d := b.Delay(0); d != 10*time.Second {
+		t.Errorf("expected no jitter, got %v", d)
+	}
+
+	b.random = nil
+	for i := 0; i < 100; i++ {
+		if d := b.Delay(1); d <= 10*time.Second || d > 20*time.Second {
+			t.Fatalf("jittered delay %v out of range", d)
+		}
+	}
+}
+
+func fakeSleep(slept *[]time.Duration) func(time.Duration) {
+	return func(d time.Duration) {
+		*slept = append(*slept, d)
+	}
+}
+
+func TestRetryBackoffStatus(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		calls  int
+	}{
+		{429, 3},
+		{503, 3},
+		{500, 3},
+		{400, 1},
+		{404, 1},
+		{501, 1},
+	} {
+		var slept []time.Duration
+		b := Backoff{
+			Initial: time.Second,
+			sleep:   fakeSleep(&slept),
+		}
+		calls := 0
+		err := RetryBackoff(b, retryStatus, func() error {
+			calls++
+			if calls < 3 {
+				return statusError(tt.status)
+			}
+			return nil
+		})
+		if calls != tt.calls {
+			t.Errorf("status %d: expected %d calls, got %d", tt.status, tt.calls, calls)
+		}
+		if tt.calls == 1 && err != statusError(tt.status) {
+			t.Errorf("status %d: expected error, got %v", tt.status, err)
+		}
+		if tt.calls > 1 {
+			if err != nil {
+				t.Errorf("status %d: unexpected error %v", tt.status, err)
+			}
+			if !reflect.DeepEqual(slept, []time.Duration{time.Second, 2 * time.Second}) {
+				t.Errorf("status %d: unexpected sleeps %v", tt.status, slept)
+			}
+		}
+	}
+}
+
+func TestRetryBackoffMaxElapsed(t *testing.T) {
+	var slept []time.Duration
+	b := Backoff{
+		Initial:    time.Second,
+		MaxElapsed: 10 * time.Second,
+		sleep:      fakeSleep(&slept),
+	}
+	calls := 0
+	err := RetryBackoff(b, retryStatus, func() error {
+		calls++
+		return statusError(503)
+	})
+	if err != statusError(503) {
+		t.Errorf("expected last error, got %v", err)
+	}
+	// 1+2+4 = 7s; waiting another 8s would exceed 10s
+	if calls != 4 || len(slept) != 3 {
+		t.Errorf("expected 4 calls and 3 sleeps, got %d and %v", calls, slept)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	var slept []time.Duration
+	b := Backoff{
+		Initial: time.Second,
+		Max:     2 * time.Second,
+		sleep:   fakeSleep(&slept),
+	}
+	results := []error{nil, statusError(429), nil, nil}
+	calls := 0
+	err := PollBackoff(b, retryStatus, func() (bool, error) {
+		err := results[calls]
+		calls++
+		return calls == len(results), err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(slept, expected) {
+		t.Errorf("expected sleeps %v, got %v", expected, slept)
+	}
+
+	calls = 0
+	err = PollBackoff(b, retryStatus, func() (bool, error) {
+		calls++
+		return false, statusError(400)
+	})
+	if err != statusError(400) || calls != 1 {
+		t.Errorf("expected immediate failure, got %v after %d calls", err, calls)
+	}
+
+	b.MaxElapsed = 5 * time.Second
+	err = PollBackoff(b, retryStatus, func() (bool, error) {
+		return false, nil
+	})
+	if err == nil {
+		t.Errorf("expected timeout")
+	}
+}
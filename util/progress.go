@@ -0,0 +1,124 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/ioprogress"
+	"github.com/coreos/pkg/capnslog"
+)
+
+// ProgressReporter is told about the progress of long uploads and
+// operations, identified by a description. It must be safe to call from
+// several goroutines.
+type ProgressReporter interface {
+	// Bytes reports that done of total bytes have been transferred.
+	// total is -1 if unknown.
+	Bytes(desc string, done, total int64)
+	// Percent reports that an operation is percent complete.
+	Percent(desc string, percent int)
+}
+
+// NopReporter discards progress, for quiet output.
+var NopReporter ProgressReporter = nopReporter{}
+
+type nopReporter struct{}
+
+func (nopReporter) Bytes(string, int64, int64) {}
+func (nopReporter) Percent(string, int)        {}
+
+// DefaultReporter logs progress at NOTICE level every 30 seconds or 10
+// percent. It is used when no reporter is configured.
+var DefaultReporter ProgressReporter = NewLogReporter(capnslog.NOTICE, 30*time.Second, 10)
+
+// NewLogReporter returns a ProgressReporter logging a line through level
+// when an upload or operation is first reported, whenever interval has
+// passed or progress advanced by step percent since the last line about
+// it, and once it completes.
+func NewLogReporter(level capnslog.LogLevel, interval time.Duration, step int) ProgressReporter {
+	return &logReporter{
+		level:    level,
+		interval: interval,
+		step:     step,
+		now:      time.Now,
+		logf: func(format string, args ...interface{}) {
+			plog.Logf(level, format, args...)
+		},
+		last: make(map[string]logged),
+	}
+}
+
+type logReporter struct {
+	level    capnslog.LogLevel
+	interval time.Duration
+	step     int
+	now      func() time.Time
+	logf     func(format string, args ...interface{})
+
+	mu   sync.Mutex
+	last map[string]logged
+}
+
+// logged is the last progress logged about an upload or operation.
+type logged struct {
+	at      time.Time
+	percent int
+}
+
+func (r *logReporter) Bytes(desc string, done, total int64) {
+	if total < 0 {
+		if r.due(desc, -1, false) {
+			r.logf("%s: %s of an unknown total size", desc, ioprogress.ByteUnitStr(done))
+		}
+		return
+	}
+	percent := 100
+	if total > 0 {
+		percent = int(done * 100 / total)
+	}
+	if r.due(desc, percent, done >= total) {
+		r.logf("%s: %d%% (%s)", desc, percent, ioprogress.DrawTextFormatBytes(done, total))
+	}
+}
+
+func (r *logReporter) Percent(desc string, percent int) {
+	if r.due(desc, percent, percent >= 100) {
+		r.logf("%s: %d%%", desc, percent)
+	}
+}
+
+// due reports whether progress about desc should be logged now, and
+// records it if so. percent is -1 if unknown.
+func (r *logReporter) due(desc string, percent int, complete bool) bool {
+	if !plog.LevelAt(r.level) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if complete {
+		delete(r.last, desc)
+		return true
+	}
+	now := r.now()
+	last, ok := r.last[desc]
+	if ok && now.Sub(last.at) < r.interval && (percent < 0 || percent < last.percent+r.step) {
+		return false
+	}
+	r.last[desc] = logged{at: now, percent: percent}
+	return true
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/pkg/capnslog"
+)
+
+func newTestReporter() (*logReporter, *time.Time, *[]string) {
+	now := time.Unix(0, 0)
+	var lines []string
+	r := NewLogReporter(capnslog.CRITICAL, 30*time.Second, 10).(*logReporter)
+	r.now = func() time.Time { return now }
+	r.logf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	return r, &now, &lines
+}
+
+func TestLogReporterPercent(t *testing.T) {
+	r, now, lines := newTestReporter()
+
+	r.Percent("op", 0)  // first report
+	r.Percent("op", 5)  // too soon
+	r.Percent("op", 12) // advanced by a step
+	*now = now.Add(10 * time.Second)
+	r.Percent("op", 13) // too soon
+	*now = now.Add(30 * time.Second)
+	r.Percent("op", 13)  // interval passed
+	r.Percent("op", 100) // complete
+	r.Percent("op", 0)   // a new operation with the same name
+
+	expected := []string{"op: 0%", "op: 12%", "op: 13%", "op: 100%", "op: 0%"}
+	if !reflect.DeepEqual(*lines, expected) {
+		t.Errorf("expected %q, got %q", expected, *lines)
+	}
+}
+
+func TestLogReporterBytes(t *testing.T) {
+	r, now, lines := newTestReporter()
+
+	r.Bytes("upload", 0, 1000)
+	r.Bytes("upload", 50, 1000)
+	r.Bytes("upload", 500, 1000)
+	r.Bytes("upload", 1000, 1000)
+	r.Bytes("stream", 100, -1)
+	r.Bytes("stream", 200, -1)
+	*now = now.Add(time.Minute)
+	r.Bytes("stream", 300, -1)
+	r.Bytes("empty", 0, 0)
+
+	if len(*lines) != 6 {
+		t.Fatalf("expected 6 lines, got %q", *lines)
+	}
+	for i, prefix := range []string{"upload: 0%", "upload: 50%", "upload: 100%", "stream: 100 B of an unknown", "stream: 300 B of an unknown", "empty: 100%"} {
+		if line := (*lines)[i]; len(line) < len(prefix) || line[:len(prefix)] != prefix {
+			t.Errorf("line %d: expected prefix %q, got %q", i, prefix, line)
+		}
+	}
+}